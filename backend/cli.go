@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"gorm.io/gorm/logger"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/services"
+)
+
+const auditUsage = `usage: stratus audit <command>
+
+commands:
+  verify            check the audit log hash chain; exits 1 if it is broken
+  export [-from N]  write the audit log as JSON lines to stdout`
+
+// runCommand handles the maintenance commands that run instead of the
+// server and returns the process exit code.
+func runCommand(cfg *config.Config, args []string) int {
+	if args[0] != "audit" || len(args) < 2 {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 2
+	}
+
+	if err := database.Connect(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+	// Keep stdout for the command's own output.
+	database.DB.Logger = logger.Default.LogMode(logger.Silent)
+
+	audit := services.NewAuditService()
+
+	switch args[1] {
+	case "verify":
+		result, err := audit.Verify()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to verify audit log: %v\n", err)
+			return 1
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+		if !result.Valid {
+			return 1
+		}
+		return 0
+
+	case "export":
+		flags := flag.NewFlagSet("audit export", flag.ContinueOnError)
+		fromSeq := flags.Int64("from", 0, "first sequence number to export")
+		if err := flags.Parse(args[2:]); err != nil {
+			return 2
+		}
+		out := bufio.NewWriter(os.Stdout)
+		if err := audit.Export(out, *fromSeq); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export audit log: %v\n", err)
+			return 1
+		}
+		if err := out.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export audit log: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, auditUsage)
+	return 2
+}
@@ -1,11 +1,11 @@
 package database
 
 import (
-	"log"
+	"context"
+	"log/slog"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 
 	"stratus/config"
 	"stratus/models"
@@ -13,14 +13,24 @@ import (
 
 var DB *gorm.DB
 
+// WithContext returns DB bound to ctx, so its statements are logged and
+// traced as part of the request ctx belongs to. Cancellation of ctx is
+// not passed on: a client disconnecting must not abort a write halfway.
+func WithContext(ctx context.Context) *gorm.DB {
+	return DB.WithContext(context.WithoutCancel(ctx))
+}
+
 func Connect(cfg *config.Config) error {
 	var err error
 	DB, err = gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: slogLogger{slowThreshold: cfg.DBSlowQueryThreshold},
 	})
 	if err != nil {
 		return err
 	}
+	if err := DB.Use(tracingPlugin{}); err != nil {
+		return err
+	}
 
 	sqlDB, err := DB.DB()
 	if err != nil {
@@ -30,22 +40,137 @@ func Connect(cfg *config.Config) error {
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetMaxOpenConns(100)
 
-	log.Println("Database connected successfully")
+	slog.Info("Database connected")
 	return nil
 }
 
-func Migrate() error {
-	log.Println("Running database migrations...")
-	err := DB.AutoMigrate(
+// Models lists every table AutoMigrate manages.
+func Models() []interface{} {
+	return []interface{}{
 		&models.User{},
 		&models.File{},
 		&models.FileVersion{},
 		&models.Activity{},
-	)
+		&models.FileContent{},
+		&models.Setting{},
+		&models.MediaMetadata{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.RecoveryCode{},
+		&models.AppPassword{},
+		&models.PersonalAccessToken{},
+		&models.EmailToken{},
+		&models.Invite{},
+		&models.Role{},
+		&models.Impersonation{},
+		&models.AuditEntry{},
+		&models.UserDeletion{},
+	}
+}
+
+func Migrate() error {
+	slog.Info("Running database migrations")
+	// Checked before AutoMigrate adds the column, so the backfill below runs
+	// once and never verifies accounts registered afterwards.
+	backfillVerified := !DB.Migrator().HasColumn(&models.User{}, "verified_at")
+	backfillAudited := !DB.Migrator().HasColumn(&models.Activity{}, "audited_at")
+
+	err := DB.AutoMigrate(Models()...)
 	if err != nil {
 		return err
 	}
-	log.Println("Database migrations completed")
+
+	if err := migrateAdminFlags(); err != nil {
+		return err
+	}
+
+	// Accounts that predate email verification count as verified, so
+	// requiring it does not lock them out.
+	if backfillVerified {
+		if err := DB.Unscoped().Model(&models.User{}).Where("verified_at IS NULL").Update("verified_at", gorm.Expr("created_at")).Error; err != nil {
+			return err
+		}
+	}
+
+	// Activities recorded before the audit writer were either appended when
+	// they were created or predate the audit log, so none wait in the outbox.
+	if backfillAudited {
+		if err := DB.Model(&models.Activity{}).Where("audited_at IS NULL").Update("audited_at", gorm.Expr("created_at")).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := protectAuditLog(); err != nil {
+		return err
+	}
+
+	if err := DB.Exec("ALTER TABLE file_contents ADD COLUMN IF NOT EXISTS search_vector tsvector").Error; err != nil {
+		return err
+	}
+	if err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_file_contents_search_vector ON file_contents USING GIN (search_vector)").Error; err != nil {
+		return err
+	}
+	slog.Info("Database migrations completed")
+	return nil
+}
+
+// migrateAdminFlags converts the is_admin columns that predate roles into
+// the admin role and drops them, so it only ever runs once.
+func migrateAdminFlags() error {
+	for _, model := range []interface{}{&models.User{}, &models.Invite{}} {
+		if !DB.Migrator().HasColumn(model, "is_admin") {
+			continue
+		}
+		if err := DB.Unscoped().Model(model).Where("is_admin = true").Update("role", models.RoleAdmin).Error; err != nil {
+			return err
+		}
+		if err := DB.Migrator().DropColumn(model, "is_admin"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protectAuditLog makes audit_log append-only for the application's own
+// database user: rows cannot be deleted or truncated, and updates may only
+// pseudonymise personal data; recorded field changes can be cleared but
+// not rewritten. Anyone able to drop the trigger can still
+// rewrite the table, which the hash chain then exposes.
+func protectAuditLog() error {
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION audit_log_guard() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP <> 'UPDATE' THEN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END IF;
+	IF NEW.seq IS DISTINCT FROM OLD.seq
+		OR NEW.created_at IS DISTINCT FROM OLD.created_at
+		OR NEW.action IS DISTINCT FROM OLD.action
+		OR NEW.activity_id IS DISTINCT FROM OLD.activity_id
+		OR NEW.actor_id IS DISTINCT FROM OLD.actor_id
+		OR NEW.subject_id IS DISTINCT FROM OLD.subject_id
+		OR NEW.file_id IS DISTINCT FROM OLD.file_id
+		OR NEW.source IS DISTINCT FROM OLD.source
+		OR (NEW.changes IS DISTINCT FROM OLD.changes AND NEW.changes <> '')
+		OR NEW.pii_digest IS DISTINCT FROM OLD.pii_digest
+		OR NEW.prev_hash IS DISTINCT FROM OLD.prev_hash
+		OR NEW.hash IS DISTINCT FROM OLD.hash
+		OR NEW.pseudonymised_at IS NULL THEN
+		RAISE EXCEPTION 'audit_log entries can only be pseudonymised';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql`,
+		"DROP TRIGGER IF EXISTS audit_log_guard_rows ON audit_log",
+		"CREATE TRIGGER audit_log_guard_rows BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE FUNCTION audit_log_guard()",
+		"DROP TRIGGER IF EXISTS audit_log_guard_truncate ON audit_log",
+		"CREATE TRIGGER audit_log_guard_truncate BEFORE TRUNCATE ON audit_log FOR EACH STATEMENT EXECUTE FUNCTION audit_log_guard()",
+	}
+	for _, statement := range statements {
+		if err := DB.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,69 @@
+// Package dbtest gives tests an empty SQLite database in place of
+// PostgreSQL. It is imported only from _test.go files and needs cgo.
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"stratus/database"
+)
+
+const driverName = "sqlite3_stratus"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// Advisory locks serialise writers on PostgreSQL; SQLite already
+			// allows only one writer at a time. GREATEST is only needed for
+			// two integers.
+			if err := conn.RegisterFunc("pg_advisory_xact_lock", func(int64) int64 { return 0 }, true); err != nil {
+				return err
+			}
+			if err := conn.RegisterFunc("pg_try_advisory_xact_lock", func(int64) bool { return true }, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("greatest", func(a, b int64) int64 { return max(a, b) }, true)
+		},
+	})
+}
+
+// Open points database.DB at a fresh database with every table migrated
+// and restores the previous one when t ends. Statements specific to
+// PostgreSQL, such as ILIKE or FILTER, do not work on it.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	// A file rather than shared memory, so that a read outside of a running
+	// transaction does not fail with a table lock.
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", filepath.Join(t.TempDir(), "stratus.db"))
+	db, err := gorm.Open(sqlite.Dialector{DriverName: driverName, DSN: dsn}, &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+
+	if err := db.AutoMigrate(database.Models()...); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		sqlDB.Close()
+	})
+	return db
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	"stratus/telemetry"
+)
+
+const (
+	spanKey          = "stratus:span"
+	parentContextKey = "stratus:parent_context"
+)
+
+// tracingPlugin records a span for every statement run on a context that
+// is already part of a trace. Statements outside of one, such as those of
+// background workers, are not traced on their own.
+type tracingPlugin struct{}
+
+func (tracingPlugin) Name() string {
+	return "stratus:tracing"
+}
+
+func (tracingPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	registrations := []error{
+		callbacks.Create().Before("gorm:create").Register("stratus:before_create", startSpan("create")),
+		callbacks.Create().After("gorm:create").Register("stratus:after_create", endSpan),
+		callbacks.Query().Before("gorm:query").Register("stratus:before_query", startSpan("query")),
+		callbacks.Query().After("gorm:query").Register("stratus:after_query", endSpan),
+		callbacks.Update().Before("gorm:update").Register("stratus:before_update", startSpan("update")),
+		callbacks.Update().After("gorm:update").Register("stratus:after_update", endSpan),
+		callbacks.Delete().Before("gorm:delete").Register("stratus:before_delete", startSpan("delete")),
+		callbacks.Delete().After("gorm:delete").Register("stratus:after_delete", endSpan),
+		callbacks.Row().Before("gorm:row").Register("stratus:before_row", startSpan("row")),
+		callbacks.Row().After("gorm:row").Register("stratus:after_row", endSpan),
+		callbacks.Raw().Before("gorm:raw").Register("stratus:before_raw", startSpan("raw")),
+		callbacks.Raw().After("gorm:raw").Register("stratus:after_raw", endSpan),
+	}
+	return errors.Join(registrations...)
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+		db.InstanceSet(parentContextKey, ctx)
+		ctx, span := telemetry.Tracer.Start(ctx, "db."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+	// A query builder can be reused for several statements; each of them
+	// must start from the caller's span rather than this one.
+	if parent, ok := db.InstanceGet(parentContextKey); ok {
+		db.Statement.Context = parent.(context.Context)
+	}
+
+	// The SQL is recorded with placeholders so no values end up in traces.
+	span.SetAttributes(
+		semconv.DBQueryText(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if table := db.Statement.Table; table != "" {
+		span.SetAttributes(semconv.DBCollectionName(table))
+	}
+	if err := db.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
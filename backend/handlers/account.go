@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/models"
+	"stratus/services"
+)
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.accounts.MailEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Password reset by email is not available"})
+		return
+	}
+
+	if err := h.accounts.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this address, a reset link has been sent"})
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required,min=8"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.accounts.ResetPassword(req.Token, req.NewPassword)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidEmailToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityPasswordReset,
+		Details: "Reset through an emailed link",
+		Changes: models.ActivityChanges{"password": {}},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.accounts.VerifyEmail(req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidEmailToken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+		case errors.Is(err, services.ErrEmailTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		}
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityEmailVerified,
+		Changes: models.ActivityChanges{"email": {After: user.Email}},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified", "email": user.Email})
+}
+
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.accounts.MailEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email verification is not available"})
+		return
+	}
+
+	if err := h.accounts.ResendVerification(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address needs verifying, a new link has been sent"})
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+// recordActivity stores activity with the request's origin. Under
+// impersonation the admin becomes the actor. The change it describes has
+// already happened, so a failure is logged rather than returned.
+func recordActivity(c *gin.Context, activity models.Activity) {
+	recordActivityTx(c, db(c), activity)
+}
+
+func recordActivityTx(c *gin.Context, tx *gorm.DB, activity models.Activity) error {
+	origin := middleware.GetOrigin(c)
+	activity.Source = origin.Source
+	activity.IPAddress = origin.IPAddress
+	activity.UserAgent = origin.UserAgent
+	if impersonation := middleware.GetImpersonation(c); impersonation != nil && activity.ActorID == nil {
+		activity.ActorID = &impersonation.ImpersonatorID
+	}
+
+	err := tx.Create(&activity).Error
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to record activity", "type", activity.Type, "error", err)
+	}
+	return err
+}
+
+// FileActivity returns the history of one file, including events from
+// before it was deleted.
+func (h *FileHandler) FileActivity(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	listActivities(c, db(c).Where("user_id = ? AND file_id = ?", user.ID, fileID))
+}
+
+// MyActivity is the current user's activity feed.
+func (h *AuthHandler) MyActivity(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	query := db(c).Where("user_id = ?", user.ID)
+	if activityType := c.Query("type"); activityType != "" {
+		query = query.Where("type = ?", activityType)
+	}
+	listActivities(c, query)
+}
+
+// listActivities pages through query newest first. The next page starts
+// after the next_before cursor of the previous one.
+func listActivities(c *gin.Context, query *gorm.DB) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	if raw := c.Query("before"); raw != "" {
+		createdAt, id, err := services.DecodeCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before cursor"})
+			return
+		}
+		query = query.Where("(created_at, id) < (?, ?)", createdAt, id)
+	}
+
+	// Only identify the actor; the feed may show an admin to the user they
+	// impersonated.
+	query = query.Preload("Actor", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "email", "display_name")
+	})
+
+	var activities []models.Activity
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&activities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load activity"})
+		return
+	}
+
+	response := gin.H{"activities": activities}
+	if len(activities) > limit {
+		activities = activities[:limit]
+		response["activities"] = activities
+		last := activities[limit-1]
+		response["next_before"] = services.EncodeCursor(last.CreatedAt, last.ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// filePath is the full path of file, as shown in activity details.
+func filePath(file *models.File) string {
+	return path.Join(file.Path, file.Name)
+}
+
+// contentChanges describes a new version of a file's content.
+func contentChanges(before, after *models.File) models.ActivityChanges {
+	return models.ActivityChanges{
+		"version":  {Before: before.Version, After: after.Version},
+		"size":     {Before: before.Size, After: after.Size},
+		"checksum": {Before: before.Checksum, After: after.Checksum},
+	}
+}
+
+func recordCopy(c *gin.Context, user *models.User, source, duplicate *models.File) {
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFileCopied,
+		FileID:   &duplicate.ID,
+		FileName: duplicate.Name,
+		Details:  "Copied from " + filePath(source),
+		Changes:  models.ActivityChanges{"path": {Before: filePath(source), After: filePath(duplicate)}},
+	})
+}
+
+// recordDeletion keeps the file ID, so the deletion shows up in the
+// file's history.
+func recordDeletion(c *gin.Context, user *models.User, file *models.File, details string) {
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFileDeleted,
+		FileID:   &file.ID,
+		FileName: file.Name,
+		Details:  details,
+		Changes:  models.ActivityChanges{"path": {Before: filePath(file)}},
+	})
+}
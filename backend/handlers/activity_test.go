@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+type activityPage struct {
+	Activities []models.Activity `json:"activities"`
+	NextBefore string            `json:"next_before"`
+}
+
+func getActivity(t *testing.T, handler gin.HandlerFunc, user *models.User, id uuid.UUID, query string) activityPage {
+	t.Helper()
+	w := serve(handler, user, id, httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var page activityPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestEditRecordsFileActivity(t *testing.T) {
+	h := newTestFileHandler(t)
+	user := createTestUser(t, "editor@example.com")
+	file := createTestTextFile(t, h, user, "first draft\n")
+
+	if w := putContent(h, user, file, fileETag(file), "second draft\n"); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	page := getActivity(t, h.FileActivity, user, file.ID, "")
+	if len(page.Activities) != 1 {
+		t.Fatalf("%d activities, want 1", len(page.Activities))
+	}
+	activity := page.Activities[0]
+	if activity.Type != models.ActivityFileUpdated || activity.Source != models.ActivitySourceWeb {
+		t.Errorf("activity = %+v", activity)
+	}
+	if version := activity.Changes["version"]; version.Before != float64(1) || version.After != float64(2) {
+		t.Errorf("version change = %+v, want 1 -> 2", version)
+	}
+
+	other := createTestUser(t, "other@example.com")
+	if page := getActivity(t, h.FileActivity, other, file.ID, ""); len(page.Activities) != 0 {
+		t.Errorf("another user sees %d activities of the file", len(page.Activities))
+	}
+}
+
+func TestMyActivityPaging(t *testing.T) {
+	newTestFileHandler(t)
+	h := &AuthHandler{}
+	user := createTestUser(t, "user@example.com")
+	other := createTestUser(t, "other@example.com")
+
+	// Pairs of activities share a timestamp, so a page can end between them.
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		activityType := models.ActivityUserLogin
+		if i == 2 {
+			activityType = models.ActivityPasswordChanged
+		}
+		database.DB.Create(&models.Activity{UserID: user.ID, Type: activityType, CreatedAt: start.Add(time.Duration(i/2) * time.Minute)})
+	}
+	database.DB.Create(&models.Activity{UserID: other.ID, Type: models.ActivityUserLogin})
+
+	var seen []models.Activity
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatal("paging does not end")
+		}
+		page := getActivity(t, h.MyActivity, user, uuid.Nil, query)
+		for _, activity := range page.Activities {
+			seen = append(seen, activity)
+		}
+		if page.NextBefore == "" {
+			break
+		}
+		query = "limit=2&before=" + page.NextBefore
+	}
+	if len(seen) != 5 {
+		t.Fatalf("paged through %d activities, want 5", len(seen))
+	}
+	ids := make(map[uuid.UUID]bool)
+	for i, activity := range seen {
+		if ids[activity.ID] {
+			t.Errorf("activity %d was listed twice", i)
+		}
+		ids[activity.ID] = true
+		if i > 0 && activity.CreatedAt.After(seen[i-1].CreatedAt) {
+			t.Errorf("activity %d is newer than the one before it", i)
+		}
+	}
+
+	page := getActivity(t, h.MyActivity, user, uuid.Nil, "type=password_changed")
+	if len(page.Activities) != 1 || page.Activities[0].Type != models.ActivityPasswordChanged {
+		t.Errorf("type filter returned %+v", page.Activities)
+	}
+}
+
+func TestRecordActivityUnderImpersonation(t *testing.T) {
+	newTestFileHandler(t)
+	user := createTestUser(t, "user@example.com")
+	admin := createTestUser(t, "admin@example.com")
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	c.Set("source", models.ActivitySourceAPI)
+	c.Set("impersonation", &models.Impersonation{ImpersonatorID: admin.ID, TargetID: user.ID})
+	recordActivity(c, models.Activity{UserID: user.ID, Type: models.ActivityProfileUpdated})
+
+	var activity models.Activity
+	if err := database.DB.First(&activity, "user_id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if activity.ActorID == nil || *activity.ActorID != admin.ID || activity.Source != models.ActivitySourceAPI {
+		t.Errorf("activity = %+v, want the admin as actor and the api source", activity)
+	}
+}
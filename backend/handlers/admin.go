@@ -1,28 +1,58 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 
 	"stratus/config"
-	"stratus/database"
 	"stratus/middleware"
 	"stratus/models"
+	"stratus/services"
 )
 
 type AdminHandler struct {
-	config *config.Config
+	config         *config.Config
+	settings       *services.SettingsService
+	tokens         *services.TokenService
+	limiter        *services.LoginLimiter
+	invites        *services.InviteService
+	roles          *services.RoleService
+	impersonations *services.ImpersonationService
+	audit          *services.AuditService
+	activities     *services.ActivityService
+	deletions      *services.DeletionService
+	exports        *services.ExportService
+	transfers      *services.TransferService
+	imports        *services.UserImportService
 }
 
-func NewAdminHandler(cfg *config.Config) *AdminHandler {
-	return &AdminHandler{config: cfg}
+func NewAdminHandler(cfg *config.Config, settings *services.SettingsService, tokens *services.TokenService, limiter *services.LoginLimiter, invites *services.InviteService, roles *services.RoleService, impersonations *services.ImpersonationService, audit *services.AuditService, activities *services.ActivityService, deletions *services.DeletionService, exports *services.ExportService, transfers *services.TransferService, imports *services.UserImportService) *AdminHandler {
+	return &AdminHandler{
+		config:         cfg,
+		settings:       settings,
+		tokens:         tokens,
+		limiter:        limiter,
+		invites:        invites,
+		roles:          roles,
+		impersonations: impersonations,
+		audit:          audit,
+		activities:     activities,
+		deletions:      deletions,
+		exports:        exports,
+		transfers:      transfers,
+		imports:        imports,
+	}
 }
 
 func (h *AdminHandler) ListUsers(c *gin.Context) {
 	var users []models.User
-	database.DB.Order("created_at DESC").Find(&users)
+	db(c).Order("created_at DESC").Find(&users)
 	c.JSON(http.StatusOK, users)
 }
 
@@ -34,7 +64,7 @@ func (h *AdminHandler) GetUser(c *gin.Context) {
 	}
 
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
+	if err := db(c).First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -50,16 +80,22 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 	}
 
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
+	if err := db(c).First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
+	currentUser := middleware.GetCurrentUser(c)
+	if !h.roles.Outranks(currentUser, &user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot modify a user with more permissions than you"})
+		return
+	}
+
 	var req struct {
 		Email    string `json:"email"`
 		Quota    *int64 `json:"quota"`
 		IsActive *bool  `json:"is_active"`
-		IsAdmin  *bool  `json:"is_admin"`
+		Verified *bool  `json:"email_verified"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -77,17 +113,54 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 	if req.IsActive != nil {
 		updates["is_active"] = *req.IsActive
 	}
-	if req.IsAdmin != nil {
-		currentUser := middleware.GetCurrentUser(c)
-		if currentUser.ID == user.ID && !*req.IsAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot remove your own admin status"})
-			return
+	if req.Verified != nil {
+		if *req.Verified {
+			updates["verified_at"] = time.Now()
+		} else {
+			updates["verified_at"] = nil
 		}
-		updates["is_admin"] = *req.IsAdmin
 	}
 
-	database.DB.Model(&user).Updates(updates)
-	database.DB.First(&user, userID)
+	previous := user
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		switch {
+		case req.IsActive == nil:
+		case *req.IsActive:
+			if err := services.EnsureNotBeingDeleted(tx, user.ID); err != nil {
+				return err
+			}
+		default:
+			if err := services.KeepAdministrator(tx, &user); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.First(&user, userID).Error
+	})
+	if errors.Is(err, services.ErrLastAdministrator) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot deactivate the last administrator"})
+		return
+	}
+	if errors.Is(err, services.ErrDeletionPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is being deleted; cancel the deletion to restore the account"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	if changes := userChanges(&previous, &user); len(changes) > 0 {
+		recordActivity(c, models.Activity{
+			UserID:  user.ID,
+			ActorID: &currentUser.ID,
+			Type:    models.ActivityUserUpdated,
+			Details: "Updated by " + currentUser.Email,
+			Changes: changes,
+		})
+	}
 
 	c.JSON(http.StatusOK, user)
 }
@@ -106,30 +179,41 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	}
 
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
+	if err := db(c).First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	database.DB.Where("owner_id = ?", userID).Delete(&models.File{})
-	database.DB.Where("user_id = ?", userID).Delete(&models.Activity{})
-	database.DB.Delete(&user)
+	if !h.roles.Outranks(currentUser, &user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete a user with more permissions than you"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+	deletion, err := h.deletions.Schedule(currentUser, &user, middleware.GetOrigin(c))
+	if errors.Is(err, services.ErrDeletionPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already being deleted"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, deletion)
 }
 
 func (h *AdminHandler) SystemStats(c *gin.Context) {
 	var userCount int64
-	database.DB.Model(&models.User{}).Count(&userCount)
+	db(c).Model(&models.User{}).Count(&userCount)
 
 	var fileCount int64
-	database.DB.Model(&models.File{}).Where("is_directory = false").Count(&fileCount)
+	db(c).Model(&models.File{}).Where("is_directory = false").Count(&fileCount)
 
 	var folderCount int64
-	database.DB.Model(&models.File{}).Where("is_directory = true").Count(&folderCount)
+	db(c).Model(&models.File{}).Where("is_directory = true").Count(&folderCount)
 
 	var totalSize int64
-	database.DB.Model(&models.File{}).
+	db(c).Model(&models.File{}).
 		Where("is_directory = false").
 		Select("COALESCE(SUM(size), 0)").
 		Scan(&totalSize)
@@ -145,12 +229,50 @@ func (h *AdminHandler) SystemStats(c *gin.Context) {
 	})
 }
 
-func (h *AdminHandler) ListActivities(c *gin.Context) {
-	var activities []models.Activity
-	database.DB.Preload("User").
-		Order("created_at DESC").
-		Limit(100).
-		Find(&activities)
+func (h *AdminHandler) GetSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, h.settings.Get())
+}
 
-	c.JSON(http.StatusOK, activities)
+func (h *AdminHandler) UpdateSettings(c *gin.Context) {
+	var patch map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous := h.settings.Get()
+	settings, err := h.settings.Update(patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if changes := previous.Changes(settings); len(changes) > 0 {
+		recordActivity(c, models.Activity{
+			UserID:  middleware.GetCurrentUser(c).ID,
+			Type:    models.ActivitySettingsUpdated,
+			Changes: changes,
+		})
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// userChanges lists the admin-editable fields that differ between before
+// and after.
+func userChanges(before, after *models.User) models.ActivityChanges {
+	changes := make(models.ActivityChanges)
+	if before.Email != after.Email {
+		changes["email"] = models.ActivityChange{Before: before.Email, After: after.Email}
+	}
+	if before.Quota != after.Quota {
+		changes["quota"] = models.ActivityChange{Before: before.Quota, After: after.Quota}
+	}
+	if before.IsActive != after.IsActive {
+		changes["is_active"] = models.ActivityChange{Before: before.IsActive, After: after.IsActive}
+	}
+	if (before.VerifiedAt == nil) != (after.VerifiedAt == nil) {
+		changes["email_verified"] = models.ActivityChange{Before: before.VerifiedAt != nil, After: after.VerifiedAt != nil}
+	}
+	return changes
 }
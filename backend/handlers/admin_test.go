@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+	"stratus/services"
+)
+
+func newTestAdminHandler(t *testing.T) *AdminHandler {
+	t.Helper()
+	dbtest.Open(t)
+	roles := services.NewRoleService()
+	if err := roles.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return &AdminHandler{roles: roles}
+}
+
+func createTestAdmin(t *testing.T, email string) *models.User {
+	t.Helper()
+	user := createTestUser(t, email)
+	if err := database.DB.Model(user).Update("role", models.RoleAdmin).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func updateUser(h *AdminHandler, actor, target *models.User, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return serve(h.UpdateUser, actor, target.ID, req)
+}
+
+func TestUpdateUserKeepsLastAdministrator(t *testing.T) {
+	h := newTestAdminHandler(t)
+	admin := createTestAdmin(t, "admin@example.com")
+
+	if w := updateUser(h, admin, admin, `{"is_active": false}`); w.Code != http.StatusForbidden {
+		t.Fatalf("deactivating the only admin: status %d, want 403", w.Code)
+	}
+	var reloaded models.User
+	database.DB.First(&reloaded, "id = ?", admin.ID)
+	if !reloaded.IsActive {
+		t.Fatal("the only admin was deactivated")
+	}
+
+	second := createTestAdmin(t, "second@example.com")
+	if w := updateUser(h, admin, second, `{"is_active": false}`); w.Code != http.StatusOK {
+		t.Fatalf("deactivating one of two admins: status %d: %s", w.Code, w.Body)
+	}
+	if w := updateUser(h, admin, admin, `{"is_active": false}`); w.Code != http.StatusForbidden {
+		t.Errorf("deactivating the remaining admin: status %d, want 403", w.Code)
+	}
+}
+
+func TestUpdateUserReportsFailedUpdates(t *testing.T) {
+	h := newTestAdminHandler(t)
+	admin := createTestAdmin(t, "admin@example.com")
+	user := createTestUser(t, "user@example.com")
+
+	// The address belongs to another account, so the unique index rejects it.
+	if w := updateUser(h, admin, user, `{"email": "admin@example.com"}`); w.Code != http.StatusInternalServerError {
+		t.Errorf("update violating a unique index: status %d, want 500", w.Code)
+	}
+	var activities int64
+	database.DB.Model(&models.Activity{}).Where("user_id = ? AND type = ?", user.ID, models.ActivityUserUpdated).Count(&activities)
+	if activities != 0 {
+		t.Errorf("%d update activities recorded for a failed update", activities)
+	}
+}
+
+func TestUpdateUserCannotReactivateUserBeingDeleted(t *testing.T) {
+	h := newTestAdminHandler(t)
+	admin := createTestAdmin(t, "admin@example.com")
+	user := createTestUser(t, "leaver@example.com")
+
+	cfg := &config.Config{UserDeletionGracePeriod: 24 * time.Hour}
+	h.deletions = services.NewDeletionService(cfg, nil, nil, nil)
+	if _, err := h.deletions.Schedule(admin, user, models.ActivityOrigin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := updateUser(h, admin, user, `{"is_active": true}`); w.Code != http.StatusConflict {
+		t.Fatalf("reactivating a user being deleted: status %d, want 409", w.Code)
+	}
+	var reloaded models.User
+	database.DB.First(&reloaded, "id = ?", user.ID)
+	if reloaded.IsActive {
+		t.Fatal("user being deleted was reactivated")
+	}
+
+	if err := h.deletions.Cancel(admin, user, models.ActivityOrigin{}); err != nil {
+		t.Fatal(err)
+	}
+	if w := updateUser(h, admin, user, `{"is_active": true}`); w.Code != http.StatusOK {
+		t.Errorf("reactivating after the deletion was cancelled: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestExportUserDataRequiresOutranking(t *testing.T) {
+	h := newTestAdminHandler(t)
+	admin := createTestAdmin(t, "admin@example.com")
+	manager := createTestUser(t, "manager@example.com")
+	database.DB.Model(manager).Update("role", models.RoleUserManager)
+
+	w := serve(h.ExportUserData, manager, admin.ID, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("manager exporting an admin's data: status %d, want 403", w.Code)
+	}
+	var exports int64
+	database.DB.Model(&models.Activity{}).Where("type = ?", models.ActivityUserDataExported).Count(&exports)
+	if exports != 0 {
+		t.Error("a refused export was recorded as done")
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/services"
+)
+
+// activityFilter reads the filter shared by the admin activity endpoints.
+// Dates may be RFC 3339 timestamps or plain days; a plain ?to day is
+// included in the range.
+func activityFilter(c *gin.Context) (services.ActivityFilter, error) {
+	filter := services.ActivityFilter{
+		Source:    c.Query("source"),
+		IPAddress: c.Query("ip"),
+		Query:     strings.TrimSpace(c.Query("q")),
+	}
+
+	for param, target := range map[string]**uuid.UUID{"user_id": &filter.UserID, "actor_id": &filter.ActorID, "file_id": &filter.FileID} {
+		if raw := c.Query(param); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s", param)
+			}
+			*target = &id
+		}
+	}
+
+	for _, activityType := range strings.Split(c.Query("type"), ",") {
+		if activityType = strings.TrimSpace(activityType); activityType != "" {
+			filter.Types = append(filter.Types, activityType)
+		}
+	}
+
+	var err error
+	if filter.From, err = parseActivityTime(c.Query("from"), 0); err != nil {
+		return filter, errors.New("invalid from date")
+	}
+	if filter.To, err = parseActivityTime(c.Query("to"), 24*time.Hour); err != nil {
+		return filter, errors.New("invalid to date")
+	}
+	return filter, nil
+}
+
+func parseActivityTime(raw string, dayOffset time.Duration) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(dayOffset), nil
+}
+
+func (h *AdminHandler) ListActivities(c *gin.Context) {
+	filter, err := activityFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	activities, next, err := h.activities.Query(filter, c.Query("cursor"), limit)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load activity"})
+		return
+	}
+
+	response := gin.H{"activities": activities}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AdminHandler) ActivityStats(c *gin.Context) {
+	filter, err := activityFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := h.activities.Stats(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count activity"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// ExportActivities streams every matching activity, oldest first, as CSV
+// or, with ?format=jsonl, as JSON lines.
+func (h *AdminHandler) ExportActivities(c *gin.Context) {
+	filter, err := activityFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	export := h.activities.ExportCSV
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case "csv":
+	case "jsonl":
+		export = h.activities.ExportJSON
+		contentType = "application/x-ndjson"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be csv or jsonl"})
+		return
+	}
+
+	filename := fmt.Sprintf("activities-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", contentDisposition("attachment", filename))
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the stream short.
+	if err := export(c.Writer, filter); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Activity export failed", "error", err)
+	}
+}
+
+func (h *AdminHandler) ListActivityArchives(c *gin.Context) {
+	archives, err := h.activities.Archives()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list archives"})
+		return
+	}
+	c.JSON(http.StatusOK, archives)
+}
+
+func (h *AdminHandler) DownloadActivityArchive(c *gin.Context) {
+	name := c.Param("name")
+	path, err := h.activities.ArchivePath(name)
+	if errors.Is(err, services.ErrActivityArchiveName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid archive name"})
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Archive not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open archive"})
+		return
+	}
+
+	c.Header("Content-Disposition", contentDisposition("attachment", name))
+	c.Header("Content-Type", "application/gzip")
+	c.File(path)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AdminHandler) VerifyAuditLog(c *gin.Context) {
+	result, err := h.audit.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ExportAuditLog streams the audit log as JSON lines, optionally starting
+// at the ?from sequence number.
+func (h *AdminHandler) ExportAuditLog(c *gin.Context) {
+	var fromSeq int64
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sequence number"})
+			return
+		}
+		fromSeq = parsed
+	}
+
+	filename := fmt.Sprintf("audit-%s.jsonl", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the stream short.
+	if err := h.audit.Export(c.Writer, fromSeq); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Audit log export failed", "error", err)
+	}
+}
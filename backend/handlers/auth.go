@@ -1,42 +1,92 @@
 package handlers
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 
 	"stratus/config"
-	"stratus/database"
 	"stratus/middleware"
 	"stratus/models"
+	"stratus/services"
 )
 
 type AuthHandler struct {
-	config *config.Config
+	config         *config.Config
+	tokens         *services.TokenService
+	mfa            *services.MFAService
+	appPasswords   *services.AppPasswordService
+	settings       *services.SettingsService
+	oidc           *services.OIDCService
+	auth           *services.AuthService
+	accounts       *services.AccountService
+	limiter        *services.LoginLimiter
+	invites        *services.InviteService
+	roles          *services.RoleService
+	impersonations *services.ImpersonationService
+	exports        *services.ExportService
 }
 
-func NewAuthHandler(cfg *config.Config) *AuthHandler {
-	return &AuthHandler{config: cfg}
+func NewAuthHandler(cfg *config.Config, auth *services.AuthService, tokens *services.TokenService, mfa *services.MFAService, appPasswords *services.AppPasswordService, settings *services.SettingsService, oidc *services.OIDCService, accounts *services.AccountService, limiter *services.LoginLimiter, invites *services.InviteService, roles *services.RoleService, impersonations *services.ImpersonationService, exports *services.ExportService) *AuthHandler {
+	return &AuthHandler{
+		config:         cfg,
+		auth:           auth,
+		accounts:       accounts,
+		limiter:        limiter,
+		invites:        invites,
+		roles:          roles,
+		impersonations: impersonations,
+		tokens:         tokens,
+		mfa:            mfa,
+		appPasswords:   appPasswords,
+		settings:       settings,
+		oidc:           oidc,
+		exports:        exports,
+	}
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required,min=8"`
+	InviteCode string `json:"invite_code"`
 }
 
+// LoginRequest.Email also accepts a directory login name when LDAP is
+// configured.
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
+	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
 
 type TokenResponse struct {
-	AccessToken string      `json:"access_token"`
-	TokenType   string      `json:"token_type"`
-	ExpiresIn   int64       `json:"expires_in"`
-	User        models.User `json:"user"`
+	AccessToken  string       `json:"access_token"`
+	RefreshToken string       `json:"refresh_token"`
+	TokenType    string       `json:"token_type"`
+	ExpiresIn    int64        `json:"expires_in"`
+	User         UserResponse `json:"user"`
+}
+
+// UserResponse adds the permissions granted by the user's role, which the
+// frontend uses to decide which admin pages to show.
+type UserResponse struct {
+	models.User
+	Permissions   []models.Permission   `json:"permissions"`
+	Impersonation *models.Impersonation `json:"impersonation,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -46,9 +96,24 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	settings := h.settings.Get()
+	switch {
+	case settings.RegistrationMode == services.RegistrationClosed:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is closed"})
+		return
+	case req.InviteCode != "":
+		// Checked when the invite is redeemed below.
+	case settings.RegistrationMode == services.RegistrationInvite:
+		c.JSON(http.StatusForbidden, gin.H{"error": "An invite code is required to register"})
+		return
+	case settings.RegistrationMode == services.RegistrationDomains && !settings.AllowsEmailDomain(req.Email):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is restricted to approved email domains"})
+		return
+	}
+
 	var existingUser models.User
 
-	if err := database.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := db(c).Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
 		return
 	}
@@ -62,39 +127,68 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	if err := database.DB.Create(&user).Error; err != nil {
+	var err error
+	if req.InviteCode != "" {
+		err = h.invites.Register(req.InviteCode, &user)
+	} else {
+		err = services.CreateUser(&user)
+	}
+	if errors.Is(err, services.ErrInvalidInvite) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invite code"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	rootFolder := models.File{
-		Name:        "root",
-		Path:        "/",
-		IsDirectory: true,
-		OwnerID:     user.ID,
-		StoragePath: user.ID.String(),
+	details := "Registered"
+	if req.InviteCode != "" {
+		details = "Registered with an invite"
 	}
-	database.DB.Create(&rootFolder)
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityUserRegistered,
+		Details: details,
+		Changes: models.ActivityChanges{"email": {After: user.Email}, "role": {After: user.Role}},
+	})
 
-	activity := models.Activity{
-		UserID:    user.ID,
-		Type:      models.ActivityUserLogin,
-		IPAddress: c.ClientIP(),
-		UserAgent: c.GetHeader("User-Agent"),
+	if h.accounts.MailEnabled() {
+		if err := h.accounts.SendVerification(&user); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to send verification email", "error", err)
+		}
 	}
-	database.DB.Create(&activity)
 
-	token, expiresIn, err := h.generateToken(user.ID)
+	if h.verificationRequired(&user) {
+		c.JSON(http.StatusCreated, gin.H{
+			"message":                     "Check your inbox to verify your email address",
+			"email_verification_required": true,
+			"user":                        user,
+		})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID: user.ID,
+		Type:   models.ActivityUserLogin,
+	})
+
+	pair, err := h.tokens.CreateSession(user.ID, c.ClientIP(), c.GetHeader("User-Agent"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, TokenResponse{
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   expiresIn,
-		User:        user,
+	c.JSON(http.StatusCreated, h.tokenResponse(pair, user))
+}
+
+// RegistrationConfig tells the sign-up page which fields to show.
+func (h *AuthHandler) RegistrationConfig(c *gin.Context) {
+	mode := h.settings.Get().RegistrationMode
+	c.JSON(http.StatusOK, gin.H{
+		"mode":            mode,
+		"enabled":         mode != services.RegistrationClosed,
+		"invite_required": mode == services.RegistrationInvite,
 	})
 }
 
@@ -105,54 +199,160 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	release, wait, ok := h.limiter.Check(c.ClientIP(), req.Email)
+	if !ok {
+		tooManyAttempts(c, wait)
 		return
 	}
+	defer release()
 
-	if !user.CheckPassword(req.Password) {
+	authenticated, err := h.auth.Authenticate(req.Email, req.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		h.limiter.Failure(middleware.GetOrigin(c), req.Email, "Invalid password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if errors.Is(err, services.ErrLDAPAccountConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A local account already uses this email address"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication service unavailable"})
+		return
+	}
+	user := *authenticated
 
 	if !user.IsActive {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
 		return
 	}
 
-	activity := models.Activity{
-		UserID:    user.ID,
-		Type:      models.ActivityUserLogin,
-		IPAddress: c.ClientIP(),
-		UserAgent: c.GetHeader("User-Agent"),
+	if h.verificationRequired(&user) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                       "Email address not verified",
+			"email_verification_required": true,
+		})
+		return
 	}
-	database.DB.Create(&activity)
 
-	token, expiresIn, err := h.generateToken(user.ID)
+	if user.TOTPEnabled {
+		mfaToken, expiresIn, err := h.tokens.GenerateMFAToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   expiresIn,
+		})
+		return
+	}
+
+	h.limiter.Success(req.Email)
+	h.completeLogin(c, user)
+}
+
+func (h *AuthHandler) completeLogin(c *gin.Context, user models.User) {
+	recordActivity(c, models.Activity{
+		UserID: user.ID,
+		Type:   models.ActivityUserLogin,
+	})
+
+	pair, err := h.tokens.CreateSession(user.ID, c.ClientIP(), c.GetHeader("User-Agent"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, TokenResponse{
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   expiresIn,
-		User:        user,
+	c.JSON(http.StatusOK, h.tokenResponse(pair, user))
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, userID, err := h.tokens.Refresh(req.RefreshToken, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err == services.ErrRefreshTokenReused {
+		recordActivity(c, models.Activity{
+			UserID:  userID,
+			Type:    models.ActivitySessionRevoked,
+			Details: "Refresh token reuse detected, session revoked",
+		})
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	var user models.User
+	if err := db(c).First(&user, "id = ?", userID).Error; err != nil || !user.IsActive {
+		h.tokens.RevokeSession(pair.SessionID, "user_disabled")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found or disabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.tokenResponse(pair, user))
+}
+
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	currentID := middleware.GetSessionID(c)
+
+	sessions, err := h.tokens.ActiveSessions(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{Session: session, Current: session.ID == currentID}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	var session models.Session
+	if err := db(c).Where("id = ? AND user_id = ?", sessionID, user.ID).First(&session).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := h.tokens.RevokeSession(session.ID, "user_revoked"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivitySessionRevoked,
+		Details: "Revoked session from " + session.IPAddress,
 	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
 }
 
 func (h *AuthHandler) Logout(c *gin.Context) {
+	h.tokens.RevokeSession(middleware.GetSessionID(c), "logout")
+
 	user := middleware.GetCurrentUser(c)
 	if user != nil {
-		activity := models.Activity{
-			UserID:    user.ID,
-			Type:      models.ActivityUserLogout,
-			IPAddress: c.ClientIP(),
-			UserAgent: c.GetHeader("User-Agent"),
-		}
-		database.DB.Create(&activity)
+		recordActivity(c, models.Activity{
+			UserID: user.ID,
+			Type:   models.ActivityUserLogout,
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
@@ -164,7 +364,9 @@ func (h *AuthHandler) Me(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
-	c.JSON(http.StatusOK, user)
+	response := h.userResponse(user)
+	response.Impersonation = middleware.GetImpersonation(c)
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *AuthHandler) UpdateProfile(c *gin.Context) {
@@ -183,18 +385,49 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	if req.Email != "" && user.AuthSource == models.AuthSourceLDAP {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is managed by the directory"})
+		return
+	}
+
 	updates := make(map[string]interface{})
-	if req.Email != "" {
+	if req.Email != "" && !strings.EqualFold(req.Email, user.Email) {
+		if h.accounts.MailEnabled() {
+			err := h.accounts.RequestEmailChange(user, req.Email)
+			if errors.Is(err, services.ErrEmailTaken) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+				return
+			}
+			c.JSON(http.StatusAccepted, gin.H{
+				"message":       "Check your new inbox to confirm the change",
+				"pending_email": req.Email,
+				"user":          user,
+			})
+			return
+		}
 		updates["email"] = req.Email
+		updates["verified_at"] = nil
 	}
 
-	if err := database.DB.Model(user).Updates(updates).Error; err != nil {
+	previousEmail := user.Email
+	if err := db(c).Model(user).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
 		return
 	}
 
-	database.DB.First(user, user.ID)
-	c.JSON(http.StatusOK, user)
+	db(c).First(user, user.ID)
+	if len(updates) > 0 {
+		recordActivity(c, models.Activity{
+			UserID:  user.ID,
+			Type:    models.ActivityProfileUpdated,
+			Changes: models.ActivityChanges{"email": {Before: previousEmail, After: user.Email}},
+		})
+	}
+	c.JSON(http.StatusOK, h.userResponse(user))
 }
 
 func (h *AuthHandler) ChangePassword(c *gin.Context) {
@@ -214,6 +447,11 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	if user.AuthSource == models.AuthSourceLDAP {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is managed by the directory"})
+		return
+	}
+
 	if !user.CheckPassword(req.CurrentPassword) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
 		return
@@ -224,29 +462,60 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	if err := database.DB.Model(user).Update("password_hash", user.PasswordHash).Error; err != nil {
+	if err := db(c).Model(user).Update("password_hash", user.PasswordHash).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
 
+	h.tokens.RevokeUserSessions(user.ID, middleware.GetSessionID(c), "password_changed")
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityPasswordChanged,
+		Details: "Other sessions were signed out",
+		Changes: models.ActivityChanges{"password": {}},
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 }
 
-func (h *AuthHandler) generateToken(userID uuid.UUID) (string, int64, error) {
-	expiresIn := int64(24 * 60 * 60)
-	claims := &middleware.Claims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// verificationRequired reports whether user must verify their address
+// before signing in. Directory and service accounts are exempt, and the
+// policy only applies when mail can actually be delivered.
+func (h *AuthHandler) verificationRequired(user *models.User) bool {
+	return h.settings.Get().RequireEmailVerified && h.accounts.MailEnabled() &&
+		user.VerifiedAt == nil && user.AuthSource == models.AuthSourceLocal && !user.IsService
+}
+
+// verifyPassword re-checks the password of a signed-in user against
+// whichever provider manages the account.
+func (h *AuthHandler) verifyPassword(user *models.User, password string) bool {
+	if user.AuthSource != models.AuthSourceLDAP {
+		return user.CheckPassword(password)
 	}
+	authenticated, err := h.auth.Authenticate(user.Email, password)
+	return err == nil && authenticated.ID == user.ID
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(h.config.JWTSecret))
-	if err != nil {
-		return "", 0, err
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, try again later",
+		"retry_after": seconds,
+	})
+}
+
+func (h *AuthHandler) tokenResponse(pair *services.TokenPair, user models.User) TokenResponse {
+	return TokenResponse{
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    pair.ExpiresIn,
+		User:         h.userResponse(&user),
 	}
+}
 
-	return signedToken, expiresIn, nil
+func (h *AuthHandler) userResponse(user *models.User) UserResponse {
+	return UserResponse{User: *user, Permissions: h.roles.RoleOf(user).Permissions}
 }
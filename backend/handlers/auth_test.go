@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/services"
+)
+
+func newTestAuthHandler(t *testing.T) *AuthHandler {
+	t.Helper()
+	dbtest.Open(t)
+	cfg := &config.Config{
+		PublicURL:               "http://localhost",
+		JWTAlgorithm:            "HS256",
+		JWTSecret:               "test-secret",
+		AccessTTL:               time.Minute,
+		RefreshTTL:              time.Hour,
+		LoginWindow:             time.Minute,
+		LoginMaxAccountFailures: 10,
+		LoginMaxIPFailures:      50,
+		LoginLockout:            time.Minute,
+		LoginDelayAfter:         3,
+		LoginMaxDelay:           time.Second,
+	}
+	tokens, err := services.NewTokenService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := services.NewMailer(cfg)
+	roles := services.NewRoleService()
+	return NewAuthHandler(
+		cfg,
+		services.NewAuthService(services.LocalAuthProvider{}),
+		tokens,
+		services.NewMFAService(cfg),
+		services.NewAppPasswordService(),
+		services.NewSettingsService(),
+		services.NewOIDCService(cfg, tokens),
+		services.NewAccountService(cfg, mailer, tokens),
+		services.NewLoginLimiter(cfg),
+		services.NewInviteService(cfg, mailer),
+		roles,
+		services.NewImpersonationService(cfg, tokens, roles),
+		services.NewExportService(),
+	)
+}
+
+func postJSON(handler gin.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(data)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+func setSetting(t *testing.T, settings *services.SettingsService, key, value string) {
+	t.Helper()
+	if _, err := settings.Update(map[string]json.RawMessage{key: json.RawMessage(value)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistrationModes(t *testing.T) {
+	h := newTestAuthHandler(t)
+	register := func(email, invite string) int {
+		return postJSON(h.Register, gin.H{"email": email, "password": "long enough", "invite_code": invite}).Code
+	}
+
+	if got := register("open@example.com", ""); got != http.StatusCreated {
+		t.Errorf("open registration: status %d, want 201", got)
+	}
+	if got := register("open@example.com", ""); got != http.StatusConflict {
+		t.Errorf("existing address: status %d, want 409", got)
+	}
+
+	setSetting(t, h.settings, "registration_mode", `"domains"`)
+	setSetting(t, h.settings, "registration_domains", `["example.com"]`)
+	if got := register("staff@example.com", ""); got != http.StatusCreated {
+		t.Errorf("allowed domain: status %d, want 201", got)
+	}
+	if got := register("staff@sub.example.com", ""); got != http.StatusForbidden {
+		t.Errorf("other domain: status %d, want 403", got)
+	}
+
+	admin := createTestUser(t, "admin@example.com")
+	_, code, err := h.invites.Create(admin, "", "", nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setSetting(t, h.settings, "registration_mode", `"invite"`)
+	if got := register("uninvited@example.com", ""); got != http.StatusForbidden {
+		t.Errorf("invite mode without code: status %d, want 403", got)
+	}
+	if got := register("guest@elsewhere.org", "wrong"); got != http.StatusBadRequest {
+		t.Errorf("invalid invite code: status %d, want 400", got)
+	}
+
+	setSetting(t, h.settings, "registration_mode", `"closed"`)
+	if got := register("guest@elsewhere.org", code); got != http.StatusForbidden {
+		t.Errorf("closed registration with an invite: status %d, want 403", got)
+	}
+
+	// An invite bypasses the domain allowlist.
+	setSetting(t, h.settings, "registration_mode", `"domains"`)
+	if got := register("guest@elsewhere.org", code); got != http.StatusCreated {
+		t.Errorf("invite from another domain: status %d, want 201", got)
+	}
+}
+
+func TestMFAChangesRequirePasswordAndAreLimited(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := createTestUser(t, "user@example.com")
+	if err := user.SetPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Save(user)
+	if _, _, err := h.mfa.BeginEnrollment(user); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := h.mfa.Enable(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.First(user, "id = ?", user.ID)
+
+	post := func(handler gin.HandlerFunc, body gin.H) int {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(data)))
+		req.Header.Set("Content-Type", "application/json")
+		return serve(handler, user, uuid.Nil, req).Code
+	}
+
+	// A recovery code alone does not disable 2FA or mint new codes.
+	if code := post(h.DisableMFA, gin.H{"recovery_code": codes[0]}); code != http.StatusBadRequest {
+		t.Errorf("disable without password: status %d, want 400", code)
+	}
+	if code := post(h.RegenerateRecoveryCodes, gin.H{"password": "wrong", "code": "000000"}); code != http.StatusUnauthorized {
+		t.Errorf("regenerate with the wrong password: status %d, want 401", code)
+	}
+	if code := post(h.DisableMFA, gin.H{"password": "wrong", "recovery_code": codes[0]}); code != http.StatusUnauthorized {
+		t.Errorf("disable with the wrong password: status %d, want 401", code)
+	}
+	if h.mfa.RemainingRecoveryCodes(user.ID) != int64(len(codes)) {
+		t.Error("a recovery code was used up by a request with the wrong password")
+	}
+
+	// Failures count towards the login limit.
+	limited := false
+	for i := 0; i < 10 && !limited; i++ {
+		limited = post(h.RegenerateRecoveryCodes, gin.H{"password": "correct horse", "code": "000000"}) == http.StatusTooManyRequests
+	}
+	if !limited {
+		t.Fatal("repeated wrong codes were never limited")
+	}
+	if code := post(h.DisableMFA, gin.H{"password": "correct horse", "recovery_code": codes[0]}); code != http.StatusTooManyRequests {
+		t.Errorf("disable while limited: status %d, want 429", code)
+	}
+
+	h.limiter.Success(user.Email)
+	if code := post(h.DisableMFA, gin.H{"password": "correct horse", "recovery_code": codes[0]}); code != http.StatusOK {
+		t.Errorf("disable with password and recovery code: status %d, want 200", code)
+	}
+}
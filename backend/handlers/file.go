@@ -1,16 +1,22 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/pmezard/go-difflib/difflib"
+	"gorm.io/gorm"
 
 	"stratus/config"
-	"stratus/database"
 	"stratus/middleware"
 	"stratus/models"
 	"stratus/services"
@@ -19,12 +25,14 @@ import (
 type FileHandler struct {
 	config  *config.Config
 	storage *services.StorageService
+	content *services.ContentPipeline
 }
 
-func NewFileHandler(cfg *config.Config, storage *services.StorageService) *FileHandler {
+func NewFileHandler(cfg *config.Config, storage *services.StorageService, content *services.ContentPipeline) *FileHandler {
 	return &FileHandler{
 		config:  cfg,
 		storage: storage,
+		content: content,
 	}
 }
 
@@ -45,11 +53,11 @@ func (h *FileHandler) List(c *gin.Context) {
 
 	var files []models.File
 
-	database.DB.Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, path).
+	db(c).Preload("Metadata").Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, path).
 		Order("is_directory DESC, name ASC").Find(&files)
 
 	var totalCount int64
-	database.DB.Model(&models.File{}).Where("owner_id = ? AND is_trashed = false", user.ID).Count(&totalCount)
+	db(c).Model(&models.File{}).Where("owner_id = ? AND is_trashed = false", user.ID).Count(&totalCount)
 
 	c.JSON(http.StatusOK, gin.H{
 		"files":       files,
@@ -67,7 +75,7 @@ func (h *FileHandler) Get(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Preload("Metadata").Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
@@ -84,7 +92,7 @@ func (h *FileHandler) GetContents(c *gin.Context) {
 	}
 
 	var folder models.File
-	if err := database.DB.Where("id = ? AND owner_id = ? AND is_directory = true", folderID, user.ID).First(&folder).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ? AND is_directory = true", folderID, user.ID).First(&folder).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
 		return
 	}
@@ -97,7 +105,7 @@ func (h *FileHandler) GetContents(c *gin.Context) {
 	}
 
 	var files []models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, folderPath).
+	if err := db(c).Preload("Metadata").Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, folderPath).
 		Order("is_directory DESC, name ASC").
 		Find(&files).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contents"})
@@ -109,6 +117,9 @@ func (h *FileHandler) GetContents(c *gin.Context) {
 
 func (h *FileHandler) Upload(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
+	var stored int64
+	done := trackUpload(c)
+	defer func() { done(stored) }()
 
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
@@ -133,7 +144,7 @@ func (h *FileHandler) Upload(c *gin.Context) {
 	if parentIDStr != "" {
 		if parsedID, err := uuid.Parse(parentIDStr); err == nil {
 			var parent models.File
-			if err := database.DB.Where("id = ? AND owner_id = ? AND is_directory = true", parsedID, user.ID).First(&parent).Error; err == nil {
+			if err := db(c).Where("id = ? AND owner_id = ? AND is_directory = true", parsedID, user.ID).First(&parent).Error; err == nil {
 				if parent.Path == "/" {
 					parentPath = "/" + parent.Name
 				} else {
@@ -143,23 +154,36 @@ func (h *FileHandler) Upload(c *gin.Context) {
 		}
 	}
 
-	storagePath, size, checksum, err := h.storage.SaveFile(user.ID, file, header.Filename)
+	saved, err := h.storage.SaveFile(c.Request.Context(), user.ID, file, header.Filename)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
 	}
+	stored = saved.Size
 
 	var existingFile models.File
-	err = database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, header.Filename).First(&existingFile).Error
+	err = db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, header.Filename).First(&existingFile).Error
 
 	if err == nil {
-		h.storage.CreateFileVersion(&existingFile)
+		h.storage.CreateFileVersion(db(c), &existingFile)
 
-		existingFile.Size = size
-		existingFile.StoragePath = storagePath
-		existingFile.Checksum = checksum
+		previous := existingFile
+		existingFile.Size = saved.Size
+		existingFile.StoragePath = saved.StoragePath
+		existingFile.Checksum = saved.Checksum
+		existingFile.MimeType = saved.MimeType
 		existingFile.Version++
-		database.DB.Save(&existingFile)
+		db(c).Save(&existingFile)
+		h.content.FileChanged(existingFile.ID)
+
+		recordActivity(c, models.Activity{
+			UserID:   user.ID,
+			Type:     models.ActivityFileUpdated,
+			FileID:   &existingFile.ID,
+			FileName: existingFile.Name,
+			Details:  fmt.Sprintf("Uploaded version %d", existingFile.Version),
+			Changes:  contentChanges(&previous, &existingFile),
+		})
 
 		c.JSON(http.StatusOK, existingFile)
 		return
@@ -168,29 +192,30 @@ func (h *FileHandler) Upload(c *gin.Context) {
 	newFile := models.File{
 		Name:        header.Filename,
 		Path:        parentPath,
-		StoragePath: storagePath,
-		MimeType:    h.storage.GetMimeType(header.Filename),
-		Size:        size,
+		StoragePath: saved.StoragePath,
+		MimeType:    saved.MimeType,
+		Size:        saved.Size,
 		IsDirectory: false,
 		OwnerID:     user.ID,
-		Checksum:    checksum,
+		Checksum:    saved.Checksum,
 	}
 
-	if err := database.DB.Create(&newFile).Error; err != nil {
-		h.storage.DeleteFile(storagePath)
+	if err := db(c).Create(&newFile).Error; err != nil {
+		h.storage.DeleteFile(c.Request.Context(), saved.StoragePath)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file record"})
 		return
 	}
 
-	database.DB.Model(user).Update("used_space", user.UsedSpace+size)
+	db(c).Model(user).Update("used_space", user.UsedSpace+saved.Size)
+	h.content.FileChanged(newFile.ID)
 
-	activity := models.Activity{
+	recordActivity(c, models.Activity{
 		UserID:   user.ID,
 		Type:     models.ActivityFileCreated,
 		FileID:   &newFile.ID,
 		FileName: newFile.Name,
-	}
-	database.DB.Create(&activity)
+		Changes:  models.ActivityChanges{"path": {After: filePath(&newFile)}, "size": {After: newFile.Size}},
+	})
 
 	c.JSON(http.StatusCreated, newFile)
 }
@@ -204,24 +229,307 @@ func (h *FileHandler) Download(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ? AND is_directory = false", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ? AND is_directory = false", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
 
-	activity := models.Activity{
+	recordActivity(c, models.Activity{
 		UserID:   user.ID,
 		Type:     models.ActivityFileDownloaded,
 		FileID:   &file.ID,
 		FileName: file.Name,
+	})
+
+	serveContentHeaders(c, "attachment", file.Name, file.MimeType)
+	sendFile(c, file.StoragePath)
+}
+
+func (h *FileHandler) Thumbnail(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	sizeName := c.DefaultQuery("size", "medium")
+	size, ok := services.ThumbnailSizes[sizeName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid thumbnail size"})
+		return
+	}
+
+	var file models.File
+	if err := db(c).Where("id = ? AND owner_id = ? AND is_directory = false", fileID, user.ID).First(&file).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	etag := fmt.Sprintf("\"%s-%s\"", file.Checksum, sizeName)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	path, err := h.content.Thumbnails.Get(c.Request.Context(), &file, size)
+	switch {
+	case errors.Is(err, services.ErrThumbnailUnsupported):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Thumbnail not available for this file"})
+		return
+	case errors.Is(err, services.ErrThumbnailTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large to thumbnail"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate thumbnail"})
+		return
 	}
-	database.DB.Create(&activity)
 
-	c.Header("Content-Disposition", "attachment; filename="+file.Name)
-	c.Header("Content-Type", file.MimeType)
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("ETag", etag)
+	c.File(path)
+}
+
+func (h *FileHandler) GetContent(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	file, ok := h.editableFile(c, user)
+	if !ok {
+		return
+	}
+
+	c.Header("ETag", fileETag(&file))
+	serveContentHeaders(c, "inline", file.Name, "text/plain; charset=utf-8")
 	c.File(file.StoragePath)
 }
 
+// errEditConflict aborts an edit whose file changed after it was read.
+var errEditConflict = errors.New("file was modified")
+
+func (h *FileHandler) UpdateContent(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	file, ok := h.editableFile(c, user)
+	if !ok {
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header required"})
+		return
+	}
+	if ifMatch != fileETag(&file) {
+		c.Header("ETag", fileETag(&file))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "File was modified by someone else", "version": file.Version})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, h.config.MaxEditSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if int64(len(body)) > h.config.MaxEditSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large to edit"})
+		return
+	}
+
+	delta := int64(len(body)) - file.Size
+	if delta > 0 && !user.HasEnoughSpace(delta) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Storage quota exceeded"})
+		return
+	}
+
+	saved, err := h.storage.SaveFile(c.Request.Context(), user.ID, bytes.NewReader(body), file.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	// The version and the new content are saved together, so a failure
+	// cannot leave the previous content without a version pointing at it.
+	previous := file
+	err = db(c).Transaction(func(tx *gorm.DB) error {
+		if err := h.storage.CreateFileVersion(tx, &previous); err != nil {
+			return err
+		}
+		result := tx.Model(&models.File{}).
+			Where("id = ? AND version = ? AND checksum = ?", file.ID, file.Version, file.Checksum).
+			Updates(map[string]interface{}{
+				"size":         saved.Size,
+				"storage_path": saved.StoragePath,
+				"checksum":     saved.Checksum,
+				"mime_type":    saved.MimeType,
+				"version":      file.Version + 1,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errEditConflict
+		}
+		return tx.Model(user).Update("used_space", gorm.Expr("used_space + ?", delta)).Error
+	})
+	if err != nil {
+		h.storage.DeleteFile(c.Request.Context(), saved.StoragePath)
+		if !errors.Is(err, errEditConflict) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file"})
+			return
+		}
+		db(c).First(&file, file.ID)
+		c.Header("ETag", fileETag(&file))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "File was modified by someone else", "version": file.Version})
+		return
+	}
+
+	h.content.FileChanged(file.ID)
+
+	db(c).First(&file, file.ID)
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFileUpdated,
+		FileID:   &file.ID,
+		FileName: file.Name,
+		Details:  fmt.Sprintf("Edited in browser (version %d)", file.Version),
+		Changes:  contentChanges(&previous, &file),
+	})
+
+	c.Header("ETag", fileETag(&file))
+	c.JSON(http.StatusOK, file)
+}
+
+func (h *FileHandler) ListVersions(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var file models.File
+	if err := db(c).Where("id = ? AND owner_id = ? AND is_directory = false", fileID, user.ID).First(&file).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	var versions []models.FileVersion
+	db(c).Where("file_id = ?", file.ID).Order("version DESC").Find(&versions)
+
+	c.JSON(http.StatusOK, gin.H{
+		"current_version": file.Version,
+		"versions":        versions,
+	})
+}
+
+func (h *FileHandler) Diff(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	file, ok := h.editableFile(c, user)
+	if !ok {
+		return
+	}
+
+	to := file.Version
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to version"})
+			return
+		}
+		to = parsed
+	}
+	from := to - 1
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from version"})
+			return
+		}
+		from = parsed
+	}
+
+	fromText, err := h.readVersionText(c, &file, from)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Version %d not found", from)})
+		return
+	}
+	toText, err := h.readVersionText(c, &file, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Version %d not found", to)})
+		return
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromText),
+		B:        difflib.SplitLines(toText),
+		FromFile: fmt.Sprintf("%s (version %d)", file.Name, from),
+		ToFile:   fmt.Sprintf("%s (version %d)", file.Name, to),
+		Context:  3,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute diff"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from": from,
+		"to":   to,
+		"diff": diff,
+	})
+}
+
+func (h *FileHandler) editableFile(c *gin.Context, user *models.User) (models.File, bool) {
+	var file models.File
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return file, false
+	}
+
+	if err := db(c).Where("id = ? AND owner_id = ? AND is_directory = false AND is_trashed = false", fileID, user.ID).First(&file).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return file, false
+	}
+
+	if !services.IsTextType(strings.ToLower(filepath.Ext(file.Name)), file.MimeType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only text files can be edited"})
+		return file, false
+	}
+	if file.Size > h.config.MaxEditSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large to edit"})
+		return file, false
+	}
+
+	return file, true
+}
+
+func (h *FileHandler) readVersionText(c *gin.Context, file *models.File, version int) (string, error) {
+	storagePath := file.StoragePath
+	if version != file.Version {
+		var fv models.FileVersion
+		if err := db(c).Where("file_id = ? AND version = ?", file.ID, version).First(&fv).Error; err != nil {
+			return "", err
+		}
+		storagePath = fv.StoragePath
+	}
+
+	f, err := h.storage.GetFile(c.Request.Context(), storagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, h.config.MaxEditSize))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func fileETag(file *models.File) string {
+	return "\"" + file.Checksum + "\""
+}
+
 func (h *FileHandler) CreateFolder(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 
@@ -242,7 +550,7 @@ func (h *FileHandler) CreateFolder(c *gin.Context) {
 	}
 
 	var existingFolder models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_directory = true AND is_trashed = false", user.ID, parentPath, req.Name).First(&existingFolder).Error; err == nil {
+	if err := db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_directory = true AND is_trashed = false", user.ID, parentPath, req.Name).First(&existingFolder).Error; err == nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "Folder already exists"})
 		return
 	}
@@ -255,18 +563,18 @@ func (h *FileHandler) CreateFolder(c *gin.Context) {
 		StoragePath: filepath.Join(user.ID.String(), uuid.New().String()),
 	}
 
-	if err := database.DB.Create(&folder).Error; err != nil {
+	if err := db(c).Create(&folder).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
 		return
 	}
 
-	activity := models.Activity{
+	recordActivity(c, models.Activity{
 		UserID:   user.ID,
 		Type:     models.ActivityFolderCreated,
 		FileID:   &folder.ID,
 		FileName: folder.Name,
-	}
-	database.DB.Create(&activity)
+		Changes:  models.ActivityChanges{"path": {After: filePath(&folder)}},
+	})
 
 	c.JSON(http.StatusCreated, folder)
 }
@@ -288,13 +596,26 @@ func (h *FileHandler) Rename(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
 
+	previousName := file.Name
 	file.Name = req.Name
-	database.DB.Save(&file)
+	db(c).Save(&file)
+	if !file.IsDirectory {
+		h.content.FileRenamed(file.ID)
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFileRenamed,
+		FileID:   &file.ID,
+		FileName: file.Name,
+		Details:  "Renamed from " + previousName,
+		Changes:  models.ActivityChanges{"name": {Before: previousName, After: file.Name}},
+	})
 
 	c.JSON(http.StatusOK, file)
 }
@@ -316,7 +637,7 @@ func (h *FileHandler) Move(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
@@ -326,17 +647,18 @@ func (h *FileHandler) Move(c *gin.Context) {
 		newPath = "/"
 	}
 
+	previousPath := file.Path
 	file.Path = newPath
-	database.DB.Save(&file)
+	db(c).Save(&file)
 
-	activity := models.Activity{
+	recordActivity(c, models.Activity{
 		UserID:   user.ID,
 		Type:     models.ActivityFileMoved,
 		FileID:   &file.ID,
 		FileName: file.Name,
 		Details:  "Moved to " + newPath,
-	}
-	database.DB.Create(&activity)
+		Changes:  models.ActivityChanges{"path": {Before: previousPath, After: newPath}},
+	})
 
 	c.JSON(http.StatusOK, file)
 }
@@ -359,7 +681,7 @@ func (h *FileHandler) Copy(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ? AND is_directory = false", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ? AND is_directory = false", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
@@ -375,7 +697,7 @@ func (h *FileHandler) Copy(c *gin.Context) {
 	}
 
 	newStoragePath := filepath.Join(h.storage.GetUserStoragePath(user.ID), uuid.New().String()+filepath.Ext(file.Name))
-	if err := h.storage.CopyFile(file.StoragePath, newStoragePath); err != nil {
+	if err := h.storage.CopyFile(c.Request.Context(), file.StoragePath, newStoragePath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy file"})
 		return
 	}
@@ -396,13 +718,16 @@ func (h *FileHandler) Copy(c *gin.Context) {
 		Checksum:    file.Checksum,
 	}
 
-	if err := database.DB.Create(&newFile).Error; err != nil {
-		h.storage.DeleteFile(newStoragePath)
+	if err := db(c).Create(&newFile).Error; err != nil {
+		h.storage.DeleteFile(c.Request.Context(), newStoragePath)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file record"})
 		return
 	}
 
-	database.DB.Model(user).Update("used_space", user.UsedSpace+file.Size)
+	db(c).Model(user).Update("used_space", user.UsedSpace+file.Size)
+	h.content.FileChanged(newFile.ID)
+
+	recordCopy(c, user, &file, &newFile)
 
 	c.JSON(http.StatusCreated, newFile)
 }
@@ -416,7 +741,7 @@ func (h *FileHandler) Trash(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
@@ -424,7 +749,15 @@ func (h *FileHandler) Trash(c *gin.Context) {
 	now := time.Now()
 	file.IsTrashed = true
 	file.TrashedAt = &now
-	database.DB.Save(&file)
+	db(c).Save(&file)
+
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFileTrashed,
+		FileID:   &file.ID,
+		FileName: file.Name,
+		Changes:  models.ActivityChanges{"trashed": {Before: false, After: true}},
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "File moved to trash"})
 }
@@ -438,14 +771,22 @@ func (h *FileHandler) Restore(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ? AND is_trashed = true", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ? AND is_trashed = true", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found in trash"})
 		return
 	}
 
 	file.IsTrashed = false
 	file.TrashedAt = nil
-	database.DB.Save(&file)
+	db(c).Save(&file)
+
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFileRestored,
+		FileID:   &file.ID,
+		FileName: file.Name,
+		Changes:  models.ActivityChanges{"trashed": {Before: true, After: false}},
+	})
 
 	c.JSON(http.StatusOK, file)
 }
@@ -459,27 +800,23 @@ func (h *FileHandler) Delete(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
+	if err := db(c).Where("id = ? AND owner_id = ?", fileID, user.ID).First(&file).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
 
 	if !file.IsDirectory {
-		h.storage.DeleteFile(file.StoragePath)
+		h.storage.DeleteFile(c.Request.Context(), file.StoragePath)
 
-		database.DB.Model(user).Update("used_space", user.UsedSpace-file.Size)
+		db(c).Model(user).Update("used_space", user.UsedSpace-file.Size)
 	}
 
-	database.DB.Where("file_id = ?", file.ID).Delete(&models.FileVersion{})
+	db(c).Where("file_id = ?", file.ID).Delete(&models.FileVersion{})
+	h.content.FileRemoved(file.ID)
 
-	database.DB.Delete(&file)
+	db(c).Delete(&file)
 
-	activity := models.Activity{
-		UserID:   user.ID,
-		Type:     models.ActivityFileDeleted,
-		FileName: file.Name,
-	}
-	database.DB.Create(&activity)
+	recordDeletion(c, user, &file, "Deleted permanently")
 
 	c.JSON(http.StatusOK, gin.H{"message": "File deleted permanently"})
 }
@@ -488,7 +825,7 @@ func (h *FileHandler) ListTrash(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 
 	var files []models.File
-	database.DB.Where("owner_id = ? AND is_trashed = true", user.ID).
+	db(c).Where("owner_id = ? AND is_trashed = true", user.ID).
 		Order("trashed_at DESC").
 		Find(&files)
 
@@ -499,19 +836,27 @@ func (h *FileHandler) EmptyTrash(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 
 	var files []models.File
-	database.DB.Where("owner_id = ? AND is_trashed = true", user.ID).Find(&files)
+	db(c).Where("owner_id = ? AND is_trashed = true", user.ID).Find(&files)
 
 	var freedSpace int64
 	for _, file := range files {
 		if !file.IsDirectory {
-			h.storage.DeleteFile(file.StoragePath)
+			h.storage.DeleteFile(c.Request.Context(), file.StoragePath)
 			freedSpace += file.Size
 		}
-		database.DB.Where("file_id = ?", file.ID).Delete(&models.FileVersion{})
-		database.DB.Delete(&file)
+		db(c).Where("file_id = ?", file.ID).Delete(&models.FileVersion{})
+		h.content.FileRemoved(file.ID)
+		db(c).Delete(&file)
+		recordDeletion(c, user, &file, "Deleted when the trash was emptied")
 	}
 
-	database.DB.Model(user).Update("used_space", user.UsedSpace-freedSpace)
+	db(c).Model(user).Update("used_space", user.UsedSpace-freedSpace)
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityTrashEmptied,
+		Details: fmt.Sprintf("%d items deleted, %d bytes freed", len(files), freedSpace),
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Trash emptied"})
 }
@@ -525,23 +870,70 @@ func (h *FileHandler) Search(c *gin.Context) {
 	}
 
 	var files []models.File
-	database.DB.Where("owner_id = ? AND is_trashed = false AND name ILIKE ?", user.ID, "%"+query+"%").
+	db(c).Where("owner_id = ? AND is_trashed = false AND name ILIKE ?", user.ID, "%"+query+"%").
 		Order("is_directory DESC, name ASC").
 		Find(&files)
 
 	c.JSON(http.StatusOK, files)
 }
 
+func (h *FileHandler) SearchContent(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query required"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	results, err := h.content.Search.Search(user.ID, query, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search file contents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func (h *FileHandler) PhotoTimeline(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "200"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 200
+	}
+
+	groups, next, err := h.content.Metadata.Timeline(user.ID, c.Query("before"), limit)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before cursor"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load timeline"})
+		return
+	}
+
+	response := gin.H{"groups": groups}
+	if next != "" {
+		response["next_before"] = next
+	}
+	c.JSON(http.StatusOK, response)
+}
+
 func (h *FileHandler) StorageStats(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 
 	usedSpace, _ := h.storage.GetStorageUsage(user.ID)
 
 	var fileCount int64
-	database.DB.Model(&models.File{}).Where("owner_id = ? AND is_directory = false AND is_trashed = false", user.ID).Count(&fileCount)
+	db(c).Model(&models.File{}).Where("owner_id = ? AND is_directory = false AND is_trashed = false", user.ID).Count(&fileCount)
 
 	var folderCount int64
-	database.DB.Model(&models.File{}).Where("owner_id = ? AND is_directory = true AND is_trashed = false", user.ID).Count(&folderCount)
+	db(c).Model(&models.File{}).Where("owner_id = ? AND is_directory = true AND is_trashed = false", user.ID).Count(&folderCount)
 
 	c.JSON(http.StatusOK, gin.H{
 		"used_space":   usedSpace,
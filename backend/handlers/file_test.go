@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+	"stratus/services"
+)
+
+func newTestFileHandler(t *testing.T) *FileHandler {
+	t.Helper()
+	dbtest.Open(t)
+	cfg := &config.Config{StoragePath: t.TempDir(), MaxEditSize: 1024}
+	pipeline := services.NewContentPipeline(
+		services.NewSearchService(cfg),
+		services.NewMetadataService(cfg),
+		services.NewThumbnailService(cfg),
+	)
+	return NewFileHandler(cfg, services.NewStorageService(cfg), pipeline)
+}
+
+func createTestUser(t *testing.T, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, PasswordHash: "x", Quota: 1 << 20, IsActive: true}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func createTestTextFile(t *testing.T, h *FileHandler, owner *models.User, content string) *models.File {
+	t.Helper()
+	saved, err := h.storage.SaveFile(context.Background(), owner.ID, strings.NewReader(content), "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &models.File{
+		Name:        "notes.txt",
+		Path:        "/notes.txt",
+		OwnerID:     owner.ID,
+		MimeType:    "text/plain",
+		Size:        saved.Size,
+		StoragePath: saved.StoragePath,
+		Checksum:    saved.Checksum,
+		Version:     1,
+	}
+	if err := database.DB.Create(file).Error; err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// serve runs handler on a request made by user, with id as the :id param.
+func serve(handler gin.HandlerFunc, user *models.User, id uuid.UUID, req *http.Request) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "id", Value: id.String()}}
+	c.Set("user", user)
+	handler(c)
+	return w
+}
+
+func putContent(h *FileHandler, user *models.User, file *models.File, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	return serve(h.UpdateContent, user, file.ID, req)
+}
+
+func TestUpdateContentPreconditions(t *testing.T) {
+	h := newTestFileHandler(t)
+	user := createTestUser(t, "editor@example.com")
+	file := createTestTextFile(t, h, user, "first draft\n")
+	etag := fileETag(file)
+
+	if w := putContent(h, user, file, "", "second draft\n"); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("without If-Match: status %d, want 428", w.Code)
+	}
+
+	w := putContent(h, user, file, `"stale"`, "second draft\n")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: status %d, want 412", w.Code)
+	}
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Errorf("412 response ETag = %q, want the current %q", got, etag)
+	}
+
+	var unchanged models.File
+	database.DB.First(&unchanged, file.ID)
+	if unchanged.Version != 1 || unchanged.Checksum != file.Checksum {
+		t.Errorf("rejected edits changed the file: %+v", unchanged)
+	}
+}
+
+func TestUpdateContentCreatesVersion(t *testing.T) {
+	h := newTestFileHandler(t)
+	user := createTestUser(t, "editor@example.com")
+	file := createTestTextFile(t, h, user, "first draft\n")
+
+	w := putContent(h, user, file, fileETag(file), "second draft\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var updated models.File
+	database.DB.First(&updated, file.ID)
+	if updated.Version != 2 || updated.Checksum == file.Checksum {
+		t.Errorf("file after edit: version %d, checksum %s", updated.Version, updated.Checksum)
+	}
+	if got := w.Header().Get("ETag"); got != fileETag(&updated) {
+		t.Errorf("ETag = %q, want %q", got, fileETag(&updated))
+	}
+
+	var versions []models.FileVersion
+	database.DB.Where("file_id = ?", file.ID).Find(&versions)
+	if len(versions) != 1 || versions[0].Version != 1 || versions[0].Checksum != file.Checksum {
+		t.Errorf("versions after edit: %+v", versions)
+	}
+
+	// The ETag of the replaced content no longer matches.
+	if w := putContent(h, user, file, fileETag(file), "third draft\n"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("edit based on version 1: status %d, want 412", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?from=1&to=2", nil)
+	w = serve(h.Diff, user, file.ID, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `-first draft\n+second draft`) {
+		t.Errorf("diff: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUpdateContentKeepsVersionWithContent(t *testing.T) {
+	h := newTestFileHandler(t)
+	user := createTestUser(t, "editor@example.com")
+	file := createTestTextFile(t, h, user, "first draft\n")
+
+	// The type follows the new content rather than what was stored.
+	database.DB.Model(file).Update("mime_type", "application/octet-stream")
+	if w := putContent(h, user, file, fileETag(file), "second draft\n"); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var updated models.File
+	database.DB.First(&updated, file.ID)
+	if !strings.HasPrefix(updated.MimeType, "text/plain") {
+		t.Errorf("mime type after edit = %q, want text/plain", updated.MimeType)
+	}
+
+	// Without a version for the replaced content, the edit does not apply.
+	if err := database.DB.Migrator().DropTable(&models.FileVersion{}); err != nil {
+		t.Fatal(err)
+	}
+	if w := putContent(h, user, &updated, fileETag(&updated), "third draft\n"); w.Code != http.StatusInternalServerError {
+		t.Errorf("edit without a version: status %d, want 500", w.Code)
+	}
+	var unchanged models.File
+	database.DB.First(&unchanged, file.ID)
+	if unchanged.Version != updated.Version || unchanged.Checksum != updated.Checksum || unchanged.Size != updated.Size {
+		t.Errorf("failed edit changed the file: %+v", unchanged)
+	}
+}
+
+func TestUpdateContentLimits(t *testing.T) {
+	h := newTestFileHandler(t)
+	user := createTestUser(t, "editor@example.com")
+	file := createTestTextFile(t, h, user, "short\n")
+
+	if w := putContent(h, user, file, fileETag(file), strings.Repeat("x", 1025)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over MaxEditSize: status %d, want 413", w.Code)
+	}
+
+	database.DB.Model(user).Update("used_space", user.Quota)
+	user.UsedSpace = user.Quota
+	if w := putContent(h, user, file, fileETag(file), "a longer text\n"); w.Code != http.StatusForbidden {
+		t.Errorf("growing a file over quota: status %d, want 403", w.Code)
+	}
+
+	other := createTestUser(t, "other@example.com")
+	if w := putContent(h, other, file, fileETag(file), "hijack\n"); w.Code != http.StatusNotFound {
+		t.Errorf("editing another user's file: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+type ImpersonateRequest struct {
+	Reason          string `json:"reason" binding:"required,max=500"`
+	ReadOnly        bool   `json:"read_only"`
+	DurationMinutes int    `json:"duration_minutes" binding:"min=0"`
+}
+
+func (h *AdminHandler) StartImpersonation(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var target models.User
+	if err := db(c).First(&target, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	impersonation, token, expiresIn, err := h.impersonations.Start(
+		middleware.GetCurrentUser(c), &target, req.Reason, req.ReadOnly,
+		time.Duration(req.DurationMinutes)*time.Minute, middleware.GetOrigin(c),
+	)
+	switch {
+	case errors.Is(err, services.ErrImpersonationNotAllowed):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrRoleEscalation):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot impersonate a user with more permissions than you"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"access_token":  token,
+		"token_type":    "Bearer",
+		"expires_in":    expiresIn,
+		"impersonation": impersonation,
+		"user":          target,
+	})
+}
+
+func (h *AdminHandler) ListImpersonations(c *gin.Context) {
+	var target *uuid.UUID
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		target = &id
+	}
+
+	impersonations, err := h.impersonations.List(target, 200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list impersonations"})
+		return
+	}
+	c.JSON(http.StatusOK, impersonations)
+}
+
+func (h *AdminHandler) EndImpersonation(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid impersonation ID"})
+		return
+	}
+	endImpersonation(c, h.impersonations, id)
+}
+
+// MyImpersonations shows users when and by whom they were impersonated.
+func (h *AuthHandler) MyImpersonations(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	impersonations, err := h.impersonations.List(&user.ID, 100)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list impersonations"})
+		return
+	}
+	c.JSON(http.StatusOK, impersonations)
+}
+
+// EndCurrentImpersonation lets an admin leave an impersonation with the
+// impersonation token itself.
+func (h *AuthHandler) EndCurrentImpersonation(c *gin.Context) {
+	impersonation := middleware.GetImpersonation(c)
+	if impersonation == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not impersonating"})
+		return
+	}
+	endImpersonation(c, h.impersonations, impersonation.ID)
+}
+
+func endImpersonation(c *gin.Context, impersonations *services.ImpersonationService, id uuid.UUID) {
+	var endedBy models.User
+	if impersonation := middleware.GetImpersonation(c); impersonation != nil {
+		db(c).First(&endedBy, "id = ?", impersonation.ImpersonatorID)
+	} else {
+		endedBy = *middleware.GetCurrentUser(c)
+	}
+
+	err := impersonations.End(id, &endedBy, middleware.GetOrigin(c))
+	if errors.Is(err, services.ErrImpersonationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation not found or already ended"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end impersonation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+}
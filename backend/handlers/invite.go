@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+type CreateInviteRequest struct {
+	Email     string     `json:"email" binding:"omitempty,email"`
+	Note      string     `json:"note" binding:"max=255"`
+	Quota     *int64     `json:"quota"`
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (h *AdminHandler) ListInvites(c *gin.Context) {
+	invites, err := h.invites.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invites"})
+		return
+	}
+	c.JSON(http.StatusOK, invites)
+}
+
+func (h *AdminHandler) CreateInvite(c *gin.Context) {
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	currentUser := middleware.GetCurrentUser(c)
+	if !h.grantable(c, currentUser, req.Role) {
+		return
+	}
+
+	invite, code, err := h.invites.Create(currentUser, req.Email, req.Note, req.Quota, req.Role, req.ExpiresAt)
+	if errors.Is(err, services.ErrInvalidInviteRequest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  currentUser.ID,
+		Type:    models.ActivityInviteCreated,
+		Details: fmt.Sprintf("Invite %s for %s", invite.Hint, inviteRecipient(invite)),
+		Changes: models.ActivityChanges{"role": {After: invite.Role}, "expires_at": {After: invite.ExpiresAt}},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invite": invite,
+		"code":   code,
+		"link":   h.invites.Link(code),
+	})
+}
+
+func (h *AdminHandler) RevokeInvite(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+
+	err = h.invites.Revoke(id)
+	if errors.Is(err, services.ErrInviteNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  middleware.GetCurrentUser(c).ID,
+		Type:    models.ActivityInviteRevoked,
+		Details: "Revoked invite " + id.String(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked"})
+}
+
+func inviteRecipient(invite *models.Invite) string {
+	if invite.Email == "" {
+		return "anyone with the link"
+	}
+	return invite.Email
+}
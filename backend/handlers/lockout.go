@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/middleware"
+	"stratus/models"
+)
+
+// ListLockouts returns every IP and account with recent failed logins,
+// locked ones first.
+func (h *AdminHandler) ListLockouts(c *gin.Context) {
+	c.JSON(http.StatusOK, h.limiter.Lockouts())
+}
+
+func (h *AdminHandler) ClearLockout(c *gin.Context) {
+	if !h.limiter.Clear(c.Param("key")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lockout not found"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  middleware.GetCurrentUser(c).ID,
+		Type:    models.ActivityLockoutCleared,
+		Details: "Cleared " + c.Param("key"),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Lockout cleared"})
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.tokens.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	var user models.User
+	if err := db(c).First(&user, "id = ?", userID).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	release, wait, ok := h.limiter.Check(c.ClientIP(), user.Email)
+	if !ok {
+		tooManyAttempts(c, wait)
+		return
+	}
+	defer release()
+
+	if !h.mfa.Verify(&user, req.Code, req.RecoveryCode) {
+		h.limiter.Failure(middleware.GetOrigin(c), user.Email, "Invalid verification code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+		return
+	}
+
+	h.limiter.Success(user.Email)
+	h.completeLogin(c, user)
+}
+
+func (h *AuthHandler) MFAStatus(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	response := gin.H{
+		"enabled":  user.TOTPEnabled,
+		"required": h.settings.Get().RequiresMFA(user),
+	}
+	if user.TOTPEnabled {
+		response["recovery_codes_remaining"] = h.mfa.RemainingRecoveryCodes(user.ID)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AuthHandler) SetupMFA(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, uri, err := h.mfa.BeginEnrollment(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+func (h *AuthHandler) EnableMFA(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment before enabling"})
+		return
+	}
+
+	if !h.mfa.VerifyCode(user, req.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+		return
+	}
+
+	codes, err := h.mfa.Enable(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID: user.ID,
+		Type:   models.ActivityMFAEnabled,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	var req struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if h.settings.Get().RequiresMFA(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for this account"})
+		return
+	}
+
+	if !h.confirmMFAChange(c, user, req.Password, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	if err := h.mfa.Disable(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID: user.ID,
+		Type:   models.ActivityMFADisabled,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !h.confirmMFAChange(c, user, req.Password, req.Code, "") {
+		return
+	}
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID: user.ID,
+		Type:   models.ActivityRecoveryCodesReset,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// confirmMFAChange re-checks the password and a second factor before the
+// user's 2FA settings change. Failures count towards the same limit as
+// logins, so a stolen session cannot be used to guess codes.
+func (h *AuthHandler) confirmMFAChange(c *gin.Context, user *models.User, password, code, recoveryCode string) bool {
+	release, wait, ok := h.limiter.Check(c.ClientIP(), user.Email)
+	if !ok {
+		tooManyAttempts(c, wait)
+		return false
+	}
+	defer release()
+
+	if !h.verifyPassword(user, password) || !h.mfa.Verify(user, code, recoveryCode) {
+		h.limiter.Failure(middleware.GetOrigin(c), user.Email, "Invalid password or verification code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or verification code"})
+		return false
+	}
+
+	h.limiter.Success(user.Email)
+	return true
+}
+
+func (h *AuthHandler) ListAppPasswords(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	appPasswords, err := h.appPasswords.List(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list app passwords"})
+		return
+	}
+
+	c.JSON(http.StatusOK, appPasswords)
+}
+
+func (h *AuthHandler) CreateAppPassword(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	var req struct {
+		Name       string                  `json:"name" binding:"required,max=100"`
+		Scope      models.AppPasswordScope `json:"scope"`
+		PathPrefix string                  `json:"path_prefix" binding:"max=1000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	appPassword, secret, err := h.appPasswords.Create(user.ID, req.Name, req.Scope, req.PathPrefix)
+	if errors.Is(err, services.ErrInvalidAppScope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scope must be read or read_write"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create app password"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityAppPasswordCreated,
+		Details: appPassword.Name,
+		Changes: models.ActivityChanges{"scope": {After: appPassword.Scope}, "path_prefix": {After: appPassword.PathPrefix}},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"app_password": appPassword,
+		"password":     secret,
+	})
+}
+
+func (h *AuthHandler) RevokeAppPassword(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid app password ID"})
+		return
+	}
+
+	if err := h.appPasswords.Revoke(user.ID, id); err != nil {
+		if errors.Is(err, services.ErrAppPasswordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "App password not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke app password"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityAppPasswordRevoked,
+		Details: "Revoked app password " + id.String(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "App password revoked"})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/models"
+	"stratus/services"
+)
+
+func (h *AuthHandler) OIDCConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       h.oidc.Enabled(),
+		"provider_name": h.config.OIDCProviderName,
+	})
+}
+
+// oidcStateCookie binds a pending SSO login to the browser that started it.
+const oidcStateCookie = "stratus_oidc_state"
+
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	authURL, state, err := h.oidc.AuthURL(c.Request.Context())
+	if errors.Is(err, services.ErrOIDCDisabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSO login is not configured"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "OIDC login failed", "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	h.setOIDCStateCookie(c, state, int(services.OIDCFlowTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// setOIDCStateCookie stores state for the callback, or clears it with a
+// negative maxAge. Lax is required: the provider redirects back with a
+// top-level cross-site navigation.
+func (h *AuthHandler) setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, "/api/auth/oidc", "", strings.HasPrefix(h.config.PublicURL, "https://"), true)
+}
+
+// OIDCCallback receives the provider redirect and sends the browser back to
+// the frontend with either a one-time handoff code or an error.
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		h.setOIDCStateCookie(c, "", -1)
+		h.oidcRedirect(c, url.Values{"error": {c.DefaultQuery("error_description", providerError)}})
+		return
+	}
+
+	boundState, _ := c.Cookie(oidcStateCookie)
+	h.setOIDCStateCookie(c, "", -1)
+
+	code, err := h.oidc.Callback(c.Request.Context(), c.Query("state"), boundState, c.Query("code"), c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		message := "SSO login failed"
+		switch {
+		case errors.Is(err, services.ErrOIDCInvalidState),
+			errors.Is(err, services.ErrOIDCMissingEmail),
+			errors.Is(err, services.ErrOIDCUnverified),
+			errors.Is(err, services.ErrOIDCNoAccount),
+			errors.Is(err, services.ErrOIDCAccountLinked),
+			errors.Is(err, services.ErrOIDCUserDisabled):
+			message = err.Error()
+		default:
+			slog.ErrorContext(c.Request.Context(), "OIDC callback failed", "error", err)
+		}
+		h.oidcRedirect(c, url.Values{"error": {message}})
+		return
+	}
+
+	h.oidcRedirect(c, url.Values{"code": {code}})
+}
+
+func (h *AuthHandler) oidcRedirect(c *gin.Context, params url.Values) {
+	target := strings.TrimSuffix(h.config.OIDCFrontendURL, "/") + "/login/oidc?" + params.Encode()
+	c.Redirect(http.StatusFound, target)
+}
+
+func (h *AuthHandler) OIDCExchange(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, user, err := h.oidc.Exchange(req.Code)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login code"})
+		return
+	}
+
+	if pair == nil {
+		mfaToken, expiresIn, err := h.tokens.GenerateMFAToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   expiresIn,
+		})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		Type:    models.ActivityUserLogin,
+		Details: "Signed in with " + h.config.OIDCProviderName,
+	})
+
+	c.JSON(http.StatusOK, h.tokenResponse(pair, *user))
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"mime"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/metrics"
+	"stratus/middleware"
+	"stratus/telemetry"
+)
+
+// db returns the database bound to the request, so its queries show up in
+// the request's logs and trace.
+func db(c *gin.Context) *gorm.DB {
+	return database.WithContext(c.Request.Context())
+}
+
+func contentDisposition(disposition, filename string) string {
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); value != "" {
+		return value
+	}
+	return disposition
+}
+
+// serveContentHeaders sets the headers shared by every endpoint that streams
+// stored file bytes back to a client.
+func serveContentHeaders(c *gin.Context, disposition, filename, mimeType string) {
+	c.Header("Content-Type", mimeType)
+	c.Header("Content-Disposition", contentDisposition(disposition, filename))
+	c.Header("X-Content-Type-Options", "nosniff")
+	if disposition == "inline" {
+		c.Header("Content-Security-Policy", "sandbox")
+	}
+}
+
+// sendFile streams a stored file and counts the bytes sent.
+func sendFile(c *gin.Context, path string) {
+	_, span := telemetry.Tracer.Start(c.Request.Context(), "storage.send")
+	defer span.End()
+
+	c.File(path)
+	span.SetAttributes(attribute.Int("storage.bytes", c.Writer.Size()))
+	if size := c.Writer.Size(); size > 0 {
+		metrics.DownloadBytes.WithLabelValues(middleware.GetOrigin(c).Source).Add(float64(size))
+	}
+}
+
+// trackUpload counts the request as an active upload until the returned
+// function is called with the number of bytes stored.
+func trackUpload(c *gin.Context) func(stored int64) {
+	source := middleware.GetOrigin(c).Source
+	metrics.ActiveUploads.WithLabelValues(source).Inc()
+	return func(stored int64) {
+		metrics.ActiveUploads.WithLabelValues(source).Dec()
+		metrics.UploadBytes.WithLabelValues(source).Add(float64(stored))
+	}
+}
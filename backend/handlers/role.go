@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+type RoleRequest struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description" binding:"max=255"`
+	Permissions []models.Permission `json:"permissions"`
+}
+
+func (h *AdminHandler) ListRoles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"roles":       h.roles.List(),
+		"permissions": models.AllPermissions,
+	})
+}
+
+func (h *AdminHandler) CreateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := h.roles.Create(middleware.GetCurrentUser(c), req.Name, req.Description, req.Permissions)
+	if err != nil {
+		roleError(c, err)
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  middleware.GetCurrentUser(c).ID,
+		Type:    models.ActivityRoleCreated,
+		Details: role.Name,
+		Changes: models.ActivityChanges{"permissions": {After: role.Permissions}},
+	})
+
+	c.JSON(http.StatusCreated, role)
+}
+
+func (h *AdminHandler) UpdateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous, _ := h.roles.Get(c.Param("name"))
+	role, err := h.roles.Update(middleware.GetCurrentUser(c), c.Param("name"), req.Description, req.Permissions)
+	if err != nil {
+		roleError(c, err)
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  middleware.GetCurrentUser(c).ID,
+		Type:    models.ActivityRoleUpdated,
+		Details: role.Name,
+		Changes: models.ActivityChanges{
+			"description": {Before: previous.Description, After: role.Description},
+			"permissions": {Before: previous.Permissions, After: role.Permissions},
+		},
+	})
+
+	c.JSON(http.StatusOK, role)
+}
+
+func (h *AdminHandler) DeleteRole(c *gin.Context) {
+	previous, _ := h.roles.Get(c.Param("name"))
+	if err := h.roles.Delete(c.Param("name")); err != nil {
+		roleError(c, err)
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  middleware.GetCurrentUser(c).ID,
+		Type:    models.ActivityRoleDeleted,
+		Details: previous.Name,
+		Changes: models.ActivityChanges{"permissions": {Before: previous.Permissions}},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+func (h *AdminHandler) AssignRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := db(c).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	currentUser := middleware.GetCurrentUser(c)
+	if currentUser.ID == user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot change your own role"})
+		return
+	}
+
+	if err := h.roles.Assign(currentUser, &user, req.Role, middleware.GetOrigin(c)); err != nil {
+		roleError(c, err)
+		return
+	}
+
+	db(c).First(&user, userID)
+	c.JSON(http.StatusOK, user)
+}
+
+// grantable rejects requests that would hand out a role the current user
+// could not assign directly.
+func (h *AdminHandler) grantable(c *gin.Context, actor *models.User, role string) bool {
+	if err := h.roles.CanGrant(actor, role); err != nil {
+		roleError(c, err)
+		return false
+	}
+	return true
+}
+
+func roleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+	case errors.Is(err, services.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleBuiltIn),
+		errors.Is(err, services.ErrRoleEscalation),
+		errors.Is(err, services.ErrRoleOwn),
+		errors.Is(err, services.ErrLastAdministrator):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+	}
+}
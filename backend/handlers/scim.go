@@ -0,0 +1,739 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/config"
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+const (
+	scimUserSchema      = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema     = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema      = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema     = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimProviderSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimResourceSchema  = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimContentType     = "application/scim+json"
+	scimDefaultPageSize = 100
+	scimMaxPageSize     = 1000
+)
+
+// SCIMHandler serves the SCIM 2.0 protocol (RFC 7643, RFC 7644) so identity
+// providers can create, update and remove accounts.
+type SCIMHandler struct {
+	config *config.Config
+	scim   *services.SCIMService
+}
+
+func NewSCIMHandler(cfg *config.Config, scim *services.SCIMService) *SCIMHandler {
+	return &SCIMHandler{config: cfg, scim: scim}
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// scimBool accepts "True" and "False" strings as well as booleans, since
+// some providers send them that way.
+type scimBool bool
+
+func (b *scimBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = scimBool(v)
+	case string:
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("expected a boolean")
+		}
+		*b = scimBool(parsed)
+	default:
+		return errors.New("expected a boolean")
+	}
+	return nil
+}
+
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *scimName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *scimBool   `json:"active,omitempty"`
+	Password    *string     `json:"password,omitempty"`
+	Groups      []scimRef   `json:"groups,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+// attributes reads the provider-managed attributes. The display name falls
+// back to the name parts; a missing active means active.
+func (u *scimUser) attributes() services.SCIMUserAttributes {
+	attrs := services.SCIMUserAttributes{
+		UserName:    u.UserName,
+		DisplayName: u.DisplayName,
+		ExternalID:  u.ExternalID,
+		Active:      u.Active == nil || bool(*u.Active),
+		Password:    u.Password,
+	}
+	if attrs.DisplayName == "" && u.Name != nil {
+		attrs.DisplayName = u.Name.display()
+	}
+	return attrs
+}
+
+func (n *scimName) display() string {
+	if n.Formatted != "" {
+		return n.Formatted
+	}
+	return strings.TrimSpace(n.GivenName + " " + n.FamilyName)
+}
+
+type scimGroup struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id"`
+	DisplayName string    `json:"displayName"`
+	Members     []scimRef `json:"members,omitempty"`
+	Meta        *scimMeta `json:"meta"`
+}
+
+type scimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type scimPatch struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []scimPatchOp `json:"Operations" binding:"required"`
+}
+
+func (h *SCIMHandler) location(kind, id string) string {
+	return strings.TrimRight(h.config.PublicURL, "/") + "/scim/v2/" + path.Join(kind, id)
+}
+
+func (h *SCIMHandler) userResource(user *models.User) scimUser {
+	active := scimBool(user.IsActive)
+	resource := scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          user.ID.String(),
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		DisplayName: user.DisplayName,
+		Emails:      []scimEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     h.location("Users", user.ID.String()),
+		},
+	}
+	if user.DisplayName != "" {
+		resource.Name = &scimName{Formatted: user.DisplayName}
+	}
+	if group, ok := h.groupOf(user); ok {
+		resource.Groups = []scimRef{{Value: group.ID.String(), Display: group.Name, Ref: h.location("Groups", group.ID.String())}}
+	}
+	return resource
+}
+
+func (h *SCIMHandler) groupOf(user *models.User) (*models.Role, bool) {
+	if user.Role == models.RoleUser {
+		return nil, false
+	}
+	groups, err := h.scim.Groups(&services.SCIMFilter{Attribute: "displayname", Value: user.Role})
+	if err != nil || len(groups) == 0 {
+		return nil, false
+	}
+	return &groups[0], true
+}
+
+func (h *SCIMHandler) groupResource(group *models.Role, members []models.User) scimGroup {
+	refs := make([]scimRef, len(members))
+	for i, member := range members {
+		refs[i] = scimRef{Value: member.ID.String(), Display: member.Email, Ref: h.location("Users", member.ID.String())}
+	}
+	return scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          group.ID.String(),
+		DisplayName: group.Name,
+		Members:     refs,
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     h.location("Groups", group.ID.String()),
+		},
+	}
+}
+
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scimContentType)
+	c.JSON(status, body)
+}
+
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{"schemas": []string{scimErrorSchema}, "status": strconv.Itoa(status), "detail": detail}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	scimJSON(c, status, body)
+}
+
+// scimFailure maps a service error to its SCIM error response.
+func scimFailure(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSCIMNotFound):
+		scimError(c, http.StatusNotFound, "", "Resource not found")
+	case errors.Is(err, services.ErrSCIMConflict):
+		scimError(c, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, services.ErrSCIMInvalidFilter):
+		scimError(c, http.StatusBadRequest, "invalidFilter", "Only filters of the form attribute eq \"value\" are supported")
+	case errors.Is(err, services.ErrSCIMInvalidValue):
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+	case errors.Is(err, services.ErrDeletionPending):
+		scimError(c, http.StatusConflict, "", "User is being deleted")
+	case errors.Is(err, services.ErrRoleEscalation), errors.Is(err, services.ErrLastAdministrator):
+		scimError(c, http.StatusForbidden, "", err.Error())
+	default:
+		scimError(c, http.StatusInternalServerError, "", "Internal error")
+	}
+}
+
+func scimOrigin(c *gin.Context) models.ActivityOrigin {
+	origin := middleware.GetOrigin(c)
+	origin.Source = models.ActivitySourceSCIM
+	return origin
+}
+
+// scimPage reads the 1-based startIndex and count parameters.
+func scimPage(c *gin.Context) (int, int) {
+	start, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil || count < 0 {
+		count = scimDefaultPageSize
+	}
+	if count > scimMaxPageSize {
+		count = scimMaxPageSize
+	}
+	return start, count
+}
+
+func listResponse(total int64, start int, resources interface{}, returned int) gin.H {
+	return gin.H{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": returned,
+		"Resources":    resources,
+	}
+}
+
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{scimProviderSchema},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxPageSize},
+		"changePassword": gin.H{"supported": true},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A personal access token of a service account with the users:provision permission",
+			"primary":     true,
+		}},
+		"meta": gin.H{"resourceType": "ServiceProviderConfig", "location": h.location("ServiceProviderConfig", "")},
+	})
+}
+
+func (h *SCIMHandler) ResourceTypes(c *gin.Context) {
+	types := []gin.H{
+		{
+			"schemas":  []string{scimResourceSchema},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   scimUserSchema,
+			"meta":     gin.H{"resourceType": "ResourceType", "location": h.location("ResourceTypes", "User")},
+		},
+		{
+			"schemas":  []string{scimResourceSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   scimGroupSchema,
+			"meta":     gin.H{"resourceType": "ResourceType", "location": h.location("ResourceTypes", "Group")},
+		},
+	}
+	scimJSON(c, http.StatusOK, listResponse(int64(len(types)), 1, types, len(types)))
+}
+
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	filter, err := services.ParseSCIMFilter(c.Query("filter"))
+	if err != nil {
+		scimFailure(c, err)
+		return
+	}
+	start, count := scimPage(c)
+	users, total, err := h.scim.ListUsers(filter, start-1, count)
+	if err != nil {
+		scimFailure(c, err)
+		return
+	}
+
+	resources := make([]scimUser, len(users))
+	for i := range users {
+		resources[i] = h.userResource(&users[i])
+	}
+	scimJSON(c, http.StatusOK, listResponse(total, start, resources, len(resources)))
+}
+
+// user loads the user named by the id parameter, answering with an error
+// when there is none.
+func (h *SCIMHandler) user(c *gin.Context) (*models.User, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "Resource not found")
+		return nil, false
+	}
+	user, err := h.scim.GetUser(id)
+	if err != nil {
+		scimFailure(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, ok := h.user(c)
+	if !ok {
+		return
+	}
+	scimJSON(c, http.StatusOK, h.userResource(user))
+}
+
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var req scimUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	user, err := h.scim.CreateUser(middleware.GetCurrentUser(c), req.attributes(), scimOrigin(c))
+	if err != nil {
+		scimFailure(c, err)
+		return
+	}
+	c.Header("Location", h.location("Users", user.ID.String()))
+	scimJSON(c, http.StatusCreated, h.userResource(user))
+}
+
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	user, ok := h.user(c)
+	if !ok {
+		return
+	}
+	var req scimUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	if err := h.scim.UpdateUser(middleware.GetCurrentUser(c), user, req.attributes(), scimOrigin(c)); err != nil {
+		scimFailure(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, h.userResource(user))
+}
+
+// PatchUser applies add, replace and remove operations to the attributes
+// this server stores. Operations on other attributes are ignored, as
+// providers tend to send everything they know about a user.
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	user, ok := h.user(c)
+	if !ok {
+		return
+	}
+	var req scimPatch
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	attrs := services.SCIMUserAttributes{
+		UserName:    user.Email,
+		DisplayName: user.DisplayName,
+		ExternalID:  user.ExternalID,
+		Active:      user.IsActive,
+	}
+	for _, op := range req.Operations {
+		if err := patchUser(&attrs, op); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+
+	if err := h.scim.UpdateUser(middleware.GetCurrentUser(c), user, attrs, scimOrigin(c)); err != nil {
+		scimFailure(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, h.userResource(user))
+}
+
+func patchUser(attrs *services.SCIMUserAttributes, op scimPatchOp) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return errors.New("unsupported operation " + op.Op)
+	}
+	if op.Path != "" {
+		return patchUserAttribute(attrs, op.Path, op.Value, kind == "remove")
+	}
+	if kind == "remove" {
+		return errors.New("remove requires a path")
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &values); err != nil {
+		return errors.New("value must be an object when no path is given")
+	}
+	for path, value := range values {
+		if err := patchUserAttribute(attrs, path, value, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func patchUserAttribute(attrs *services.SCIMUserAttributes, path string, value json.RawMessage, remove bool) error {
+	var text string
+	decode := func(target interface{}) error {
+		if err := json.Unmarshal(value, target); err != nil {
+			return errors.New("invalid value for " + path)
+		}
+		return nil
+	}
+
+	switch strings.ToLower(strings.TrimPrefix(path, scimUserSchema+":")) {
+	case "username":
+		if remove {
+			return errors.New("userName is required")
+		}
+		if err := decode(&text); err != nil {
+			return err
+		}
+		attrs.UserName = text
+	case "displayname":
+		if !remove {
+			if err := decode(&text); err != nil {
+				return err
+			}
+		}
+		attrs.DisplayName = text
+	case "name":
+		var name scimName
+		if !remove {
+			if err := decode(&name); err != nil {
+				return err
+			}
+		}
+		attrs.DisplayName = name.display()
+	case "name.formatted":
+		if !remove {
+			if err := decode(&text); err != nil {
+				return err
+			}
+		}
+		attrs.DisplayName = text
+	case "externalid":
+		if !remove {
+			if err := decode(&text); err != nil {
+				return err
+			}
+		}
+		attrs.ExternalID = text
+	case "active":
+		if remove {
+			return errors.New("active cannot be removed")
+		}
+		var active scimBool
+		if err := decode(&active); err != nil {
+			return err
+		}
+		attrs.Active = bool(active)
+	case "password":
+		if remove {
+			return errors.New("password cannot be removed")
+		}
+		if err := decode(&text); err != nil {
+			return err
+		}
+		attrs.Password = &text
+	}
+	return nil
+}
+
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	user, ok := h.user(c)
+	if !ok {
+		return
+	}
+	if err := h.scim.DeleteUser(middleware.GetCurrentUser(c), user, scimOrigin(c)); err != nil {
+		scimFailure(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	filter, err := services.ParseSCIMFilter(c.Query("filter"))
+	if err != nil {
+		scimFailure(c, err)
+		return
+	}
+	groups, err := h.scim.Groups(filter)
+	if err != nil {
+		scimFailure(c, err)
+		return
+	}
+
+	start, count := scimPage(c)
+	total := len(groups)
+	if start-1 < len(groups) {
+		groups = groups[start-1:]
+	} else {
+		groups = nil
+	}
+	if len(groups) > count {
+		groups = groups[:count]
+	}
+
+	withMembers := !strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members")
+	resources := make([]scimGroup, len(groups))
+	for i := range groups {
+		var members []models.User
+		if withMembers {
+			if members, err = h.scim.Members(&groups[i]); err != nil {
+				scimFailure(c, err)
+				return
+			}
+		}
+		resources[i] = h.groupResource(&groups[i], members)
+	}
+	scimJSON(c, http.StatusOK, listResponse(int64(total), start, resources, len(resources)))
+}
+
+func (h *SCIMHandler) group(c *gin.Context) (*models.Role, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "Resource not found")
+		return nil, false
+	}
+	group, err := h.scim.GetGroup(id)
+	if err != nil {
+		scimFailure(c, err)
+		return nil, false
+	}
+	return group, true
+}
+
+func (h *SCIMHandler) respondGroup(c *gin.Context, group *models.Role) {
+	members, err := h.scim.Members(group)
+	if err != nil {
+		scimFailure(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, h.groupResource(group, members))
+}
+
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, ok := h.group(c)
+	if !ok {
+		return
+	}
+	h.respondGroup(c, group)
+}
+
+// ReplaceGroup sets a group's members. Groups are roles, whose names are
+// fixed, so displayName must not change.
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	group, ok := h.group(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		DisplayName string    `json:"displayName"`
+		Members     []scimRef `json:"members"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if req.DisplayName != "" && req.DisplayName != group.Name {
+		scimError(c, http.StatusBadRequest, "mutability", "Group names cannot be changed")
+		return
+	}
+	ids, err := memberIDs(req.Members)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	if err := h.scim.ReplaceMembers(middleware.GetCurrentUser(c), group, ids, scimOrigin(c)); err != nil {
+		scimFailure(c, err)
+		return
+	}
+	h.respondGroup(c, group)
+}
+
+// memberPathPattern matches the path providers use to remove one member.
+var memberPathPattern = regexp.MustCompile(`(?i)^members\[value eq "([^"]+)"\]$`)
+
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	group, ok := h.group(c)
+	if !ok {
+		return
+	}
+	var req scimPatch
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	actor := middleware.GetCurrentUser(c)
+	origin := scimOrigin(c)
+	for _, op := range req.Operations {
+		kind := strings.ToLower(op.Op)
+		path := op.Path
+
+		var members []scimRef
+		if match := memberPathPattern.FindStringSubmatch(path); match != nil {
+			members = []scimRef{{Value: match[1]}}
+			path = "members"
+		} else if path == "" && kind != "remove" {
+			var values struct {
+				DisplayName string    `json:"displayName"`
+				Members     []scimRef `json:"members"`
+			}
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", "value must be an object when no path is given")
+				return
+			}
+			if values.DisplayName != "" && values.DisplayName != group.Name {
+				scimError(c, http.StatusBadRequest, "mutability", "Group names cannot be changed")
+				return
+			}
+			if values.Members == nil {
+				continue
+			}
+			members = values.Members
+			path = "members"
+		} else if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &members); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", "members must be a list")
+				return
+			}
+		}
+
+		if !strings.EqualFold(path, "members") {
+			if strings.EqualFold(path, "displayName") {
+				scimError(c, http.StatusBadRequest, "mutability", "Group names cannot be changed")
+				return
+			}
+			scimError(c, http.StatusBadRequest, "invalidPath", "Unsupported path "+op.Path)
+			return
+		}
+		ids, err := memberIDs(members)
+		if err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+
+		switch kind {
+		case "add":
+			err = h.scim.AddMembers(actor, group, ids, origin)
+		case "remove":
+			if len(members) == 0 {
+				err = h.scim.ReplaceMembers(actor, group, nil, origin)
+			} else {
+				err = h.scim.RemoveMembers(actor, group, ids, origin)
+			}
+		case "replace":
+			err = h.scim.ReplaceMembers(actor, group, ids, origin)
+		default:
+			scimError(c, http.StatusBadRequest, "invalidSyntax", "Unsupported operation "+op.Op)
+			return
+		}
+		if err != nil {
+			scimFailure(c, err)
+			return
+		}
+	}
+	h.respondGroup(c, group)
+}
+
+func memberIDs(members []scimRef) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		id, err := uuid.Parse(member.Value)
+		if err != nil {
+			return nil, errors.New("invalid member " + member.Value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// CreateGroup and DeleteGroup are refused: groups are roles, which are
+// managed in the admin console.
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	scimError(c, http.StatusNotImplemented, "", "Groups are roles and must be created in Stratus")
+}
+
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	scimError(c, http.StatusNotImplemented, "", "Groups are roles and must be deleted in Stratus")
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+	"stratus/services"
+)
+
+const scimPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+
+type scimTest struct {
+	router *gin.Engine
+	actor  *models.User
+}
+
+// newSCIMTest serves the SCIM user endpoints as an administrator's token
+// would reach them through the authentication middleware.
+func newSCIMTest(t *testing.T) *scimTest {
+	t.Helper()
+	dbtest.Open(t)
+	gin.SetMode(gin.TestMode)
+
+	roles := services.NewRoleService()
+	if err := roles.Load(); err != nil {
+		t.Fatal(err)
+	}
+	actor := &models.User{Email: "idp@example.com", Role: models.RoleAdmin, IsActive: true, IsService: true}
+	if err := services.CreateUser(actor); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{PublicURL: "http://localhost", UserDeletionGracePeriod: 30 * 24 * time.Hour}
+	deletions := services.NewDeletionService(cfg, nil, nil, nil)
+	h := NewSCIMHandler(cfg, services.NewSCIMService(roles, deletions))
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user", actor)
+		c.Set("source", models.ActivitySourceAPI)
+		c.Next()
+	})
+	r.GET("/scim/v2/Users/:id", h.GetUser)
+	r.PATCH("/scim/v2/Users/:id", h.PatchUser)
+	r.DELETE("/scim/v2/Users/:id", h.DeleteUser)
+	return &scimTest{router: r, actor: actor}
+}
+
+func (s *scimTest) do(method string, user *models.User, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, "/scim/v2/Users/"+user.ID.String(), strings.NewReader(body))
+	req.Header.Set("Content-Type", scimContentType)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response
+}
+
+func patchBody(operations string) string {
+	return `{"schemas":["` + scimPatchSchema + `"],"Operations":` + operations + `}`
+}
+
+func createSCIMUser(t *testing.T, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, DisplayName: "Before", IsActive: true}
+	if err := services.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func reloadSCIMUser(t *testing.T, user *models.User) *models.User {
+	t.Helper()
+	var reloaded models.User
+	if err := database.DB.Unscoped().First(&reloaded, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &reloaded
+}
+
+func TestSCIMPatchUser(t *testing.T) {
+	s := newSCIMTest(t)
+	user := createSCIMUser(t, "alice@example.com")
+
+	// Entra ID sends booleans as strings and capitalises the operation.
+	w, body := s.do(http.MethodPatch, user, patchBody(`[{"op":"Replace","path":"active","value":"False"}]`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("deactivate: status %d: %s", w.Code, w.Body)
+	}
+	if body["active"] != false {
+		t.Errorf("response shows active=%v", body["active"])
+	}
+	if reloadSCIMUser(t, user).IsActive {
+		t.Fatal("user is still active")
+	}
+
+	// Okta sends a value object without a path.
+	w, body = s.do(http.MethodPatch, user, patchBody(`[{"op":"replace","value":{"active":true,"displayName":"Alice","nickName":"ignored"}}]`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("reactivate: status %d: %s", w.Code, w.Body)
+	}
+	if reloaded := reloadSCIMUser(t, user); !reloaded.IsActive || reloaded.DisplayName != "Alice" {
+		t.Fatalf("user after patch: active=%v name=%q", reloaded.IsActive, reloaded.DisplayName)
+	}
+	if body["displayName"] != "Alice" {
+		t.Errorf("response shows displayName=%v", body["displayName"])
+	}
+
+	var changes int64
+	database.DB.Model(&models.Activity{}).Where("user_id = ? AND type = ? AND source = ?", user.ID, models.ActivityUserUpdated, models.ActivitySourceSCIM).Count(&changes)
+	if changes != 2 {
+		t.Errorf("%d user_updated activities from SCIM, want 2", changes)
+	}
+}
+
+func TestSCIMPatchUserRejectsInvalidOperations(t *testing.T) {
+	s := newSCIMTest(t)
+	user := createSCIMUser(t, "alice@example.com")
+
+	for name, operations := range map[string]string{
+		"unknown op":       `[{"op":"move","path":"active","value":false}]`,
+		"remove userName":  `[{"op":"remove","path":"userName"}]`,
+		"non-boolean":      `[{"op":"replace","path":"active","value":"maybe"}]`,
+		"invalid userName": `[{"op":"replace","path":"userName","value":"not an address"}]`,
+		// The first operation is valid, but nothing applies unless all are.
+		"partly valid": `[{"op":"replace","path":"displayName","value":"Changed"},{"op":"remove","path":"active"}]`,
+	} {
+		w, body := s.do(http.MethodPatch, user, patchBody(operations))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, w.Code)
+		}
+		if body["scimType"] != "invalidValue" {
+			t.Errorf("%s: scimType %v, want invalidValue", name, body["scimType"])
+		}
+	}
+
+	if reloaded := reloadSCIMUser(t, user); !reloaded.IsActive || reloaded.DisplayName != "Before" || reloaded.Email != "alice@example.com" {
+		t.Fatalf("rejected patches changed the user: %+v", reloaded)
+	}
+}
+
+func TestSCIMDeleteUserSchedulesDeprovisioning(t *testing.T) {
+	s := newSCIMTest(t)
+	user := createSCIMUser(t, "bob@example.com")
+
+	tokens, err := services.NewTokenService(&config.Config{
+		JWTAlgorithm: "HS256",
+		JWTSecret:    "0123456789abcdef0123456789abcdef",
+		JWTIssuer:    "http://localhost",
+		AccessTTL:    time.Minute,
+		RefreshTTL:   time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := tokens.CreateSession(user.ID, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, _ := s.do(http.MethodDelete, user, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", w.Code, w.Body)
+	}
+
+	var deletion models.UserDeletion
+	if err := database.DB.Where("user_id = ?", user.ID).First(&deletion).Error; err != nil {
+		t.Fatalf("no deletion was scheduled: %v", err)
+	}
+	if deletion.Status != models.DeletionScheduled || deletion.RequestedByID == nil || *deletion.RequestedByID != s.actor.ID {
+		t.Errorf("unexpected deletion %+v", deletion)
+	}
+	if time.Until(deletion.ScheduledFor) < 29*24*time.Hour {
+		t.Errorf("deletion scheduled for %v, before the grace period ends", deletion.ScheduledFor)
+	}
+	if reloadSCIMUser(t, user).IsActive {
+		t.Error("user is still active")
+	}
+	if _, _, err := tokens.Refresh(session.RefreshToken, "192.0.2.1", "test"); err == nil {
+		t.Error("session survived deprovisioning")
+	}
+
+	// Providers retry deletes; the account stays scheduled once.
+	if w, _ := s.do(http.MethodDelete, user, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("repeated delete: status %d: %s", w.Code, w.Body)
+	}
+	var deletions int64
+	database.DB.Model(&models.UserDeletion{}).Where("user_id = ?", user.ID).Count(&deletions)
+	if deletions != 1 {
+		t.Errorf("%d deletions scheduled, want 1", deletions)
+	}
+
+	// A pending deletion cannot be undone by reactivating the account.
+	w, body := s.do(http.MethodPatch, user, patchBody(`[{"op":"replace","path":"active","value":true}]`))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("reactivate: status %d, want 409: %s", w.Code, w.Body)
+	}
+	if body["detail"] != "User is being deleted" || reloadSCIMUser(t, user).IsActive {
+		t.Error("account was reactivated during its deletion")
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+func (h *AdminHandler) ListServiceAccounts(c *gin.Context) {
+	var users []models.User
+	db(c).Where("is_service = true").Order("created_at DESC").Find(&users)
+	c.JSON(http.StatusOK, users)
+}
+
+func (h *AdminHandler) CreateServiceAccount(c *gin.Context) {
+	var req struct {
+		Name  string `json:"name" binding:"required,max=255"`
+		Role  string `json:"role"`
+		Quota *int64 `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	if !h.grantable(c, middleware.GetCurrentUser(c), req.Role) {
+		return
+	}
+
+	// Service accounts never log in interactively: they get a placeholder
+	// address and no password, and authenticate with tokens only.
+	id := uuid.New()
+	user := models.User{
+		ID:          id,
+		Email:       "svc-" + id.String() + "@service-accounts.invalid",
+		DisplayName: req.Name,
+		Role:        req.Role,
+		IsService:   true,
+	}
+	if req.Quota != nil {
+		user.Quota = *req.Quota
+	}
+
+	if err := services.CreateUser(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+
+	admin := middleware.GetCurrentUser(c)
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		ActorID: &admin.ID,
+		Type:    models.ActivityServiceAccountCreated,
+		Details: "Created by " + admin.Email,
+		Changes: models.ActivityChanges{"name": {After: user.DisplayName}, "role": {After: user.Role}, "quota": {After: user.Quota}},
+	})
+
+	c.JSON(http.StatusCreated, user)
+}
+
+func (h *AdminHandler) ListServiceAccountTokens(c *gin.Context) {
+	user, ok := h.serviceAccount(c)
+	if !ok {
+		return
+	}
+	listPersonalTokens(c, h.tokens, user.ID)
+}
+
+func (h *AdminHandler) CreateServiceAccountToken(c *gin.Context) {
+	user, ok := h.serviceAccount(c)
+	if !ok {
+		return
+	}
+	createPersonalToken(c, h.tokens, user)
+}
+
+func (h *AdminHandler) RevokeServiceAccountToken(c *gin.Context) {
+	user, ok := h.serviceAccount(c)
+	if !ok {
+		return
+	}
+	revokePersonalToken(c, h.tokens, user.ID)
+}
+
+func (h *AdminHandler) serviceAccount(c *gin.Context) (*models.User, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := db(c).Where("id = ? AND is_service = true", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		return nil, false
+	}
+	return &user, true
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+type CreateTokenRequest struct {
+	Name       string     `json:"name" binding:"required,max=100"`
+	Scopes     []string   `json:"scopes" binding:"required"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	AllowedIPs []string   `json:"allowed_ips"`
+}
+
+func (h *AuthHandler) ListTokens(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	listPersonalTokens(c, h.tokens, user.ID)
+}
+
+func (h *AuthHandler) CreateToken(c *gin.Context) {
+	createPersonalToken(c, h.tokens, middleware.GetCurrentUser(c))
+}
+
+func (h *AuthHandler) RevokeToken(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	revokePersonalToken(c, h.tokens, user.ID)
+}
+
+func listPersonalTokens(c *gin.Context, tokens *services.TokenService, userID uuid.UUID) {
+	list, err := tokens.ListPersonalTokens(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func createPersonalToken(c *gin.Context, tokens *services.TokenService, owner *models.User) {
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, token, err := tokens.CreatePersonalToken(owner, req.Name, req.Scopes, req.ExpiresAt, req.AllowedIPs)
+	if errors.Is(err, services.ErrInvalidTokenRequest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  owner.ID,
+		ActorID: tokenActor(c, owner.ID),
+		Type:    models.ActivityTokenCreated,
+		Details: record.Name,
+		Changes: models.ActivityChanges{"scopes": {After: record.Scopes}, "expires_at": {After: record.ExpiresAt}},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"token_info": record,
+	})
+}
+
+// tokenActor is the admin managing a service account's tokens, or nil when
+// users manage their own.
+func tokenActor(c *gin.Context, ownerID uuid.UUID) *uuid.UUID {
+	if user := middleware.GetCurrentUser(c); user.ID != ownerID {
+		return &user.ID
+	}
+	return nil
+}
+
+func revokePersonalToken(c *gin.Context, tokens *services.TokenService, userID uuid.UUID) {
+	id, err := uuid.Parse(c.Param("tokenId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	if err := tokens.RevokePersonalToken(userID, id); err != nil {
+		if errors.Is(err, services.ErrTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	recordActivity(c, models.Activity{
+		UserID:  userID,
+		ActorID: tokenActor(c, userID),
+		Type:    models.ActivityTokenRevoked,
+		Details: "Revoked token " + id.String(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
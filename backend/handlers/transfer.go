@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+type TransferRequest struct {
+	FromUserID uuid.UUID  `json:"from_user_id" binding:"required"`
+	ToUserID   uuid.UUID  `json:"to_user_id" binding:"required"`
+	FileID     *uuid.UUID `json:"file_id"`
+	TargetPath string     `json:"target_path"`
+}
+
+// TransferFiles gives a folder, a file or, without file_id, a user's whole
+// drive to another user. The files end up in target_path of the
+// recipient, renamed if that name is taken.
+func (h *AdminHandler) TransferFiles(c *gin.Context) {
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var from, to models.User
+	if err := db(c).First(&from, req.FromUserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := db(c).First(&to, req.ToUserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recipient not found"})
+		return
+	}
+
+	currentUser := middleware.GetCurrentUser(c)
+	if !h.roles.Outranks(currentUser, &from) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot transfer files of a user with more permissions than you"})
+		return
+	}
+
+	result, err := h.transfers.Transfer(currentUser, services.TransferRequest{
+		From:       &from,
+		To:         &to,
+		RootID:     req.FileID,
+		TargetPath: req.TargetPath,
+	}, middleware.GetOrigin(c))
+	switch {
+	case errors.Is(err, services.ErrTransferSameUser):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot transfer files to the same user"})
+	case errors.Is(err, services.ErrTransferSourceMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	case errors.Is(err, services.ErrTransferTargetMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target folder not found"})
+	case errors.Is(err, services.ErrTransferQuota):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Recipient storage quota exceeded"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer files"})
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"stratus/middleware"
+	"stratus/models"
+	"stratus/services"
+)
+
+// ExportData streams a zip of the current user's files and account data.
+func (h *AuthHandler) ExportData(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	streamExport(c, h.exports, user, "Exported their data")
+}
+
+func (h *AdminHandler) ExportUserData(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	if err := db(c).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	currentUser := middleware.GetCurrentUser(c)
+	if !h.roles.Outranks(currentUser, &user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot export the data of a user with more permissions than you"})
+		return
+	}
+	streamExport(c, h.exports, &user, fmt.Sprintf("%s exported the data of %s", currentUser.Email, user.Email))
+}
+
+func streamExport(c *gin.Context, exports *services.ExportService, user *models.User, details string) {
+	currentUser := middleware.GetCurrentUser(c)
+	recordActivity(c, models.Activity{
+		UserID:  user.ID,
+		ActorID: &currentUser.ID,
+		Type:    models.ActivityUserDataExported,
+		Details: details,
+	})
+
+	filename := fmt.Sprintf("stratus-export-%s-%s.zip", user.ID.String()[:8], time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", contentDisposition("attachment", filename))
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the stream short.
+	if err := exports.Export(c.Writer, user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Data export failed", "user_id", user.ID, "error", err)
+	}
+}
+
+func (h *AdminHandler) ListDeletions(c *gin.Context) {
+	deletions, err := h.deletions.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deletions"})
+		return
+	}
+	c.JSON(http.StatusOK, deletions)
+}
+
+// GetUserDeletion reports the progress of a user's latest deletion.
+func (h *AdminHandler) GetUserDeletion(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	deletion, err := h.deletions.Latest(userID)
+	if errors.Is(err, services.ErrDeletionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No deletion for this user"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deletion"})
+		return
+	}
+	c.JSON(http.StatusOK, deletion)
+}
+
+// CancelUserDeletion restores an account during its grace period.
+func (h *AdminHandler) CancelUserDeletion(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	if err := db(c).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	currentUser := middleware.GetCurrentUser(c)
+	if !h.roles.Outranks(currentUser, &user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot restore a user with more permissions than you"})
+		return
+	}
+
+	err = h.deletions.Cancel(currentUser, &user, middleware.GetOrigin(c))
+	switch {
+	case errors.Is(err, services.ErrDeletionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending deletion for this user"})
+	case errors.Is(err, services.ErrDeletionRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": "Deletion has already started"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel deletion"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Deletion cancelled"})
+	}
+}
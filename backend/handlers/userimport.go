@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/middleware"
+	"stratus/services"
+)
+
+const maxImportSize = 10 << 20
+
+// ImportUsers creates users from a CSV or JSON file, sent either as the
+// request body or as the multipart field "file". With dry_run=true the rows
+// are only validated; a file with invalid rows is never imported.
+func (h *AdminHandler) ImportUsers(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	body := io.Reader(c.Request.Body)
+	format := c.ContentType()
+	if strings.HasPrefix(format, "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+			return
+		}
+		defer file.Close()
+		body = file
+		format = header.Header.Get("Content-Type")
+		if strings.EqualFold(filepath.Ext(header.Filename), ".json") {
+			format = "application/json"
+		}
+	}
+
+	var (
+		rows []services.ImportRow
+		err  error
+	)
+	if strings.Contains(format, "json") {
+		rows, err = services.ParseImportJSON(body)
+	} else {
+		rows, err = services.ParseImportCSV(body)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The file contains no users"})
+		return
+	}
+
+	currentUser := middleware.GetCurrentUser(c)
+	dryRun := c.Query("dry_run") == "true"
+	invite := c.Query("invite") == "true"
+	report, err := h.imports.Import(currentUser, rows, dryRun, invite, middleware.GetOrigin(c))
+	switch {
+	case errors.Is(err, services.ErrMailerDisabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is not configured, so invitations cannot be sent"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import users"})
+	case !report.Valid:
+		c.JSON(http.StatusUnprocessableEntity, report)
+	case report.DryRun:
+		c.JSON(http.StatusOK, report)
+	default:
+		c.JSON(http.StatusCreated, report)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"net/http"
 	"path/filepath"
@@ -14,21 +15,24 @@ import (
 	"github.com/google/uuid"
 
 	"stratus/config"
-	"stratus/database"
 	"stratus/middleware"
 	"stratus/models"
 	"stratus/services"
 )
 
 type WebDAVHandler struct {
-	config  *config.Config
-	storage *services.StorageService
+	config   *config.Config
+	storage  *services.StorageService
+	content  *services.ContentPipeline
+	settings *services.SettingsService
 }
 
-func NewWebDAVHandler(cfg *config.Config, storage *services.StorageService) *WebDAVHandler {
+func NewWebDAVHandler(cfg *config.Config, storage *services.StorageService, content *services.ContentPipeline, settings *services.SettingsService) *WebDAVHandler {
 	return &WebDAVHandler{
-		config:  cfg,
-		storage: storage,
+		config:   cfg,
+		storage:  storage,
+		content:  content,
+		settings: settings,
 	}
 }
 
@@ -75,10 +79,10 @@ func (h *WebDAVHandler) Propfind(c *gin.Context) {
 	var files []models.File
 
 	if path == "/" {
-		database.DB.Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, "/").Find(&files)
+		db(c).Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, "/").Find(&files)
 	} else {
 		cleanPath := strings.TrimSuffix(path, "/")
-		database.DB.Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, cleanPath).Find(&files)
+		db(c).Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, cleanPath).Find(&files)
 	}
 
 	response := PropfindResponse{
@@ -145,9 +149,9 @@ func (h *WebDAVHandler) Get(c *gin.Context) {
 		var files []models.File
 
 		if path == "/" {
-			database.DB.Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, "/").Find(&files)
+			db(c).Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, "/").Find(&files)
 		} else {
-			database.DB.Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, cleanPath).Find(&files)
+			db(c).Where("owner_id = ? AND path = ? AND is_trashed = false", user.ID, cleanPath).Find(&files)
 		}
 
 		response := PropfindResponse{
@@ -206,22 +210,29 @@ func (h *WebDAVHandler) Get(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_directory = false AND is_trashed = false", user.ID, parentPath, fileName).First(&file).Error; err != nil {
+	if err := db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_directory = false AND is_trashed = false", user.ID, parentPath, fileName).First(&file).Error; err != nil {
 		c.Status(http.StatusNotFound)
 		return
 	}
 
-	c.Header("Content-Type", file.MimeType)
+	disposition := "inline"
+	if h.settings.Get().ForcesAttachment(file.MimeType) {
+		disposition = "attachment"
+	}
+
+	serveContentHeaders(c, disposition, file.Name, file.MimeType)
 	c.Header("Content-Length", strconv.FormatInt(file.Size, 10))
-	c.Header("Content-Disposition", "inline; filename="+file.Name)
 	c.Header("ETag", "\""+file.Checksum+"\"")
 	c.Header("Accept-Ranges", "bytes")
 	c.Header("Cache-Control", "no-cache")
-	c.File(file.StoragePath)
+	sendFile(c, file.StoragePath)
 }
 
 func (h *WebDAVHandler) Put(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
+	var stored int64
+	done := trackUpload(c)
+	defer func() { done(stored) }()
 	path := c.Param("path")
 
 	cleanPath := strings.TrimSuffix(path, "/")
@@ -241,7 +252,7 @@ func (h *WebDAVHandler) Put(c *gin.Context) {
 
 	if len(bodyBytes) == 0 {
 		var existingFile models.File
-		err = database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&existingFile).Error
+		err = db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&existingFile).Error
 		if err == nil {
 			c.Status(http.StatusNoContent)
 		} else {
@@ -252,22 +263,34 @@ func (h *WebDAVHandler) Put(c *gin.Context) {
 
 	bodyReader := bytes.NewReader(bodyBytes)
 
-	storagePath, size, checksum, err := h.storage.SaveFile(user.ID, bodyReader, fileName)
+	saved, err := h.storage.SaveFile(c.Request.Context(), user.ID, bodyReader, fileName)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	stored = saved.Size
 
 	var existingFile models.File
-	err = database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&existingFile).Error
+	err = db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&existingFile).Error
 
 	if err == nil {
-		h.storage.CreateFileVersion(&existingFile)
-		existingFile.Size = size
-		existingFile.StoragePath = storagePath
-		existingFile.Checksum = checksum
+		h.storage.CreateFileVersion(db(c), &existingFile)
+		previous := existingFile
+		existingFile.Size = saved.Size
+		existingFile.StoragePath = saved.StoragePath
+		existingFile.Checksum = saved.Checksum
+		existingFile.MimeType = saved.MimeType
 		existingFile.Version++
-		database.DB.Save(&existingFile)
+		db(c).Save(&existingFile)
+		h.content.FileChanged(existingFile.ID)
+		recordActivity(c, models.Activity{
+			UserID:   user.ID,
+			Type:     models.ActivityFileUpdated,
+			FileID:   &existingFile.ID,
+			FileName: existingFile.Name,
+			Details:  fmt.Sprintf("Uploaded version %d", existingFile.Version),
+			Changes:  contentChanges(&previous, &existingFile),
+		})
 		c.Status(http.StatusNoContent)
 		return
 	}
@@ -275,16 +298,25 @@ func (h *WebDAVHandler) Put(c *gin.Context) {
 	newFile := models.File{
 		Name:        fileName,
 		Path:        parentPath,
-		StoragePath: storagePath,
-		MimeType:    h.storage.GetMimeType(fileName),
-		Size:        size,
+		StoragePath: saved.StoragePath,
+		MimeType:    saved.MimeType,
+		Size:        saved.Size,
 		IsDirectory: false,
 		OwnerID:     user.ID,
-		Checksum:    checksum,
+		Checksum:    saved.Checksum,
 	}
 
-	database.DB.Create(&newFile)
-	database.DB.Model(user).Update("used_space", user.UsedSpace+size)
+	db(c).Create(&newFile)
+	db(c).Model(user).Update("used_space", user.UsedSpace+saved.Size)
+	h.content.FileChanged(newFile.ID)
+
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFileCreated,
+		FileID:   &newFile.ID,
+		FileName: newFile.Name,
+		Changes:  models.ActivityChanges{"path": {After: filePath(&newFile)}, "size": {After: newFile.Size}},
+	})
 
 	c.Status(http.StatusCreated)
 }
@@ -302,7 +334,7 @@ func (h *WebDAVHandler) Mkcol(c *gin.Context) {
 	}
 
 	var existingFolder models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_directory = true AND is_trashed = false", user.ID, parentPath, folderName).First(&existingFolder).Error; err == nil {
+	if err := db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_directory = true AND is_trashed = false", user.ID, parentPath, folderName).First(&existingFolder).Error; err == nil {
 		c.Status(http.StatusConflict)
 		return
 	}
@@ -315,11 +347,19 @@ func (h *WebDAVHandler) Mkcol(c *gin.Context) {
 		StoragePath: user.ID.String(),
 	}
 
-	if err := database.DB.Create(&folder).Error; err != nil {
+	if err := db(c).Create(&folder).Error; err != nil {
 		c.Status(http.StatusConflict)
 		return
 	}
 
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     models.ActivityFolderCreated,
+		FileID:   &folder.ID,
+		FileName: folder.Name,
+		Changes:  models.ActivityChanges{"path": {After: filePath(&folder)}},
+	})
+
 	c.Status(http.StatusCreated)
 }
 
@@ -336,17 +376,19 @@ func (h *WebDAVHandler) Delete(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&file).Error; err != nil {
+	if err := db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&file).Error; err != nil {
 		c.Status(http.StatusNotFound)
 		return
 	}
 
 	if !file.IsDirectory {
-		h.storage.DeleteFile(file.StoragePath)
-		database.DB.Model(user).Update("used_space", user.UsedSpace-file.Size)
+		h.storage.DeleteFile(c.Request.Context(), file.StoragePath)
+		db(c).Model(user).Update("used_space", user.UsedSpace-file.Size)
+		h.content.FileRemoved(file.ID)
 	}
 
-	database.DB.Delete(&file)
+	db(c).Delete(&file)
+	recordDeletion(c, user, &file, "Deleted permanently")
 	c.Status(http.StatusNoContent)
 }
 
@@ -364,19 +406,12 @@ func (h *WebDAVHandler) Move(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, srcParentPath, srcName).First(&file).Error; err != nil {
+	if err := db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, srcParentPath, srcName).First(&file).Error; err != nil {
 		c.Status(http.StatusNotFound)
 		return
 	}
 
-	if strings.Contains(destPath, "://") {
-		parts := strings.SplitN(destPath, "/webdav", 2)
-		if len(parts) == 2 {
-			destPath = parts[1]
-		}
-	} else {
-		destPath = strings.TrimPrefix(destPath, "/webdav")
-	}
+	destPath = middleware.WebDAVDestination(destPath)
 
 	cleanDestPath := strings.TrimSuffix(destPath, "/")
 	destParts := strings.Split(strings.TrimPrefix(cleanDestPath, "/"), "/")
@@ -386,9 +421,26 @@ func (h *WebDAVHandler) Move(c *gin.Context) {
 		destParentPath = "/" + strings.Join(destParts[:len(destParts)-1], "/")
 	}
 
+	previousPath := filePath(&file)
 	file.Name = destName
 	file.Path = destParentPath
-	database.DB.Save(&file)
+	db(c).Save(&file)
+	if !file.IsDirectory {
+		h.content.FileRenamed(file.ID)
+	}
+
+	activityType := models.ActivityFileMoved
+	if destParentPath == srcParentPath {
+		activityType = models.ActivityFileRenamed
+	}
+	recordActivity(c, models.Activity{
+		UserID:   user.ID,
+		Type:     activityType,
+		FileID:   &file.ID,
+		FileName: file.Name,
+		Details:  "Moved from " + previousPath,
+		Changes:  models.ActivityChanges{"path": {Before: previousPath, After: filePath(&file)}},
+	})
 
 	c.Status(http.StatusCreated)
 }
@@ -407,19 +459,12 @@ func (h *WebDAVHandler) Copy(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_directory = false AND is_trashed = false", user.ID, srcParentPath, srcName).First(&file).Error; err != nil {
+	if err := db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_directory = false AND is_trashed = false", user.ID, srcParentPath, srcName).First(&file).Error; err != nil {
 		c.Status(http.StatusNotFound)
 		return
 	}
 
-	if strings.Contains(destPath, "://") {
-		parts := strings.SplitN(destPath, "/webdav", 2)
-		if len(parts) == 2 {
-			destPath = parts[1]
-		}
-	} else {
-		destPath = strings.TrimPrefix(destPath, "/webdav")
-	}
+	destPath = middleware.WebDAVDestination(destPath)
 
 	cleanDestPath := strings.TrimSuffix(destPath, "/")
 	destParts := strings.Split(strings.TrimPrefix(cleanDestPath, "/"), "/")
@@ -430,7 +475,7 @@ func (h *WebDAVHandler) Copy(c *gin.Context) {
 	}
 
 	newStoragePath := filepath.Join(h.storage.GetUserStoragePath(user.ID), uuid.New().String()+filepath.Ext(file.Name))
-	if err := h.storage.CopyFile(file.StoragePath, newStoragePath); err != nil {
+	if err := h.storage.CopyFile(c.Request.Context(), file.StoragePath, newStoragePath); err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
@@ -446,8 +491,11 @@ func (h *WebDAVHandler) Copy(c *gin.Context) {
 		Checksum:    file.Checksum,
 	}
 
-	database.DB.Create(&newFile)
-	database.DB.Model(user).Update("used_space", user.UsedSpace+file.Size)
+	db(c).Create(&newFile)
+	db(c).Model(user).Update("used_space", user.UsedSpace+file.Size)
+	h.content.FileChanged(newFile.ID)
+
+	recordCopy(c, user, &file, &newFile)
 
 	c.Status(http.StatusCreated)
 }
@@ -473,7 +521,7 @@ func (h *WebDAVHandler) Head(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := database.DB.Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&file).Error; err != nil {
+	if err := db(c).Where("owner_id = ? AND path = ? AND name = ? AND is_trashed = false", user.ID, parentPath, fileName).First(&file).Error; err != nil {
 		c.Status(http.StatusNotFound)
 		return
 	}
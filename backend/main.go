@@ -1,79 +1,130 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
 	"stratus/config"
 	"stratus/database"
+	"stratus/metrics"
 	"stratus/middleware"
 	"stratus/models"
 	"stratus/routes"
+	"stratus/telemetry"
 )
 
 func main() {
 	cfg := config.Load()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1:]))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	telemetry.SetupLogging(cfg)
+	shutdownTracing, err := telemetry.SetupTracing(context.Background(), cfg)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
 
 	if err := database.Connect(cfg); err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
 	defer database.Close()
 
 	if err := database.Migrate(); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+		fatal("Failed to run migrations", err)
+	}
+	if sqlDB, err := database.DB.DB(); err == nil {
+		metrics.RegisterDB(sqlDB)
 	}
 
 	createInitialAdmin()
 
-	r := gin.Default()
+	r := gin.New()
+	// Client addresses come from X-Forwarded-For only when the request
+	// arrives through one of these proxies.
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		fatal("Invalid TRUSTED_PROXIES", err)
+	}
 
+	r.Use(middleware.RequestID())
+	r.Use(otelgin.Middleware(telemetry.ServiceName, otelgin.WithFilter(traceRequest)))
+	r.Use(middleware.RequestLogger())
+	r.Use(middleware.Metrics())
+	r.Use(middleware.Recovery())
 	r.Use(middleware.CORSMiddleware())
-	r.Use(gin.Recovery())
 
-	routes.SetupRoutes(r, cfg)
+	if err := routes.SetupRoutes(r, cfg); err != nil {
+		fatal("Failed to set up routes", err)
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		<-quit
-		log.Println("Shutting down server...")
+		slog.Info("Shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+		cancel()
 		database.Close()
 		os.Exit(0)
 	}()
 
-	log.Printf("Stratus server starting on port %s", cfg.ServerPort)
+	slog.Info("Stratus server starting", "port", cfg.ServerPort)
 	if err := r.Run(":" + cfg.ServerPort); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fatal("Failed to start server", err)
 	}
 }
 
+// fatal logs err and exits, for failures the server cannot start without.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// traceRequest leaves health checks and metric scrapes out of traces.
+func traceRequest(r *http.Request) bool {
+	return r.URL.Path != "/health" && r.URL.Path != "/metrics"
+}
+
 func createInitialAdmin() {
 	var count int64
 	database.DB.Table("users").Count(&count)
 	if count == 0 {
-		log.Println("Creating initial admin user...")
+		slog.Info("Creating initial admin user")
 
 		adminPassword := os.Getenv("ADMIN_PASSWORD")
 		if adminPassword == "" {
 			adminPassword = "admin123"
 		}
 
+		now := time.Now()
 		admin := models.User{
 			Email:       "admin@stratus.local",
+			VerifiedAt:  &now,
 			DisplayName: "Administrator",
-			IsAdmin:     true,
+			Role:        models.RoleAdmin,
 			IsActive:    true,
 			Quota:       107374182400, // 100GB
 		}
 		admin.SetPassword(adminPassword)
 
 		if err := database.DB.Create(&admin).Error; err != nil {
-			log.Printf("Failed to create admin user: %v", err)
+			slog.Error("Failed to create admin user", "error", err)
 			return
 		}
 
@@ -86,7 +137,6 @@ func createInitialAdmin() {
 		}
 		database.DB.Create(&rootFolder)
 
-		log.Printf("Initial admin user created: username=admin, password=%s", adminPassword)
-		log.Println("Please change the admin password after first login!")
+		slog.Warn("Initial admin user created; change the password after first login", "email", admin.Email, "password", adminPassword)
 	}
 }
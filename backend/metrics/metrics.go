@@ -0,0 +1,75 @@
+// Package metrics holds the Prometheus collectors served at /metrics.
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Registry is separate from the global default so that only what is
+// registered here, and nothing a dependency adds, is exported.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratus_http_requests_total",
+		Help: "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stratus_http_request_duration_seconds",
+		Help:    "Time spent serving HTTP requests by method and route.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"method", "route"})
+
+	UploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratus_upload_bytes_total",
+		Help: "Bytes of file content stored through uploads, by interface.",
+	}, []string{"source"})
+
+	DownloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratus_download_bytes_total",
+		Help: "Bytes of file content sent to clients, by interface.",
+	}, []string{"source"})
+
+	ActiveUploads = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stratus_active_uploads",
+		Help: "Uploads currently being received, by interface.",
+	}, []string{"source"})
+
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratus_auth_failures_total",
+		Help: "Rejected credentials by kind (login or token) and interface.",
+	}, []string{"kind", "source"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		UploadBytes,
+		DownloadBytes,
+		ActiveUploads,
+		AuthFailures,
+	)
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, "stratus"))
+}
+
+// RegisterQueue exports the number of jobs waiting in a background queue.
+func RegisterQueue(name string, depth func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "stratus_job_queue_depth",
+		Help:        "Jobs waiting in a background queue.",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 {
+		return float64(depth())
+	}))
+}
@@ -3,24 +3,23 @@ package middleware
 import (
 	"encoding/base64"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
 	"stratus/config"
 	"stratus/database"
+	"stratus/metrics"
 	"stratus/models"
+	"stratus/services"
 )
 
-type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	jwt.RegisteredClaims
-}
-
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+func AuthMiddleware(tokens *services.TokenService, settings *services.SettingsService, impersonations *services.ImpersonationService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -36,19 +35,43 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWTSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
+		var userID, sessionID uuid.UUID
+		var personalToken *models.PersonalAccessToken
+		var impersonation *models.Impersonation
+		if strings.HasPrefix(tokenString, services.PersonalTokenPrefix) {
+			var err error
+			personalToken, err = tokens.ParsePersonalToken(tokenString, c.ClientIP())
+			if err == services.ErrTokenIPNotAllowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Token is not allowed from this address"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				metrics.AuthFailures.WithLabelValues("token", models.ActivitySourceAPI).Inc()
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			userID = personalToken.UserID
+		} else {
+			claims, err := tokens.ParseAccessToken(tokenString)
+			if err == services.ErrSessionRevoked {
+				metrics.AuthFailures.WithLabelValues("token", models.ActivitySourceWeb).Inc()
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				metrics.AuthFailures.WithLabelValues("token", models.ActivitySourceWeb).Inc()
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			userID, sessionID, impersonation = claims.UserID, claims.SessionID, claims.Impersonation
 		}
 
 		var user models.User
-		if err := database.DB.First(&user, "id = ?", claims.UserID).Error; err != nil {
+		if err := database.WithContext(c.Request.Context()).First(&user, "id = ?", userID).Error; err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			c.Abort()
 			return
@@ -60,24 +83,123 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		if impersonation != nil {
+			// Every impersonated request is logged, including rejected ones.
+			c.Header("X-Impersonated-By", impersonation.ImpersonatorID.String())
+			defer func() {
+				impersonations.RecordRequest(impersonation, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), GetOrigin(c))
+			}()
+
+			if impersonation.ReadOnly && !isSafeMethod(c.Request.Method) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "This impersonation is read-only"})
+				c.Abort()
+				return
+			}
+		}
+
+		// Users covered by the 2FA policy may only enroll, look up their
+		// account and sign out until they have enrolled. Impersonation
+		// bypasses this, since the admin has already signed in with their
+		// own factors.
+		if impersonation == nil && !user.TOTPEnabled && !user.IsService && settings.Get().RequiresMFA(&user) && !mfaEnrollmentPaths[c.FullPath()] {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                   "Two-factor authentication enrollment required",
+				"mfa_enrollment_required": true,
+			})
+			c.Abort()
+			return
+		}
+
 		c.Set("user", &user)
 		c.Set("userID", user.ID)
+		c.Set("sessionID", sessionID)
+		c.Set("source", models.ActivitySourceWeb)
+		if personalToken != nil {
+			c.Set("personalToken", personalToken)
+			c.Set("source", models.ActivitySourceAPI)
+		}
+		if impersonation != nil {
+			c.Set("impersonation", impersonation)
+		}
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// mfaEnrollmentPaths are the routes open to users who must enroll in 2FA
+// before using anything else. App passwords and personal tokens are left
+// out on purpose: they never ask for a second factor.
+var mfaEnrollmentPaths = map[string]bool{
+	"/api/auth/me":         true,
+	"/api/auth/logout":     true,
+	"/api/auth/2fa":        true,
+	"/api/auth/2fa/setup":  true,
+	"/api/auth/2fa/enable": true,
+}
+
+// RequireScope limits personal access tokens to routes their scopes cover:
+// safe methods need read, everything else needs write. Session tokens carry
+// the user's full rights and pass through.
+func RequireScope(read, write string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
+		token := GetPersonalToken(c)
+		if token == nil {
+			c.Next()
+			return
+		}
+
+		scope := write
+		if isSafeMethod(c.Request.Method) {
+			scope = read
+		}
+
+		if !token.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects personal access tokens, keeping account and
+// credential management to interactive logins.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetPersonalToken(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires an interactive session"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RejectImpersonation keeps impersonation tokens away from credential
+// management and administration.
+func RejectImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetImpersonation(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not available while impersonating"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission admits users whose role grants permission.
+func RequirePermission(roles *services.RoleService, permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		if user == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
 			c.Abort()
 			return
 		}
 
-		u := user.(*models.User)
-		if !u.IsAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !roles.Can(user, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission required: " + string(permission)})
 			c.Abort()
 			return
 		}
@@ -94,8 +216,57 @@ func GetCurrentUser(c *gin.Context) *models.User {
 	return user.(*models.User)
 }
 
-func BasicAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+func GetImpersonation(c *gin.Context) *models.Impersonation {
+	impersonation, exists := c.Get("impersonation")
+	if !exists {
+		return nil
+	}
+	return impersonation.(*models.Impersonation)
+}
+
+// GetOrigin describes the request for activity records. Requests without
+// credentials can only come from the web app.
+func GetOrigin(c *gin.Context) models.ActivityOrigin {
+	source := c.GetString("source")
+	if source == "" {
+		source = models.ActivitySourceWeb
+	}
+	return models.ActivityOrigin{
+		Source:    source,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func GetPersonalToken(c *gin.Context) *models.PersonalAccessToken {
+	token, exists := c.Get("personalToken")
+	if !exists {
+		return nil
+	}
+	return token.(*models.PersonalAccessToken)
+}
+
+func GetSessionID(c *gin.Context) uuid.UUID {
+	sessionID, exists := c.Get("sessionID")
+	if !exists {
+		return uuid.Nil
+	}
+	return sessionID.(uuid.UUID)
+}
+
+func BasicAuthMiddleware(cfg *config.Config, auth *services.AuthService, appPasswords *services.AppPasswordService, settings *services.SettingsService, limiter *services.LoginLimiter) gin.HandlerFunc {
+	verified := &verifiedPasswords{entries: make(map[string]verifiedPassword)}
 	return func(c *gin.Context) {
+		c.Set("source", models.ActivitySourceWebDAV)
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.Header("WWW-Authenticate", `Basic realm="WebDAV"`)
@@ -131,35 +302,195 @@ func BasicAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 		username := pair[0]
 		password := pair[1]
 
-		var user models.User
-		if err := database.DB.Where("email = ?", username).First(&user).Error; err != nil {
-			if err := database.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		var user *models.User
+		var appPassword *models.AppPassword
+		var candidate models.User
+		if err := database.WithContext(c.Request.Context()).Where("email = ?", username).First(&candidate).Error; err == nil {
+			if ap, ok := appPasswords.Authenticate(candidate.ID, password, c.ClientIP()); ok {
+				user, appPassword = &candidate, ap
+			}
+		}
+
+		// App passwords are random and cannot be guessed, and account
+		// passwords that recently passed need not be checked again, so
+		// clients with valid credentials only touch the limiter on their
+		// first request.
+		if user == nil && settings.Get().WebDAVAccountPassword {
+			user = verified.lookup(username, password, settings)
+		}
+		if user == nil {
+			release, wait, ok := limiter.Check(c.ClientIP(), username)
+			if !ok {
+				c.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+				c.Status(http.StatusTooManyRequests)
+				c.Abort()
+				return
+			}
+
+			if settings.Get().WebDAVAccountPassword {
+				if authenticated, err := auth.Authenticate(username, password); err == nil && webDAVAccountPasswordAllowed(settings, authenticated) {
+					user = authenticated
+				}
+			}
+
+			if user == nil {
+				limiter.Failure(GetOrigin(c), username, "Invalid WebDAV credentials")
+				release()
 				c.Header("WWW-Authenticate", `Basic realm="WebDAV"`)
 				c.Status(http.StatusUnauthorized)
 				c.Abort()
 				return
 			}
+			limiter.Success(username)
+			release()
+			verified.store(username, password, user)
 		}
 
-		if !VerifyPassword(user.PasswordHash, password) {
-			c.Header("WWW-Authenticate", `Basic realm="WebDAV"`)
-			c.Status(http.StatusUnauthorized)
+		if !user.IsActive {
+			c.Status(http.StatusForbidden)
 			c.Abort()
 			return
 		}
 
-		if !user.IsActive {
+		// As in AuthMiddleware, nothing but enrollment is open until a user
+		// covered by the 2FA policy has enrolled.
+		if !user.TOTPEnabled && !user.IsService && settings.Get().RequiresMFA(user) {
 			c.Status(http.StatusForbidden)
 			c.Abort()
 			return
 		}
 
-		c.Set("user", &user)
+		if appPassword != nil && !appPasswordAllows(c, appPassword) {
+			c.Status(http.StatusForbidden)
+			c.Abort()
+			return
+		}
+
+		c.Set("user", user)
 		c.Set("userID", user.ID)
+		c.Set("appPassword", appPassword)
 		c.Next()
 	}
 }
 
+// webDAVAccountPasswordAllowed reports whether user may sign in to WebDAV
+// with their account password. Accounts with 2FA enabled, or required to
+// enable it, must use an app password, since WebDAV clients cannot prompt
+// for a second factor.
+func webDAVAccountPasswordAllowed(settings *services.SettingsService, user *models.User) bool {
+	return !user.TOTPEnabled && !settings.Get().RequiresMFA(user)
+}
+
+// verifiedPasswordTTL bounds how long an account password that passed is
+// accepted without checking it again.
+const verifiedPasswordTTL = time.Minute
+
+// verifiedPasswords remembers account passwords that recently passed, keyed
+// by a hash of the credentials, so that a WebDAV client sending the same
+// ones with every request is checked once per verifiedPasswordTTL.
+type verifiedPasswords struct {
+	mu      sync.Mutex
+	entries map[string]verifiedPassword
+}
+
+type verifiedPassword struct {
+	userID       uuid.UUID
+	passwordHash string
+	expiresAt    time.Time
+}
+
+func verifiedPasswordKey(username, password string) string {
+	return services.HashToken(username + "\x00" + password)
+}
+
+// lookup returns the user the credentials were verified for, as long as
+// the entry has not expired and the user's password has not changed since.
+func (v *verifiedPasswords) lookup(username, password string, settings *services.SettingsService) *models.User {
+	key := verifiedPasswordKey(username, password)
+	v.mu.Lock()
+	entry, ok := v.entries[key]
+	v.mu.Unlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", entry.userID).Error; err != nil {
+		return nil
+	}
+	if user.PasswordHash != entry.passwordHash || !webDAVAccountPasswordAllowed(settings, &user) {
+		v.mu.Lock()
+		delete(v.entries, key)
+		v.mu.Unlock()
+		return nil
+	}
+	return &user
+}
+
+func (v *verifiedPasswords) store(username, password string, user *models.User) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if len(v.entries) > 10000 {
+		for key, entry := range v.entries {
+			if now.After(entry.expiresAt) {
+				delete(v.entries, key)
+			}
+		}
+	}
+	v.entries[verifiedPasswordKey(username, password)] = verifiedPassword{
+		userID:       user.ID,
+		passwordHash: user.PasswordHash,
+		expiresAt:    now.Add(verifiedPasswordTTL),
+	}
+}
+
+// appPasswordAllows applies an app password's scope to a WebDAV request,
+// including the Destination of MOVE and COPY.
+func appPasswordAllows(c *gin.Context, appPassword *models.AppPassword) bool {
+	method := c.Request.Method
+	if method == "OPTIONS" {
+		return true
+	}
+
+	switch method {
+	case "GET", "HEAD", "PROPFIND":
+	default:
+		if !appPassword.CanWrite() {
+			return false
+		}
+	}
+
+	if !appPassword.CoversPath(WebDAVPath(c.Param("path"))) {
+		return false
+	}
+	if method == "MOVE" || method == "COPY" {
+		return appPassword.CoversPath(WebDAVDestination(c.GetHeader("Destination")))
+	}
+	return true
+}
+
+func WebDAVPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// WebDAVDestination turns a Destination header, absolute URL or not, into a
+// path relative to the WebDAV root.
+func WebDAVDestination(destination string) string {
+	if strings.Contains(destination, "://") {
+		parts := strings.SplitN(destination, "/webdav", 2)
+		if len(parts) == 2 {
+			return WebDAVPath(parts[1])
+		}
+		return destination
+	}
+	return WebDAVPath(strings.TrimPrefix(destination, "/webdav"))
+}
+
 func VerifyPassword(hash, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
@@ -0,0 +1,425 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+	"stratus/services"
+)
+
+var webDAVMethods = []string{"OPTIONS", "GET", "HEAD", "PROPFIND", "PUT", "DELETE", "MKCOL", "MOVE", "COPY"}
+
+func newTestLimiter() *services.LoginLimiter {
+	return services.NewLoginLimiter(&config.Config{
+		LoginWindow:             time.Minute,
+		LoginMaxAccountFailures: 3,
+		LoginMaxIPFailures:      50,
+		LoginLockout:            time.Minute,
+		LoginDelayAfter:         100,
+		LoginMaxDelay:           time.Second,
+	})
+}
+
+func newWebDAVTestRouter(t *testing.T, settings *services.SettingsService, limiter *services.LoginLimiter) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	group := r.Group("/webdav", BasicAuthMiddleware(&config.Config{}, services.NewAuthService(services.LocalAuthProvider{}), services.NewAppPasswordService(), settings, limiter))
+	for _, method := range webDAVMethods {
+		group.Handle(method, "/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+	return r
+}
+
+func createTestUser(t *testing.T, email, password string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, IsActive: true}
+	if err := user.SetPassword(password); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func webDAVRequest(r *gin.Engine, method, target, username, password, destination string) int {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	if destination != "" {
+		req.Header.Set("Destination", destination)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAppPasswordScope(t *testing.T) {
+	dbtest.Open(t)
+	r := newWebDAVTestRouter(t, services.NewSettingsService(), newTestLimiter())
+	user := createTestUser(t, "user@example.com", "account password")
+
+	appPasswords := services.NewAppPasswordService()
+	_, readOnly, err := appPasswords.Create(user.ID, "phone", models.AppPasswordReadOnly, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, docsOnly, err := appPasswords.Create(user.ID, "laptop", models.AppPasswordReadWrite, "docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		target      string
+		password    string
+		destination string
+		want        int
+	}{
+		{"read-only GET", "GET", "/webdav/docs/a.txt", readOnly, "", http.StatusOK},
+		{"read-only PROPFIND", "PROPFIND", "/webdav/", readOnly, "", http.StatusOK},
+		{"read-only PUT", "PUT", "/webdav/docs/a.txt", readOnly, "", http.StatusForbidden},
+		{"read-only DELETE", "DELETE", "/webdav/docs/a.txt", readOnly, "", http.StatusForbidden},
+		{"inside the prefix", "PUT", "/webdav/docs/a.txt", docsOnly, "", http.StatusOK},
+		{"the prefix itself", "PROPFIND", "/webdav/docs", docsOnly, "", http.StatusOK},
+		{"outside the prefix", "GET", "/webdav/private/a.txt", docsOnly, "", http.StatusForbidden},
+		{"sibling with the same prefix", "GET", "/webdav/docsecret/a.txt", docsOnly, "", http.StatusForbidden},
+		{"dot-dot out of the prefix", "GET", "/webdav/docs/../private/a.txt", docsOnly, "", http.StatusForbidden},
+		{"the WebDAV root", "PROPFIND", "/webdav/", docsOnly, "", http.StatusForbidden},
+		{"move within the prefix", "MOVE", "/webdav/docs/a.txt", docsOnly, "/webdav/docs/b.txt", http.StatusOK},
+		{"move out by URL", "MOVE", "/webdav/docs/a.txt", docsOnly, "https://example.com/webdav/private/a.txt", http.StatusForbidden},
+		{"copy out by path", "COPY", "/webdav/docs/a.txt", docsOnly, "/webdav/docs/../private/a.txt", http.StatusForbidden},
+		{"options outside the prefix", "OPTIONS", "/webdav/private", docsOnly, "", http.StatusOK},
+		{"wrong secret", "GET", "/webdav/docs/a.txt", "not-a-secret", "", http.StatusUnauthorized},
+	} {
+		if got := webDAVRequest(r, tc.method, tc.target, user.Email, tc.password, tc.destination); got != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAppPasswordRevoked(t *testing.T) {
+	dbtest.Open(t)
+	r := newWebDAVTestRouter(t, services.NewSettingsService(), newTestLimiter())
+	user := createTestUser(t, "user@example.com", "account password")
+
+	appPasswords := services.NewAppPasswordService()
+	record, secret, _ := appPasswords.Create(user.ID, "phone", "", "/")
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, secret, ""); got != http.StatusOK {
+		t.Fatalf("active app password: status %d", got)
+	}
+	if err := appPasswords.Revoke(user.ID, record.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, secret, ""); got != http.StatusUnauthorized {
+		t.Errorf("revoked app password: status %d, want 401", got)
+	}
+
+	other := createTestUser(t, "other@example.com", "other password")
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", other.Email, secret, ""); got != http.StatusUnauthorized {
+		t.Errorf("app password of another user: status %d, want 401", got)
+	}
+}
+
+func TestWebDAVAccountPassword(t *testing.T) {
+	dbtest.Open(t)
+	settings := services.NewSettingsService()
+	r := newWebDAVTestRouter(t, settings, newTestLimiter())
+	user := createTestUser(t, "user@example.com", "account password")
+	_, secret, _ := services.NewAppPasswordService().Create(user.ID, "phone", "", "/")
+
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "account password", ""); got != http.StatusOK {
+		t.Errorf("account password: status %d, want 200", got)
+	}
+
+	// Accounts with two-factor authentication need an app password.
+	database.DB.Model(user).Update("totp_enabled", true)
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "account password", ""); got != http.StatusUnauthorized {
+		t.Errorf("account password with 2FA: status %d, want 401", got)
+	}
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, secret, ""); got != http.StatusOK {
+		t.Errorf("app password with 2FA: status %d, want 200", got)
+	}
+
+	database.DB.Model(user).Update("totp_enabled", false)
+	if _, err := settings.Update(map[string]json.RawMessage{"webdav_account_password": json.RawMessage("false")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "account password", ""); got != http.StatusUnauthorized {
+		t.Errorf("account password when disabled by the admin: status %d, want 401", got)
+	}
+
+	database.DB.Model(user).Update("is_active", false)
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, secret, ""); got != http.StatusForbidden {
+		t.Errorf("deactivated user: status %d, want 403", got)
+	}
+}
+
+func TestWebDAVLockout(t *testing.T) {
+	dbtest.Open(t)
+	r := newWebDAVTestRouter(t, services.NewSettingsService(), newTestLimiter())
+	user := createTestUser(t, "user@example.com", "account password")
+
+	for i := 0; i < 3; i++ {
+		if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "guess", ""); got != http.StatusUnauthorized {
+			t.Fatalf("failed attempt %d: status %d, want 401", i+1, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/webdav/a.txt", nil)
+	req.SetBasicAuth(user.Email, "account password")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("locked account with the right password: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestWebDAVValidCredentialsSkipLimiter(t *testing.T) {
+	dbtest.Open(t)
+	limiter := newTestLimiter()
+	r := newWebDAVTestRouter(t, services.NewSettingsService(), limiter)
+	user := createTestUser(t, "user@example.com", "account password")
+	_, secret, _ := services.NewAppPasswordService().Create(user.ID, "phone", "", "/")
+
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "account password", ""); got != http.StatusOK {
+		t.Fatalf("account password: status %d, want 200", got)
+	}
+
+	// A sync client's parallel requests fill the account's budget.
+	for i := 0; i < 3; i++ {
+		release, _, ok := limiter.Check("192.0.2.1", user.Email)
+		if !ok {
+			t.Fatalf("attempt %d was blocked", i+1)
+		}
+		defer release()
+	}
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "account password", ""); got != http.StatusOK {
+		t.Errorf("verified account password: status %d, want 200", got)
+	}
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, secret, ""); got != http.StatusOK {
+		t.Errorf("app password: status %d, want 200", got)
+	}
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "guess", ""); got != http.StatusTooManyRequests {
+		t.Errorf("wrong password: status %d, want 429", got)
+	}
+
+	// A verified password stops passing once it is changed.
+	if err := user.SetPassword("new password"); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Model(user).Update("password_hash", user.PasswordHash)
+	if got := webDAVRequest(r, "GET", "/webdav/a.txt", user.Email, "account password", ""); got != http.StatusTooManyRequests {
+		t.Errorf("old account password: status %d, want 429", got)
+	}
+}
+
+func TestPersonalTokenScopes(t *testing.T) {
+	dbtest.Open(t)
+	tokens, err := services.NewTokenService(&config.Config{JWTAlgorithm: "HS256", JWTSecret: "test-secret", AccessTTL: time.Minute, RefreshTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, "user@example.com", "account password")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api", AuthMiddleware(tokens, services.NewSettingsService(), nil))
+	files := RequireScope(services.ScopeFilesRead, services.ScopeFilesWrite)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/files", files, ok)
+	api.POST("/files", files, ok)
+	api.POST("/auth/password", RequireSession(), ok)
+
+	_, readToken, err := tokens.CreatePersonalToken(user, "backup", []string{services.ScopeFilesRead}, nil, []string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := tokens.CreateSession(user.ID, "", "")
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		remote string
+		want   int
+	}{
+		{"read scope on GET", "GET", "/api/files", readToken, "192.0.2.10", http.StatusOK},
+		{"read scope on POST", "POST", "/api/files", readToken, "192.0.2.10", http.StatusForbidden},
+		{"token from another address", "GET", "/api/files", readToken, "198.51.100.1", http.StatusForbidden},
+		{"token on a session-only route", "POST", "/api/auth/password", readToken, "192.0.2.10", http.StatusForbidden},
+		{"unknown token", "GET", "/api/files", services.PersonalTokenPrefix + "nope", "192.0.2.10", http.StatusUnauthorized},
+		{"session on POST", "POST", "/api/files", session.AccessToken, "198.51.100.1", http.StatusOK},
+		{"session on a session-only route", "POST", "/api/auth/password", session.AccessToken, "198.51.100.1", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.RemoteAddr = tc.remote + ":40000"
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestImpersonationToken(t *testing.T) {
+	dbtest.Open(t)
+	cfg := &config.Config{JWTAlgorithm: "HS256", JWTSecret: "test-secret", AccessTTL: time.Minute, RefreshTTL: time.Hour, ImpersonationMaxTTL: time.Hour}
+	tokens, err := services.NewTokenService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := services.NewRoleService()
+	if err := roles.Load(); err != nil {
+		t.Fatal(err)
+	}
+	impersonations := services.NewImpersonationService(cfg, tokens, roles)
+	admin := createTestUser(t, "admin@example.com", "admin password")
+	database.DB.Model(admin).Update("role", models.RoleAdmin)
+	user := createTestUser(t, "user@example.com", "account password")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api", AuthMiddleware(tokens, services.NewSettingsService(), impersonations))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/files", ok)
+	api.POST("/files", ok)
+	api.POST("/account/password", RejectImpersonation(), ok)
+
+	readOnly, readOnlyToken, _, err := impersonations.Start(admin, user, "ticket 42", true, 0, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fullToken, _, err := impersonations.Start(admin, user, "ticket 43", false, 0, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"read-only on GET", "GET", "/api/files", readOnlyToken, http.StatusOK},
+		{"read-only on POST", "POST", "/api/files", readOnlyToken, http.StatusForbidden},
+		{"full access on POST", "POST", "/api/files", fullToken, http.StatusOK},
+		{"credential management", "POST", "/api/account/password", fullToken, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if w.Header().Get("X-Impersonated-By") != admin.ID.String() {
+			t.Errorf("%s: X-Impersonated-By = %q", tc.name, w.Header().Get("X-Impersonated-By"))
+		}
+	}
+
+	// Rejected requests are logged as well.
+	var logged int64
+	database.DB.Model(&models.Activity{}).Where("user_id = ? AND type = ?", user.ID, models.ActivityImpersonatedRequest).Count(&logged)
+	if logged != 4 {
+		t.Errorf("%d impersonated requests logged, want 4", logged)
+	}
+
+	if err := impersonations.End(readOnly.ID, admin, models.ActivityOrigin{}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/api/files", nil)
+	req.Header.Set("Authorization", "Bearer "+readOnlyToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("ended impersonation: status %d, want 401", w.Code)
+	}
+}
+
+func TestMFAEnrollmentRequired(t *testing.T) {
+	dbtest.Open(t)
+	tokens, err := services.NewTokenService(&config.Config{JWTAlgorithm: "HS256", JWTSecret: "test-secret", AccessTTL: time.Minute, RefreshTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := services.NewSettingsService()
+	if _, err := settings.Update(map[string]json.RawMessage{"mfa_policy": json.RawMessage(`"all"`)}); err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, "user@example.com", "account password")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api", AuthMiddleware(tokens, settings, nil))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/auth/me", ok)
+	api.POST("/auth/2fa/setup", ok)
+	api.POST("/auth/password", ok)
+	api.GET("/auth/app-passwords", ok)
+	api.GET("/files", ok)
+
+	session, _ := tokens.CreateSession(user.ID, "", "")
+	_, personalToken, err := tokens.CreatePersonalToken(user, "backup", []string{services.ScopeFilesRead}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"GET", "/api/auth/me", session.AccessToken, http.StatusOK},
+		{"POST", "/api/auth/2fa/setup", session.AccessToken, http.StatusOK},
+		{"POST", "/api/auth/password", session.AccessToken, http.StatusForbidden},
+		{"GET", "/api/auth/app-passwords", session.AccessToken, http.StatusForbidden},
+		{"GET", "/api/files", session.AccessToken, http.StatusForbidden},
+		{"GET", "/api/files", personalToken, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s %s before enrolling: status %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+
+	// WebDAV offers no way to enroll, so neither kind of password works.
+	webdav := newWebDAVTestRouter(t, settings, newTestLimiter())
+	_, secret, _ := services.NewAppPasswordService().Create(user.ID, "phone", "", "/")
+	if got := webDAVRequest(webdav, "GET", "/webdav/a.txt", user.Email, "account password", ""); got != http.StatusUnauthorized {
+		t.Errorf("WebDAV account password before enrolling: status %d, want 401", got)
+	}
+	if got := webDAVRequest(webdav, "GET", "/webdav/a.txt", user.Email, secret, ""); got != http.StatusForbidden {
+		t.Errorf("WebDAV app password before enrolling: status %d, want 403", got)
+	}
+
+	database.DB.Model(user).Update("totp_enabled", true)
+	if got := webDAVRequest(webdav, "GET", "/webdav/a.txt", user.Email, secret, ""); got != http.StatusOK {
+		t.Errorf("WebDAV app password after enrolling: status %d, want 200", got)
+	}
+	req := httptest.NewRequest("GET", "/api/files", nil)
+	req.Header.Set("Authorization", "Bearer "+personalToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("personal token after enrolling: status %d, want 200", w.Code)
+	}
+}
@@ -11,8 +11,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Disposition"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept", "If-Match"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", "ETag", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	})
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/telemetry"
+)
+
+// captureHandler keeps every record together with the request ID of the
+// context it was logged with.
+type captureHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+	ids     []string
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *captureHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, record)
+	h.ids = append(h.ids, telemetry.RequestID(ctx))
+	return nil
+}
+
+func captureLogs(t *testing.T) *captureHandler {
+	t.Helper()
+	handler := &captureHandler{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return handler
+}
+
+func attrs(record slog.Record) map[string]string {
+	values := make(map[string]string)
+	record.Attrs(func(attr slog.Attr) bool {
+		values[attr.Key] = attr.Value.String()
+		return true
+	})
+	return values
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, telemetry.RequestID(c.Request.Context())) })
+
+	for _, tc := range []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"none", "", false},
+		{"from a proxy", "7b0c5d0e-proxy:1", true},
+		{"with a line break", "abc\nlevel=ERROR", false},
+		{"with spaces", "abc def", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.incoming != "" {
+			req.Header.Set(RequestIDHeader, tc.incoming)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		id := w.Header().Get(RequestIDHeader)
+		if id == "" || w.Body.String() != id {
+			t.Errorf("%s: header %q, context %q", tc.name, id, w.Body.String())
+		}
+		if (id == tc.incoming) != tc.keep {
+			t.Errorf("%s: incoming %q, got %q", tc.name, tc.incoming, id)
+		}
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	logs := captureLogs(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID(), RequestLogger())
+	r.GET("/s/:token", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/s/abc?password=secret", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(logs.records) != 1 {
+		t.Fatalf("%d log records, want 1", len(logs.records))
+	}
+	values := attrs(logs.records[0])
+	if values["path"] != "/s/abc" || values["route"] != "/s/:token" || values["status"] != "200" {
+		t.Errorf("logged %v", values)
+	}
+	for key, value := range values {
+		if strings.Contains(value, "secret") {
+			t.Errorf("%s = %q leaks the query string", key, value)
+		}
+	}
+	if logs.ids[0] != "req-1" {
+		t.Errorf("logged with request ID %q, want req-1", logs.ids[0])
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	logs := captureLogs(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID(), Recovery())
+	r.GET("/", func(c *gin.Context) { panic("boom") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", w.Code)
+	}
+	if len(logs.records) != 1 || logs.records[0].Level != slog.LevelError || attrs(logs.records[0])["panic"] != "boom" {
+		t.Fatalf("records = %v", logs.records)
+	}
+	if logs.ids[0] == "" || logs.ids[0] != w.Header().Get(RequestIDHeader) {
+		t.Errorf("panic logged with request ID %q, response has %q", logs.ids[0], w.Header().Get(RequestIDHeader))
+	}
+}
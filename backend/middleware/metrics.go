@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/config"
+	"stratus/metrics"
+)
+
+// Metrics records the count and duration of every request by route
+// pattern, so paths with IDs in them share a series.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		method, route := c.Request.Method, c.FullPath()
+		if route == "" {
+			// Unmatched requests can carry any method; keep them in one series.
+			method, route = "OTHER", "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HTTPDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+var loopbackNetworks = []string{"127.0.0.0/8", "::1/128"}
+
+// MetricsAccess admits requests bearing METRICS_TOKEN or coming from one of
+// METRICS_ALLOWED_NETWORKS. With neither configured only loopback clients
+// are admitted. Networks are matched against the connecting address, never
+// against forwarding headers, which clients can set freely.
+func MetricsAccess(cfg *config.Config) gin.HandlerFunc {
+	allowed := cfg.MetricsAllowedNetworks
+	if len(allowed) == 0 && cfg.MetricsToken == "" {
+		allowed = loopbackNetworks
+	}
+	var networks []*net.IPNet
+	for _, cidr := range allowed {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return func(c *gin.Context) {
+		if cfg.MetricsToken != "" {
+			token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if ok && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) == 1 {
+				c.Next()
+				return
+			}
+		}
+
+		if ip := net.ParseIP(c.RemoteIP()); ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
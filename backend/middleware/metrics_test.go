@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"stratus/config"
+	"stratus/metrics"
+)
+
+func TestMetricsAccess(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		cfg       config.Config
+		remote    string
+		token     string
+		forwarded string
+		want      int
+	}{
+		{"default from loopback", config.Config{}, "127.0.0.1", "", "", http.StatusOK},
+		{"default from IPv6 loopback", config.Config{}, "[::1]", "", "", http.StatusOK},
+		{"default from elsewhere", config.Config{}, "192.0.2.1", "", "", http.StatusForbidden},
+		{"default with forged forwarding header", config.Config{}, "192.0.2.1", "", "127.0.0.1", http.StatusForbidden},
+		{"token", config.Config{MetricsToken: "scrape"}, "192.0.2.1", "scrape", "", http.StatusOK},
+		{"wrong token", config.Config{MetricsToken: "scrape"}, "192.0.2.1", "scrap", "", http.StatusForbidden},
+		{"token configured, loopback without it", config.Config{MetricsToken: "scrape"}, "127.0.0.1", "", "", http.StatusForbidden},
+		{"allowed network", config.Config{MetricsAllowedNetworks: []string{"10.0.0.0/8"}}, "10.1.2.3", "", "", http.StatusOK},
+		{"outside allowed network", config.Config{MetricsAllowedNetworks: []string{"10.0.0.0/8"}}, "127.0.0.1", "", "", http.StatusForbidden},
+		{"token or network", config.Config{MetricsToken: "scrape", MetricsAllowedNetworks: []string{"10.0.0.0/8"}}, "10.1.2.3", "", "", http.StatusOK},
+	} {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		cfg := tc.cfg
+		r.GET("/metrics", MetricsAccess(&cfg), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = tc.remote + ":40000"
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestMetricsLabelsByRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Metrics())
+	r.GET("/api/files/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, path := range []string{"/api/files/1", "/api/files/2", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	counts := make(map[string]float64)
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "stratus_http_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := ""
+			for _, label := range metric.GetLabel() {
+				labels += label.GetName() + "=" + label.GetValue() + " "
+			}
+			counts[labels] = metric.GetCounter().GetValue()
+		}
+	}
+
+	if got := counts["method=GET route=/api/files/:id status=204 "]; got != 2 {
+		t.Errorf("requests for /api/files/:id = %v, want 2; series %v", got, counts)
+	}
+	if got := counts["method=OTHER route=unmatched status=404 "]; got != 1 {
+		t.Errorf("unmatched requests = %v, want 1; series %v", got, counts)
+	}
+}
@@ -17,23 +17,110 @@ const (
 	ActivityFileShared     ActivityType = "file_shared"
 	ActivityFileDownloaded ActivityType = "file_downloaded"
 	ActivityFolderCreated  ActivityType = "folder_created"
+	ActivityFileRenamed    ActivityType = "file_renamed"
+	ActivityFileCopied     ActivityType = "file_copied"
+	ActivityFileTrashed    ActivityType = "file_trashed"
+	ActivityFileRestored   ActivityType = "file_restored"
+	ActivityTrashEmptied   ActivityType = "trash_emptied"
+
+	// Transfers are stored on both the previous and the new owner with the
+	// admin as ActorID.
+	ActivityFilesTransferred ActivityType = "files_transferred"
+
 	ActivityUserLogin      ActivityType = "user_login"
 	ActivityUserLogout     ActivityType = "user_logout"
+	ActivitySessionRevoked ActivityType = "session_revoked"
+	ActivityMFAEnabled     ActivityType = "mfa_enabled"
+	ActivityMFADisabled    ActivityType = "mfa_disabled"
+	ActivityLoginFailed    ActivityType = "login_failed"
+	ActivityAccountLocked  ActivityType = "account_locked"
+	ActivityRoleChanged    ActivityType = "role_changed"
+
+	ActivityProfileUpdated     ActivityType = "profile_updated"
+	ActivityPasswordChanged    ActivityType = "password_changed"
+	ActivityPasswordReset      ActivityType = "password_reset"
+	ActivityEmailVerified      ActivityType = "email_verified"
+	ActivityAppPasswordCreated ActivityType = "app_password_created"
+	ActivityAppPasswordRevoked ActivityType = "app_password_revoked"
+	ActivityTokenCreated       ActivityType = "token_created"
+	ActivityTokenRevoked       ActivityType = "token_revoked"
+	ActivityRecoveryCodesReset ActivityType = "recovery_codes_regenerated"
+
+	// Accounts changed by an admin carry the admin as ActorID.
+	ActivityUserRegistered        ActivityType = "user_registered"
+	ActivityUserUpdated           ActivityType = "user_updated"
+	ActivityUserDeleted           ActivityType = "user_deleted"
+	ActivityUserDeletionScheduled ActivityType = "user_deletion_scheduled"
+	ActivityUserDeletionCancelled ActivityType = "user_deletion_cancelled"
+	ActivityUserDataExported      ActivityType = "user_data_exported"
+	ActivityServiceAccountCreated ActivityType = "service_account_created"
+
+	// Administrative changes that are not about one user are stored on the
+	// admin who made them.
+	ActivityRoleCreated     ActivityType = "role_created"
+	ActivityRoleUpdated     ActivityType = "role_updated"
+	ActivityRoleDeleted     ActivityType = "role_deleted"
+	ActivitySettingsUpdated ActivityType = "settings_updated"
+	ActivityInviteCreated   ActivityType = "invite_created"
+	ActivityInviteRevoked   ActivityType = "invite_revoked"
+	ActivityLockoutCleared  ActivityType = "lockout_cleared"
+
+	// Impersonation activities are stored on the impersonated user with the
+	// admin as ActorID.
+	ActivityImpersonationStarted ActivityType = "impersonation_started"
+	ActivityImpersonationEnded   ActivityType = "impersonation_ended"
+	ActivityImpersonatedRequest  ActivityType = "impersonated_request"
+)
+
+// Sources record which interface a change came through. System changes
+// come from background jobs such as directory sync.
+const (
+	ActivitySourceWeb    = "web"
+	ActivitySourceWebDAV = "webdav"
+	ActivitySourceAPI    = "api"
+	ActivitySourceSystem = "system"
+	ActivitySourceSCIM   = "scim"
 )
 
+// ActivityOrigin is where a change was requested from.
+type ActivityOrigin struct {
+	Source    string
+	IPAddress string
+	UserAgent string
+}
+
+// ActivityChange holds one field's value before and after a mutation; nil
+// means the field did not exist on that side. Secrets are never stored, so
+// changes to them have neither value.
+type ActivityChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type ActivityChanges map[string]ActivityChange
+
 type Activity struct {
 	ID        uuid.UUID    `gorm:"type:uuid;primary_key" json:"id"`
 	UserID    uuid.UUID    `gorm:"type:uuid;not null;index" json:"user_id"`
+	ActorID   *uuid.UUID   `gorm:"type:uuid;index" json:"actor_id,omitempty"`
 	Type      ActivityType `gorm:"type:varchar(50);not null" json:"type"`
 	FileID    *uuid.UUID   `gorm:"type:uuid;index" json:"file_id,omitempty"`
 	FileName  string       `gorm:"size:255" json:"file_name,omitempty"`
 	Details   string       `gorm:"type:text" json:"details,omitempty"`
 	IPAddress string       `gorm:"size:45" json:"ip_address,omitempty"`
 	UserAgent string       `gorm:"size:500" json:"user_agent,omitempty"`
-	CreatedAt time.Time    `json:"created_at"`
+	Source    string       `gorm:"size:20" json:"source,omitempty"`
+	CreatedAt time.Time    `gorm:"index" json:"created_at"`
+
+	// AuditedAt is set once the audit writer has copied the activity into
+	// the audit log; until then the row waits in the outbox.
+	AuditedAt *time.Time `gorm:"index" json:"-"`
+
+	Changes ActivityChanges `gorm:"serializer:json;type:text" json:"changes,omitempty"`
 
-	User User  `gorm:"foreignKey:UserID" json:"-"`
-	File *File `gorm:"foreignKey:FileID" json:"-"`
+	User  User  `gorm:"foreignKey:UserID" json:"-"`
+	Actor *User `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+	File  *File `gorm:"foreignKey:FileID" json:"-"`
 }
 
 func (a *Activity) BeforeCreate(tx *gorm.DB) error {
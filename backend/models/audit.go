@@ -0,0 +1,112 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditGenesisHash is the PrevHash of the first audit entry.
+const AuditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// AuditLockKey serialises appends across every server instance through a
+// Postgres advisory lock.
+const AuditLockKey = 0x5374726174757341
+
+// AuditEntry is one record of the append-only audit log. Each entry's Hash
+// covers its own fields and the Hash of the entry before it, so editing,
+// removing or reordering entries breaks the chain.
+//
+// Personal data (labels, file name, details, changes, IP address and user
+// agent) is not hashed directly but through PIIDigest. Pseudonymising an
+// entry replaces that data and sets PseudonymisedAt while PIIDigest, and
+// with it the chain, stays untouched.
+type AuditEntry struct {
+	Seq        int64      `gorm:"primaryKey;autoIncrement:false" json:"seq"`
+	CreatedAt  time.Time  `gorm:"not null;index" json:"created_at"`
+	Action     string     `gorm:"size:50;not null;index" json:"action"`
+	ActivityID *uuid.UUID `gorm:"type:uuid;index" json:"activity_id,omitempty"`
+	ActorID    *uuid.UUID `gorm:"type:uuid;index" json:"actor_id,omitempty"`
+	SubjectID  *uuid.UUID `gorm:"type:uuid;index" json:"subject_id,omitempty"`
+	FileID     *uuid.UUID `gorm:"type:uuid" json:"file_id,omitempty"`
+	Source     string     `gorm:"size:20" json:"source,omitempty"`
+
+	ActorLabel      string     `gorm:"size:255" json:"actor_label,omitempty"`
+	SubjectLabel    string     `gorm:"size:255" json:"subject_label,omitempty"`
+	FileName        string     `gorm:"size:255" json:"file_name,omitempty"`
+	Details         string     `gorm:"type:text" json:"details,omitempty"`
+	Changes         string     `gorm:"type:text" json:"changes,omitempty"`
+	IPAddress       string     `gorm:"size:45" json:"ip_address,omitempty"`
+	UserAgent       string     `gorm:"size:500" json:"user_agent,omitempty"`
+	PseudonymisedAt *time.Time `json:"pseudonymised_at,omitempty"`
+
+	PIIDigest string `gorm:"column:pii_digest;size:64;not null" json:"pii_digest"`
+	PrevHash  string `gorm:"size:64;not null" json:"prev_hash"`
+	Hash      string `gorm:"size:64;not null;uniqueIndex" json:"hash"`
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+func (e *AuditEntry) ComputePIIDigest() string {
+	return digestJSON(struct {
+		ActorLabel   string `json:"actor_label"`
+		SubjectLabel string `json:"subject_label"`
+		FileName     string `json:"file_name"`
+		Details      string `json:"details"`
+		Changes      string `json:"changes"`
+		IPAddress    string `json:"ip_address"`
+		UserAgent    string `json:"user_agent"`
+	}{e.ActorLabel, e.SubjectLabel, e.FileName, e.Details, e.Changes, e.IPAddress, e.UserAgent})
+}
+
+func (e *AuditEntry) ComputeHash() string {
+	return digestJSON(struct {
+		Seq        int64      `json:"seq"`
+		CreatedAt  string     `json:"created_at"`
+		Action     string     `json:"action"`
+		ActivityID *uuid.UUID `json:"activity_id"`
+		ActorID    *uuid.UUID `json:"actor_id"`
+		SubjectID  *uuid.UUID `json:"subject_id"`
+		FileID     *uuid.UUID `json:"file_id"`
+		Source     string     `json:"source"`
+		PIIDigest  string     `json:"pii_digest"`
+		PrevHash   string     `json:"prev_hash"`
+	}{e.Seq, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.Action, e.ActivityID, e.ActorID, e.SubjectID, e.FileID, e.Source, e.PIIDigest, e.PrevHash})
+}
+
+func digestJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAuditEntry links entry to the end of the chain and inserts it. It
+// must run inside a transaction holding the AuditLockKey advisory lock, so
+// concurrent appends cannot fork the chain.
+func AppendAuditEntry(tx *gorm.DB, entry *AuditEntry) error {
+	var last AuditEntry
+	if err := tx.Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+		return err
+	}
+	entry.Seq = last.Seq + 1
+	entry.PrevHash = AuditGenesisHash
+	if last.Hash != "" {
+		entry.PrevHash = last.Hash
+	}
+
+	// Postgres keeps microseconds; hash the value that will be read back.
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+	entry.PIIDigest = entry.ComputePIIDigest()
+	entry.Hash = entry.ComputeHash()
+
+	return tx.Create(entry).Error
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type FileContent struct {
+	FileID    uuid.UUID `gorm:"type:uuid;primary_key" json:"file_id"`
+	OwnerID   uuid.UUID `gorm:"type:uuid;not null;index" json:"owner_id"`
+	Version   int       `gorm:"not null" json:"version"`
+	Checksum  string    `gorm:"size:64" json:"checksum"`
+	Content   string    `gorm:"type:text" json:"-"`
+	Error     string    `gorm:"size:255" json:"error,omitempty"`
+	IndexedAt time.Time `json:"indexed_at"`
+
+	File File `gorm:"foreignKey:FileID" json:"-"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type DeletionStatus string
+
+const (
+	DeletionScheduled DeletionStatus = "scheduled"
+	DeletionRunning   DeletionStatus = "running"
+	DeletionCompleted DeletionStatus = "completed"
+	DeletionCancelled DeletionStatus = "cancelled"
+	DeletionFailed    DeletionStatus = "failed"
+)
+
+// UserDeletion is a background job that removes an account and everything
+// it owns. Until ScheduledFor passes the account is only deactivated and
+// the deletion can be cancelled.
+type UserDeletion struct {
+	ID            uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
+	UserID        uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	RequestedByID *uuid.UUID     `gorm:"type:uuid" json:"requested_by_id,omitempty"`
+	Status        DeletionStatus `gorm:"size:20;not null;index" json:"status"`
+	ScheduledFor  time.Time      `gorm:"not null;index" json:"scheduled_for"`
+	StartedAt     *time.Time     `json:"started_at,omitempty"`
+	CompletedAt   *time.Time     `json:"completed_at,omitempty"`
+	FilesTotal    int64          `json:"files_total"`
+	FilesDeleted  int64          `json:"files_deleted"`
+	BytesFreed    int64          `json:"bytes_freed"`
+	Error         string         `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+
+	// WasActive restores the account if the deletion is cancelled.
+	WasActive bool `gorm:"default:false" json:"-"`
+
+	User *User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (d *UserDeletion) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// Pending reports whether the deletion is still going to happen.
+func (d *UserDeletion) Pending() bool {
+	return d.Status == DeletionScheduled || d.Status == DeletionRunning
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type EmailTokenPurpose string
+
+const (
+	EmailTokenPasswordReset EmailTokenPurpose = "password_reset"
+	EmailTokenVerification  EmailTokenPurpose = "email_verification"
+	EmailTokenChange        EmailTokenPurpose = "email_change"
+)
+
+type EmailToken struct {
+	ID        uuid.UUID         `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID         `gorm:"type:uuid;not null;index" json:"user_id"`
+	Purpose   EmailTokenPurpose `gorm:"size:30;not null" json:"purpose"`
+	TokenHash string            `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Email     string            `gorm:"size:255;not null" json:"email"`
+	ExpiresAt time.Time         `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time        `json:"used_at,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (t *EmailToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
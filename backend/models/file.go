@@ -26,9 +26,10 @@ type File struct {
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 
-	Owner    User   `gorm:"foreignKey:OwnerID" json:"-"`
-	Parent   *File  `gorm:"foreignKey:ParentID" json:"-"`
-	Children []File `gorm:"foreignKey:ParentID" json:"children,omitempty"`
+	Owner    User           `gorm:"foreignKey:OwnerID" json:"-"`
+	Parent   *File          `gorm:"foreignKey:ParentID" json:"-"`
+	Children []File         `gorm:"foreignKey:ParentID" json:"children,omitempty"`
+	Metadata *MediaMetadata `gorm:"foreignKey:FileID" json:"metadata,omitempty"`
 }
 
 func (f *File) BeforeCreate(tx *gorm.DB) error {
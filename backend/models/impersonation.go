@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Impersonation records an admin acting as another user. Tokens issued for
+// it are only valid while the record is neither expired nor ended.
+type Impersonation struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	ImpersonatorID uuid.UUID  `gorm:"type:uuid;not null;index" json:"impersonator_id"`
+	TargetID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"target_id"`
+	Reason         string     `gorm:"size:500;not null" json:"reason"`
+	ReadOnly       bool       `gorm:"default:false" json:"read_only"`
+	IPAddress      string     `gorm:"size:45" json:"ip_address,omitempty"`
+	ExpiresAt      time.Time  `gorm:"not null" json:"expires_at"`
+	EndedAt        *time.Time `json:"ended_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	Impersonator *User `gorm:"foreignKey:ImpersonatorID" json:"impersonator,omitempty"`
+	Target       *User `gorm:"foreignKey:TargetID" json:"target,omitempty"`
+}
+
+func (i *Impersonation) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+func (i *Impersonation) IsActive() bool {
+	return i.EndedAt == nil && time.Now().Before(i.ExpiresAt)
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Invite is a single-use registration code. Quota and Role are applied to
+// the account created with it; Email, when set, restricts who may use it.
+type Invite struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	CodeHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Hint      string     `gorm:"size:16" json:"hint"`
+	Email     string     `gorm:"size:255" json:"email,omitempty"`
+	Note      string     `gorm:"size:255" json:"note,omitempty"`
+	Quota     *int64     `json:"quota,omitempty"`
+	Role      string     `gorm:"size:50;not null;default:user" json:"role"`
+	CreatedBy uuid.UUID  `gorm:"type:uuid;not null" json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedBy    *uuid.UUID `gorm:"type:uuid" json:"used_by,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (i *Invite) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MediaKind string
+
+const (
+	MediaImage MediaKind = "image"
+	MediaAudio MediaKind = "audio"
+	MediaVideo MediaKind = "video"
+)
+
+type MediaMetadata struct {
+	FileID      uuid.UUID  `gorm:"type:uuid;primary_key" json:"-"`
+	OwnerID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"-"`
+	Kind        MediaKind  `gorm:"type:varchar(20);not null;index" json:"kind"`
+	Checksum    string     `gorm:"size:64" json:"-"`
+	CapturedAt  *time.Time `gorm:"index" json:"captured_at,omitempty"`
+	CameraMake  string     `gorm:"size:100" json:"camera_make,omitempty"`
+	CameraModel string     `gorm:"size:100" json:"camera_model,omitempty"`
+	Latitude    *float64   `json:"latitude,omitempty"`
+	Longitude   *float64   `json:"longitude,omitempty"`
+	Width       int        `json:"width,omitempty"`
+	Height      int        `json:"height,omitempty"`
+	Orientation int        `json:"orientation,omitempty"`
+	DurationMs  int64      `json:"duration_ms,omitempty"`
+	Title       string     `gorm:"size:255" json:"title,omitempty"`
+	Artist      string     `gorm:"size:255" json:"artist,omitempty"`
+	Album       string     `gorm:"size:255" json:"album,omitempty"`
+	Genre       string     `gorm:"size:100" json:"genre,omitempty"`
+	Year        int        `json:"year,omitempty"`
+	TrackNumber int        `json:"track_number,omitempty"`
+	VideoCodec  string     `gorm:"size:50" json:"video_codec,omitempty"`
+	AudioCodec  string     `gorm:"size:50" json:"audio_codec,omitempty"`
+	ExtractedAt time.Time  `json:"extracted_at"`
+}
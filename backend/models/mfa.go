@@ -0,0 +1,68 @@
+package models
+
+import (
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (r *RecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+type AppPasswordScope string
+
+const (
+	AppPasswordReadOnly  AppPasswordScope = "read"
+	AppPasswordReadWrite AppPasswordScope = "read_write"
+)
+
+type AppPassword struct {
+	ID           uuid.UUID        `gorm:"type:uuid;primary_key" json:"id"`
+	UserID       uuid.UUID        `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name         string           `gorm:"size:100;not null" json:"name"`
+	PasswordHash string           `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scope        AppPasswordScope `gorm:"size:20;not null;default:read_write" json:"scope"`
+	PathPrefix   string           `gorm:"size:1024;not null;default:'/'" json:"path_prefix"`
+	LastUsedAt   *time.Time       `json:"last_used_at,omitempty"`
+	LastUsedIP   string           `gorm:"size:45" json:"last_used_ip,omitempty"`
+	RevokedAt    *time.Time       `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (a *AppPassword) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+func (a *AppPassword) CanWrite() bool {
+	return a.Scope == AppPasswordReadWrite
+}
+
+// CoversPath reports whether path lies inside the password's path prefix.
+func (a *AppPassword) CoversPath(path string) bool {
+	prefix := strings.TrimSuffix(a.PathPrefix, "/")
+	if prefix == "" {
+		return true
+	}
+	path = pathpkg.Clean("/" + path)
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Permission string
+
+const (
+	PermUsersRead             Permission = "users:read"
+	PermUsersWrite            Permission = "users:write"
+	PermUsersDelete           Permission = "users:delete"
+	PermUsersExport           Permission = "users:export"
+	PermFilesTransfer         Permission = "files:transfer"
+	PermUsersProvision        Permission = "users:provision"
+	PermUsersImpersonate      Permission = "users:impersonate"
+	PermRolesAssign           Permission = "roles:assign"
+	PermRolesManage           Permission = "roles:manage"
+	PermActivityRead          Permission = "activity:read"
+	PermSettingsRead          Permission = "settings:read"
+	PermSettingsWrite         Permission = "settings:write"
+	PermStatsRead             Permission = "stats:read"
+	PermServiceAccountsManage Permission = "service_accounts:manage"
+	PermInvitesManage         Permission = "invites:manage"
+	PermLockoutsRead          Permission = "lockouts:read"
+	PermLockoutsWrite         Permission = "lockouts:write"
+	PermAuditRead             Permission = "audit:read"
+)
+
+var AllPermissions = []Permission{
+	PermUsersRead,
+	PermUsersWrite,
+	PermUsersDelete,
+	PermUsersExport,
+	PermFilesTransfer,
+	PermUsersProvision,
+	PermUsersImpersonate,
+	PermRolesAssign,
+	PermRolesManage,
+	PermActivityRead,
+	PermSettingsRead,
+	PermSettingsWrite,
+	PermStatsRead,
+	PermServiceAccountsManage,
+	PermInvitesManage,
+	PermLockoutsRead,
+	PermLockoutsWrite,
+	PermAuditRead,
+}
+
+const (
+	RoleAdmin       = "admin"
+	RoleUserManager = "user-manager"
+	RoleAuditor     = "auditor"
+	RoleUser        = "user"
+)
+
+// Role is a named set of permissions. Users reference roles by name, which
+// is why names cannot change once created. Built-in roles are recreated at
+// startup and cannot be edited or deleted.
+type Role struct {
+	ID          uuid.UUID    `gorm:"type:uuid;primary_key" json:"id"`
+	Name        string       `gorm:"size:50;not null;uniqueIndex" json:"name"`
+	Description string       `gorm:"size:255" json:"description"`
+	Permissions []Permission `gorm:"serializer:json;type:text" json:"permissions"`
+	BuiltIn     bool         `gorm:"default:false" json:"built_in"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+func (r *Role) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *Role) Has(permission Permission) bool {
+	for _, granted := range r.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether r grants every permission of other.
+func (r *Role) Covers(other *Role) bool {
+	for _, permission := range other.Permissions {
+		if !r.Has(permission) {
+			return false
+		}
+	}
+	return true
+}
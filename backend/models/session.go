@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Session struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	IPAddress     string     `gorm:"size:45" json:"ip_address,omitempty"`
+	UserAgent     string     `gorm:"size:500" json:"user_agent,omitempty"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	ExpiresAt     time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `gorm:"size:50" json:"revoked_reason,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+type RefreshToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	SessionID uuid.UUID  `gorm:"type:uuid;not null;index" json:"session_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	Session Session `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+type Setting struct {
+	Key       string    `gorm:"primary_key;size:100" json:"key"`
+	Value     string    `gorm:"type:text;not null" json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
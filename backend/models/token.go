@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Hint       string     `gorm:"size:16" json:"hint"`
+	Scopes     []string   `gorm:"serializer:json;type:text" json:"scopes"`
+	AllowedIPs []string   `gorm:"serializer:json;type:text" json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `gorm:"size:45" json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (t *PersonalAccessToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// HasScope reports whether the token grants scope. A ":write" scope also
+// grants the matching ":read" scope.
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+		if strings.HasSuffix(scope, ":read") && granted == strings.TrimSuffix(scope, ":read")+":write" {
+			return true
+		}
+	}
+	return false
+}
@@ -8,15 +8,31 @@ import (
 	"gorm.io/gorm"
 )
 
+type AuthSource string
+
+const (
+	AuthSourceLocal AuthSource = "local"
+	AuthSourceLDAP  AuthSource = "ldap"
+	AuthSourceOIDC  AuthSource = "oidc"
+)
+
 type User struct {
 	ID           uuid.UUID      `gorm:"type:uuid;primary_key" json:"id"`
 	Email        string         `gorm:"uniqueIndex;not null;size:255" json:"email"`
+	VerifiedAt   *time.Time     `json:"email_verified_at"`
 	PasswordHash string         `gorm:"not null" json:"-"`
 	DisplayName  string         `gorm:"size:255" json:"display_name"`
 	Quota        int64          `gorm:"default:10737418240" json:"quota"`
 	UsedSpace    int64          `gorm:"default:0" json:"used_space"`
-	IsAdmin      bool           `gorm:"default:false" json:"is_admin"`
+	Role         string         `gorm:"size:50;not null;default:user;index" json:"role"`
 	IsActive     bool           `gorm:"default:true" json:"is_active"`
+	IsService    bool           `gorm:"default:false" json:"is_service_account"`
+	TOTPSecret   string         `gorm:"size:64" json:"-"`
+	TOTPEnabled  bool           `gorm:"default:false" json:"totp_enabled"`
+	TOTPLastStep int64          `gorm:"default:0" json:"-"`
+	OIDCSubject  *string        `gorm:"column:oidc_subject;size:255;uniqueIndex" json:"-"`
+	AuthSource   AuthSource     `gorm:"size:20;not null;default:local" json:"auth_source"`
+	ExternalID   string         `gorm:"size:512;index" json:"-"`
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
@@ -45,6 +61,12 @@ func (u *User) CheckPassword(password string) bool {
 	return err == nil
 }
 
+// IsStaff reports whether the user holds any role beyond the default one.
+// Every other role grants at least one admin permission.
+func (u *User) IsStaff() bool {
+	return u.Role != "" && u.Role != RoleUser
+}
+
 func (u *User) HasEnoughSpace(size int64) bool {
 	return u.UsedSpace+size <= u.Quota
 }
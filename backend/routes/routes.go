@@ -1,48 +1,153 @@
 package routes
 
 import (
+	"fmt"
+	"log/slog"
+
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"stratus/config"
 	"stratus/handlers"
+	"stratus/metrics"
 	"stratus/middleware"
+	"stratus/models"
 	"stratus/services"
 )
 
-func SetupRoutes(r *gin.Engine, cfg *config.Config) {
+func SetupRoutes(r *gin.Engine, cfg *config.Config) error {
 	storageService := services.NewStorageService(cfg)
 	storageService.InitStorage()
 
-	authHandler := handlers.NewAuthHandler(cfg)
-	fileHandler := handlers.NewFileHandler(cfg, storageService)
-	adminHandler := handlers.NewAdminHandler(cfg)
-	webdavHandler := handlers.NewWebDAVHandler(cfg, storageService)
+	settingsService := services.NewSettingsService()
+	if err := settingsService.Load(); err != nil {
+		slog.Error("Failed to load settings, using defaults", "error", err)
+	}
+
+	roleService := services.NewRoleService()
+	if err := roleService.Load(); err != nil {
+		slog.Error("Failed to load roles", "error", err)
+	}
+
+	searchService := services.NewSearchService(cfg)
+	searchService.Start()
+
+	metadataService := services.NewMetadataService(cfg)
+	metadataService.Start()
+
+	thumbnailService := services.NewThumbnailService(cfg)
+	thumbnailService.InitThumbnails()
+
+	contentPipeline := services.NewContentPipeline(searchService, metadataService, thumbnailService)
+
+	tokenService, err := services.NewTokenService(cfg)
+	if err != nil {
+		return fmt.Errorf("loading JWT keys: %w", err)
+	}
+	mfaService := services.NewMFAService(cfg)
+	appPasswordService := services.NewAppPasswordService()
+	oidcService := services.NewOIDCService(cfg, tokenService)
+	impersonationService := services.NewImpersonationService(cfg, tokenService, roleService)
+
+	ldapService := services.NewLDAPService(cfg)
+	ldapService.StartSync()
+	authService := services.NewAuthService(services.LocalAuthProvider{}, ldapService)
+
+	mailer := services.NewMailer(cfg)
+	accountService := services.NewAccountService(cfg, mailer, tokenService)
+	inviteService := services.NewInviteService(cfg, mailer)
+	loginLimiter := services.NewLoginLimiter(cfg)
+	auditService := services.NewAuditService()
+	auditService.Start()
+	activityService := services.NewActivityService(cfg)
+	activityService.StartArchiving()
+	exportService := services.NewExportService()
+	deletionService := services.NewDeletionService(cfg, storageService, contentPipeline, auditService)
+	deletionService.Start()
+	transferService := services.NewTransferService(storageService, contentPipeline)
+	importService := services.NewUserImportService(accountService, roleService)
+	scimService := services.NewSCIMService(roleService, deletionService)
+
+	authHandler := handlers.NewAuthHandler(cfg, authService, tokenService, mfaService, appPasswordService, settingsService, oidcService, accountService, loginLimiter, inviteService, roleService, impersonationService, exportService)
+	fileHandler := handlers.NewFileHandler(cfg, storageService, contentPipeline)
+	adminHandler := handlers.NewAdminHandler(cfg, settingsService, tokenService, loginLimiter, inviteService, roleService, impersonationService, auditService, activityService, deletionService, exportService, transferService, importService)
+	scimHandler := handlers.NewSCIMHandler(cfg, scimService)
+	webdavHandler := handlers.NewWebDAVHandler(cfg, storageService, contentPipeline, settingsService)
 
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok", "service": "stratus"})
 	})
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+	metrics.RegisterQueue("search", searchService.QueueDepth)
+	metrics.RegisterQueue("metadata", metadataService.QueueDepth)
+	metrics.RegisterQueue("thumbnail", thumbnailService.QueueDepth)
+	metrics.Registry.MustRegister(services.NewStorageCollector())
+	r.GET("/metrics", middleware.MetricsAccess(cfg), gin.WrapH(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})))
 
 	auth := r.Group("/api/auth")
 	{
+		auth.GET("/registration", authHandler.RegistrationConfig)
 		auth.POST("/register", authHandler.Register)
 		auth.POST("/login", authHandler.Login)
+		auth.POST("/login/mfa", authHandler.LoginMFA)
+		auth.POST("/refresh", authHandler.Refresh)
+		auth.POST("/password/forgot", authHandler.ForgotPassword)
+		auth.POST("/password/reset", authHandler.ResetPassword)
+		auth.POST("/email/verify", authHandler.VerifyEmail)
+		auth.POST("/email/resend", authHandler.ResendVerification)
+		auth.GET("/oidc/config", authHandler.OIDCConfig)
+		auth.GET("/oidc/login", authHandler.OIDCLogin)
+		auth.GET("/oidc/callback", authHandler.OIDCCallback)
+		auth.POST("/oidc/exchange", authHandler.OIDCExchange)
 	}
 
 	api := r.Group("/api")
-	api.Use(middleware.AuthMiddleware(cfg))
+	api.Use(middleware.AuthMiddleware(tokenService, settingsService, impersonationService))
 	{
-		api.POST("/auth/logout", authHandler.Logout)
-		api.GET("/auth/me", authHandler.Me)
-		api.PUT("/auth/profile", authHandler.UpdateProfile)
-		api.PUT("/auth/password", authHandler.ChangePassword)
+		api.GET("/auth/me", middleware.RequireScope(services.ScopeAccountRead, services.ScopeAccountRead), authHandler.Me)
+		api.POST("/auth/impersonation/end", authHandler.EndCurrentImpersonation)
+		api.GET("/activity", middleware.RequireScope(services.ScopeAccountRead, services.ScopeAccountRead), authHandler.MyActivity)
+
+		account := api.Group("/auth")
+		account.Use(middleware.RequireSession(), middleware.RejectImpersonation())
+		{
+			account.GET("/impersonations", authHandler.MyImpersonations)
+			account.GET("/export", authHandler.ExportData)
+			account.POST("/logout", authHandler.Logout)
+			account.PUT("/profile", authHandler.UpdateProfile)
+			account.PUT("/password", authHandler.ChangePassword)
+			account.GET("/sessions", authHandler.ListSessions)
+			account.DELETE("/sessions/:id", authHandler.RevokeSession)
+			account.GET("/2fa", authHandler.MFAStatus)
+			account.POST("/2fa/setup", authHandler.SetupMFA)
+			account.POST("/2fa/enable", authHandler.EnableMFA)
+			account.POST("/2fa/disable", authHandler.DisableMFA)
+			account.POST("/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+			account.GET("/app-passwords", authHandler.ListAppPasswords)
+			account.POST("/app-passwords", authHandler.CreateAppPassword)
+			account.DELETE("/app-passwords/:id", authHandler.RevokeAppPassword)
+			account.GET("/tokens", authHandler.ListTokens)
+			account.POST("/tokens", authHandler.CreateToken)
+			account.DELETE("/tokens/:tokenId", authHandler.RevokeToken)
+		}
+
+		filesScope := middleware.RequireScope(services.ScopeFilesRead, services.ScopeFilesWrite)
 
 		files := api.Group("/files")
+		files.Use(filesScope)
 		{
 			files.GET("", fileHandler.List)
 			files.GET("/:id", fileHandler.Get)
 			files.GET("/:id/contents", fileHandler.GetContents)
 			files.POST("/upload", fileHandler.Upload)
 			files.GET("/:id/download", fileHandler.Download)
+			files.GET("/:id/thumbnail", fileHandler.Thumbnail)
+			files.GET("/:id/content", fileHandler.GetContent)
+			files.PUT("/:id/content", fileHandler.UpdateContent)
+			files.GET("/:id/versions", fileHandler.ListVersions)
+			files.GET("/:id/diff", fileHandler.Diff)
+			files.GET("/:id/activity", fileHandler.FileActivity)
 			files.POST("/folder", fileHandler.CreateFolder)
 			files.PUT("/:id/rename", fileHandler.Rename)
 			files.PUT("/:id/move", fileHandler.Move)
@@ -51,30 +156,93 @@ func SetupRoutes(r *gin.Engine, cfg *config.Config) {
 			files.POST("/:id/restore", fileHandler.Restore)
 			files.DELETE("/:id", fileHandler.Delete)
 			files.GET("/search", fileHandler.Search)
+			files.GET("/search/content", fileHandler.SearchContent)
 		}
 
 		trash := api.Group("/trash")
+		trash.Use(filesScope)
 		{
 			trash.GET("", fileHandler.ListTrash)
 			trash.DELETE("", fileHandler.EmptyTrash)
 		}
 
-		api.GET("/storage/stats", fileHandler.StorageStats)
+		api.GET("/storage/stats", filesScope, fileHandler.StorageStats)
+		api.GET("/photos/timeline", filesScope, fileHandler.PhotoTimeline)
 
 		admin := api.Group("/admin")
-		admin.Use(middleware.AdminMiddleware())
+		admin.Use(middleware.RejectImpersonation(), middleware.RequireScope(services.ScopeAdminRead, services.ScopeAdminWrite))
 		{
-			admin.GET("/users", adminHandler.ListUsers)
-			admin.GET("/users/:id", adminHandler.GetUser)
-			admin.PUT("/users/:id", adminHandler.UpdateUser)
-			admin.DELETE("/users/:id", adminHandler.DeleteUser)
-			admin.GET("/stats", adminHandler.SystemStats)
-			admin.GET("/activities", adminHandler.ListActivities)
+			can := func(permission models.Permission) gin.HandlerFunc {
+				return middleware.RequirePermission(roleService, permission)
+			}
+
+			admin.GET("/users", can(models.PermUsersRead), adminHandler.ListUsers)
+			admin.GET("/users/:id", can(models.PermUsersRead), adminHandler.GetUser)
+			admin.POST("/users/import", can(models.PermUsersWrite), adminHandler.ImportUsers)
+			admin.PUT("/users/:id", can(models.PermUsersWrite), adminHandler.UpdateUser)
+			admin.DELETE("/users/:id", can(models.PermUsersDelete), adminHandler.DeleteUser)
+			admin.GET("/users/:id/deletion", can(models.PermUsersRead), adminHandler.GetUserDeletion)
+			admin.DELETE("/users/:id/deletion", can(models.PermUsersDelete), adminHandler.CancelUserDeletion)
+			admin.GET("/users/:id/export", can(models.PermUsersExport), adminHandler.ExportUserData)
+			admin.GET("/deletions", can(models.PermUsersRead), adminHandler.ListDeletions)
+			admin.POST("/transfers", can(models.PermFilesTransfer), adminHandler.TransferFiles)
+			admin.PUT("/users/:id/role", can(models.PermRolesAssign), adminHandler.AssignRole)
+			admin.POST("/users/:id/impersonate", can(models.PermUsersImpersonate), adminHandler.StartImpersonation)
+			admin.GET("/impersonations", can(models.PermActivityRead), adminHandler.ListImpersonations)
+			admin.DELETE("/impersonations/:id", can(models.PermUsersImpersonate), adminHandler.EndImpersonation)
+			admin.GET("/roles", can(models.PermUsersRead), adminHandler.ListRoles)
+			admin.POST("/roles", can(models.PermRolesManage), adminHandler.CreateRole)
+			admin.PUT("/roles/:name", can(models.PermRolesManage), adminHandler.UpdateRole)
+			admin.DELETE("/roles/:name", can(models.PermRolesManage), adminHandler.DeleteRole)
+			admin.GET("/stats", can(models.PermStatsRead), adminHandler.SystemStats)
+			admin.GET("/activities", can(models.PermActivityRead), adminHandler.ListActivities)
+			admin.GET("/activities/stats", can(models.PermActivityRead), adminHandler.ActivityStats)
+			admin.GET("/activities/export", can(models.PermActivityRead), adminHandler.ExportActivities)
+			admin.GET("/activities/archives", can(models.PermActivityRead), adminHandler.ListActivityArchives)
+			admin.GET("/activities/archives/:name", can(models.PermActivityRead), adminHandler.DownloadActivityArchive)
+			admin.GET("/audit/verify", can(models.PermAuditRead), adminHandler.VerifyAuditLog)
+			admin.GET("/audit/export", can(models.PermAuditRead), adminHandler.ExportAuditLog)
+			admin.GET("/settings", can(models.PermSettingsRead), adminHandler.GetSettings)
+			admin.PUT("/settings", can(models.PermSettingsWrite), adminHandler.UpdateSettings)
+			admin.GET("/service-accounts", can(models.PermServiceAccountsManage), adminHandler.ListServiceAccounts)
+			admin.POST("/service-accounts", can(models.PermServiceAccountsManage), adminHandler.CreateServiceAccount)
+			admin.GET("/service-accounts/:id/tokens", can(models.PermServiceAccountsManage), adminHandler.ListServiceAccountTokens)
+			admin.POST("/service-accounts/:id/tokens", can(models.PermServiceAccountsManage), adminHandler.CreateServiceAccountToken)
+			admin.DELETE("/service-accounts/:id/tokens/:tokenId", can(models.PermServiceAccountsManage), adminHandler.RevokeServiceAccountToken)
+			admin.GET("/lockouts", can(models.PermLockoutsRead), adminHandler.ListLockouts)
+			admin.DELETE("/lockouts/:key", can(models.PermLockoutsWrite), adminHandler.ClearLockout)
+			admin.GET("/invites", can(models.PermInvitesManage), adminHandler.ListInvites)
+			admin.POST("/invites", can(models.PermInvitesManage), adminHandler.CreateInvite)
+			admin.DELETE("/invites/:id", can(models.PermInvitesManage), adminHandler.RevokeInvite)
 		}
 	}
 
+	scim := r.Group("/scim/v2")
+	scim.Use(
+		middleware.AuthMiddleware(tokenService, settingsService, impersonationService),
+		middleware.RejectImpersonation(),
+		middleware.RequireScope(services.ScopeAdminRead, services.ScopeAdminWrite),
+		middleware.RequirePermission(roleService, models.PermUsersProvision),
+	)
+	{
+		scim.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+		scim.GET("/ResourceTypes", scimHandler.ResourceTypes)
+		scim.GET("/Users", scimHandler.ListUsers)
+		scim.POST("/Users", scimHandler.CreateUser)
+		scim.GET("/Users/:id", scimHandler.GetUser)
+		scim.PUT("/Users/:id", scimHandler.ReplaceUser)
+		scim.PATCH("/Users/:id", scimHandler.PatchUser)
+		scim.DELETE("/Users/:id", scimHandler.DeleteUser)
+		scim.GET("/Groups", scimHandler.ListGroups)
+		scim.POST("/Groups", scimHandler.CreateGroup)
+		scim.GET("/Groups/:id", scimHandler.GetGroup)
+		scim.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+		scim.PATCH("/Groups/:id", scimHandler.PatchGroup)
+		scim.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+	}
+
 	webdav := r.Group("/webdav")
-	webdav.Use(middleware.BasicAuthMiddleware(cfg))
+	webdav.Use(middleware.BasicAuthMiddleware(cfg, authService, appPasswordService, settingsService, loginLimiter))
 	{
 		webdav.Handle("OPTIONS", "", webdavHandler.Options)
 		webdav.Handle("PROPFIND", "", webdavHandler.Propfind)
@@ -90,4 +258,6 @@ func SetupRoutes(r *gin.Engine, cfg *config.Config) {
 		webdav.Handle("LOCK", "/*path", webdavHandler.Lock)
 		webdav.Handle("UNLOCK", "/*path", webdavHandler.Unlock)
 	}
+
+	return nil
 }
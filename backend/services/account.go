@@ -0,0 +1,227 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+	accountSetupTTL      = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidEmailToken = errors.New("invalid or expired link")
+	ErrEmailTaken        = errors.New("email address is already in use")
+)
+
+// AccountService handles the self-service flows that prove control of an
+// email address: password resets, verification and address changes.
+type AccountService struct {
+	config *config.Config
+	mailer *Mailer
+	tokens *TokenService
+}
+
+func NewAccountService(cfg *config.Config, mailer *Mailer, tokens *TokenService) *AccountService {
+	return &AccountService{config: cfg, mailer: mailer, tokens: tokens}
+}
+
+func (s *AccountService) MailEnabled() bool {
+	return s.mailer.Enabled()
+}
+
+// RequestPasswordReset mails a reset link if email belongs to a local
+// account. It never reports whether the account exists.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	var user models.User
+	err := database.DB.Where("LOWER(email) = ? AND auth_source = ? AND is_service = false", strings.ToLower(email), models.AuthSourceLocal).
+		First(&user).Error
+	if err != nil || !user.IsActive {
+		return nil
+	}
+
+	return s.send(&user, models.EmailTokenPasswordReset, user.Email, passwordResetTTL, "/reset-password", "password_reset")
+}
+
+// ResetPassword consumes a reset token, sets the new password and signs the
+// user out everywhere.
+func (s *AccountService) ResetPassword(token, password string) (*models.User, error) {
+	record, err := s.consume(token, models.EmailTokenPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", record.UserID).Error; err != nil {
+		return nil, ErrInvalidEmailToken
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{"password_hash": user.PasswordHash}
+	if user.VerifiedAt == nil && strings.EqualFold(record.Email, user.Email) {
+		updates["verified_at"] = time.Now()
+	}
+	if err := database.DB.Model(&user).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	s.tokens.RevokeUserSessions(user.ID, uuid.Nil, "password_reset")
+	return &user, nil
+}
+
+// SendAccountSetup mails a user created by an admin a link to choose a
+// password. It is a password reset token with a longer lifetime, so using
+// it also verifies the address.
+func (s *AccountService) SendAccountSetup(user *models.User) error {
+	return s.send(user, models.EmailTokenPasswordReset, user.Email, accountSetupTTL, "/reset-password", "account_setup")
+}
+
+func (s *AccountService) SendVerification(user *models.User) error {
+	if user.VerifiedAt != nil {
+		return nil
+	}
+	return s.send(user, models.EmailTokenVerification, user.Email, emailVerificationTTL, "/verify-email", "email_verification")
+}
+
+// ResendVerification is the unauthenticated variant used from the login
+// page; like RequestPasswordReset it does not reveal whether email exists.
+func (s *AccountService) ResendVerification(email string) error {
+	var user models.User
+	if err := database.DB.Where("LOWER(email) = ?", strings.ToLower(email)).First(&user).Error; err != nil {
+		return nil
+	}
+	return s.SendVerification(&user)
+}
+
+// RequestEmailChange mails a confirmation link to the new address; the
+// account keeps its current address until the link is used.
+func (s *AccountService) RequestEmailChange(user *models.User, email string) error {
+	var count int64
+	database.DB.Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", strings.ToLower(email), user.ID).Count(&count)
+	if count > 0 {
+		return ErrEmailTaken
+	}
+	return s.send(user, models.EmailTokenChange, email, emailVerificationTTL, "/verify-email", "email_change")
+}
+
+// VerifyEmail consumes a verification or email change token.
+func (s *AccountService) VerifyEmail(token string) (*models.User, error) {
+	record, err := s.consume(token, models.EmailTokenVerification, models.EmailTokenChange)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", record.UserID).Error; err != nil {
+		return nil, ErrInvalidEmailToken
+	}
+
+	updates := map[string]interface{}{"verified_at": time.Now()}
+	if record.Purpose == models.EmailTokenChange {
+		var count int64
+		database.DB.Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", strings.ToLower(record.Email), user.ID).Count(&count)
+		if count > 0 {
+			return nil, ErrEmailTaken
+		}
+		updates["email"] = record.Email
+	} else if !strings.EqualFold(record.Email, user.Email) {
+		// The address changed since the link was sent.
+		return nil, ErrInvalidEmailToken
+	}
+
+	if err := database.DB.Model(&user).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *AccountService) send(user *models.User, purpose models.EmailTokenPurpose, email string, ttl time.Duration, path, template string) error {
+	if !s.mailer.Enabled() {
+		return ErrMailerDisabled
+	}
+
+	token, err := RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the most recent link of each kind stays valid.
+		if err := tx.Model(&models.EmailToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.EmailToken{
+			UserID:    user.ID,
+			Purpose:   purpose,
+			TokenHash: HashToken(token),
+			Email:     email,
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	name := user.DisplayName
+	if name == "" {
+		name = user.Email
+	}
+	s.mailer.SendAsync(email, template, map[string]interface{}{
+		"Name":      name,
+		"Email":     email,
+		"Link":      strings.TrimSuffix(s.config.PublicURL, "/") + path + "?token=" + url.QueryEscape(token),
+		"ExpiresIn": formatTTL(ttl),
+	})
+	return nil
+}
+
+func (s *AccountService) consume(token string, purposes ...models.EmailTokenPurpose) (*models.EmailToken, error) {
+	var record models.EmailToken
+	err := database.DB.
+		Where("token_hash = ? AND purpose IN ? AND used_at IS NULL AND expires_at > ?", HashToken(token), purposes, time.Now()).
+		First(&record).Error
+	if err != nil {
+		return nil, ErrInvalidEmailToken
+	}
+
+	result := database.DB.Model(&models.EmailToken{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, ErrInvalidEmailToken
+	}
+	return &record, nil
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl >= 24*time.Hour && ttl%(24*time.Hour) == 0 {
+		return pluralize(int(ttl/(24*time.Hour)), "day")
+	}
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		return pluralize(int(ttl/time.Hour), "hour")
+	}
+	return pluralize(int(ttl/time.Minute), "minute")
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return strconv.Itoa(n) + " " + unit + "s"
+}
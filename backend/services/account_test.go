@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+// fakeSMTP accepts every message, in the way MailHog does, and hands the
+// plain text part to the test.
+type fakeSMTP struct {
+	host     string
+	port     int
+	messages chan string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	addr := listener.Addr().(*net.TCPAddr)
+	s := &fakeSMTP{host: addr.IP.String(), port: addr.Port, messages: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+		case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.messages <- plainText(data)
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Unknown command")
+		}
+	}
+}
+
+// plainText returns the decoded text/plain part of a message from Mailer.
+func plainText(data []byte) string {
+	message, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data))))
+	if err != nil {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			return ""
+		}
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain") {
+			body, _ := io.ReadAll(part)
+			return string(body)
+		}
+	}
+}
+
+var linkToken = regexp.MustCompile(`token=([^\s"&<]+)`)
+
+// token waits for the next message and returns the token of its link.
+func (s *fakeSMTP) token(t *testing.T) string {
+	t.Helper()
+	select {
+	case body := <-s.messages:
+		match := linkToken.FindStringSubmatch(body)
+		if match == nil {
+			t.Fatalf("no link in message:\n%s", body)
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was sent")
+		return ""
+	}
+}
+
+func newTestAccountService(t *testing.T) (*AccountService, *fakeSMTP) {
+	t.Helper()
+	dbtest.Open(t)
+	smtpServer := newFakeSMTP(t)
+	cfg := &config.Config{
+		PublicURL:    "http://localhost",
+		SMTPHost:     smtpServer.host,
+		SMTPPort:     smtpServer.port,
+		SMTPFrom:     "Stratus <noreply@stratus.local>",
+		SMTPTLS:      "none",
+		JWTAlgorithm: "HS256",
+		JWTSecret:    "test-secret",
+		AccessTTL:    time.Minute,
+		RefreshTTL:   time.Hour,
+	}
+	return NewAccountService(cfg, NewMailer(cfg), mustTokenService(t, cfg)), smtpServer
+}
+
+func createAccountUser(t *testing.T, email string, verified bool) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, IsActive: true}
+	if verified {
+		now := time.Now()
+		user.VerifiedAt = &now
+	}
+	if err := user.SetPassword("old-password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func reloadUser(t *testing.T, id interface{}) *models.User {
+	t.Helper()
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func TestPasswordResetTokenIsSingleUse(t *testing.T) {
+	s, mailbox := newTestAccountService(t)
+	user := createAccountUser(t, "alice@example.com", true)
+
+	session, err := s.tokens.CreateSession(user.ID, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RequestPasswordReset("Alice@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := mailbox.token(t)
+
+	if _, err := s.ResetPassword(token, "new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if !reloadUser(t, user.ID).CheckPassword("new-password") {
+		t.Fatal("password was not changed")
+	}
+	if _, _, err := s.tokens.Refresh(session.RefreshToken, "192.0.2.1", "test"); err == nil {
+		t.Error("session survived the password reset")
+	}
+
+	if _, err := s.ResetPassword(token, "attacker-password"); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Fatalf("second use: got %v, want ErrInvalidEmailToken", err)
+	}
+	if !reloadUser(t, user.ID).CheckPassword("new-password") {
+		t.Fatal("a used token changed the password again")
+	}
+}
+
+func TestPasswordResetTokenConcurrentUse(t *testing.T) {
+	s, mailbox := newTestAccountService(t)
+	user := createAccountUser(t, "alice@example.com", true)
+	if err := s.RequestPasswordReset(user.Email); err != nil {
+		t.Fatal(err)
+	}
+	token := mailbox.token(t)
+
+	var wg sync.WaitGroup
+	results := make(chan error, 8)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.ResetPassword(token, "password-"+strconv.Itoa(i))
+			results <- err
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrInvalidEmailToken):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("token was used %d times, want once", succeeded)
+	}
+}
+
+func TestPasswordResetTokenSupersededAndExpired(t *testing.T) {
+	s, mailbox := newTestAccountService(t)
+	user := createAccountUser(t, "alice@example.com", true)
+
+	s.RequestPasswordReset(user.Email)
+	first := mailbox.token(t)
+	s.RequestPasswordReset(user.Email)
+	second := mailbox.token(t)
+
+	if _, err := s.ResetPassword(first, "new-password"); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("superseded link: got %v, want ErrInvalidEmailToken", err)
+	}
+
+	database.DB.Model(&models.EmailToken{}).Where("token_hash = ?", HashToken(second)).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := s.ResetPassword(second, "new-password"); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("expired link: got %v, want ErrInvalidEmailToken", err)
+	}
+	if !reloadUser(t, user.ID).CheckPassword("old-password") {
+		t.Error("password changed through an invalid link")
+	}
+}
+
+func TestEmailVerificationTokenIsSingleUse(t *testing.T) {
+	s, mailbox := newTestAccountService(t)
+	user := createAccountUser(t, "bob@example.com", false)
+
+	if err := s.SendVerification(user); err != nil {
+		t.Fatal(err)
+	}
+	token := mailbox.token(t)
+
+	// A verification link is not a password reset link.
+	if _, err := s.ResetPassword(token, "new-password"); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Fatalf("verification token reset the password: %v", err)
+	}
+
+	if _, err := s.VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	}
+	if reloadUser(t, user.ID).VerifiedAt == nil {
+		t.Fatal("address was not marked verified")
+	}
+	if _, err := s.VerifyEmail(token); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Fatalf("second use: got %v, want ErrInvalidEmailToken", err)
+	}
+}
+
+func TestEmailChangeTokenIsSingleUse(t *testing.T) {
+	s, mailbox := newTestAccountService(t)
+	user := createAccountUser(t, "carol@example.com", true)
+
+	if err := s.RequestEmailChange(user, "carol@new.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := mailbox.token(t)
+	if reloadUser(t, user.ID).Email != "carol@example.com" {
+		t.Fatal("address changed before it was confirmed")
+	}
+
+	if _, err := s.VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	}
+	if email := reloadUser(t, user.ID).Email; email != "carol@new.example.com" {
+		t.Fatalf("address is %q after confirmation", email)
+	}
+
+	// Change back by other means; replaying the link must not undo it.
+	database.DB.Model(user).Update("email", "carol@example.com")
+	if _, err := s.VerifyEmail(token); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Fatalf("second use: got %v, want ErrInvalidEmailToken", err)
+	}
+	if email := reloadUser(t, user.ID).Email; email != "carol@example.com" {
+		t.Fatalf("replayed link changed the address to %q", email)
+	}
+}
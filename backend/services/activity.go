@@ -0,0 +1,471 @@
+package services
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+const activityBatchSize = 1000
+
+// activityArchiveLockKey keeps two server instances from archiving the
+// same rows at once.
+const activityArchiveLockKey = 0x5374726174757342
+
+var (
+	ErrActivityArchiveName = errors.New("invalid archive name")
+)
+
+// ActivityFilter narrows the admin activity queries. Zero fields match
+// everything.
+type ActivityFilter struct {
+	UserID    *uuid.UUID
+	ActorID   *uuid.UUID
+	FileID    *uuid.UUID
+	Types     []string
+	Source    string
+	IPAddress string
+	From      time.Time
+	To        time.Time
+	Query     string
+}
+
+func (f *ActivityFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.UserID != nil {
+		query = query.Where("activities.user_id = ?", *f.UserID)
+	}
+	if f.ActorID != nil {
+		query = query.Where("activities.actor_id = ?", *f.ActorID)
+	}
+	if f.FileID != nil {
+		query = query.Where("activities.file_id = ?", *f.FileID)
+	}
+	if len(f.Types) > 0 {
+		query = query.Where("activities.type IN ?", f.Types)
+	}
+	if f.Source != "" {
+		query = query.Where("activities.source = ?", f.Source)
+	}
+	if f.IPAddress != "" {
+		query = query.Where("activities.ip_address = ?", f.IPAddress)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("activities.created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("activities.created_at < ?", f.To)
+	}
+	if f.Query != "" {
+		pattern := "%" + escapeLike(f.Query) + "%"
+		query = query.Where("(activities.file_name ILIKE ? OR activities.details ILIKE ? OR activities.user_agent ILIKE ?)", pattern, pattern, pattern)
+	}
+	return query
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// AdminActivity is an activity with the email addresses of the people
+// involved, so it still reads correctly once it leaves the database.
+type AdminActivity struct {
+	models.Activity
+	UserEmail  string `json:"user_email"`
+	ActorEmail string `json:"actor_email,omitempty"`
+}
+
+type ActivityTypeCount struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+type ActivityDayCount struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+type ActivityStats struct {
+	Total  int64               `json:"total"`
+	ByType []ActivityTypeCount `json:"by_type"`
+	ByDay  []ActivityDayCount  `json:"by_day"`
+}
+
+type ActivityArchive struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ActivityService struct {
+	config *config.Config
+}
+
+func NewActivityService(cfg *config.Config) *ActivityService {
+	return &ActivityService{config: cfg}
+}
+
+// Query returns up to limit activities matching filter, newest first,
+// starting after cursor. The returned cursor is empty on the last page.
+func (s *ActivityService) Query(filter ActivityFilter, cursor string, limit int) ([]AdminActivity, string, error) {
+	query := filter.apply(database.DB.Model(&models.Activity{}))
+	if cursor != "" {
+		createdAt, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("(activities.created_at, activities.id) < (?, ?)", createdAt, id)
+	}
+
+	var activities []models.Activity
+	if err := query.Order("activities.created_at DESC, activities.id DESC").Limit(limit + 1).Find(&activities).Error; err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(activities) > limit {
+		activities = activities[:limit]
+		last := activities[limit-1]
+		next = EncodeCursor(last.CreatedAt, last.ID)
+	}
+
+	results, err := s.withEmails(activities)
+	if err != nil {
+		return nil, "", err
+	}
+	return results, next, nil
+}
+
+// withEmails looks up the users and actors of activities, including
+// deleted accounts.
+func (s *ActivityService) withEmails(activities []models.Activity) ([]AdminActivity, error) {
+	ids := make(map[uuid.UUID]bool)
+	for _, activity := range activities {
+		ids[activity.UserID] = true
+		if activity.ActorID != nil {
+			ids[*activity.ActorID] = true
+		}
+	}
+
+	emails := make(map[uuid.UUID]string, len(ids))
+	if len(ids) > 0 {
+		userIDs := make([]uuid.UUID, 0, len(ids))
+		for id := range ids {
+			userIDs = append(userIDs, id)
+		}
+		var users []models.User
+		if err := database.DB.Unscoped().Select("id", "email").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			emails[user.ID] = user.Email
+		}
+	}
+
+	results := make([]AdminActivity, len(activities))
+	for i, activity := range activities {
+		results[i] = AdminActivity{Activity: activity, UserEmail: emails[activity.UserID]}
+		if activity.ActorID != nil {
+			results[i].ActorEmail = emails[*activity.ActorID]
+		}
+	}
+	return results, nil
+}
+
+// Stats counts the activities matching filter per type and per UTC day.
+func (s *ActivityService) Stats(filter ActivityFilter) (*ActivityStats, error) {
+	stats := &ActivityStats{ByType: []ActivityTypeCount{}, ByDay: []ActivityDayCount{}}
+
+	err := filter.apply(database.DB.Model(&models.Activity{})).
+		Select("activities.type AS type, COUNT(*) AS count").
+		Group("activities.type").
+		Order("count DESC").
+		Scan(&stats.ByType).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = filter.apply(database.DB.Model(&models.Activity{})).
+		Select("to_char(activities.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, COUNT(*) AS count").
+		Group("day").
+		Order("day").
+		Scan(&stats.ByDay).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, count := range stats.ByType {
+		stats.Total += count.Count
+	}
+	return stats, nil
+}
+
+// each passes every activity matching filter to fn in batches, oldest
+// first.
+func (s *ActivityService) each(db *gorm.DB, filter ActivityFilter, fn func([]AdminActivity) error) error {
+	var (
+		lastCreatedAt time.Time
+		lastID        uuid.UUID
+	)
+	for first := true; ; first = false {
+		query := filter.apply(db.Model(&models.Activity{}))
+		if !first {
+			query = query.Where("(activities.created_at, activities.id) > (?, ?)", lastCreatedAt, lastID)
+		}
+
+		var activities []models.Activity
+		if err := query.Order("activities.created_at, activities.id").Limit(activityBatchSize).Find(&activities).Error; err != nil {
+			return err
+		}
+		if len(activities) == 0 {
+			return nil
+		}
+
+		results, err := s.withEmails(activities)
+		if err != nil {
+			return err
+		}
+		if err := fn(results); err != nil {
+			return err
+		}
+
+		last := activities[len(activities)-1]
+		lastCreatedAt, lastID = last.CreatedAt, last.ID
+		if len(activities) < activityBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *ActivityService) ExportJSON(w io.Writer, filter ActivityFilter) error {
+	encoder := json.NewEncoder(w)
+	return s.each(database.DB, filter, func(activities []AdminActivity) error {
+		for i := range activities {
+			if err := encoder.Encode(&activities[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var activityCSVHeader = []string{
+	"id", "created_at", "type", "user_id", "user_email", "actor_id", "actor_email", "source",
+	"file_id", "file_name", "details", "changes", "ip_address", "user_agent",
+}
+
+func (s *ActivityService) ExportCSV(w io.Writer, filter ActivityFilter) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(activityCSVHeader); err != nil {
+		return err
+	}
+
+	err := s.each(database.DB, filter, func(activities []AdminActivity) error {
+		for _, activity := range activities {
+			var changes string
+			if len(activity.Changes) > 0 {
+				data, err := json.Marshal(activity.Changes)
+				if err != nil {
+					return err
+				}
+				changes = string(data)
+			}
+			record := []string{
+				activity.ID.String(),
+				activity.CreatedAt.UTC().Format(time.RFC3339Nano),
+				string(activity.Type),
+				activity.UserID.String(),
+				activity.UserEmail,
+				optionalID(activity.ActorID),
+				activity.ActorEmail,
+				activity.Source,
+				optionalID(activity.FileID),
+				activity.FileName,
+				activity.Details,
+				changes,
+				activity.IPAddress,
+				activity.UserAgent,
+			}
+			for i := range record {
+				record[i] = csvSafe(record[i])
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func optionalID(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+// csvSafe stops spreadsheets from evaluating user-controlled values such
+// as file names as formulas.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (s *ActivityService) archiveDir() string {
+	return filepath.Join(s.config.StoragePath, ".archive", "activities")
+}
+
+// StartArchiving periodically moves activities older than the configured
+// retention into archives.
+func (s *ActivityService) StartArchiving() {
+	if s.config.ActivityRetention <= 0 || s.config.ActivityArchiveInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.config.ActivityArchiveInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			archived, err := s.Archive(time.Now().Add(-s.config.ActivityRetention))
+			if err != nil {
+				slog.Error("Activity archiving failed", "error", err)
+			} else if archived > 0 {
+				slog.Info("Archived activities", "count", archived)
+			}
+		}
+	}()
+}
+
+// Archive writes every activity created before cutoff to a gzipped JSON
+// lines file and deletes it from the database. The rows are only deleted
+// once the archive is complete; if the commit fails afterwards they are
+// archived again next time. Audit log entries are not affected.
+func (s *ActivityService) Archive(cutoff time.Time) (int, error) {
+	if err := os.MkdirAll(s.archiveDir(), 0755); err != nil {
+		return 0, err
+	}
+
+	archived := 0
+	name := fmt.Sprintf("activities-%s.jsonl.gz", time.Now().UTC().Format("20060102-150405"))
+	target := filepath.Join(s.archiveDir(), name)
+	tmp := target + ".tmp"
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", activityArchiveLockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		file, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+		defer file.Close()
+
+		compressed := gzip.NewWriter(file)
+		encoder := json.NewEncoder(compressed)
+		// Activities still waiting for the audit writer stay until they
+		// are in the audit log.
+		audited := tx.Where("activities.audited_at IS NOT NULL").Session(&gorm.Session{})
+		err = s.each(audited, ActivityFilter{To: cutoff}, func(activities []AdminActivity) error {
+			ids := make([]uuid.UUID, len(activities))
+			for i := range activities {
+				if err := encoder.Encode(&activities[i]); err != nil {
+					return err
+				}
+				ids[i] = activities[i].ID
+			}
+			archived += len(activities)
+			return tx.Where("id IN ?", ids).Delete(&models.Activity{}).Error
+		})
+		if err != nil {
+			return err
+		}
+		if archived == 0 {
+			return nil
+		}
+
+		if err := compressed.Close(); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp, target)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return archived, nil
+}
+
+// Archives lists the archive files, newest first.
+func (s *ActivityService) Archives() ([]ActivityArchive, error) {
+	entries, err := os.ReadDir(s.archiveDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []ActivityArchive{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archives := []ActivityArchive{}
+	for _, entry := range entries {
+		if !isActivityArchive(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, ActivityArchive{Name: entry.Name(), Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Name > archives[j].Name })
+	return archives, nil
+}
+
+// ArchivePath returns the location of the named archive.
+func (s *ActivityService) ArchivePath(name string) (string, error) {
+	if filepath.Base(name) != name || !isActivityArchive(name) {
+		return "", ErrActivityArchiveName
+	}
+	path := filepath.Join(s.archiveDir(), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func isActivityArchive(name string) bool {
+	return strings.HasPrefix(name, "activities-") && strings.HasSuffix(name, ".jsonl.gz")
+}
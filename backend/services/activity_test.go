@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+func newTestActivityService(t *testing.T) *ActivityService {
+	t.Helper()
+	dbtest.Open(t)
+	return NewActivityService(&config.Config{StoragePath: t.TempDir()})
+}
+
+func createTestActivity(t *testing.T, activity models.Activity) models.Activity {
+	t.Helper()
+	if err := database.DB.Create(&activity).Error; err != nil {
+		t.Fatal(err)
+	}
+	return activity
+}
+
+func TestActivityQueryPaging(t *testing.T) {
+	s := newTestActivityService(t)
+	user := createTestUser(t, "user@example.com")
+	admin := createTestUser(t, "admin@example.com")
+
+	// Activities share timestamps, so the cursor has to break ties by ID.
+	at := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	for i := 0; i < 7; i++ {
+		createTestActivity(t, models.Activity{UserID: user.ID, ActorID: &admin.ID, Type: models.ActivityUserUpdated, Source: models.ActivitySourceAPI, CreatedAt: at.Add(time.Duration(i/3) * time.Minute)})
+	}
+	createTestActivity(t, models.Activity{UserID: admin.ID, Type: models.ActivityUserLogin, Source: models.ActivitySourceWeb})
+
+	seen := make(map[uuid.UUID]bool)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages == 10 {
+			t.Fatal("paging does not end")
+		}
+		activities, next, err := s.Query(ActivityFilter{UserID: &user.ID}, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, activity := range activities {
+			if seen[activity.ID] {
+				t.Errorf("activity %s returned twice", activity.ID)
+			}
+			seen[activity.ID] = true
+			if activity.UserEmail != user.Email || activity.ActorEmail != admin.Email {
+				t.Errorf("emails = %q, %q", activity.UserEmail, activity.ActorEmail)
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 7 {
+		t.Errorf("paged through %d activities, want 7", len(seen))
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter ActivityFilter
+		want   int
+	}{
+		{"type", ActivityFilter{Types: []string{string(models.ActivityUserLogin)}}, 1},
+		{"source", ActivityFilter{Source: models.ActivitySourceAPI}, 7},
+		{"actor", ActivityFilter{ActorID: &admin.ID}, 7},
+		{"time range", ActivityFilter{From: at.Add(time.Minute), To: at.Add(2 * time.Minute)}, 3},
+	} {
+		activities, _, err := s.Query(tc.filter, "", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(activities) != tc.want {
+			t.Errorf("%s filter: %d activities, want %d", tc.name, len(activities), tc.want)
+		}
+	}
+
+	if _, _, err := s.Query(ActivityFilter{}, "not a cursor", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("invalid cursor: got %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestActivityExportCSV(t *testing.T) {
+	s := newTestActivityService(t)
+	user := createTestUser(t, "user@example.com")
+	createTestActivity(t, models.Activity{UserID: user.ID, Type: models.ActivityFileCreated, FileName: "=HYPERLINK(\"http://example.com\")", Changes: models.ActivityChanges{"size": {After: 3}}})
+
+	var out bytes.Buffer
+	if err := s.ExportCSV(&out, ActivityFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[1]) != len(activityCSVHeader) {
+		t.Fatalf("records = %v", records)
+	}
+	row := make(map[string]string)
+	for i, column := range activityCSVHeader {
+		row[column] = records[1][i]
+	}
+	if row["file_name"] != `'=HYPERLINK("http://example.com")` {
+		t.Errorf("file name exported as %q, want it defused", row["file_name"])
+	}
+	if row["user_email"] != user.Email || row["changes"] != `{"size":{"before":null,"after":3}}` {
+		t.Errorf("row = %v", row)
+	}
+}
+
+func TestActivityArchive(t *testing.T) {
+	s := newTestActivityService(t)
+	user := createTestUser(t, "user@example.com")
+	for i := 0; i < 3; i++ {
+		createTestActivity(t, models.Activity{UserID: user.ID, Type: models.ActivityUserLogin, CreatedAt: time.Now().Add(-48 * time.Hour)})
+	}
+	recent := createTestActivity(t, models.Activity{UserID: user.ID, Type: models.ActivityUserLogin})
+
+	// Nothing leaves before the audit writer has appended it.
+	archived, err := s.Archive(time.Now().Add(-24 * time.Hour))
+	if err != nil || archived != 0 {
+		t.Fatalf("Archive() before auditing = %d, %v, want 0", archived, err)
+	}
+	if err := NewAuditService().Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	archived, err = s.Archive(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 3 {
+		t.Errorf("archived %d activities, want 3", archived)
+	}
+
+	var remaining []models.Activity
+	database.DB.Find(&remaining)
+	if len(remaining) != 1 || remaining[0].ID != recent.ID {
+		t.Errorf("%d activities remain, want only the recent one", len(remaining))
+	}
+	var audited int64
+	database.DB.Model(&models.AuditEntry{}).Count(&audited)
+	if audited != 4 {
+		t.Errorf("%d audit entries after archiving, want 4", audited)
+	}
+
+	archives, err := s.Archives()
+	if err != nil || len(archives) != 1 {
+		t.Fatalf("Archives() = %v, %v", archives, err)
+	}
+	path, err := s.ArchivePath(archives[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content bytes.Buffer
+	content.ReadFrom(reader)
+	if lines := bytes.Count(content.Bytes(), []byte("\n")); lines != 3 {
+		t.Errorf("archive holds %d lines, want 3", lines)
+	}
+
+	for _, name := range []string{"../" + archives[0].Name, "stratus.db", filepath.Join("x", archives[0].Name)} {
+		if _, err := s.ArchivePath(name); !errors.Is(err, ErrActivityArchiveName) {
+			t.Errorf("ArchivePath(%q): got %v, want ErrActivityArchiveName", name, err)
+		}
+	}
+
+	// Nothing left to archive leaves no empty file behind.
+	if archived, err := s.Archive(time.Now().Add(-24 * time.Hour)); err != nil || archived != 0 {
+		t.Errorf("second Archive() = %d, %v", archived, err)
+	}
+	if archives, _ := s.Archives(); len(archives) != 1 {
+		t.Errorf("%d archives after an empty run, want 1", len(archives))
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+var (
+	ErrAppPasswordNotFound = errors.New("app password not found")
+	ErrInvalidAppScope     = errors.New("invalid app password scope")
+)
+
+// lastUsedInterval throttles last-used writes, since WebDAV clients
+// authenticate on every request.
+const lastUsedInterval = time.Minute
+
+type AppPasswordService struct{}
+
+func NewAppPasswordService() *AppPasswordService {
+	return &AppPasswordService{}
+}
+
+// Create returns the new record and its secret, which is only ever shown once.
+func (s *AppPasswordService) Create(userID uuid.UUID, name string, scope models.AppPasswordScope, pathPrefix string) (*models.AppPassword, string, error) {
+	if scope == "" {
+		scope = models.AppPasswordReadWrite
+	}
+	if scope != models.AppPasswordReadOnly && scope != models.AppPasswordReadWrite {
+		return nil, "", ErrInvalidAppScope
+	}
+
+	secret, err := RandomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	appPassword := models.AppPassword{
+		UserID:       userID,
+		Name:         name,
+		PasswordHash: HashToken(secret),
+		Scope:        scope,
+		PathPrefix:   path.Clean("/" + strings.TrimSpace(pathPrefix)),
+	}
+	if err := database.DB.Create(&appPassword).Error; err != nil {
+		return nil, "", err
+	}
+	return &appPassword, secret, nil
+}
+
+func (s *AppPasswordService) List(userID uuid.UUID) ([]models.AppPassword, error) {
+	var appPasswords []models.AppPassword
+	err := database.DB.
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&appPasswords).Error
+	return appPasswords, err
+}
+
+func (s *AppPasswordService) Revoke(userID, id uuid.UUID) error {
+	result := database.DB.Model(&models.AppPassword{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAppPasswordNotFound
+	}
+	return nil
+}
+
+// Authenticate matches secret against the user's active app passwords and
+// records its use.
+func (s *AppPasswordService) Authenticate(userID uuid.UUID, secret, ipAddress string) (*models.AppPassword, bool) {
+	var appPassword models.AppPassword
+	err := database.DB.
+		Where("user_id = ? AND password_hash = ? AND revoked_at IS NULL", userID, HashToken(secret)).
+		First(&appPassword).Error
+	if err != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	if appPassword.LastUsedAt == nil || now.Sub(*appPassword.LastUsedAt) > lastUsedInterval || appPassword.LastUsedIP != ipAddress {
+		database.DB.Model(&appPassword).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ipAddress,
+		})
+	}
+	return &appPassword, true
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+const (
+	auditBatchSize   = 1000
+	maxAuditProblems = 100
+
+	// auditPollInterval picks up activities recorded by other instances or
+	// committed after the writer was woken.
+	auditPollInterval = 5 * time.Second
+)
+
+type AuditProblem struct {
+	Seq     int64  `json:"seq"`
+	Problem string `json:"problem"`
+}
+
+// AuditVerification reports the state of the audit chain. HeadSeq and
+// HeadHash should be recorded somewhere outside the database: the chain
+// alone cannot show that entries were cut from its end.
+type AuditVerification struct {
+	Valid         bool           `json:"valid"`
+	Entries       int64          `json:"entries"`
+	Pseudonymised int64          `json:"pseudonymised"`
+	HeadSeq       int64          `json:"head_seq"`
+	HeadHash      string         `json:"head_hash"`
+	ProblemCount  int            `json:"problem_count"`
+	Problems      []AuditProblem `json:"problems"`
+	CheckedAt     time.Time      `json:"checked_at"`
+}
+
+func (v *AuditVerification) report(seq int64, format string, args ...interface{}) {
+	v.Valid = false
+	v.ProblemCount++
+	if len(v.Problems) < maxAuditProblems {
+		v.Problems = append(v.Problems, AuditProblem{Seq: seq, Problem: fmt.Sprintf(format, args...)})
+	}
+}
+
+type AuditService struct {
+	wake chan struct{}
+}
+
+func NewAuditService() *AuditService {
+	return &AuditService{wake: make(chan struct{}, 1)}
+}
+
+func (s *AuditService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the audit writer. Activities are only recorded in the
+// activities table, which serves as an outbox: the writer appends them to
+// the chain in (created_at, id) order, so recording an activity never
+// waits for the chain. It catches up on anything left from before a
+// restart, then runs whenever an activity is created and on a timer.
+func (s *AuditService) Start() {
+	database.DB.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("stratus:audit_wake", func(db *gorm.DB) {
+		if _, ok := db.Statement.Model.(*models.Activity); ok && db.Error == nil {
+			s.notify()
+		}
+	})
+
+	go func() {
+		ticker := time.NewTicker(auditPollInterval)
+		defer ticker.Stop()
+		for {
+			if err := s.Flush(); err != nil {
+				slog.Error("Failed to append activities to the audit log", "error", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.wake:
+			}
+		}
+	}()
+}
+
+// Flush appends every activity that is not in the audit log yet.
+func (s *AuditService) Flush() error {
+	for {
+		var appended int
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			appended, err = s.AppendPending(tx)
+			return err
+		})
+		if err != nil || appended < auditBatchSize {
+			return err
+		}
+	}
+}
+
+// AppendPending appends up to one batch of unaudited activities to the
+// chain within tx and marks them audited. The advisory lock taken by
+// AppendAuditEntry makes the writer single across instances; callers that
+// are about to delete or pseudonymise activities run it in their own
+// transaction first so nothing leaves the outbox unaudited.
+func (s *AuditService) AppendPending(tx *gorm.DB) (int, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", models.AuditLockKey).Error; err != nil {
+		return 0, err
+	}
+
+	var activities []models.Activity
+	if err := tx.Where("audited_at IS NULL").Order("created_at, id").Limit(auditBatchSize).Find(&activities).Error; err != nil {
+		return 0, err
+	}
+	if len(activities) == 0 {
+		return 0, nil
+	}
+
+	ids := make(map[uuid.UUID]bool)
+	for _, activity := range activities {
+		ids[activity.UserID] = true
+		if activity.ActorID != nil {
+			ids[*activity.ActorID] = true
+		}
+	}
+	userIDs := make([]uuid.UUID, 0, len(ids))
+	for id := range ids {
+		userIDs = append(userIDs, id)
+	}
+	var users []models.User
+	if err := tx.Unscoped().Select("id", "email").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return 0, err
+	}
+	emails := make(map[uuid.UUID]string, len(users))
+	for _, user := range users {
+		emails[user.ID] = user.Email
+	}
+
+	auditedIDs := make([]uuid.UUID, len(activities))
+	for i := range activities {
+		entry, err := auditEntryFor(&activities[i], emails)
+		if err != nil {
+			return 0, err
+		}
+		if err := models.AppendAuditEntry(tx, entry); err != nil {
+			return 0, err
+		}
+		auditedIDs[i] = activities[i].ID
+	}
+	if err := tx.Model(&models.Activity{}).Where("id IN ?", auditedIDs).Update("audited_at", time.Now()).Error; err != nil {
+		return 0, err
+	}
+	return len(activities), nil
+}
+
+func auditEntryFor(a *models.Activity, emails map[uuid.UUID]string) (*models.AuditEntry, error) {
+	actorID := a.UserID
+	if a.ActorID != nil {
+		actorID = *a.ActorID
+	}
+
+	var changes string
+	if len(a.Changes) > 0 {
+		data, err := json.Marshal(a.Changes)
+		if err != nil {
+			return nil, err
+		}
+		changes = string(data)
+	}
+
+	activityID, subjectID := a.ID, a.UserID
+	return &models.AuditEntry{
+		CreatedAt:    a.CreatedAt,
+		Action:       string(a.Type),
+		ActivityID:   &activityID,
+		ActorID:      &actorID,
+		SubjectID:    &subjectID,
+		FileID:       a.FileID,
+		ActorLabel:   emails[actorID],
+		SubjectLabel: emails[a.UserID],
+		FileName:     a.FileName,
+		Details:      a.Details,
+		Changes:      changes,
+		IPAddress:    a.IPAddress,
+		UserAgent:    a.UserAgent,
+		Source:       a.Source,
+	}, nil
+}
+
+// Verify walks the whole chain and reports gaps, broken links and entries
+// whose contents no longer match their hash.
+func (s *AuditService) Verify() (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true, Problems: []AuditProblem{}, HeadHash: models.AuditGenesisHash}
+
+	var entries []models.AuditEntry
+	err := database.DB.FindInBatches(&entries, auditBatchSize, func(tx *gorm.DB, batch int) error {
+		for i := range entries {
+			s.check(result, &entries[i])
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result.CheckedAt = time.Now()
+	return result, nil
+}
+
+func (s *AuditService) check(result *AuditVerification, entry *models.AuditEntry) {
+	expected := result.HeadSeq + 1
+	switch {
+	case entry.Seq == expected+1:
+		result.report(entry.Seq, "entry %d is missing", expected)
+	case entry.Seq > expected:
+		result.report(entry.Seq, "entries %d to %d are missing", expected, entry.Seq-1)
+	case entry.Seq < expected:
+		result.report(entry.Seq, "sequence number out of order")
+	case entry.PrevHash != result.HeadHash:
+		result.report(entry.Seq, "previous hash does not match entry %d", result.HeadSeq)
+	}
+
+	if entry.Hash != entry.ComputeHash() {
+		result.report(entry.Seq, "entry was modified")
+	}
+	if entry.PseudonymisedAt != nil {
+		result.Pseudonymised++
+	} else if entry.PIIDigest != entry.ComputePIIDigest() {
+		result.report(entry.Seq, "personal data was modified without pseudonymisation")
+	}
+
+	result.Entries++
+	result.HeadSeq = entry.Seq
+	result.HeadHash = entry.Hash
+}
+
+// Export writes entries from seq onwards as JSON lines, including the
+// hashes, so the chain can be verified independently.
+func (s *AuditService) Export(w io.Writer, fromSeq int64) error {
+	encoder := json.NewEncoder(w)
+	var entries []models.AuditEntry
+	return database.DB.Where("seq >= ?", fromSeq).FindInBatches(&entries, auditBatchSize, func(tx *gorm.DB, batch int) error {
+		for i := range entries {
+			if err := encoder.Encode(&entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// Pseudonymise replaces the personal data of user in every audit entry
+// they appear in. The entries themselves, and the chain, are kept.
+func (s *AuditService) Pseudonymise(tx *gorm.DB, user *models.User) error {
+	pseudonym := "deleted-user-" + user.ID.String()[:8]
+	now := time.Now()
+
+	err := tx.Model(&models.AuditEntry{}).Where("subject_id = ?", user.ID).Updates(map[string]interface{}{
+		"subject_label":    pseudonym,
+		"actor_label":      gorm.Expr("CASE WHEN actor_id = ? THEN ? ELSE actor_label END", user.ID, pseudonym),
+		"file_name":        "",
+		"details":          "",
+		"changes":          "",
+		"ip_address":       gorm.Expr("CASE WHEN actor_id = ? THEN '' ELSE ip_address END", user.ID),
+		"user_agent":       gorm.Expr("CASE WHEN actor_id = ? THEN '' ELSE user_agent END", user.ID),
+		"pseudonymised_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	// Entries about other users keep their details, minus this user's email.
+	return tx.Model(&models.AuditEntry{}).Where("actor_id = ? AND subject_id <> ?", user.ID, user.ID).Updates(map[string]interface{}{
+		"actor_label":      pseudonym,
+		"details":          gorm.Expr("REPLACE(details, ?, ?)", user.Email, pseudonym),
+		"ip_address":       "",
+		"user_agent":       "",
+		"pseudonymised_at": now,
+	}).Error
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+// recordTestActivities creates n activities and appends them to the audit
+// log.
+func recordTestActivities(t *testing.T, user *models.User, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		err := database.DB.Create(&models.Activity{
+			UserID:    user.ID,
+			Type:      models.ActivityUserLogin,
+			IPAddress: "192.0.2.1",
+			Source:    models.ActivitySourceWeb,
+			Details:   "signed in as " + user.Email,
+			Changes:   models.ActivityChanges{"sessions": {Before: i, After: i + 1}},
+		}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := NewAuditService().Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditVerifyValidChain(t *testing.T) {
+	dbtest.Open(t)
+	user := createTestUser(t, "user@example.com")
+	recordTestActivities(t, user, 3)
+
+	result, err := NewAuditService().Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Entries != 3 || result.HeadSeq != 3 || len(result.Problems) != 0 {
+		t.Errorf("Verify() = %+v", result)
+	}
+
+	var entries []models.AuditEntry
+	database.DB.Order("seq").Find(&entries)
+	if entries[0].PrevHash != models.AuditGenesisHash || entries[1].PrevHash != entries[0].Hash || result.HeadHash != entries[2].Hash {
+		t.Error("entries are not linked")
+	}
+	if entries[0].SubjectLabel != user.Email || entries[0].ActivityID == nil {
+		t.Errorf("entry does not describe its activity: %+v", entries[0])
+	}
+}
+
+func TestAuditAppendsActivitiesInOrder(t *testing.T) {
+	dbtest.Open(t)
+	user := createTestUser(t, "user@example.com")
+
+	// Activities recorded in the same instant are appended by ID.
+	now := time.Now().Truncate(time.Second)
+	var activities []models.Activity
+	for i := 0; i < 4; i++ {
+		activity := models.Activity{UserID: user.ID, Type: models.ActivityUserLogin, CreatedAt: now.Add(time.Duration(i/2) * time.Minute)}
+		if err := database.DB.Create(&activity).Error; err != nil {
+			t.Fatal(err)
+		}
+		activities = append(activities, activity)
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if !activities[i].CreatedAt.Equal(activities[j].CreatedAt) {
+			return activities[i].CreatedAt.Before(activities[j].CreatedAt)
+		}
+		return activities[i].ID.String() < activities[j].ID.String()
+	})
+
+	var entries int64
+	database.DB.Model(&models.AuditEntry{}).Count(&entries)
+	if entries != 0 {
+		t.Fatalf("%d audit entries before the writer ran, want 0", entries)
+	}
+
+	s := NewAuditService()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var appended []models.AuditEntry
+	database.DB.Order("seq").Find(&appended)
+	if len(appended) != len(activities) {
+		t.Fatalf("%d audit entries, want %d", len(appended), len(activities))
+	}
+	for i, entry := range appended {
+		if entry.ActivityID == nil || *entry.ActivityID != activities[i].ID {
+			t.Errorf("entry %d is not activity %d", entry.Seq, i)
+		}
+	}
+
+	if n, err := s.AppendPending(database.DB); err != nil || n != 0 {
+		t.Errorf("AppendPending() again = %d, %v, want nothing left", n, err)
+	}
+}
+
+func TestAuditVerifyDetectsTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(entry *models.AuditEntry) string
+		want   string
+	}{
+		{"changed action", func(_ *models.AuditEntry) string {
+			return "UPDATE audit_log SET action = 'logout' WHERE seq = 2"
+		}, "entry was modified"},
+		{"changed personal data", func(_ *models.AuditEntry) string {
+			return "UPDATE audit_log SET ip_address = '198.51.100.1' WHERE seq = 2"
+		}, "personal data was modified"},
+		{"changed source", func(_ *models.AuditEntry) string {
+			return "UPDATE audit_log SET source = 'api' WHERE seq = 2"
+		}, "entry was modified"},
+		{"changed field values", func(_ *models.AuditEntry) string {
+			return "UPDATE audit_log SET changes = '{}' WHERE seq = 2"
+		}, "personal data was modified"},
+		{"deleted entry", func(_ *models.AuditEntry) string {
+			return "DELETE FROM audit_log WHERE seq = 2"
+		}, "entry 2 is missing"},
+		{"rehashed entry", func(e *models.AuditEntry) string {
+			e.Action = "logout"
+			return "UPDATE audit_log SET action = 'logout', hash = '" + e.ComputeHash() + "' WHERE seq = 2"
+		}, "previous hash does not match entry 2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dbtest.Open(t)
+			user := createTestUser(t, "user@example.com")
+			recordTestActivities(t, user, 3)
+
+			var entry models.AuditEntry
+			database.DB.First(&entry, "seq = ?", 2)
+			if err := database.DB.Exec(tc.tamper(&entry)).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := NewAuditService().Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid || result.ProblemCount == 0 {
+				t.Fatalf("tampered chain verified: %+v", result)
+			}
+			found := false
+			for _, problem := range result.Problems {
+				found = found || strings.Contains(problem.Problem, tc.want)
+			}
+			if !found {
+				t.Errorf("problems %+v do not mention %q", result.Problems, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuditPseudonymiseKeepsChain(t *testing.T) {
+	dbtest.Open(t)
+	user := createTestUser(t, "user@example.com")
+	other := createTestUser(t, "other@example.com")
+	recordTestActivities(t, user, 2)
+	recordTestActivities(t, other, 1)
+
+	s := NewAuditService()
+	if err := s.Pseudonymise(database.DB, user); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Pseudonymised != 2 {
+		t.Errorf("Verify() after pseudonymising = %+v", result)
+	}
+
+	var entries []models.AuditEntry
+	database.DB.Where("subject_id = ?", user.ID).Find(&entries)
+	for _, entry := range entries {
+		if entry.SubjectLabel == user.Email || entry.IPAddress != "" || entry.Details != "" {
+			t.Errorf("entry %d still holds personal data: %+v", entry.Seq, entry)
+		}
+	}
+}
+
+func TestAuditExport(t *testing.T) {
+	dbtest.Open(t)
+	user := createTestUser(t, "user@example.com")
+	recordTestActivities(t, user, 3)
+
+	var out bytes.Buffer
+	if err := NewAuditService().Export(&out, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var seqs []int64
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var entry models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Hash != entry.ComputeHash() {
+			t.Errorf("exported entry %d does not verify", entry.Seq)
+		}
+		seqs = append(seqs, entry.Seq)
+	}
+	if len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 3 {
+		t.Errorf("exported seqs %v, want [2 3]", seqs)
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// AuthProvider verifies a login and password against one account source.
+// Providers return ErrInvalidCredentials when they do not recognise the
+// login, so the next provider can be tried.
+type AuthProvider interface {
+	Name() string
+	Authenticate(login, password string) (*models.User, error)
+}
+
+// AuthService checks credentials against each configured provider in turn.
+type AuthService struct {
+	providers []AuthProvider
+}
+
+func NewAuthService(providers ...AuthProvider) *AuthService {
+	return &AuthService{providers: providers}
+}
+
+func (s *AuthService) Authenticate(login, password string) (*models.User, error) {
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	var lastErr error
+	for _, provider := range s.providers {
+		user, err := provider.Authenticate(login, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			slog.Error("Authentication provider failed", "provider", provider.Name(), "error", err)
+			lastErr = err
+		}
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// LocalAuthProvider checks passwords stored on local accounts.
+type LocalAuthProvider struct{}
+
+func (LocalAuthProvider) Name() string {
+	return "local"
+}
+
+func (LocalAuthProvider) Authenticate(login, password string) (*models.User, error) {
+	var user models.User
+	if err := database.DB.Where("email = ? AND auth_source <> ?", login, models.AuthSourceLDAP).First(&user).Error; err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if user.PasswordHash == "" || !user.CheckPassword(password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
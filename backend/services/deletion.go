@@ -0,0 +1,419 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+const (
+	deletionBatchSize    = 200
+	deletionPollInterval = time.Minute
+)
+
+var (
+	ErrDeletionPending  = errors.New("account is already being deleted")
+	ErrDeletionNotFound = errors.New("no pending deletion")
+	ErrDeletionRunning  = errors.New("deletion is already running")
+)
+
+// DeletionService removes accounts in the background: file blobs and
+// versions, derived content, credentials and the activity feed. The user
+// row itself is kept, scrubbed and soft-deleted, so references from other
+// records stay valid while the email address becomes free again.
+type DeletionService struct {
+	config  *config.Config
+	storage *StorageService
+	content *ContentPipeline
+	audit   *AuditService
+	wake    chan struct{}
+}
+
+func NewDeletionService(cfg *config.Config, storage *StorageService, content *ContentPipeline, audit *AuditService) *DeletionService {
+	return &DeletionService{
+		config:  cfg,
+		storage: storage,
+		content: content,
+		audit:   audit,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Schedule deactivates user and signs them out everywhere, then deletes
+// the account once the grace period has passed.
+func (s *DeletionService) Schedule(actor, user *models.User, origin models.ActivityOrigin) (*models.UserDeletion, error) {
+	deletion := models.UserDeletion{
+		UserID:        user.ID,
+		RequestedByID: &actor.ID,
+		Status:        models.DeletionScheduled,
+		ScheduledFor:  time.Now().Add(s.config.UserDeletionGracePeriod),
+		WasActive:     user.IsActive,
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := EnsureNotBeingDeleted(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := tx.Create(&deletion).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(user).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		if err := revokeCredentials(tx, user.ID); err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf("%s scheduled the deletion of %s", actor.Email, user.Email)
+		if s.config.UserDeletionGracePeriod > 0 {
+			details += " for " + deletion.ScheduledFor.UTC().Format(time.RFC3339)
+		}
+		return tx.Create(&models.Activity{
+			UserID:    user.ID,
+			ActorID:   &actor.ID,
+			Type:      models.ActivityUserDeletionScheduled,
+			IPAddress: origin.IPAddress,
+			UserAgent: origin.UserAgent,
+			Source:    origin.Source,
+			Details:   details,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.UserDeletionGracePeriod <= 0 {
+		s.notify()
+	}
+	return &deletion, nil
+}
+
+var pendingDeletionStatuses = []models.DeletionStatus{models.DeletionScheduled, models.DeletionRunning}
+
+// EnsureNotBeingDeleted returns ErrDeletionPending while a deletion of the
+// user is scheduled or running. Such an account may only come back through
+// Cancel, which also ends the job.
+func EnsureNotBeingDeleted(tx *gorm.DB, userID uuid.UUID) error {
+	var pending int64
+	if err := tx.Model(&models.UserDeletion{}).Where("user_id = ? AND status IN ?", userID, pendingDeletionStatuses).Count(&pending).Error; err != nil {
+		return err
+	}
+	if pending > 0 {
+		return ErrDeletionPending
+	}
+	return nil
+}
+
+// revokeCredentials ends every way user could still act: sessions,
+// personal access tokens, app passwords and impersonations of them.
+func revokeCredentials(tx *gorm.DB, userID uuid.UUID) error {
+	now := time.Now()
+	if err := tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": "user_deleted"}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.PersonalAccessToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.AppPassword{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Impersonation{}).Where("target_id = ? AND ended_at IS NULL", userID).
+		Update("ended_at", now).Error
+}
+
+// Cancel stops a deletion that has not started yet and restores the
+// account's previous state. Revoked credentials stay revoked.
+func (s *DeletionService) Cancel(actor, user *models.User, origin models.ActivityOrigin) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var deletion models.UserDeletion
+		err := tx.Where("user_id = ? AND status IN ?", user.ID, pendingDeletionStatuses).First(&deletion).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrDeletionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// Claim the job so a worker cannot pick it up concurrently.
+		result := tx.Model(&deletion).Where("status = ?", models.DeletionScheduled).Update("status", models.DeletionCancelled)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDeletionRunning
+		}
+
+		if err := tx.Model(user).Update("is_active", deletion.WasActive).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Activity{
+			UserID:    user.ID,
+			ActorID:   &actor.ID,
+			Type:      models.ActivityUserDeletionCancelled,
+			IPAddress: origin.IPAddress,
+			UserAgent: origin.UserAgent,
+			Source:    origin.Source,
+			Details:   fmt.Sprintf("%s cancelled the deletion of %s", actor.Email, user.Email),
+		}).Error
+	})
+}
+
+// Latest returns the most recent deletion job for a user, if any.
+func (s *DeletionService) Latest(userID uuid.UUID) (*models.UserDeletion, error) {
+	var deletion models.UserDeletion
+	err := database.DB.Where("user_id = ?", userID).Order("created_at DESC").First(&deletion).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeletionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+func (s *DeletionService) List() ([]models.UserDeletion, error) {
+	var deletions []models.UserDeletion
+	err := database.DB.Order("created_at DESC").Limit(100).Find(&deletions).Error
+	return deletions, err
+}
+
+func (s *DeletionService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs due deletions one at a time. Jobs left running by a previous
+// process are resumed; every step can safely be repeated.
+func (s *DeletionService) Start() {
+	go func() {
+		var interrupted []models.UserDeletion
+		database.DB.Where("status = ?", models.DeletionRunning).Find(&interrupted)
+		for i := range interrupted {
+			s.run(&interrupted[i])
+		}
+
+		ticker := time.NewTicker(deletionPollInterval)
+		defer ticker.Stop()
+		for {
+			s.runDue()
+			select {
+			case <-ticker.C:
+			case <-s.wake:
+			}
+		}
+	}()
+}
+
+func (s *DeletionService) runDue() {
+	var due []models.UserDeletion
+	if err := database.DB.Where("status = ? AND scheduled_for <= ?", models.DeletionScheduled, time.Now()).
+		Order("scheduled_for").Find(&due).Error; err != nil {
+		slog.Error("Failed to load due deletions", "error", err)
+		return
+	}
+
+	for i := range due {
+		deletion := &due[i]
+		now := time.Now()
+		result := database.DB.Model(deletion).Where("status = ?", models.DeletionScheduled).
+			Updates(map[string]interface{}{"status": models.DeletionRunning, "started_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue // cancelled, or claimed by another instance
+		}
+		deletion.Status = models.DeletionRunning
+		deletion.StartedAt = &now
+		s.run(deletion)
+	}
+}
+
+func (s *DeletionService) run(deletion *models.UserDeletion) {
+	err := s.delete(deletion)
+	if err == nil {
+		return
+	}
+
+	slog.Error("User deletion failed", "user_id", deletion.UserID, "error", err)
+	database.DB.Model(deletion).Updates(map[string]interface{}{
+		"status": models.DeletionFailed,
+		"error":  err.Error(),
+	})
+}
+
+func (s *DeletionService) delete(deletion *models.UserDeletion) error {
+	var user models.User
+	if err := database.DB.Unscoped().First(&user, deletion.UserID).Error; err != nil {
+		return err
+	}
+
+	// Trashed and previously deleted rows may still have blobs.
+	files := database.DB.Unscoped().Model(&models.File{}).Where("owner_id = ?", user.ID).Session(&gorm.Session{})
+	var total int64
+	if err := files.Count(&total).Error; err != nil {
+		return err
+	}
+	// A resumed job starts over, so the counters do too.
+	deletion.FilesTotal, deletion.FilesDeleted, deletion.BytesFreed = total, 0, 0
+	database.DB.Model(deletion).Updates(map[string]interface{}{"files_total": total, "files_deleted": 0, "bytes_freed": 0})
+
+	var batch []models.File
+	err := files.FindInBatches(&batch, deletionBatchSize, func(tx *gorm.DB, _ int) error {
+		return s.removeFiles(deletion, batch)
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	// Catch blobs that were never recorded, such as interrupted uploads.
+	if err := os.RemoveAll(s.storage.GetUserStoragePath(user.ID)); err != nil {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return s.removeAccount(tx, deletion, &user)
+	})
+}
+
+// removeFiles deletes the blobs of a batch of files and everything derived
+// from them, but not the rows themselves, which go with the account.
+func (s *DeletionService) removeFiles(deletion *models.UserDeletion, files []models.File) error {
+	ids := make([]uuid.UUID, len(files))
+	var freed int64
+	for i, file := range files {
+		ids[i] = file.ID
+		if !file.IsDirectory && file.StoragePath != "" {
+			if err := removeBlob(file.StoragePath); err != nil {
+				return err
+			}
+			if file.DeletedAt.Time.IsZero() {
+				freed += file.Size
+			}
+		}
+	}
+
+	var versions []models.FileVersion
+	if err := database.DB.Where("file_id IN ?", ids).Find(&versions).Error; err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if err := removeBlob(version.StoragePath); err != nil {
+			return err
+		}
+	}
+	if err := database.DB.Where("file_id IN ?", ids).Delete(&models.FileVersion{}).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.content.FileRemoved(id)
+	}
+
+	deletion.FilesDeleted += int64(len(files))
+	deletion.BytesFreed += freed
+	return database.DB.Model(deletion).Updates(map[string]interface{}{
+		"files_deleted": deletion.FilesDeleted,
+		"bytes_freed":   deletion.BytesFreed,
+	}).Error
+}
+
+func removeBlob(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// removeAccount deletes the remaining rows, pseudonymises the audit log
+// and scrubs the user so the email address can be registered again.
+func (s *DeletionService) removeAccount(tx *gorm.DB, deletion *models.UserDeletion, user *models.User) error {
+	details := "Account deleted"
+	if deletion.RequestedByID != nil {
+		var requester models.User
+		if err := tx.Unscoped().Select("id", "email").First(&requester, *deletion.RequestedByID).Error; err == nil {
+			details = fmt.Sprintf("%s deleted the account %s", requester.Email, user.Email)
+		}
+	}
+	err := tx.Create(&models.Activity{
+		UserID:  user.ID,
+		ActorID: deletion.RequestedByID,
+		Type:    models.ActivityUserDeleted,
+		Source:  models.ActivitySourceSystem,
+		Details: details,
+	}).Error
+	if err != nil {
+		return err
+	}
+	// The user's activities are deleted below, so they have to reach the
+	// audit log first, and be pseudonymised along with everything else.
+	for {
+		appended, err := s.audit.AppendPending(tx)
+		if err != nil {
+			return err
+		}
+		if appended < auditBatchSize {
+			break
+		}
+	}
+	if err := s.audit.Pseudonymise(tx, user); err != nil {
+		return err
+	}
+
+	owned := tx.Unscoped().Model(&models.File{}).Select("id").Where("owner_id = ?", user.ID)
+	steps := []*gorm.DB{
+		tx.Where("user_id = ?", user.ID).Delete(&models.Activity{}),
+		tx.Model(&models.Activity{}).Where("file_id IN (?)", owned).Update("file_id", nil),
+		tx.Where("owner_id = ?", user.ID).Delete(&models.FileContent{}),
+		tx.Where("owner_id = ?", user.ID).Delete(&models.MediaMetadata{}),
+		tx.Unscoped().Where("owner_id = ?", user.ID).Delete(&models.File{}),
+		tx.Where("session_id IN (?)", tx.Model(&models.Session{}).Select("id").Where("user_id = ?", user.ID)).Delete(&models.RefreshToken{}),
+		tx.Where("user_id = ?", user.ID).Delete(&models.Session{}),
+		tx.Where("user_id = ?", user.ID).Delete(&models.PersonalAccessToken{}),
+		tx.Where("user_id = ?", user.ID).Delete(&models.AppPassword{}),
+		tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}),
+		tx.Where("user_id = ?", user.ID).Delete(&models.EmailToken{}),
+	}
+	for _, step := range steps {
+		if step.Error != nil {
+			return step.Error
+		}
+	}
+
+	pseudonym := "deleted-user-" + user.ID.String()[:8]
+	err = tx.Unscoped().Model(user).Updates(map[string]interface{}{
+		"email":         user.ID.String() + "@deleted.invalid",
+		"display_name":  pseudonym,
+		"password_hash": "",
+		"totp_secret":   "",
+		"totp_enabled":  false,
+		"oidc_subject":  nil,
+		"external_id":   "",
+		"used_space":    0,
+		"is_active":     false,
+	}).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Delete(user).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return tx.Model(deletion).Updates(map[string]interface{}{
+		"status":       models.DeletionCompleted,
+		"completed_at": now,
+		"error":        "",
+	}).Error
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+func newTestDeletionService(t *testing.T, grace time.Duration) (*DeletionService, *StorageService) {
+	t.Helper()
+	dbtest.Open(t)
+	cfg := &config.Config{StoragePath: t.TempDir(), UserDeletionGracePeriod: grace}
+	storage := NewStorageService(cfg)
+	pipeline := NewContentPipeline(NewSearchService(cfg), NewMetadataService(cfg), NewThumbnailService(cfg))
+	return NewDeletionService(cfg, storage, pipeline, NewAuditService()), storage
+}
+
+func createTestFile(t *testing.T, storage *StorageService, owner *models.User, name, content string) *models.File {
+	t.Helper()
+	saved, err := storage.SaveFile(context.Background(), owner.ID, strings.NewReader(content), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &models.File{
+		Name:        name,
+		Path:        "/",
+		OwnerID:     owner.ID,
+		MimeType:    "text/plain",
+		Size:        saved.Size,
+		StoragePath: saved.StoragePath,
+		Checksum:    saved.Checksum,
+		Version:     1,
+	}
+	if err := database.DB.Create(file).Error; err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDeletionScheduleAndCancel(t *testing.T) {
+	s, _ := newTestDeletionService(t, time.Hour)
+	admin := createTestUser(t, "admin@example.com")
+	user := createTestUser(t, "user@example.com")
+	tokens := mustTokenService(t, &config.Config{JWTAlgorithm: "HS256", JWTSecret: "test-secret", AccessTTL: time.Minute, RefreshTTL: time.Hour})
+	pair, err := tokens.CreateSession(user.ID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deletion, err := s.Schedule(admin, user, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(deletion.ScheduledFor) < 59*time.Minute {
+		t.Errorf("scheduled for %v, want after the grace period", deletion.ScheduledFor)
+	}
+	var reloaded models.User
+	database.DB.First(&reloaded, "id = ?", user.ID)
+	if reloaded.IsActive {
+		t.Error("user is still active after scheduling the deletion")
+	}
+	if _, err := tokens.ParseAccessToken(pair.AccessToken); err == nil {
+		t.Error("session survived scheduling the deletion")
+	}
+	if _, err := s.Schedule(admin, user, models.ActivityOrigin{}); !errors.Is(err, ErrDeletionPending) {
+		t.Errorf("scheduling twice: got %v, want ErrDeletionPending", err)
+	}
+
+	// Nothing happens before the grace period ends.
+	s.runDue()
+	if latest, _ := s.Latest(user.ID); latest.Status != models.DeletionScheduled {
+		t.Errorf("status before the grace period ended = %s", latest.Status)
+	}
+
+	if err := s.Cancel(admin, user, models.ActivityOrigin{}); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.First(&reloaded, "id = ?", user.ID)
+	if !reloaded.IsActive {
+		t.Error("cancelling did not reactivate the user")
+	}
+	if latest, _ := s.Latest(user.ID); latest.Status != models.DeletionCancelled {
+		t.Errorf("status after cancelling = %s", latest.Status)
+	}
+	if err := s.Cancel(admin, user, models.ActivityOrigin{}); !errors.Is(err, ErrDeletionNotFound) {
+		t.Errorf("cancelling twice: got %v, want ErrDeletionNotFound", err)
+	}
+}
+
+func TestDeletionCannotCancelRunningJob(t *testing.T) {
+	s, _ := newTestDeletionService(t, time.Hour)
+	admin := createTestUser(t, "admin@example.com")
+	user := createTestUser(t, "user@example.com")
+
+	deletion, err := s.Schedule(admin, user, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Model(deletion).Update("status", models.DeletionRunning)
+	if err := s.Cancel(admin, user, models.ActivityOrigin{}); !errors.Is(err, ErrDeletionRunning) {
+		t.Errorf("cancelling a running deletion: got %v, want ErrDeletionRunning", err)
+	}
+}
+
+func TestDeletionRemovesAccount(t *testing.T) {
+	s, storage := newTestDeletionService(t, time.Hour)
+	admin := createTestUser(t, "admin@example.com")
+	user := createTestUser(t, "user@example.com")
+	file := createTestFile(t, storage, user, "notes.txt", "private notes")
+	kept := createTestFile(t, storage, admin, "admin.txt", "admin notes")
+
+	deletion, err := s.Schedule(admin, user, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Model(deletion).Update("scheduled_for", time.Now().Add(-time.Second))
+	s.runDue()
+
+	latest, err := s.Latest(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Status != models.DeletionCompleted || latest.FilesDeleted != 1 || latest.BytesFreed != file.Size {
+		t.Fatalf("deletion = %+v", latest)
+	}
+
+	if _, err := os.Stat(file.StoragePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("blob still exists: %v", err)
+	}
+	if _, err := os.Stat(kept.StoragePath); err != nil {
+		t.Errorf("another user's blob was removed: %v", err)
+	}
+
+	var scrubbed models.User
+	if err := database.DB.Unscoped().First(&scrubbed, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !scrubbed.DeletedAt.Valid || scrubbed.Email == user.Email || scrubbed.PasswordHash != "" {
+		t.Errorf("user row was not scrubbed: %+v", scrubbed)
+	}
+	var files int64
+	database.DB.Unscoped().Model(&models.File{}).Where("owner_id = ?", user.ID).Count(&files)
+	if files != 0 {
+		t.Errorf("%d file rows remain", files)
+	}
+
+	// The email address can be used again, and the audit log still verifies.
+	createTestUser(t, user.Email)
+	result, err := NewAuditService().Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Pseudonymised == 0 {
+		t.Errorf("audit log after deletion: %+v", result)
+	}
+
+	// Activities the audit writer had not reached yet were appended before
+	// they were deleted, and pseudonymised with the rest.
+	var entries []models.AuditEntry
+	database.DB.Where("subject_id = ?", user.ID).Find(&entries)
+	actions := make(map[string]bool)
+	for _, entry := range entries {
+		actions[entry.Action] = true
+		if entry.PseudonymisedAt == nil || entry.SubjectLabel == user.Email {
+			t.Errorf("entry %d was not pseudonymised: %+v", entry.Seq, entry)
+		}
+	}
+	if !actions[string(models.ActivityUserDeleted)] {
+		t.Errorf("audit log lacks the deletion, actions %v", actions)
+	}
+}
+
+func TestExportArchive(t *testing.T) {
+	_, storage := newTestDeletionService(t, 0)
+	user := createTestUser(t, "user@example.com")
+	createTestFile(t, storage, user, "notes.txt", "live")
+	trashed := createTestFile(t, storage, user, "notes.txt", "trashed")
+	database.DB.Model(trashed).Update("is_trashed", true)
+	createTestFile(t, storage, user, "../../escape.txt", "escape")
+
+	var out bytes.Buffer
+	if err := NewExportService().Export(&out, user); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+	for _, entry := range archive.File {
+		names[entry.Name] = true
+		if strings.Contains(entry.Name, "..") {
+			t.Errorf("archive entry %q escapes its folder", entry.Name)
+		}
+	}
+	for _, want := range []string{"files/notes.txt", "trash/notes.txt", "files/escape.txt", "metadata/account.json", "metadata/files.json", "metadata/activity.json"} {
+		if !names[want] {
+			t.Errorf("archive lacks %s; has %v", want, names)
+		}
+	}
+}
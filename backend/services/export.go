@@ -0,0 +1,175 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+const exportBatchSize = 500
+
+// exportedFile is a file's entry in files.json. ArchivePath is empty for
+// folders and for files whose content could not be read.
+type exportedFile struct {
+	models.File
+	ArchivePath string               `json:"archive_path,omitempty"`
+	Versions    []models.FileVersion `json:"versions,omitempty"`
+}
+
+// ExportService packages everything stored about a user into a zip
+// archive: live files under files/, trashed ones under trash/ and the
+// account's records as JSON under metadata/.
+type ExportService struct{}
+
+func NewExportService() *ExportService {
+	return &ExportService{}
+}
+
+func (s *ExportService) Export(w io.Writer, user *models.User) error {
+	archive := zip.NewWriter(w)
+
+	files, err := s.writeFiles(archive, user)
+	if err != nil {
+		return err
+	}
+
+	var activities []models.Activity
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&activities).Error; err != nil {
+		return err
+	}
+	var sessions []models.Session
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&sessions).Error; err != nil {
+		return err
+	}
+	var appPasswords []models.AppPassword
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&appPasswords).Error; err != nil {
+		return err
+	}
+	var tokens []models.PersonalAccessToken
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	metadata := []struct {
+		name  string
+		value interface{}
+	}{
+		{"account.json", user},
+		{"files.json", files},
+		{"activity.json", activities},
+		{"sessions.json", sessions},
+		{"app_passwords.json", appPasswords},
+		{"tokens.json", tokens},
+	}
+	for _, entry := range metadata {
+		if err := writeJSON(archive, "metadata/"+entry.name, entry.value); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func (s *ExportService) writeFiles(archive *zip.Writer, user *models.User) ([]exportedFile, error) {
+	exported := []exportedFile{}
+	used := make(map[string]bool)
+
+	var batch []models.File
+	err := database.DB.Where("owner_id = ?", user.ID).FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		ids := make([]uuid.UUID, len(batch))
+		for i := range batch {
+			ids[i] = batch[i].ID
+		}
+		var versions []models.FileVersion
+		if err := database.DB.Where("file_id IN ?", ids).Order("version").Find(&versions).Error; err != nil {
+			return err
+		}
+		byFile := make(map[uuid.UUID][]models.FileVersion)
+		for _, version := range versions {
+			byFile[version.FileID] = append(byFile[version.FileID], version)
+		}
+
+		for _, file := range batch {
+			entry := exportedFile{File: file, Versions: byFile[file.ID]}
+			if !file.IsDirectory {
+				name := uniqueName(used, archivePath(&file))
+				written, err := writeBlob(archive, name, &file)
+				if err != nil {
+					return err
+				}
+				if written {
+					entry.ArchivePath = name
+				}
+			}
+			exported = append(exported, entry)
+		}
+		return nil
+	}).Error
+	return exported, err
+}
+
+func archivePath(file *models.File) string {
+	root := "files"
+	if file.IsTrashed {
+		root = "trash"
+	}
+	// Cleaning an absolute path removes any "..", so entries stay inside root.
+	return root + path.Clean("/"+path.Join(file.Path, file.Name))
+}
+
+// uniqueName numbers names that are already taken, such as a trashed file
+// that was replaced by a new one of the same name, and marks the result as
+// used.
+func uniqueName(used map[string]bool, name string) string {
+	candidate := name
+	ext := path.Ext(name)
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+// writeBlob copies a file's content into the archive. A missing blob is
+// skipped rather than failing the whole export.
+func writeBlob(archive *zip.Writer, name string, file *models.File) (bool, error) {
+	blob, err := os.Open(file.StoragePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer blob.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: file.UpdatedAt}
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(entry, blob); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
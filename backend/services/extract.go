@@ -0,0 +1,198 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+var ErrUnsupportedContent = errors.New("unsupported content type")
+
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".rst": true, ".csv": true, ".tsv": true,
+	".log": true, ".json": true, ".xml": true, ".yaml": true, ".yml": true, ".toml": true,
+	".ini": true, ".conf": true, ".cfg": true, ".env": true, ".html": true, ".htm": true,
+	".css": true, ".scss": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true,
+	".go": true, ".py": true, ".rb": true, ".rs": true, ".java": true, ".kt": true,
+	".c": true, ".h": true, ".cpp": true, ".hpp": true, ".cs": true, ".php": true,
+	".sh": true, ".bash": true, ".sql": true, ".swift": true, ".lua": true, ".tex": true,
+}
+
+var officeParts = map[string][]string{
+	".docx": {"word/document.xml", "word/header", "word/footer", "word/footnotes.xml"},
+	".xlsx": {"xl/sharedStrings.xml", "xl/worksheets/sheet"},
+	".pptx": {"ppt/slides/slide", "ppt/notesSlides/notesSlide"},
+	".odt":  {"content.xml"},
+	".ods":  {"content.xml"},
+	".odp":  {"content.xml"},
+}
+
+// ExtractText returns the plain text of a stored file, reading at most
+// maxBytes of output. Formats without a known extractor return
+// ErrUnsupportedContent.
+func ExtractText(storagePath, name, mimeType string, maxBytes int) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	switch {
+	case ext == ".pdf" || mimeType == "application/pdf":
+		return extractPDF(storagePath, maxBytes)
+	case officeParts[ext] != nil:
+		return extractOffice(storagePath, officeParts[ext], maxBytes)
+	case IsTextType(ext, mimeType):
+		return extractPlain(storagePath, maxBytes)
+	}
+
+	return "", ErrUnsupportedContent
+}
+
+func IsTextType(ext, mimeType string) bool {
+	if textExtensions[ext] {
+		return true
+	}
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	switch mimeType {
+	case "application/json", "application/xml", "application/javascript", "application/x-sh":
+		return true
+	}
+	return false
+}
+
+func extractPlain(storagePath string, maxBytes int) (string, error) {
+	file, err := os.Open(storagePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxBytes)))
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", ErrUnsupportedContent
+	}
+
+	return sanitizeText(data), nil
+}
+
+func extractPDF(storagePath string, maxBytes int) (string, error) {
+	file, reader, err := pdf.Open(storagePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	text, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(text, int64(maxBytes)))
+	if err != nil {
+		return "", err
+	}
+
+	return sanitizeText(data), nil
+}
+
+func extractOffice(storagePath string, prefixes []string, maxBytes int) (string, error) {
+	archive, err := zip.OpenReader(storagePath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	var parts []*zip.File
+	for _, f := range archive.File {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(f.Name, prefix) && strings.HasSuffix(f.Name, ".xml") {
+				parts = append(parts, f)
+				break
+			}
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return naturalLess(parts[i].Name, parts[j].Name)
+	})
+
+	var buf bytes.Buffer
+	for _, part := range parts {
+		if buf.Len() >= maxBytes {
+			break
+		}
+		rc, err := part.Open()
+		if err != nil {
+			return "", err
+		}
+		err = xmlText(&buf, rc, maxBytes)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		buf.WriteByte('\n')
+	}
+
+	return sanitizeText(buf.Bytes()), nil
+}
+
+// xmlText writes the character data of an OOXML or ODF part, breaking lines
+// at paragraph, row and slide boundaries so snippets stay readable.
+func xmlText(buf *bytes.Buffer, r io.Reader, maxBytes int) error {
+	decoder := xml.NewDecoder(r)
+	for buf.Len() < maxBytes {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.CharData:
+			buf.Write(t)
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tab":
+				buf.WriteByte('\t')
+			case "br", "line-break":
+				buf.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p", "h", "tr", "row", "si", "sp":
+				buf.WriteByte('\n')
+			case "c", "tc", "table-cell":
+				buf.WriteByte(' ')
+			}
+		}
+	}
+	return nil
+}
+
+// naturalLess orders sheet1.xml before sheet10.xml.
+func naturalLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func sanitizeText(data []byte) string {
+	if !utf8.Valid(data) {
+		data = bytes.ToValidUTF8(data, []byte(" "))
+	}
+	return strings.ReplaceAll(string(data), "\x00", "")
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeTestZip(t *testing.T, name string, parts map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(out)
+	for part, content := range parts {
+		w, err := archive.Create(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	return path
+}
+
+func TestExtractTextPlain(t *testing.T) {
+	path := writeTestFile(t, "notes", []byte("quarterly report\ninvalid \xff byte"))
+
+	text, err := ExtractText(path, "notes.md", "application/octet-stream", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "quarterly report\ninvalid   byte" {
+		t.Errorf("ExtractText() = %q", text)
+	}
+
+	// The MIME type alone is enough for files without a known extension.
+	if _, err := ExtractText(path, "notes", "text/plain", 1024); err != nil {
+		t.Errorf("text/plain without extension: %v", err)
+	}
+
+	text, err = ExtractText(path, "notes.txt", "text/plain", 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "quarterly" {
+		t.Errorf("truncated text = %q, want %q", text, "quarterly")
+	}
+}
+
+func TestExtractTextRejectsBinary(t *testing.T) {
+	binary := writeTestFile(t, "data", []byte("looks like text\x00but is not"))
+	if _, err := ExtractText(binary, "data.txt", "text/plain", 1024); !errors.Is(err, ErrUnsupportedContent) {
+		t.Errorf("text file with NUL bytes: got %v, want ErrUnsupportedContent", err)
+	}
+
+	if _, err := ExtractText(binary, "photo.jpg", "image/jpeg", 1024); !errors.Is(err, ErrUnsupportedContent) {
+		t.Errorf("image: got %v, want ErrUnsupportedContent", err)
+	}
+}
+
+func TestExtractTextOffice(t *testing.T) {
+	docx := writeTestZip(t, "letter.docx", map[string]string{
+		"word/document.xml": `<w:document xmlns:w="w"><w:body>` +
+			`<w:p><w:r><w:t>Dear</w:t><w:tab/><w:t>customer</w:t></w:r></w:p>` +
+			`<w:p><w:r><w:t>Invoice attached</w:t></w:r></w:p></w:body></w:document>`,
+		"word/styles.xml": `<w:styles xmlns:w="w"><w:t>ignored</w:t></w:styles>`,
+	})
+	text, err := ExtractText(docx, "letter.docx", "", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Dear\tcustomer\nInvoice attached\n\n" {
+		t.Errorf("docx text = %q", text)
+	}
+
+	xlsx := writeTestZip(t, "book.xlsx", map[string]string{
+		"xl/worksheets/sheet10.xml": `<worksheet><row><c>ten</c></row></worksheet>`,
+		"xl/worksheets/sheet2.xml":  `<worksheet><row><c>two</c></row></worksheet>`,
+		"xl/worksheets/sheet1.xml":  `<worksheet><row><c>one</c><c>1</c></row></worksheet>`,
+	})
+	text, err = ExtractText(xlsx, "book.xlsx", "", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "one 1 \n\ntwo \n\nten \n\n" {
+		t.Errorf("sheets are not in natural order: %q", text)
+	}
+
+	if _, err := ExtractText(writeTestFile(t, "broken.docx", []byte("not a zip")), "broken.docx", "", 1024); err == nil {
+		t.Error("corrupt docx was accepted")
+	}
+}
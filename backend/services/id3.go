@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+type audioTags struct {
+	Title    string
+	Artist   string
+	Album    string
+	Genre    string
+	Year     int
+	Track    int
+	Duration int64
+}
+
+// readID3 reads ID3v2.2-2.4 text frames from the start of r, falling back
+// to an ID3v1 trailer when no v2 tag is present.
+func readID3(r io.ReadSeeker) (*audioTags, bool) {
+	if tags, ok := readID3v2(r); ok {
+		return tags, true
+	}
+	return readID3v1(r)
+}
+
+func readID3v2(r io.ReadSeeker) (*audioTags, bool) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, false
+	}
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "ID3" {
+		return nil, false
+	}
+
+	version := header[3]
+	flags := header[5]
+	size := syncsafe(header[6:10])
+	if version < 2 || version > 4 || size <= 0 || size > 16*1024*1024 {
+		return nil, false
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, false
+	}
+	if flags&0x80 != 0 && version < 4 {
+		data = bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+	}
+	if flags&0x40 != 0 && version >= 3 && len(data) >= 4 {
+		extended := int(binary.BigEndian.Uint32(data[:4]))
+		if version == 4 {
+			extended = syncsafe(data[:4])
+		} else {
+			extended += 4
+		}
+		if extended > len(data) {
+			return nil, false
+		}
+		data = data[extended:]
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	tags := &audioTags{}
+	for len(data) >= headerLen && data[0] != 0 {
+		id := string(data[:idLen])
+		var frameSize int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			frameSize = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(data[4:8]))
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		default:
+			frameSize = syncsafe(data[4:8])
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		}
+		if frameSize <= 0 || headerLen+frameSize > len(data) {
+			break
+		}
+
+		body := data[headerLen : headerLen+frameSize]
+		data = data[headerLen+frameSize:]
+
+		// Compressed or encrypted frames are skipped rather than decoded.
+		if (version == 3 && frameFlags&0x00C0 != 0) || (version == 4 && frameFlags&0x000C != 0) {
+			continue
+		}
+
+		value := func() string { return decodeID3Text(body) }
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = value()
+		case "TPE1", "TP1":
+			tags.Artist = value()
+		case "TALB", "TAL":
+			tags.Album = value()
+		case "TCON", "TCO":
+			tags.Genre = cleanID3Genre(value())
+		case "TYER", "TYE", "TDRC":
+			tags.Year = leadingInt(value())
+		case "TRCK", "TRK":
+			tags.Track = leadingInt(value())
+		case "TLEN", "TLE":
+			tags.Duration = int64(leadingInt(value()))
+		}
+	}
+
+	return tags, true
+}
+
+func readID3v1(r io.ReadSeeker) (*audioTags, bool) {
+	if _, err := r.Seek(-128, io.SeekEnd); err != nil {
+		return nil, false
+	}
+
+	data := make([]byte, 128)
+	if _, err := io.ReadFull(r, data); err != nil || string(data[:3]) != "TAG" {
+		return nil, false
+	}
+
+	field := func(b []byte) string {
+		return strings.TrimSpace(strings.TrimRight(latin1(b), "\x00"))
+	}
+
+	tags := &audioTags{
+		Title:  field(data[3:33]),
+		Artist: field(data[33:63]),
+		Album:  field(data[63:93]),
+		Year:   leadingInt(field(data[93:97])),
+	}
+	if data[125] == 0 && data[126] != 0 {
+		tags.Track = int(data[126])
+	}
+	return tags, true
+}
+
+func decodeID3Text(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	encoding, text := body[0], body[1:]
+	var value string
+	switch encoding {
+	case 1, 2:
+		value = decodeUTF16(text, encoding == 2)
+	case 3:
+		value = string(text)
+	default:
+		value = latin1(text)
+	}
+
+	// Multiple values are NUL separated; keep the first.
+	if i := strings.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFF && b[1] == 0xFE:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xFE && b[1] == 0xFF:
+			bigEndian, b = true, b[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			units = append(units, binary.BigEndian.Uint16(b[i:]))
+		} else {
+			units = append(units, binary.LittleEndian.Uint16(b[i:]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// cleanID3Genre turns "(17)Rock" or "(17)" into a readable genre name.
+func cleanID3Genre(genre string) string {
+	if strings.HasPrefix(genre, "(") {
+		if end := strings.IndexByte(genre, ')'); end > 0 {
+			if rest := strings.TrimSpace(genre[end+1:]); rest != "" {
+				return rest
+			}
+			return genre[1:end]
+		}
+	}
+	return genre
+}
+
+func leadingInt(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func id3Frame(id string, body []byte) []byte {
+	frame := make([]byte, 10, 10+len(body))
+	copy(frame, id)
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(body)))
+	return append(frame, body...)
+}
+
+func id3Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	size := len(body)
+	header := []byte{'I', 'D', '3', version, 0, 0,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(header, body...)
+}
+
+func TestReadID3v2(t *testing.T) {
+	tag := id3Tag(3,
+		id3Frame("TIT2", []byte("\x00Blue in Green")),
+		id3Frame("TPE1", []byte("\x01\xff\xfeM\x00i\x00l\x00e\x00s\x00")),
+		id3Frame("TCON", []byte("\x00(8)Jazz")),
+		id3Frame("TRCK", []byte("\x003/5")),
+		id3Frame("TYER", []byte("\x001959")),
+	)
+	tags, ok := readID3(bytes.NewReader(append(tag, make([]byte, 64)...)))
+	if !ok {
+		t.Fatal("tag was not recognised")
+	}
+	want := audioTags{Title: "Blue in Green", Artist: "Miles", Genre: "Jazz", Track: 3, Year: 1959}
+	if *tags != want {
+		t.Errorf("readID3() = %+v, want %+v", *tags, want)
+	}
+}
+
+func TestReadID3v1(t *testing.T) {
+	trailer := make([]byte, 128)
+	copy(trailer, "TAG")
+	copy(trailer[3:], "So What")
+	copy(trailer[33:], "Miles Davis")
+	copy(trailer[93:], "1959")
+	trailer[126] = 1
+
+	tags, ok := readID3(bytes.NewReader(append(make([]byte, 500), trailer...)))
+	if !ok {
+		t.Fatal("ID3v1 trailer was not recognised")
+	}
+	if tags.Title != "So What" || tags.Artist != "Miles Davis" || tags.Year != 1959 || tags.Track != 1 {
+		t.Errorf("readID3() = %+v", *tags)
+	}
+}
+
+func TestReadID3Malformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":            nil,
+		"truncated header": []byte("ID3\x03\x00"),
+		"oversized tag":    []byte("ID3\x03\x00\x00\x7f\x7f\x7f\x7f"),
+		"short tag body":   []byte("ID3\x03\x00\x00\x00\x00\x01\x00TIT2"),
+		"bad version":      id3Tag(9, id3Frame("TIT2", []byte("\x00x"))),
+		"extended header past the tag": append([]byte("ID3\x03\x00\x40\x00\x00\x00\x08"),
+			0x7F, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0),
+	} {
+		if tags, ok := readID3(bytes.NewReader(data)); ok {
+			t.Errorf("%s: readID3() = %+v, want no tags", name, *tags)
+		}
+	}
+
+	// A frame that claims to run past the end of the tag is dropped.
+	oversized := id3Frame("TIT2", []byte("\x00title"))
+	binary.BigEndian.PutUint32(oversized[4:8], 1<<20)
+	tags, ok := readID3(bytes.NewReader(id3Tag(3, oversized)))
+	if !ok || tags.Title != "" {
+		t.Errorf("oversized frame: %+v %v", tags, ok)
+	}
+
+	// No truncation or corruption of a valid tag may panic.
+	valid := id3Tag(4, id3Frame("TIT2", []byte("\x01\xfe\xff\x00A")), id3Frame("TALB", []byte("\x03album")))
+	for i := range valid {
+		readID3(bytes.NewReader(valid[:i]))
+
+		corrupt := bytes.Clone(valid)
+		corrupt[i] ^= 0xFF
+		readID3(bytes.NewReader(corrupt))
+	}
+}
+
+func TestCleanID3Genre(t *testing.T) {
+	for in, want := range map[string]string{
+		"(17)Rock": "Rock",
+		"(17)":     "17",
+		"Ambient":  "Ambient",
+		"(":        "(",
+	} {
+		if got := cleanID3Genre(in); got != want {
+			t.Errorf("cleanID3Genre(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
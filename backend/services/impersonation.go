@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+const defaultImpersonationTTL = 15 * time.Minute
+
+var (
+	ErrImpersonationNotAllowed = errors.New("this user cannot be impersonated")
+	ErrImpersonationNotFound   = errors.New("impersonation not found")
+)
+
+type ImpersonationService struct {
+	config *config.Config
+	tokens *TokenService
+	roles  *RoleService
+}
+
+func NewImpersonationService(cfg *config.Config, tokens *TokenService, roles *RoleService) *ImpersonationService {
+	return &ImpersonationService{config: cfg, tokens: tokens, roles: roles}
+}
+
+// Start opens an impersonation of target and returns its token. ttl is
+// clamped to ImpersonationMaxTTL; zero selects the default.
+func (s *ImpersonationService) Start(actor, target *models.User, reason string, readOnly bool, ttl time.Duration, origin models.ActivityOrigin) (*models.Impersonation, string, int64, error) {
+	if actor.ID == target.ID || !target.IsActive {
+		return nil, "", 0, ErrImpersonationNotAllowed
+	}
+	if !s.roles.Outranks(actor, target) {
+		return nil, "", 0, ErrRoleEscalation
+	}
+
+	if ttl <= 0 {
+		ttl = defaultImpersonationTTL
+	}
+	ttl = min(ttl, s.config.ImpersonationMaxTTL)
+
+	impersonation := models.Impersonation{
+		ImpersonatorID: actor.ID,
+		TargetID:       target.ID,
+		Reason:         reason,
+		ReadOnly:       readOnly,
+		IPAddress:      origin.IPAddress,
+		ExpiresAt:      time.Now().Add(ttl),
+	}
+
+	mode := "full access"
+	if readOnly {
+		mode = "read-only"
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&impersonation).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Activity{
+			UserID:    target.ID,
+			ActorID:   &actor.ID,
+			Type:      models.ActivityImpersonationStarted,
+			IPAddress: origin.IPAddress,
+			UserAgent: origin.UserAgent,
+			Source:    origin.Source,
+			Details:   fmt.Sprintf("%s started a %s impersonation for %s: %s", actor.Email, mode, formatTTL(ttl), reason),
+		}).Error
+	})
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	token, expiresIn, err := s.tokens.GenerateImpersonationToken(&impersonation)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return &impersonation, token, expiresIn, nil
+}
+
+// End invalidates every token issued for the impersonation.
+func (s *ImpersonationService) End(id uuid.UUID, endedBy *models.User, origin models.ActivityOrigin) error {
+	var impersonation models.Impersonation
+	if err := database.DB.First(&impersonation, "id = ?", id).Error; err != nil {
+		return ErrImpersonationNotFound
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&impersonation).
+			Where("ended_at IS NULL").
+			Update("ended_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrImpersonationNotFound
+		}
+		return tx.Create(&models.Activity{
+			UserID:    impersonation.TargetID,
+			ActorID:   &impersonation.ImpersonatorID,
+			Type:      models.ActivityImpersonationEnded,
+			IPAddress: origin.IPAddress,
+			UserAgent: origin.UserAgent,
+			Source:    origin.Source,
+			Details:   "Ended by " + endedBy.Email,
+		}).Error
+	})
+}
+
+// List returns impersonations, newest first, optionally only those of
+// target.
+func (s *ImpersonationService) List(target *uuid.UUID, limit int) ([]models.Impersonation, error) {
+	query := database.DB.Preload("Impersonator").Preload("Target").Order("created_at DESC").Limit(limit)
+	if target != nil {
+		query = query.Where("target_id = ?", *target)
+	}
+
+	var impersonations []models.Impersonation
+	err := query.Find(&impersonations).Error
+	return impersonations, err
+}
+
+// RecordRequest logs one request made with an impersonation token under
+// both identities.
+func (s *ImpersonationService) RecordRequest(impersonation *models.Impersonation, method, path string, status int, origin models.ActivityOrigin) {
+	database.DB.Create(&models.Activity{
+		UserID:    impersonation.TargetID,
+		ActorID:   &impersonation.ImpersonatorID,
+		Type:      models.ActivityImpersonatedRequest,
+		IPAddress: origin.IPAddress,
+		UserAgent: origin.UserAgent,
+		Source:    origin.Source,
+		Details:   fmt.Sprintf("%s %s -> %d (impersonation %s)", method, path, status, impersonation.ID),
+	})
+}
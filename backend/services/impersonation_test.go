@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+func newTestImpersonationService(t *testing.T) (*ImpersonationService, *TokenService) {
+	t.Helper()
+	roles := newTestRoleService(t)
+	cfg := &config.Config{JWTAlgorithm: "HS256", JWTSecret: "test-secret", AccessTTL: time.Minute, RefreshTTL: time.Hour, ImpersonationMaxTTL: time.Hour}
+	tokens := mustTokenService(t, cfg)
+	return NewImpersonationService(cfg, tokens, roles), tokens
+}
+
+func TestImpersonationStart(t *testing.T) {
+	s, tokens := newTestImpersonationService(t)
+	admin := createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+	manager := createUserWithRole(t, "manager@example.com", models.RoleUserManager)
+	user := createUserWithRole(t, "user@example.com", models.RoleUser)
+
+	if _, _, _, err := s.Start(admin, admin, "self", false, 0, models.ActivityOrigin{}); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("impersonating yourself: got %v, want ErrImpersonationNotAllowed", err)
+	}
+	if _, _, _, err := s.Start(manager, admin, "escalate", false, 0, models.ActivityOrigin{}); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager impersonating an admin: got %v, want ErrRoleEscalation", err)
+	}
+
+	impersonation, token, expiresIn, err := s.Start(admin, user, "ticket 42", true, 24*time.Hour, testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresIn > int64(time.Hour.Seconds()) {
+		t.Errorf("token lives %ds, longer than ImpersonationMaxTTL", expiresIn)
+	}
+
+	claims, err := tokens.ParseAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID || claims.Actor == nil || claims.Actor.Subject != admin.ID || claims.Impersonation == nil || !claims.Impersonation.ReadOnly {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	var started int64
+	database.DB.Model(&models.Activity{}).Where("user_id = ? AND actor_id = ? AND type = ?", user.ID, admin.ID, models.ActivityImpersonationStarted).Count(&started)
+	if started != 1 {
+		t.Errorf("%d impersonation_started activities, want 1", started)
+	}
+
+	if err := s.End(impersonation.ID, admin, models.ActivityOrigin{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.ParseAccessToken(token); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("token after End: got %v, want ErrSessionRevoked", err)
+	}
+	if err := s.End(impersonation.ID, admin, models.ActivityOrigin{}); !errors.Is(err, ErrImpersonationNotFound) {
+		t.Errorf("ending twice: got %v, want ErrImpersonationNotFound", err)
+	}
+}
+
+func TestImpersonationTokenExpires(t *testing.T) {
+	s, tokens := newTestImpersonationService(t)
+	admin := createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+	user := createUserWithRole(t, "user@example.com", models.RoleUser)
+
+	impersonation, token, _, err := s.Start(admin, user, "ticket 42", false, 0, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Model(impersonation).Update("expires_at", time.Now().Add(-time.Second))
+	if _, err := tokens.ParseAccessToken(token); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("token of an expired impersonation: got %v, want ErrSessionRevoked", err)
+	}
+}
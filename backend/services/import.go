@@ -0,0 +1,328 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+const maxImportRows = 5000
+
+var ErrInvalidImport = errors.New("invalid import file")
+
+// ImportRow is one user to create. Line is the row's position in the file,
+// counting the CSV header, so problems can be reported against it.
+type ImportRow struct {
+	Line        int    `json:"line"`
+	Email       string `json:"email"`
+	DisplayName string `json:"display_name"`
+	Quota       *int64 `json:"quota,omitempty"`
+	Role        string `json:"role"`
+	Active      *bool  `json:"active,omitempty"`
+}
+
+type ImportStatus string
+
+const (
+	ImportCreate  ImportStatus = "create"
+	ImportCreated ImportStatus = "created"
+	ImportExists  ImportStatus = "exists"
+	ImportInvalid ImportStatus = "invalid"
+)
+
+type ImportResult struct {
+	ImportRow
+	Status  ImportStatus `json:"status"`
+	Errors  []string     `json:"errors,omitempty"`
+	UserID  *uuid.UUID   `json:"user_id,omitempty"`
+	Invited bool         `json:"invited,omitempty"`
+}
+
+// ImportReport describes an import. Nothing is written unless every row is
+// valid, so a report with invalid rows is always a dry run.
+type ImportReport struct {
+	DryRun   bool           `json:"dry_run"`
+	Valid    bool           `json:"valid"`
+	Created  int            `json:"created"`
+	Existing int            `json:"existing"`
+	Invalid  int            `json:"invalid"`
+	Rows     []ImportResult `json:"rows"`
+}
+
+// ParseImportCSV reads users from CSV with a header row. Only the email
+// column is required; the others are display_name (or name), quota in
+// bytes, role and active.
+func ParseImportCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if name == "name" {
+			name = "display_name"
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("%w: missing email column", ErrInvalidImport)
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []ImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, maxImportRows)
+		}
+
+		row := ImportRow{
+			Line:        line,
+			Email:       field(record, "email"),
+			DisplayName: field(record, "display_name"),
+			Role:        field(record, "role"),
+		}
+		if raw := field(record, "quota"); raw != "" {
+			quota, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: quota must be a number of bytes", ErrInvalidImport, line)
+			}
+			row.Quota = &quota
+		}
+		if raw := field(record, "active"); raw != "" {
+			active, ok := parseImportBool(raw)
+			if !ok {
+				return nil, fmt.Errorf("%w: line %d: active must be true or false", ErrInvalidImport, line)
+			}
+			row.Active = &active
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseImportBool(raw string) (bool, bool) {
+	switch strings.ToLower(raw) {
+	case "true", "yes", "y", "1":
+		return true, true
+	case "false", "no", "n", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// ParseImportJSON reads users from a JSON array of objects with the same
+// fields as the CSV columns.
+func ParseImportJSON(r io.Reader) ([]ImportRow, error) {
+	var rows []ImportRow
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if len(rows) > maxImportRows {
+		return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, maxImportRows)
+	}
+	for i := range rows {
+		rows[i].Line = i + 1
+		rows[i].Email = strings.TrimSpace(rows[i].Email)
+		rows[i].DisplayName = strings.TrimSpace(rows[i].DisplayName)
+		rows[i].Role = strings.TrimSpace(rows[i].Role)
+	}
+	return rows, nil
+}
+
+type UserImportService struct {
+	accounts *AccountService
+	roles    *RoleService
+}
+
+func NewUserImportService(accounts *AccountService, roles *RoleService) *UserImportService {
+	return &UserImportService{accounts: accounts, roles: roles}
+}
+
+// Import validates rows and, unless dryRun is set or a row is invalid,
+// creates the new users in one transaction. Addresses that already have an
+// account are skipped. With invite set, every new active user is mailed a
+// link to choose a password.
+func (s *UserImportService) Import(actor *models.User, rows []ImportRow, dryRun, invite bool, origin models.ActivityOrigin) (*ImportReport, error) {
+	if invite && !s.accounts.MailEnabled() {
+		return nil, ErrMailerDisabled
+	}
+
+	report, err := s.validate(actor, rows)
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun || !report.Valid
+	if report.DryRun {
+		return report, nil
+	}
+
+	var created []*models.User
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range report.Rows {
+			result := &report.Rows[i]
+			if result.Status != ImportCreate {
+				continue
+			}
+
+			user := &models.User{
+				Email:       result.Email,
+				DisplayName: result.DisplayName,
+				Role:        result.Role,
+			}
+			if result.Quota != nil {
+				user.Quota = *result.Quota
+			}
+			if err := createUser(tx, user); err != nil {
+				return err
+			}
+			// Zero values are replaced by the column defaults on create.
+			zeroed := map[string]interface{}{}
+			if result.Quota != nil && *result.Quota == 0 {
+				zeroed["quota"] = int64(0)
+			}
+			if result.Active != nil && !*result.Active {
+				zeroed["is_active"] = false
+			}
+			if len(zeroed) > 0 {
+				if err := tx.Model(user).Updates(zeroed).Error; err != nil {
+					return err
+				}
+			}
+
+			err := tx.Create(&models.Activity{
+				UserID:    user.ID,
+				ActorID:   &actor.ID,
+				Type:      models.ActivityUserRegistered,
+				IPAddress: origin.IPAddress,
+				UserAgent: origin.UserAgent,
+				Source:    origin.Source,
+				Details:   "Imported by " + actor.Email,
+				Changes:   models.ActivityChanges{"email": {After: user.Email}, "role": {After: user.Role}, "quota": {After: user.Quota}},
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			result.Status = ImportCreated
+			result.UserID = &user.ID
+			created = append(created, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Created = len(created)
+
+	if invite {
+		for i := range report.Rows {
+			result := &report.Rows[i]
+			if result.Status != ImportCreated {
+				continue
+			}
+			user := created[0]
+			created = created[1:]
+			if !user.IsActive {
+				continue
+			}
+			if err := s.accounts.SendAccountSetup(user); err != nil {
+				slog.Warn("Failed to invite imported user", "user", user.Email, "error", err)
+				continue
+			}
+			result.Invited = true
+		}
+	}
+	return report, nil
+}
+
+func (s *UserImportService) validate(actor *models.User, rows []ImportRow) (*ImportReport, error) {
+	report := &ImportReport{Valid: true, Rows: make([]ImportResult, len(rows))}
+
+	emails := make([]string, 0, len(rows))
+	for _, row := range rows {
+		emails = append(emails, strings.ToLower(row.Email))
+	}
+	// Deleted accounts count too: until they are purged they hold on to
+	// their address.
+	var taken []string
+	if len(emails) > 0 {
+		err := database.DB.Unscoped().Model(&models.User{}).Where("LOWER(email) IN ?", emails).Pluck("LOWER(email)", &taken).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	existing := make(map[string]bool, len(taken))
+	for _, email := range taken {
+		existing[email] = true
+	}
+
+	seen := make(map[string]int)
+	for i, row := range rows {
+		result := ImportResult{ImportRow: row, Status: ImportCreate}
+		if result.Role == "" {
+			result.Role = models.RoleUser
+		}
+		invalid := func(format string, args ...interface{}) {
+			result.Status = ImportInvalid
+			result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+		}
+
+		key := strings.ToLower(row.Email)
+		if address, err := mail.ParseAddress(row.Email); err != nil || address.Address != row.Email {
+			invalid("invalid email address")
+		} else if line, ok := seen[key]; ok {
+			invalid("duplicate of line %d", line)
+		}
+		seen[key] = row.Line
+		if len(row.DisplayName) > 255 {
+			invalid("display name is longer than 255 characters")
+		}
+		if row.Quota != nil && *row.Quota < 0 {
+			invalid("quota must not be negative")
+		}
+		if err := s.roles.CanGrant(actor, result.Role); errors.Is(err, ErrRoleNotFound) {
+			invalid("unknown role %q", result.Role)
+		} else if err != nil {
+			invalid("you cannot grant the role %q", result.Role)
+		}
+
+		switch {
+		case result.Status == ImportInvalid:
+			report.Invalid++
+			report.Valid = false
+		case existing[key]:
+			result.Status = ImportExists
+			report.Existing++
+		}
+		report.Rows[i] = result
+	}
+	return report, nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	rows, err := ParseImportCSV(strings.NewReader("\ufeffEmail, Name,quota,role,active\n" +
+		"alice@example.com, Alice ,1024,admin,yes\n" +
+		"bob@example.com,,,,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quota, active := int64(1024), true
+	want := []ImportRow{
+		{Line: 2, Email: "alice@example.com", DisplayName: "Alice", Quota: &quota, Role: "admin", Active: &active},
+		{Line: 3, Email: "bob@example.com"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("ParseImportCSV() = %+v, want %+v", rows, want)
+	}
+
+	for name, input := range map[string]string{
+		"empty":          "",
+		"no email":       "name,role\nAlice,user\n",
+		"quota":          "email,quota\nalice@example.com,10GB\n",
+		"active":         "email,active\nalice@example.com,maybe\n",
+		"unclosed quote": "email,name\nalice@example.com,\"Alice\n",
+	} {
+		if _, err := ParseImportCSV(strings.NewReader(input)); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("%s: got %v, want ErrInvalidImport", name, err)
+		}
+	}
+
+	tooMany := "email\n" + strings.Repeat("alice@example.com\n", maxImportRows+1)
+	if _, err := ParseImportCSV(strings.NewReader(tooMany)); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("%d rows: got %v, want ErrInvalidImport", maxImportRows+1, err)
+	}
+}
+
+func TestParseImportJSON(t *testing.T) {
+	rows, err := ParseImportJSON(strings.NewReader(`[{"email":" alice@example.com ","display_name":"Alice","active":false},{"email":"bob@example.com","role":"admin"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Line != 1 || rows[0].Email != "alice@example.com" || rows[0].Active == nil || *rows[0].Active ||
+		rows[1].Line != 2 || rows[1].Role != "admin" {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+
+	if _, err := ParseImportJSON(strings.NewReader(`{"email":"alice@example.com"}`)); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("object instead of array: got %v, want ErrInvalidImport", err)
+	}
+}
+
+func newTestImportService(t *testing.T, cfg *config.Config) (*UserImportService, *models.User) {
+	t.Helper()
+	dbtest.Open(t)
+	roles := NewRoleService()
+	if err := roles.Load(); err != nil {
+		t.Fatal(err)
+	}
+	actor := &models.User{Email: "manager@example.com", Role: models.RoleUserManager, IsActive: true}
+	if err := CreateUser(actor); err != nil {
+		t.Fatal(err)
+	}
+	return NewUserImportService(NewAccountService(cfg, NewMailer(cfg), nil), roles), actor
+}
+
+func countUsers(t *testing.T) int64 {
+	t.Helper()
+	var count int64
+	if err := database.DB.Unscoped().Model(&models.User{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestImportValidation(t *testing.T) {
+	s, actor := newTestImportService(t, &config.Config{})
+	existing := &models.User{Email: "taken@example.com", IsActive: true}
+	if err := CreateUser(existing); err != nil {
+		t.Fatal(err)
+	}
+	before := countUsers(t)
+
+	negative := int64(-1)
+	rows := []ImportRow{
+		{Line: 2, Email: "new@example.com"},
+		{Line: 3, Email: "Taken@Example.com"},
+		{Line: 4, Email: "not an address"},
+		{Line: 5, Email: "NEW@example.com"},
+		{Line: 6, Email: "quota@example.com", Quota: &negative},
+		{Line: 7, Email: "role@example.com", Role: "no-such-role"},
+		{Line: 8, Email: "admin@example.com", Role: models.RoleAdmin},
+	}
+	report, err := s.Import(actor, rows, false, false, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Valid || !report.DryRun {
+		t.Errorf("report with invalid rows: valid=%v dry_run=%v", report.Valid, report.DryRun)
+	}
+	if report.Invalid != 5 || report.Existing != 1 || report.Created != 0 {
+		t.Errorf("counts: invalid=%d existing=%d created=%d", report.Invalid, report.Existing, report.Created)
+	}
+	want := []struct {
+		status ImportStatus
+		error  string
+	}{
+		{ImportCreate, ""},
+		{ImportExists, ""},
+		{ImportInvalid, "invalid email address"},
+		{ImportInvalid, "duplicate of line 2"},
+		{ImportInvalid, "quota must not be negative"},
+		{ImportInvalid, `unknown role "no-such-role"`},
+		{ImportInvalid, `you cannot grant the role "admin"`},
+	}
+	for i, result := range report.Rows {
+		if result.Status != want[i].status || (want[i].error != "" && (len(result.Errors) != 1 || result.Errors[0] != want[i].error)) {
+			t.Errorf("line %d: status %s errors %q, want %s %q", result.Line, result.Status, result.Errors, want[i].status, want[i].error)
+		}
+	}
+
+	if after := countUsers(t); after != before {
+		t.Fatalf("an invalid import created %d users", after-before)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	s, actor := newTestImportService(t, &config.Config{})
+	before := countUsers(t)
+
+	rows := []ImportRow{{Line: 2, Email: "alice@example.com"}, {Line: 3, Email: "bob@example.com", Role: models.RoleUserManager}}
+	report, err := s.Import(actor, rows, true, false, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid || !report.DryRun || report.Created != 0 {
+		t.Fatalf("dry run report: valid=%v dry_run=%v created=%d", report.Valid, report.DryRun, report.Created)
+	}
+	for _, result := range report.Rows {
+		if result.Status != ImportCreate || result.UserID != nil {
+			t.Errorf("line %d: status %s user %v", result.Line, result.Status, result.UserID)
+		}
+	}
+	if after := countUsers(t); after != before {
+		t.Fatalf("dry run created %d users", after-before)
+	}
+}
+
+func TestImportCreatesUsers(t *testing.T) {
+	s, actor := newTestImportService(t, &config.Config{})
+
+	zero, inactive := int64(0), false
+	rows := []ImportRow{
+		{Line: 2, Email: "alice@example.com", DisplayName: "Alice", Role: models.RoleUserManager},
+		{Line: 3, Email: "bob@example.com", Quota: &zero, Active: &inactive},
+	}
+	report, err := s.Import(actor, rows, false, false, models.ActivityOrigin{Source: models.ActivitySourceWeb})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DryRun || report.Created != 2 {
+		t.Fatalf("report: dry_run=%v created=%d", report.DryRun, report.Created)
+	}
+
+	var alice, bob models.User
+	database.DB.First(&alice, "email = ?", "alice@example.com")
+	database.DB.First(&bob, "email = ?", "bob@example.com")
+	if alice.Role != models.RoleUserManager || alice.DisplayName != "Alice" || !alice.IsActive {
+		t.Errorf("alice: %+v", alice)
+	}
+	// Zero values must survive the column defaults.
+	if bob.Quota != 0 || bob.IsActive {
+		t.Errorf("bob: quota=%d active=%v", bob.Quota, bob.IsActive)
+	}
+	if *report.Rows[0].UserID != alice.ID || *report.Rows[1].UserID != bob.ID {
+		t.Error("report does not name the created users")
+	}
+
+	// Running the same file again only reports the accounts as existing.
+	report, err = s.Import(actor, rows, false, false, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Existing != 2 || report.Created != 0 {
+		t.Errorf("second run: existing=%d created=%d", report.Existing, report.Created)
+	}
+}
+
+func TestImportInviteRequiresMail(t *testing.T) {
+	s, actor := newTestImportService(t, &config.Config{})
+	before := countUsers(t)
+	if _, err := s.Import(actor, []ImportRow{{Line: 2, Email: "alice@example.com"}}, false, true, models.ActivityOrigin{}); !errors.Is(err, ErrMailerDisabled) {
+		t.Fatalf("got %v, want ErrMailerDisabled", err)
+	}
+	if after := countUsers(t); after != before {
+		t.Fatalf("import without mail created %d users", after-before)
+	}
+}
+
+func TestImportInvites(t *testing.T) {
+	mailbox := newFakeSMTP(t)
+	s, actor := newTestImportService(t, &config.Config{
+		PublicURL: "http://localhost",
+		SMTPHost:  mailbox.host,
+		SMTPPort:  mailbox.port,
+		SMTPFrom:  "Stratus <noreply@stratus.local>",
+		SMTPTLS:   "none",
+	})
+	inactive := false
+	rows := []ImportRow{
+		{Line: 2, Email: "alice@example.com"},
+		{Line: 3, Email: "bob@example.com", Active: &inactive},
+	}
+	report, err := s.Import(actor, rows, false, true, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Rows[0].Invited || report.Rows[1].Invited {
+		t.Fatalf("invited: alice=%v bob=%v, want only alice", report.Rows[0].Invited, report.Rows[1].Invited)
+	}
+	mailbox.token(t)
+	select {
+	case <-mailbox.messages:
+		t.Fatal("an inactive user was invited")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
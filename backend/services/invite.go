@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+var (
+	ErrInvalidInvite        = errors.New("invalid or expired invite code")
+	ErrInviteNotFound       = errors.New("invite not found")
+	ErrInvalidInviteRequest = errors.New("invalid invite request")
+)
+
+type InviteService struct {
+	config *config.Config
+	mailer *Mailer
+}
+
+func NewInviteService(cfg *config.Config, mailer *Mailer) *InviteService {
+	return &InviteService{config: cfg, mailer: mailer}
+}
+
+// Create returns the new invite and its code, which is only ever shown once.
+// When the invite is bound to an email address and mail is configured, the
+// code is also sent to that address.
+func (s *InviteService) Create(creator *models.User, email, note string, quota *int64, role string, expiresAt *time.Time) (*models.Invite, string, error) {
+	if quota != nil && *quota < 0 {
+		return nil, "", fmt.Errorf("%w: quota must not be negative", ErrInvalidInviteRequest)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expiry must be in the future", ErrInvalidInviteRequest)
+	}
+
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	code, err := RandomToken(18)
+	if err != nil {
+		return nil, "", err
+	}
+
+	invite := models.Invite{
+		CodeHash:  HashToken(code),
+		Hint:      code[len(code)-4:],
+		Email:     strings.TrimSpace(email),
+		Note:      note,
+		Quota:     quota,
+		Role:      role,
+		CreatedBy: creator.ID,
+		ExpiresAt: expiresAt,
+	}
+	if err := database.DB.Create(&invite).Error; err != nil {
+		return nil, "", err
+	}
+
+	if invite.Email != "" && s.mailer.Enabled() {
+		inviter := creator.DisplayName
+		if inviter == "" {
+			inviter = creator.Email
+		}
+		data := map[string]interface{}{
+			"Inviter": inviter,
+			"Link":    s.Link(code),
+		}
+		if expiresAt != nil {
+			data["ExpiresIn"] = formatTTL(time.Until(*expiresAt).Round(time.Minute))
+		}
+		s.mailer.SendAsync(invite.Email, "invitation", data)
+	}
+
+	return &invite, code, nil
+}
+
+func (s *InviteService) Link(code string) string {
+	return strings.TrimSuffix(s.config.PublicURL, "/") + "/register?invite=" + url.QueryEscape(code)
+}
+
+func (s *InviteService) List() ([]models.Invite, error) {
+	var invites []models.Invite
+	err := database.DB.Order("created_at DESC").Find(&invites).Error
+	return invites, err
+}
+
+func (s *InviteService) Revoke(id uuid.UUID) error {
+	result := database.DB.Model(&models.Invite{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// Register creates user with the quota and role preset by the invite and
+// marks the invite used, all in one transaction.
+func (s *InviteService) Register(code string, user *models.User) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var invite models.Invite
+		err := tx.Where("code_hash = ? AND used_at IS NULL AND revoked_at IS NULL", HashToken(code)).
+			First(&invite).Error
+		if err != nil {
+			return ErrInvalidInvite
+		}
+		if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+			return ErrInvalidInvite
+		}
+		if invite.Email != "" && !strings.EqualFold(invite.Email, user.Email) {
+			return ErrInvalidInvite
+		}
+
+		if invite.Quota != nil {
+			user.Quota = *invite.Quota
+		}
+		user.Role = invite.Role
+		// An invite sent to this address already proves control of it.
+		if invite.Email != "" {
+			now := time.Now()
+			user.VerifiedAt = &now
+		}
+		if err := createUser(tx, user); err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.Invite{}).
+			Where("id = ? AND used_at IS NULL", invite.ID).
+			Updates(map[string]interface{}{"used_at": time.Now(), "used_by": user.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidInvite
+		}
+		return nil
+	})
+}
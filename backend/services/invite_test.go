@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+func newTestInviteService(t *testing.T) (*InviteService, *models.User) {
+	t.Helper()
+	dbtest.Open(t)
+	cfg := &config.Config{PublicURL: "http://localhost"}
+	return NewInviteService(cfg, NewMailer(cfg)), createTestUser(t, "admin@example.com")
+}
+
+func TestInviteRegister(t *testing.T) {
+	s, admin := newTestInviteService(t)
+	quota := int64(5 << 30)
+	invite, code, err := s.Create(admin, "", "new colleague", &quota, models.RoleAuditor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.Hint != code[len(code)-4:] || invite.CodeHash == code {
+		t.Errorf("invite stores hint %q and hash %q", invite.Hint, invite.CodeHash)
+	}
+
+	user := models.User{Email: "new@example.com"}
+	if err := s.Register(code, &user); err != nil {
+		t.Fatal(err)
+	}
+	var stored models.User
+	database.DB.First(&stored, "id = ?", user.ID)
+	if stored.Quota != quota || stored.Role != models.RoleAuditor || stored.VerifiedAt != nil {
+		t.Errorf("registered user: quota %d, role %q, verified %v", stored.Quota, stored.Role, stored.VerifiedAt)
+	}
+
+	var used models.Invite
+	database.DB.First(&used, "id = ?", invite.ID)
+	if used.UsedAt == nil || used.UsedBy == nil || *used.UsedBy != user.ID {
+		t.Errorf("invite after use: %+v", used)
+	}
+	if err := s.Register(code, &models.User{Email: "second@example.com"}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("second use: got %v, want ErrInvalidInvite", err)
+	}
+}
+
+func TestInviteBoundToEmail(t *testing.T) {
+	s, admin := newTestInviteService(t)
+	_, code, err := s.Create(admin, "Invitee@Example.com", "", nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Register(code, &models.User{Email: "someone@example.com"}); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatalf("other address: got %v, want ErrInvalidInvite", err)
+	}
+	user := models.User{Email: "invitee@example.com"}
+	if err := s.Register(code, &user); err != nil {
+		t.Fatalf("invited address: %v", err)
+	}
+	if user.VerifiedAt == nil {
+		t.Error("an address proven by the invite was not marked verified")
+	}
+}
+
+func TestInviteRejectsExpiredAndRevoked(t *testing.T) {
+	s, admin := newTestInviteService(t)
+
+	soon := time.Now().Add(time.Hour)
+	expiring, expiringCode, _ := s.Create(admin, "", "", nil, "", &soon)
+	database.DB.Model(expiring).Update("expires_at", time.Now().Add(-time.Second))
+	if err := s.Register(expiringCode, &models.User{Email: "a@example.com"}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("expired invite: got %v, want ErrInvalidInvite", err)
+	}
+
+	revoked, revokedCode, _ := s.Create(admin, "", "", nil, "", nil)
+	if err := s.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(revoked.ID); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("second revoke: got %v, want ErrInviteNotFound", err)
+	}
+	if err := s.Register(revokedCode, &models.User{Email: "b@example.com"}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("revoked invite: got %v, want ErrInvalidInvite", err)
+	}
+
+	var users int64
+	database.DB.Model(&models.User{}).Count(&users)
+	if users != 1 {
+		t.Errorf("%d users after rejected invites, want only the admin", users)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	negative := int64(-1)
+	if _, _, err := s.Create(admin, "", "", nil, "", &past); !errors.Is(err, ErrInvalidInviteRequest) {
+		t.Errorf("invite expiring in the past: got %v", err)
+	}
+	if _, _, err := s.Create(admin, "", "", &negative, "", nil); !errors.Is(err, ErrInvalidInviteRequest) {
+		t.Errorf("negative quota: got %v", err)
+	}
+}
+
+func TestInviteConcurrentRedeem(t *testing.T) {
+	s, admin := newTestInviteService(t)
+	_, code, _ := s.Create(admin, "", "", nil, "", nil)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Register(code, &models.User{Email: string(rune('a'+i)) + "@example.com"})
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d registrations with one invite, want 1: %v", succeeded, errs)
+	}
+}
+
+func TestAllowsEmailDomain(t *testing.T) {
+	settings := Settings{RegistrationDomains: []string{"example.com", " Corp.Example "}}
+	for email, want := range map[string]bool{
+		"alice@example.com":       true,
+		"ALICE@EXAMPLE.COM":       true,
+		"bob@corp.example":        true,
+		"eve@sub.example.com":     false,
+		"eve@example.com.evil.io": false,
+		"not-an-email":            false,
+	} {
+		if got := settings.AllowsEmailDomain(email); got != want {
+			t.Errorf("AllowsEmailDomain(%q) = %v, want %v", email, got, want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+
+	"stratus/config"
+)
+
+const minRSAKeyBits = 2048
+
+// SigningKeys holds the key new tokens are signed with and every key still
+// accepted for verification, indexed by kid. Rotating means signing with a
+// new key while listing the previous one in JWT_VERIFICATION_KEY_FILES, or
+// for HS256 the previous secret in JWT_PREVIOUS_SECRETS, until its tokens
+// have expired.
+type SigningKeys struct {
+	method     jwt.SigningMethod
+	signingKey interface{}
+	keyID      string
+	verify     map[string]verificationKey
+	methods    []string
+	jwks       jose.JSONWebKeySet
+}
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+func LoadSigningKeys(cfg *config.Config) (*SigningKeys, error) {
+	keys := &SigningKeys{verify: make(map[string]verificationKey)}
+
+	if cfg.JWTAlgorithm == jwt.SigningMethodHS256.Alg() {
+		keys.method = jwt.SigningMethodHS256
+		keys.signingKey = []byte(cfg.JWTSecret)
+		keys.keyID = keys.addSecret(cfg.JWTSecret)
+		for _, secret := range cfg.JWTPreviousSecrets {
+			keys.addSecret(secret)
+		}
+		keys.methods = []string{jwt.SigningMethodHS256.Alg()}
+		keys.jwks.Keys = []jose.JSONWebKey{}
+		return keys, nil
+	}
+
+	private, err := readPrivateKey(cfg.JWTSigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	keys.signingKey = private
+	keys.keyID, err = keys.addPublicKey(private.Public())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.JWTSigningKeyFile, err)
+	}
+	keys.method = keys.verify[keys.keyID].method
+	if keys.method.Alg() != cfg.JWTAlgorithm {
+		return nil, fmt.Errorf("%s holds a %s key but JWT_ALGORITHM is %s", cfg.JWTSigningKeyFile, keys.method.Alg(), cfg.JWTAlgorithm)
+	}
+
+	for _, file := range cfg.JWTVerificationKeyFiles {
+		public, err := readPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := keys.addPublicKey(public); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return keys, nil
+}
+
+// addSecret registers an HS256 secret for verification. Its kid is derived
+// from a hash of the secret, which reveals nothing usable about it.
+func (k *SigningKeys) addSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	keyID := "hs256-" + hex.EncodeToString(sum[:6])
+	k.verify[keyID] = verificationKey{method: jwt.SigningMethodHS256, key: []byte(secret)}
+	return keyID
+}
+
+// addPublicKey registers key for verification and publishes it in the JWKS.
+// Its kid is the RFC 7638 thumbprint.
+func (k *SigningKeys) addPublicKey(key crypto.PublicKey) (string, error) {
+	var method jwt.SigningMethod
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return "", fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		method = jwt.SigningMethodRS256
+	default:
+		return "", fmt.Errorf("unsupported key type %T; use Ed25519 or RSA", key)
+	}
+
+	jwk := jose.JSONWebKey{Key: key, Algorithm: method.Alg(), Use: "sig"}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	if _, exists := k.verify[jwk.KeyID]; exists {
+		return jwk.KeyID, nil
+	}
+	k.verify[jwk.KeyID] = verificationKey{method: method, key: key}
+	k.jwks.Keys = append(k.jwks.Keys, jwk)
+
+	known := false
+	for _, alg := range k.methods {
+		known = known || alg == method.Alg()
+	}
+	if !known {
+		k.methods = append(k.methods, method.Alg())
+	}
+	return jwk.KeyID, nil
+}
+
+func (k *SigningKeys) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.keyID
+	return token.SignedString(k.signingKey)
+}
+
+func (k *SigningKeys) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append(options, jwt.WithValidMethods(k.methods))
+	return jwt.ParseWithClaims(tokenString, claims, k.keyFunc, options...)
+}
+
+func (k *SigningKeys) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("token algorithm does not match its key")
+	}
+	return key.key, nil
+}
+
+// JWKS returns the public verification keys. It is empty for HS256, whose
+// secret cannot be published.
+func (k *SigningKeys) JWKS() jose.JSONWebKeySet {
+	return k.jwks
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", file)
+	}
+	return block, nil
+}
+
+func readPrivateKey(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", file, key)
+	}
+	return signer, nil
+}
+
+// readPublicKey also accepts private keys, so a retired signing key file can
+// be listed as a verification key unchanged.
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return key, nil
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return key, nil
+	}
+
+	private, err := readPrivateKey(file)
+	if err != nil {
+		return nil, err
+	}
+	return private.Public(), nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"stratus/config"
+)
+
+func writeTestKey(t *testing.T, name string, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "user", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func TestSecretRotation(t *testing.T) {
+	oldSecret := strings.Repeat("o", 32)
+	newSecret := strings.Repeat("n", 32)
+
+	old, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "HS256", JWTSecret: oldSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := old.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "HS256", JWTSecret: newSecret, JWTPreviousSecrets: []string{oldSecret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Parse(issued, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("token signed with the previous secret: %v", err)
+	}
+	current, err := rotated.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Parse(current, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("new tokens are still signed with the previous secret")
+	}
+	if jwks := rotated.JWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("JWKS lists %d keys, want none for HS256", len(jwks.Keys))
+	}
+
+	retired, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "HS256", JWTSecret: newSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Parse(issued, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("token signed with a retired secret was accepted")
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	oldFile := writeTestKey(t, "old.pem", newEd25519Key(t))
+	newFile := writeTestKey(t, "new.pem", newRSAKey(t, 2048))
+
+	old, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "EdDSA", JWTSigningKeyFile: oldFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := old.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "RS256", JWTSigningKeyFile: newFile, JWTVerificationKeyFiles: []string{oldFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Parse(issued, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("token signed with the previous key: %v", err)
+	}
+	if jwks := rotated.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("JWKS lists %d keys, want 2", len(jwks.Keys))
+	}
+	current, err := rotated.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Parse(current, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("token signed with the current key: %v", err)
+	}
+
+	retired, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "RS256", JWTSigningKeyFile: newFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Parse(issued, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("token signed with a retired key was accepted")
+	}
+}
+
+func TestSigningKeyConfusion(t *testing.T) {
+	edKey := newEd25519Key(t)
+	rsaKey := newRSAKey(t, 2048)
+	rsaFile := writeTestKey(t, "rsa.pem", rsaKey)
+	keys, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "EdDSA", JWTSigningKeyFile: writeTestKey(t, "ed.pem", edKey), JWTVerificationKeyFiles: []string{rsaFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rsaKeyID string
+	for _, jwk := range keys.JWKS().Keys {
+		if jwk.Algorithm == "RS256" {
+			rsaKeyID = jwk.KeyID
+		}
+	}
+	publicDER, _ := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	forge := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, testClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"EdDSA token naming the RSA key", forge(jwt.SigningMethodEdDSA, rsaKeyID, edKey)},
+		{"HS256 keyed with the RSA public key", forge(jwt.SigningMethodHS256, rsaKeyID, publicPEM)},
+		{"HS256 keyed with the RSA public key and no kid", forge(jwt.SigningMethodHS256, "", publicPEM)},
+		{"unsigned token", forge(jwt.SigningMethodNone, rsaKeyID, jwt.UnsafeAllowNoneSignatureType)},
+		{"unknown kid", forge(jwt.SigningMethodEdDSA, "unknown", edKey)},
+	} {
+		if _, err := keys.Parse(tc.token, &jwt.RegisteredClaims{}); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+}
+
+func TestHS256Keys(t *testing.T) {
+	keys, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "HS256", JWTSecret: "test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwks := keys.JWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("JWKS publishes %d keys for HS256", len(jwks.Keys))
+	}
+
+	signed, err := keys.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("own token: %v", err)
+	}
+
+	other, err := LoadSigningKeys(&config.Config{JWTAlgorithm: "HS256", JWTSecret: "another-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Parse(signed, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("token signed with another secret was accepted")
+	}
+
+	// Without a kid the token matches no key, even with the right secret.
+	unnamed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(unnamed, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("token without a kid was accepted")
+	}
+}
+
+func TestLoadSigningKeysRejectsWeakOrMismatchedKeys(t *testing.T) {
+	edFile := writeTestKey(t, "ed.pem", newEd25519Key(t))
+	weakFile := writeTestKey(t, "weak.pem", newRSAKey(t, 1024))
+
+	for _, tc := range []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{"algorithm mismatch", config.Config{JWTAlgorithm: "RS256", JWTSigningKeyFile: edFile}, "JWT_ALGORITHM"},
+		{"short RSA signing key", config.Config{JWTAlgorithm: "RS256", JWTSigningKeyFile: weakFile}, "2048"},
+		{"short RSA verification key", config.Config{JWTAlgorithm: "EdDSA", JWTSigningKeyFile: edFile, JWTVerificationKeyFiles: []string{weakFile}}, "2048"},
+		{"missing file", config.Config{JWTAlgorithm: "EdDSA", JWTSigningKeyFile: filepath.Join(t.TempDir(), "missing.pem")}, "missing.pem"},
+	} {
+		_, err := LoadSigningKeys(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error mentioning %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+var ErrLDAPAccountConflict = errors.New("a local account already uses this email address")
+
+type ldapEntry struct {
+	DN          string
+	Email       string
+	DisplayName string
+	Groups      []string
+}
+
+// LDAPService authenticates against a directory with a search-then-bind,
+// creates users on first login and periodically syncs them.
+type LDAPService struct {
+	config *config.Config
+}
+
+func NewLDAPService(cfg *config.Config) *LDAPService {
+	return &LDAPService{config: cfg}
+}
+
+func (s *LDAPService) Name() string {
+	return "ldap"
+}
+
+func (s *LDAPService) Enabled() bool {
+	return s.config.LDAPURL != "" && s.config.LDAPBaseDN != ""
+}
+
+func (s *LDAPService) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: s.config.LDAPInsecureSkipVerify}
+	conn, err := ldap.DialURL(s.config.LDAPURL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(10 * time.Second)
+
+	if s.config.LDAPStartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if s.config.LDAPBindDN != "" {
+		err = conn.Bind(s.config.LDAPBindDN, s.config.LDAPBindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("service bind failed: %w", err)
+	}
+	return conn, nil
+}
+
+func (s *LDAPService) Authenticate(login, password string) (*models.User, error) {
+	// An empty password would make the bind below unauthenticated.
+	if !s.Enabled() || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(s.config.LDAPUserFilter, "{login}", ldap.EscapeFilter(login))
+	entries, err := s.search(conn, s.config.LDAPBaseDN, ldap.ScopeWholeSubtree, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	// Group lookups run as the service account, not the user.
+	if s.config.LDAPBindDN != "" {
+		if err := conn.Bind(s.config.LDAPBindDN, s.config.LDAPBindPassword); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.loadGroups(conn, entry); err != nil {
+		return nil, err
+	}
+
+	return s.upsertUser(entry)
+}
+
+func (s *LDAPService) search(conn *ldap.Conn, baseDN string, scope int, filter string) ([]*ldapEntry, error) {
+	attributes := []string{s.config.LDAPEmailAttr, s.config.LDAPNameAttr, s.config.LDAPGroupAttr}
+	request := ldap.NewSearchRequest(baseDN, scope, ldap.NeverDerefAliases, 2, 10, false, filter, attributes, nil)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, nil
+		}
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) && result != nil {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	entries := make([]*ldapEntry, len(result.Entries))
+	for i, e := range result.Entries {
+		entries[i] = &ldapEntry{
+			DN:          e.DN,
+			Email:       strings.ToLower(strings.TrimSpace(e.GetAttributeValue(s.config.LDAPEmailAttr))),
+			DisplayName: e.GetAttributeValue(s.config.LDAPNameAttr),
+			Groups:      e.GetAttributeValues(s.config.LDAPGroupAttr),
+		}
+	}
+	return entries, nil
+}
+
+// loadGroups searches for group entries when the directory has no memberOf
+// attribute, as with a stock OpenLDAP.
+func (s *LDAPService) loadGroups(conn *ldap.Conn, entry *ldapEntry) error {
+	if s.config.LDAPGroupBaseDN == "" {
+		return nil
+	}
+
+	filter := strings.ReplaceAll(s.config.LDAPGroupFilter, "{dn}", ldap.EscapeFilter(entry.DN))
+	request := ldap.NewSearchRequest(s.config.LDAPGroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 10, false, filter, []string{"dn"}, nil)
+	result, err := conn.Search(request)
+	if err != nil {
+		return err
+	}
+	for _, group := range result.Entries {
+		entry.Groups = append(entry.Groups, group.DN)
+	}
+	return nil
+}
+
+func (s *LDAPService) inGroup(entry *ldapEntry, group string) bool {
+	for _, dn := range entry.Groups {
+		if strings.EqualFold(dn, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *LDAPService) attributes(entry *ldapEntry) map[string]interface{} {
+	updates := map[string]interface{}{
+		"email":     entry.Email,
+		"is_active": s.config.LDAPUserGroup == "" || s.inGroup(entry, s.config.LDAPUserGroup),
+	}
+	if entry.DisplayName != "" {
+		updates["display_name"] = entry.DisplayName
+	}
+	return updates
+}
+
+func (s *LDAPService) syncRole(user *models.User, entry *ldapEntry) error {
+	if s.config.LDAPAdminGroup == "" {
+		return nil
+	}
+	return syncDirectoryRole(user, s.inGroup(entry, s.config.LDAPAdminGroup), "LDAP group sync")
+}
+
+func (s *LDAPService) upsertUser(entry *ldapEntry) (*models.User, error) {
+	if entry.Email == "" {
+		return nil, fmt.Errorf("directory entry %s has no %s attribute", entry.DN, s.config.LDAPEmailAttr)
+	}
+
+	var user models.User
+	err := database.DB.Where("auth_source = ? AND external_id = ?", models.AuthSourceLDAP, entry.DN).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var existing models.User
+		if database.DB.Where("email = ?", entry.Email).First(&existing).Error == nil {
+			return nil, ErrLDAPAccountConflict
+		}
+
+		active := s.attributes(entry)["is_active"].(bool)
+		now := time.Now()
+		user = models.User{
+			VerifiedAt:  &now,
+			Email:       entry.Email,
+			DisplayName: entry.DisplayName,
+			IsActive:    active,
+			AuthSource:  models.AuthSourceLDAP,
+			ExternalID:  entry.DN,
+		}
+		if s.config.LDAPAdminGroup != "" && s.inGroup(entry, s.config.LDAPAdminGroup) {
+			user.Role = models.RoleAdmin
+		}
+		if err := CreateUser(&user); err != nil {
+			return nil, err
+		}
+		// A false IsActive is a zero value that the column default
+		// overrides on insert, and the insert reads back into user.
+		if !active {
+			if err := database.DB.Model(&user).Update("is_active", false).Error; err != nil {
+				return nil, err
+			}
+			user.IsActive = false
+		}
+		return &user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.DB.Model(&user).Updates(s.attributes(entry)).Error; err != nil {
+		return nil, err
+	}
+	if err := s.syncRole(&user, entry); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// StartSync periodically refreshes every directory-backed user and
+// deactivates those that were removed from the directory.
+func (s *LDAPService) StartSync() {
+	if !s.Enabled() || s.config.LDAPSyncInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.config.LDAPSyncInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.Sync(); err != nil {
+				slog.Error("LDAP sync failed", "error", err)
+			}
+		}
+	}()
+}
+
+// LDAPSyncResult summarises one sync run.
+type LDAPSyncResult struct {
+	Checked     int
+	Deactivated int
+	Failed      int
+}
+
+// Sync refreshes every directory-backed user. A user whose entry cannot be
+// read or saved is logged and counted as failed without holding up the
+// others; only losing the connection ends the run early.
+func (s *LDAPService) Sync() (LDAPSyncResult, error) {
+	var result LDAPSyncResult
+	conn, err := s.connect()
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+
+	var users []models.User
+	if err := database.DB.Where("auth_source = ?", models.AuthSourceLDAP).Find(&users).Error; err != nil {
+		return result, err
+	}
+
+	for _, user := range users {
+		deactivated, err := s.syncUser(conn, &user)
+		if err != nil {
+			if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+				return result, err
+			}
+			slog.Warn("LDAP sync failed for user", "user", user.Email, "external_id", user.ExternalID, "error", err)
+			result.Failed++
+			continue
+		}
+		result.Checked++
+		if deactivated {
+			result.Deactivated++
+		}
+	}
+
+	slog.Info("LDAP sync completed", "checked", result.Checked, "deactivated", result.Deactivated, "failed", result.Failed)
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d of %d users could not be synced", result.Failed, len(users))
+	}
+	return result, nil
+}
+
+// syncUser refreshes one user from the directory and reports whether it
+// was deactivated.
+func (s *LDAPService) syncUser(conn *ldap.Conn, user *models.User) (bool, error) {
+	entries, err := s.search(conn, user.ExternalID, ldap.ScopeBaseObject, "(objectClass=*)")
+	if err != nil {
+		return false, err
+	}
+
+	if len(entries) == 0 {
+		if !user.IsActive {
+			return false, nil
+		}
+		if err := database.DB.Model(user).Update("is_active", false).Error; err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	entry := entries[0]
+	if err := s.loadGroups(conn, entry); err != nil {
+		return false, err
+	}
+	updates := s.attributes(entry)
+	if entry.Email == "" {
+		delete(updates, "email")
+	}
+	deactivated := updates["is_active"] == false && user.IsActive
+	if err := database.DB.Model(user).Updates(updates).Error; err != nil {
+		return false, err
+	}
+	if err := s.syncRole(user, entry); err != nil {
+		slog.Warn("LDAP sync failed to update role", "user", user.Email, "error", err)
+	}
+	return deactivated, nil
+}
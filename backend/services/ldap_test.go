@@ -0,0 +1,355 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+const (
+	testBindDN       = "cn=stratus,dc=example,dc=com"
+	testBindPassword = "service-secret"
+	testUserGroup    = "cn=staff,ou=groups,dc=example,dc=com"
+)
+
+// fakeDirectory is an LDAP server that answers simple binds and searches,
+// which is all LDAPService uses.
+type fakeDirectory struct {
+	addr string
+
+	mu        sync.Mutex
+	entries   map[string]*fakeEntry
+	failures  map[string]int
+	userBinds int
+}
+
+type fakeEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDirectory{
+		addr: listener.Addr().String(),
+		entries: map[string]*fakeEntry{
+			testBindDN: {password: testBindPassword},
+		},
+		failures: make(map[string]int),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDirectory) addUser(uid, name, password string, groups ...string) string {
+	dn := "uid=" + uid + ",ou=people,dc=example,dc=com"
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[dn] = &fakeEntry{password: password, attributes: map[string][]string{
+		"uid":      {uid},
+		"mail":     {uid + "@example.com"},
+		"cn":       {name},
+		"memberOf": groups,
+	}}
+	return dn
+}
+
+func (d *fakeDirectory) update(dn string, change func(*fakeEntry)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	change(d.entries[dn])
+}
+
+func (d *fakeDirectory) remove(dn string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, dn)
+}
+
+// fail makes every search based at dn end with code, or work again when
+// code is success.
+func (d *fakeDirectory) fail(dn string, code int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if code == ldap.LDAPResultSuccess {
+		delete(d.failures, dn)
+	} else {
+		d.failures[dn] = code
+	}
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := d.bind(op.Children[1].Data.String(), op.Children[2].Data.String())
+			conn.Write(ldapMessage(id, ldapResult(ldap.ApplicationBindResponse, code)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			for _, response := range d.search(op) {
+				conn.Write(ldapMessage(id, response).Bytes())
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) bind(dn, password string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dn == "" {
+		return ldap.LDAPResultSuccess
+	}
+	entry, ok := d.entries[dn]
+	if !ok || password == "" || entry.password != password {
+		return ldap.LDAPResultInvalidCredentials
+	}
+	if dn != testBindDN {
+		d.userBinds++
+	}
+	return ldap.LDAPResultSuccess
+}
+
+// search supports base-object reads and single equality filters, the two
+// kinds of search LDAPService makes.
+func (d *fakeDirectory) search(op *ber.Packet) []*ber.Packet {
+	base := op.Children[0].Data.String()
+	scope := op.Children[1].Value.(int64)
+	filter, err := ldap.DecompileFilter(op.Children[6])
+	if err != nil {
+		return []*ber.Packet{ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if code, ok := d.failures[base]; ok {
+		return []*ber.Packet{ldapResult(ldap.ApplicationSearchResultDone, code)}
+	}
+
+	var responses []*ber.Packet
+	if scope == ldap.ScopeBaseObject {
+		entry, ok := d.entries[base]
+		if !ok {
+			return []*ber.Packet{ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject)}
+		}
+		responses = append(responses, searchEntry(base, entry))
+	} else {
+		attribute, value, _ := strings.Cut(strings.Trim(filter, "()"), "=")
+		for dn, entry := range d.entries {
+			for _, candidate := range entry.attributes[attribute] {
+				if strings.HasSuffix(dn, base) && candidate == value {
+					responses = append(responses, searchEntry(dn, entry))
+					break
+				}
+			}
+		}
+	}
+	return append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+func searchEntry(dn string, entry *fakeEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	op.AppendChild(attributes)
+	return op
+}
+
+func newTestLDAPService(t *testing.T, directory *fakeDirectory) *LDAPService {
+	t.Helper()
+	dbtest.Open(t)
+	return NewLDAPService(&config.Config{
+		LDAPURL:          "ldap://" + directory.addr,
+		LDAPBaseDN:       "dc=example,dc=com",
+		LDAPBindDN:       testBindDN,
+		LDAPBindPassword: testBindPassword,
+		LDAPUserFilter:   "(uid={login})",
+		LDAPEmailAttr:    "mail",
+		LDAPNameAttr:     "cn",
+		LDAPGroupAttr:    "memberOf",
+		LDAPUserGroup:    testUserGroup,
+	})
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	directory := newFakeDirectory(t)
+	s := newTestLDAPService(t, directory)
+	dn := directory.addUser("alice", "Alice", "alice-secret", testUserGroup)
+
+	user, err := s.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.DisplayName != "Alice" || !user.IsActive ||
+		user.AuthSource != models.AuthSourceLDAP || user.ExternalID != dn {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	again, err := s.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Fatalf("second login created user %s, want %s", again.ID, user.ID)
+	}
+
+	for _, attempt := range []struct{ login, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"nobody", "alice-secret"},
+	} {
+		if _, err := s.Authenticate(attempt.login, attempt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q) = %v, want ErrInvalidCredentials", attempt.login, attempt.password, err)
+		}
+	}
+	if directory.userBinds != 2 {
+		t.Errorf("%d user binds, want 2", directory.userBinds)
+	}
+}
+
+func TestLDAPAuthenticateOutsideUserGroup(t *testing.T) {
+	directory := newFakeDirectory(t)
+	s := newTestLDAPService(t, directory)
+	directory.addUser("bob", "Bob", "bob-secret")
+
+	user, err := s.Authenticate("bob", "bob-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored models.User
+	database.DB.First(&stored, "id = ?", user.ID)
+	if user.IsActive || stored.IsActive {
+		t.Fatal("user outside the required group was created active")
+	}
+}
+
+func TestLDAPAuthenticateRejectsLocalAccount(t *testing.T) {
+	directory := newFakeDirectory(t)
+	s := newTestLDAPService(t, directory)
+	directory.addUser("carol", "Carol", "carol-secret", testUserGroup)
+
+	local := models.User{Email: "carol@example.com", IsActive: true}
+	if err := CreateUser(&local); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate("carol", "carol-secret"); !errors.Is(err, ErrLDAPAccountConflict) {
+		t.Fatalf("got %v, want ErrLDAPAccountConflict", err)
+	}
+}
+
+func TestLDAPSync(t *testing.T) {
+	directory := newFakeDirectory(t)
+	s := newTestLDAPService(t, directory)
+
+	users := make(map[string]*models.User)
+	dns := make(map[string]string)
+	for _, uid := range []string{"alice", "bob", "carol", "dave"} {
+		dns[uid] = directory.addUser(uid, strings.ToUpper(uid), uid+"-secret", testUserGroup)
+		user, err := s.Authenticate(uid, uid+"-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[uid] = user
+	}
+
+	directory.update(dns["alice"], func(e *fakeEntry) { e.attributes["cn"] = []string{"Alice Renamed"} })
+	directory.remove(dns["bob"])
+	directory.update(dns["carol"], func(e *fakeEntry) { e.attributes["memberOf"] = nil })
+	directory.fail(dns["dave"], ldap.LDAPResultOther)
+
+	result, err := s.Sync()
+	if err == nil {
+		t.Error("Sync reported no error although one user failed")
+	}
+	if want := (LDAPSyncResult{Checked: 3, Deactivated: 2, Failed: 1}); result != want {
+		t.Errorf("Sync() = %+v, want %+v", result, want)
+	}
+
+	reload := func(uid string) models.User {
+		var user models.User
+		if err := database.DB.First(&user, "id = ?", users[uid].ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	if alice := reload("alice"); !alice.IsActive || alice.DisplayName != "Alice Renamed" {
+		t.Errorf("alice was not refreshed: active=%v name=%q", alice.IsActive, alice.DisplayName)
+	}
+	if reload("bob").IsActive {
+		t.Error("bob was removed from the directory but is still active")
+	}
+	if reload("carol").IsActive {
+		t.Error("carol left the user group but is still active")
+	}
+	if !reload("dave").IsActive {
+		t.Error("dave was deactivated although the entry could not be read")
+	}
+
+	// Once the directory recovers, the next run completes cleanly and does
+	// not count the users deactivated before.
+	directory.fail(dns["dave"], ldap.LDAPResultSuccess)
+	result, err = s.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LDAPSyncResult{Checked: 4}); result != want {
+		t.Errorf("second Sync() = %+v, want %+v", result, want)
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"bytes"
+	"crypto/tls"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"stratus/config"
+)
+
+//go:embed templates/mail/*.tmpl
+var mailTemplates embed.FS
+
+var ErrMailerDisabled = errors.New("mailer is not configured")
+
+// Mailer renders the templates in templates/mail and delivers them over
+// SMTP. Each template file defines "<name>.subject", "<name>.text" and
+// "<name>.html".
+type Mailer struct {
+	config *config.Config
+	text   *texttemplate.Template
+	html   *htmltemplate.Template
+}
+
+func NewMailer(cfg *config.Config) *Mailer {
+	return &Mailer{
+		config: cfg,
+		text:   texttemplate.Must(texttemplate.ParseFS(mailTemplates, "templates/mail/*.tmpl")),
+		html:   htmltemplate.Must(htmltemplate.ParseFS(mailTemplates, "templates/mail/*.tmpl")),
+	}
+}
+
+func (m *Mailer) Enabled() bool {
+	return m.config.SMTPHost != ""
+}
+
+// Send renders template name with data and delivers it to a single recipient.
+func (m *Mailer) Send(to, name string, data map[string]interface{}) error {
+	if !m.Enabled() {
+		return ErrMailerDisabled
+	}
+
+	from, err := mail.ParseAddress(m.config.SMTPFrom)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+
+	message, err := m.render(from, recipient, name, data)
+	if err != nil {
+		return err
+	}
+	return m.deliver(from.Address, recipient.Address, message)
+}
+
+func (m *Mailer) render(from, to *mail.Address, name string, data map[string]interface{}) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+	if err := m.text.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return nil, err
+	}
+	if err := m.text.ExecuteTemplate(&text, name+".text", data); err != nil {
+		return nil, err
+	}
+	if err := m.html.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	parts.Close()
+
+	var message bytes.Buffer
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + to.String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + from.Address + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + parts.Boundary(),
+	}
+	message.WriteString(strings.Join(headers, "\r\n"))
+	message.WriteString("\r\n\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// deliver speaks SMTP directly so implicit TLS, STARTTLS and plain
+// connections (as used by local test servers) are all supported.
+func (m *Mailer) deliver(from, to string, message []byte) error {
+	host := m.config.SMTPHost
+	addr := net.JoinHostPort(host, strconv.Itoa(m.config.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: host}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if m.config.SMTPTLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.config.SMTPTLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if m.config.SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.config.SMTPUsername, m.config.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// SendAsync delivers in the background, so request latency does not reveal
+// whether a message was sent.
+func (m *Mailer) SendAsync(to, name string, data map[string]interface{}) {
+	go func() {
+		if err := m.Send(to, name, data); err != nil {
+			slog.Error("Failed to send email", "template", name, "error", err)
+		}
+	}()
+}
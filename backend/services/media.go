@@ -0,0 +1,269 @@
+package services
+
+import (
+	"errors"
+	"image"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abema/go-mp4"
+	"github.com/google/uuid"
+	"github.com/rwcarlsen/goexif/exif"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+type MetadataService struct {
+	config *config.Config
+	queue  *jobQueue
+}
+
+func NewMetadataService(cfg *config.Config) *MetadataService {
+	s := &MetadataService{config: cfg}
+	s.queue = newJobQueue("Metadata extraction", 1024, s.ExtractFile)
+	return s
+}
+
+func (s *MetadataService) Start() {
+	s.queue.start(s.config.MetadataWorkers)
+	go s.enqueueStale()
+}
+
+func (s *MetadataService) enqueueStale() {
+	var fileIDs []uuid.UUID
+	database.DB.Model(&models.File{}).
+		Joins("LEFT JOIN media_metadata ON media_metadata.file_id = files.id").
+		Where("files.is_directory = false").
+		Where("files.mime_type LIKE 'image/%' OR files.mime_type LIKE 'audio/%' OR files.mime_type LIKE 'video/%'").
+		Where("media_metadata.file_id IS NULL OR media_metadata.checksum <> files.checksum").
+		Pluck("files.id", &fileIDs)
+
+	s.queue.enqueueAll(fileIDs)
+}
+
+func (s *MetadataService) Enqueue(fileID uuid.UUID) {
+	s.queue.enqueue(fileID)
+}
+
+func (s *MetadataService) QueueDepth() int {
+	return s.queue.depth()
+}
+
+func mediaKind(mimeType string) (models.MediaKind, bool) {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return models.MediaImage, true
+	case strings.HasPrefix(mimeType, "audio/"):
+		return models.MediaAudio, true
+	case strings.HasPrefix(mimeType, "video/"):
+		return models.MediaVideo, true
+	}
+	return "", false
+}
+
+func (s *MetadataService) ExtractFile(fileID uuid.UUID) error {
+	var file models.File
+	if err := database.DB.Where("id = ? AND is_directory = false", fileID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.RemoveFile(fileID)
+		}
+		return err
+	}
+
+	kind, ok := mediaKind(file.MimeType)
+	if !ok {
+		return s.RemoveFile(fileID)
+	}
+
+	meta := models.MediaMetadata{
+		FileID:      file.ID,
+		OwnerID:     file.OwnerID,
+		Kind:        kind,
+		Checksum:    file.Checksum,
+		ExtractedAt: time.Now(),
+	}
+
+	in, err := os.Open(file.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	switch kind {
+	case models.MediaImage:
+		extractImageMetadata(in, &meta)
+	case models.MediaAudio:
+		if strings.Contains(file.MimeType, "mp4") || strings.HasSuffix(strings.ToLower(file.Name), ".m4a") {
+			extractMP4Metadata(in, &meta)
+		} else if tags, ok := readID3(in); ok {
+			meta.Title = tags.Title
+			meta.Artist = tags.Artist
+			meta.Album = tags.Album
+			meta.Genre = tags.Genre
+			meta.Year = tags.Year
+			meta.TrackNumber = tags.Track
+			meta.DurationMs = tags.Duration
+		}
+	case models.MediaVideo:
+		if file.MimeType == "video/mp4" || file.MimeType == "video/quicktime" || file.MimeType == "video/x-m4v" {
+			extractMP4Metadata(in, &meta)
+		}
+	}
+
+	return database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&meta).Error
+}
+
+func extractImageMetadata(in *os.File, meta *models.MediaMetadata) {
+	if cfg, _, err := image.DecodeConfig(in); err == nil {
+		meta.Width = cfg.Width
+		meta.Height = cfg.Height
+	}
+
+	if _, err := in.Seek(0, 0); err != nil {
+		return
+	}
+	x, err := exif.Decode(in)
+	if err != nil {
+		return
+	}
+
+	if t, err := x.DateTime(); err == nil && !t.IsZero() {
+		meta.CapturedAt = &t
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		meta.Latitude = &lat
+		meta.Longitude = &long
+	}
+	meta.CameraMake = exifString(x, exif.Make)
+	meta.CameraModel = exifString(x, exif.Model)
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		meta.Orientation, _ = tag.Int(0)
+	}
+	if meta.Width == 0 {
+		meta.Width = exifInt(x, exif.PixelXDimension)
+		meta.Height = exifInt(x, exif.PixelYDimension)
+	}
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	value, _ := tag.Int(0)
+	return value
+}
+
+func extractMP4Metadata(in *os.File, meta *models.MediaMetadata) {
+	info, err := mp4.Probe(in)
+	if err != nil {
+		return
+	}
+
+	if info.Timescale > 0 {
+		meta.DurationMs = int64(info.Duration * 1000 / uint64(info.Timescale))
+	}
+
+	for _, track := range info.Tracks {
+		switch track.Codec {
+		case mp4.CodecAVC1:
+			meta.VideoCodec = "h264"
+			if track.AVC != nil {
+				meta.Width = int(track.AVC.Width)
+				meta.Height = int(track.AVC.Height)
+			}
+		case mp4.CodecMP4A:
+			meta.AudioCodec = "aac"
+		}
+	}
+}
+
+func (s *MetadataService) RemoveFile(fileID uuid.UUID) error {
+	return database.DB.Where("file_id = ?", fileID).Delete(&models.MediaMetadata{}).Error
+}
+
+type TimelineGroup struct {
+	Date  string        `json:"date"`
+	Count int           `json:"count"`
+	Files []models.File `json:"files"`
+}
+
+// Timeline groups the user's photos by capture day, newest first. Photos
+// without EXIF dates fall back to their upload time. Pages are keyed on
+// that effective date and the file ID; the returned cursor, empty on the
+// last page, starts the next one.
+func (s *MetadataService) Timeline(userID uuid.UUID, cursor string, limit int) ([]TimelineGroup, string, error) {
+	type row struct {
+		models.File
+		TakenAt time.Time
+	}
+
+	query := database.DB.Table("files").
+		Select("files.*, COALESCE(media_metadata.captured_at, files.created_at) AS taken_at").
+		Joins("JOIN media_metadata ON media_metadata.file_id = files.id").
+		Scopes(VisibleFiles(userID)).
+		Where("media_metadata.kind = ?", models.MediaImage)
+	if cursor != "" {
+		takenAt, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("(COALESCE(media_metadata.captured_at, files.created_at), files.id) < (?, ?)", takenAt, id)
+	}
+
+	var rows []row
+	if err := query.Order("taken_at DESC, files.id DESC").Limit(limit + 1).Scan(&rows).Error; err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[limit-1]
+		next = EncodeCursor(last.TakenAt, last.ID)
+	}
+
+	fileIDs := make([]uuid.UUID, len(rows))
+	for i, r := range rows {
+		fileIDs[i] = r.ID
+	}
+	var metadata []models.MediaMetadata
+	database.DB.Where("file_id IN ?", fileIDs).Find(&metadata)
+	byFile := make(map[uuid.UUID]*models.MediaMetadata, len(metadata))
+	for i := range metadata {
+		byFile[metadata[i].FileID] = &metadata[i]
+	}
+
+	groups := []TimelineGroup{}
+	for _, r := range rows {
+		file := r.File
+		file.Metadata = byFile[file.ID]
+
+		date := r.TakenAt.Format("2006-01-02")
+		if len(groups) == 0 || groups[len(groups)-1].Date != date {
+			groups = append(groups, TimelineGroup{Date: date})
+		}
+		group := &groups[len(groups)-1]
+		group.Files = append(group.Files, file)
+		group.Count++
+	}
+
+	return groups, next, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"stratus/models"
+)
+
+func openTestFile(t *testing.T, name string, data []byte) *os.File {
+	t.Helper()
+	in, err := os.Open(writeTestFile(t, name, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { in.Close() })
+	return in
+}
+
+// jpegWithAPP1 inserts an APP1 segment after the SOI marker of a small JPEG.
+func jpegWithAPP1(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 6, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	data := append([]byte{0xFF, 0xD8}, segment...)
+	data = append(data, payload...)
+	return append(data, buf.Bytes()[2:]...)
+}
+
+func TestExtractImageMetadataMalformedExif(t *testing.T) {
+	for name, payload := range map[string][]byte{
+		"truncated header": []byte("Exif\x00\x00MM"),
+		"bad byte order":   []byte("Exif\x00\x00XX\x00\x2a\x00\x00\x00\x08"),
+		"ifd past the end": []byte("Exif\x00\x00MM\x00\x2a\x7f\xff\xff\xff"),
+		"huge entry count": []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\xff\xff\x00\x01"),
+	} {
+		var meta models.MediaMetadata
+		extractImageMetadata(openTestFile(t, "photo.jpg", jpegWithAPP1(t, payload)), &meta)
+		if meta.Width != 6 || meta.Height != 4 {
+			t.Errorf("%s: dimensions %dx%d, want 6x4", name, meta.Width, meta.Height)
+		}
+		if meta.CapturedAt != nil || meta.CameraMake != "" {
+			t.Errorf("%s: metadata read from a corrupt EXIF block: %+v", name, meta)
+		}
+	}
+
+	var meta models.MediaMetadata
+	extractImageMetadata(openTestFile(t, "photo.jpg", []byte("not an image")), &meta)
+	if meta.Width != 0 {
+		t.Errorf("garbage file has width %d", meta.Width)
+	}
+}
+
+func mp4Box(kind string, body []byte) []byte {
+	box := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(box, uint32(8+len(body)))
+	copy(box[4:], kind)
+	return append(box, body...)
+}
+
+func TestExtractMP4MetadataMalformed(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isom"))
+	oversized := mp4Box("moov", mp4Box("mvhd", make([]byte, 16)))
+	binary.BigEndian.PutUint32(oversized, 1<<30)
+
+	for name, data := range map[string][]byte{
+		"empty":             nil,
+		"truncated box":     ftyp[:6],
+		"box past the end":  append(bytes.Clone(ftyp), oversized...),
+		"truncated mvhd":    append(bytes.Clone(ftyp), mp4Box("moov", mp4Box("mvhd", []byte{0, 0}))...),
+		"zero-length trak":  append(bytes.Clone(ftyp), mp4Box("moov", mp4Box("trak", nil))...),
+		"not an mp4 at all": []byte("ID3\x03\x00\x00\x00\x00\x00\x00"),
+	} {
+		var meta models.MediaMetadata
+		extractMP4Metadata(openTestFile(t, "clip.mp4", data), &meta)
+		if meta.Width != 0 || meta.VideoCodec != "" {
+			t.Errorf("%s: metadata read from a corrupt file: %+v", name, meta)
+		}
+	}
+}
+
+func TestMediaKind(t *testing.T) {
+	for mimeType, want := range map[string]models.MediaKind{
+		"image/png":  models.MediaImage,
+		"audio/mpeg": models.MediaAudio,
+		"video/mp4":  models.MediaVideo,
+	} {
+		if got, ok := mediaKind(mimeType); !ok || got != want {
+			t.Errorf("mediaKind(%q) = %q, %v", mimeType, got, ok)
+		}
+	}
+	if _, ok := mediaKind("application/pdf"); ok {
+		t.Error("application/pdf has a media kind")
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+// storageStatsTTL keeps frequent scrapes from counting every file each time.
+const storageStatsTTL = time.Minute
+
+var (
+	usersDesc     = prometheus.NewDesc("stratus_users", "Accounts by state, not counting service accounts.", []string{"state"}, nil)
+	filesDesc     = prometheus.NewDesc("stratus_files", "Stored files by state.", []string{"state"}, nil)
+	bytesDesc     = prometheus.NewDesc("stratus_file_bytes", "Bytes of current file content by state, without versions.", []string{"state"}, nil)
+	usedDesc      = prometheus.NewDesc("stratus_storage_used_bytes", "Storage used by all accounts, including versions.", nil, nil)
+	quotaDesc     = prometheus.NewDesc("stratus_storage_quota_bytes", "Sum of all account quotas.", nil, nil)
+	deletionsDesc = prometheus.NewDesc("stratus_user_deletions_pending", "Account deletions that are scheduled or running.", nil, nil)
+)
+
+type storageStats struct {
+	ActiveUsers   int64
+	InactiveUsers int64
+	UsedBytes     int64
+	QuotaBytes    int64
+	LiveFiles     int64
+	LiveBytes     int64
+	TrashedFiles  int64
+	TrashedBytes  int64
+	Deletions     int64
+}
+
+// StorageCollector exports totals for this instance's accounts and files,
+// read from the database at most once per storageStatsTTL.
+type StorageCollector struct {
+	mu        sync.Mutex
+	stats     storageStats
+	fetchedAt time.Time
+}
+
+func NewStorageCollector() *StorageCollector {
+	return &StorageCollector{}
+}
+
+func (s *StorageCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{usersDesc, filesDesc, bytesDesc, usedDesc, quotaDesc, deletionsDesc} {
+		ch <- desc
+	}
+}
+
+func (s *StorageCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	if time.Since(s.fetchedAt) > storageStatsTTL {
+		if stats, err := fetchStorageStats(); err != nil {
+			slog.Error("Failed to collect storage metrics", "error", err)
+		} else {
+			s.stats = stats
+			s.fetchedAt = time.Now()
+		}
+	}
+	stats := s.stats
+	s.mu.Unlock()
+
+	gauge := func(desc *prometheus.Desc, value int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), labels...)
+	}
+	gauge(usersDesc, stats.ActiveUsers, "active")
+	gauge(usersDesc, stats.InactiveUsers, "inactive")
+	gauge(filesDesc, stats.LiveFiles, "live")
+	gauge(filesDesc, stats.TrashedFiles, "trashed")
+	gauge(bytesDesc, stats.LiveBytes, "live")
+	gauge(bytesDesc, stats.TrashedBytes, "trashed")
+	gauge(usedDesc, stats.UsedBytes)
+	gauge(quotaDesc, stats.QuotaBytes)
+	gauge(deletionsDesc, stats.Deletions)
+}
+
+func fetchStorageStats() (storageStats, error) {
+	var stats storageStats
+	err := database.DB.Model(&models.User{}).
+		Where("is_service = false").
+		Select(`COUNT(*) FILTER (WHERE is_active) AS active_users,
+			COUNT(*) FILTER (WHERE NOT is_active) AS inactive_users,
+			COALESCE(SUM(used_space), 0) AS used_bytes,
+			COALESCE(SUM(quota), 0) AS quota_bytes`).
+		Scan(&stats).Error
+	if err != nil {
+		return stats, err
+	}
+
+	var files struct {
+		LiveFiles    int64
+		LiveBytes    int64
+		TrashedFiles int64
+		TrashedBytes int64
+	}
+	err = database.DB.Model(&models.File{}).
+		Where("is_directory = false").
+		Select(`COUNT(*) FILTER (WHERE NOT is_trashed) AS live_files,
+			COALESCE(SUM(size) FILTER (WHERE NOT is_trashed), 0) AS live_bytes,
+			COUNT(*) FILTER (WHERE is_trashed) AS trashed_files,
+			COALESCE(SUM(size) FILTER (WHERE is_trashed), 0) AS trashed_bytes`).
+		Scan(&files).Error
+	if err != nil {
+		return stats, err
+	}
+	stats.LiveFiles, stats.LiveBytes = files.LiveFiles, files.LiveBytes
+	stats.TrashedFiles, stats.TrashedBytes = files.TrashedFiles, files.TrashedBytes
+
+	err = database.DB.Model(&models.UserDeletion{}).Where("status IN ?", pendingDeletionStatuses).Count(&stats.Deletions).Error
+	return stats, err
+}
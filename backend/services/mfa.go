@@ -0,0 +1,153 @@
+package services
+
+import (
+	"crypto/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+const recoveryCodeCount = 10
+
+type MFAService struct {
+	config *config.Config
+}
+
+func NewMFAService(cfg *config.Config) *MFAService {
+	return &MFAService{config: cfg}
+}
+
+// BeginEnrollment stores a fresh pending secret. It only takes effect once
+// Enable confirms the user's authenticator produces matching codes.
+func (s *MFAService) BeginEnrollment(user *models.User) (string, string, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := database.DB.Model(user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return "", "", err
+	}
+
+	return secret, TOTPProvisioningURI(s.config.MFAIssuer, user.Email, secret), nil
+}
+
+// VerifyCode accepts a TOTP code at most once.
+func (s *MFAService) VerifyCode(user *models.User, code string) bool {
+	if user.TOTPSecret == "" {
+		return false
+	}
+
+	step, ok := ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false
+	}
+
+	result := database.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+// Verify accepts either a TOTP code or an unused recovery code.
+func (s *MFAService) Verify(user *models.User, code, recoveryCode string) bool {
+	if code != "" {
+		return s.VerifyCode(user, code)
+	}
+	if recoveryCode != "" {
+		return s.UseRecoveryCode(user.ID, recoveryCode)
+	}
+	return false
+}
+
+func (s *MFAService) Enable(user *models.User) ([]string, error) {
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+func (s *MFAService) Disable(user *models.User) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func (s *MFAService) RegenerateRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+func (s *MFAService) RemainingRecoveryCodes(userID uuid.UUID) int64 {
+	var count int64
+	database.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count
+}
+
+func (s *MFAService) UseRecoveryCode(userID uuid.UUID, code string) bool {
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func (s *MFAService) replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	// Each code is ten base32 characters, 50 bits, shown in two groups.
+	codes := make([]string, recoveryCodeCount)
+	raw := make([]byte, 7)
+	for i := range codes {
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+
+		record := models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: HashToken(normalizeRecoveryCode(codes[i])),
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+// The SHA-1 test vector from RFC 6238, truncated to six digits.
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTP(t *testing.T) {
+	at := time.Unix(59, 0)
+
+	step, ok := ValidateTOTP(rfcTOTPSecret, "287082", at)
+	if !ok || step != 1 {
+		t.Fatalf("ValidateTOTP() = %d, %v; want step 1", step, ok)
+	}
+	if _, ok := ValidateTOTP(strings.ToLower(rfcTOTPSecret), " 287 082 ", at); !ok {
+		t.Error("lower-case secret or spaced code was rejected")
+	}
+	if _, ok := ValidateTOTP(rfcTOTPSecret, "287082", at.Add(totpPeriod*time.Second)); !ok {
+		t.Error("code from the previous step was rejected")
+	}
+	if _, ok := ValidateTOTP(rfcTOTPSecret, "287082", at.Add(3*totpPeriod*time.Second)); ok {
+		t.Error("code three steps old was accepted")
+	}
+	for _, code := range []string{"", "28708", "2870820", "287083"} {
+		if _, ok := ValidateTOTP(rfcTOTPSecret, code, at); ok {
+			t.Errorf("code %q was accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", at); ok {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func newTestMFAUser(t *testing.T) (*MFAService, *models.User) {
+	t.Helper()
+	dbtest.Open(t)
+	s := NewMFAService(&config.Config{MFAIssuer: "Stratus"})
+	user := createTestUser(t, "user@example.com")
+	if _, _, err := s.BeginEnrollment(user); err != nil {
+		t.Fatal(err)
+	}
+	database.DB.First(user, "id = ?", user.ID)
+	return s, user
+}
+
+func currentTOTP(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/totpPeriod)
+}
+
+func TestVerifyCodeRejectsReplay(t *testing.T) {
+	s, user := newTestMFAUser(t)
+	code := currentTOTP(t, user.TOTPSecret)
+
+	if !s.VerifyCode(user, code) {
+		t.Fatal("valid code was rejected")
+	}
+	if s.VerifyCode(user, code) {
+		t.Error("the same code was accepted twice")
+	}
+
+	// A second copy of the user loaded before the first login still sees
+	// the old step; the conditional update must catch the replay.
+	var stale models.User
+	database.DB.First(&stale, "id = ?", user.ID)
+	stale.TOTPLastStep = 0
+	if s.VerifyCode(&stale, code) {
+		t.Error("replay through a stale user row was accepted")
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	s, user := newTestMFAUser(t)
+
+	codes, err := s.Enable(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) || seen[code] {
+			t.Errorf("bad or duplicate recovery code %q", code)
+		}
+		seen[code] = true
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("%d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	// Codes are accepted regardless of case, spacing and the dash.
+	typed := " " + strings.ToUpper(strings.Replace(codes[0], "-", "", 1)) + " "
+	if !s.Verify(user, "", typed) {
+		t.Fatal("recovery code was rejected")
+	}
+	if s.Verify(user, "", codes[0]) {
+		t.Error("recovery code was accepted twice")
+	}
+	if n := s.RemainingRecoveryCodes(user.ID); n != recoveryCodeCount-1 {
+		t.Errorf("%d codes remaining, want %d", n, recoveryCodeCount-1)
+	}
+
+	if _, err := s.RegenerateRecoveryCodes(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if s.UseRecoveryCode(user.ID, codes[1]) {
+		t.Error("code from before regeneration was accepted")
+	}
+
+	other := createTestUser(t, "other@example.com")
+	if _, err := s.Enable(other); err != nil {
+		t.Fatal(err)
+	}
+	if s.UseRecoveryCode(other.ID, codes[2]) {
+		t.Error("another user's recovery code was accepted")
+	}
+}
+
+func TestDisableClearsMFA(t *testing.T) {
+	s, user := newTestMFAUser(t)
+	codes, _ := s.Enable(user)
+
+	if err := s.Disable(user); err != nil {
+		t.Fatal(err)
+	}
+	var reloaded models.User
+	database.DB.First(&reloaded, "id = ?", user.ID)
+	if reloaded.TOTPEnabled || reloaded.TOTPSecret != "" {
+		t.Errorf("user after Disable: enabled %v, secret %q", reloaded.TOTPEnabled, reloaded.TOTPSecret)
+	}
+	if s.VerifyCode(&reloaded, currentTOTP(t, user.TOTPSecret)) || s.UseRecoveryCode(user.ID, codes[0]) {
+		t.Error("second factor still accepted after Disable")
+	}
+}
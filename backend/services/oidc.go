@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+// OIDCFlowTTL is how long a started SSO login may take to come back.
+const OIDCFlowTTL = 10 * time.Minute
+
+var (
+	ErrOIDCDisabled      = errors.New("OIDC login is not configured")
+	ErrOIDCInvalidState  = errors.New("invalid or expired OIDC state")
+	ErrOIDCMissingEmail  = errors.New("identity provider did not return an email address")
+	ErrOIDCUnverified    = errors.New("email address is not verified by the identity provider")
+	ErrOIDCNoAccount     = errors.New("no account exists for this identity")
+	ErrOIDCAccountLinked = errors.New("account is linked to a different identity")
+	ErrOIDCUserDisabled  = errors.New("account is disabled")
+)
+
+type oidcFlow struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// oidcHandoff carries a finished login to the frontend. Accounts with 2FA
+// get no session yet; they must still pass the second-factor step.
+type oidcHandoff struct {
+	userID    uuid.UUID
+	pair      *TokenPair
+	expiresAt time.Time
+}
+
+// OIDCService implements the authorization code flow with PKCE. Pending
+// flows and login handoffs live in memory and expire after a few minutes.
+type OIDCService struct {
+	config *config.Config
+	tokens *TokenService
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	flows    map[string]oidcFlow
+	handoffs map[string]oidcHandoff
+}
+
+func NewOIDCService(cfg *config.Config, tokens *TokenService) *OIDCService {
+	return &OIDCService{
+		config:   cfg,
+		tokens:   tokens,
+		flows:    make(map[string]oidcFlow),
+		handoffs: make(map[string]oidcHandoff),
+	}
+}
+
+func (s *OIDCService) Enabled() bool {
+	return s.config.OIDCIssuer != "" && s.config.OIDCClientID != ""
+}
+
+// discover fetches the provider metadata on first use, so an unreachable
+// identity provider does not prevent the server from starting.
+func (s *OIDCService) discover(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	if !s.Enabled() {
+		return nil, nil, ErrOIDCDisabled
+	}
+
+	s.mu.Lock()
+	provider := s.provider
+	s.mu.Unlock()
+
+	if provider == nil {
+		var err error
+		provider, err = oidc.NewProvider(ctx, s.config.OIDCIssuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("OIDC discovery failed: %w", err)
+		}
+		s.mu.Lock()
+		s.provider = provider
+		s.mu.Unlock()
+	}
+
+	oauthConfig := &oauth2.Config{
+		ClientID:     s.config.OIDCClientID,
+		ClientSecret: s.config.OIDCClientSecret,
+		RedirectURL:  s.config.OIDCRedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       s.config.OIDCScopes,
+	}
+	return provider, oauthConfig, nil
+}
+
+// AuthURL starts a login and returns the provider URL to redirect to along
+// with the flow's state, which the caller must bind to the browser so that
+// Callback only completes logins that browser started.
+func (s *OIDCService) AuthURL(ctx context.Context) (authURL, state string, err error) {
+	_, oauthConfig, err := s.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = RandomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := RandomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	s.mu.Lock()
+	s.pruneLocked()
+	s.flows[state] = oidcFlow{nonce: nonce, verifier: verifier, expiresAt: time.Now().Add(OIDCFlowTTL)}
+	s.mu.Unlock()
+
+	return oauthConfig.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), state, nil
+}
+
+// Callback completes a login and returns a one-time handoff code for the
+// frontend to exchange. boundState is the state stored in the browser by
+// the caller of AuthURL; a callback arriving in any other browser is
+// rejected, so nobody can be signed in to an account they did not choose.
+// A session is only created for users without 2FA.
+func (s *OIDCService) Callback(ctx context.Context, state, boundState, code, ipAddress, userAgent string) (string, error) {
+	provider, oauthConfig, err := s.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	flow, ok := s.flows[state]
+	delete(s.flows, state)
+	s.mu.Unlock()
+	if !ok || time.Now().After(flow.expiresAt) || subtle.ConstantTimeCompare([]byte(state), []byte(boundState)) != 1 {
+		return "", ErrOIDCInvalidState
+	}
+
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(flow.verifier))
+	if err != nil {
+		return "", fmt.Errorf("code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("token response did not include an id_token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: s.config.OIDCClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", fmt.Errorf("invalid id_token: %w", err)
+	}
+	if idToken.Nonce != flow.nonce {
+		return "", errors.New("id_token nonce mismatch")
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", err
+	}
+	if _, ok := claims[s.config.OIDCEmailClaim]; !ok {
+		if info, err := provider.UserInfo(ctx, oauthConfig.TokenSource(ctx, token)); err == nil {
+			info.Claims(&claims)
+		}
+	}
+
+	user, err := s.resolveUser(idToken.Subject, claims)
+	if err != nil {
+		return "", err
+	}
+	if !user.IsActive {
+		return "", ErrOIDCUserDisabled
+	}
+
+	// An identity linked to an account by email must not skip the account's
+	// second factor.
+	var pair *TokenPair
+	if !user.TOTPEnabled {
+		pair, err = s.tokens.CreateSession(user.ID, ipAddress, userAgent)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	handoff, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.handoffs[handoff] = oidcHandoff{userID: user.ID, pair: pair, expiresAt: time.Now().Add(time.Minute)}
+	s.mu.Unlock()
+
+	return handoff, nil
+}
+
+// Exchange redeems a handoff code for the session created by Callback. The
+// pair is nil when the user still has to pass the second-factor step.
+func (s *OIDCService) Exchange(code string) (*TokenPair, *models.User, error) {
+	s.mu.Lock()
+	handoff, ok := s.handoffs[code]
+	delete(s.handoffs, code)
+	s.mu.Unlock()
+	if !ok || time.Now().After(handoff.expiresAt) {
+		return nil, nil, ErrOIDCInvalidState
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", handoff.userID).Error; err != nil {
+		return nil, nil, err
+	}
+	return handoff.pair, &user, nil
+}
+
+// resolveUser finds the account for an identity: first by subject, then by
+// verified email (linking the subject), and finally by provisioning a new
+// user. Profile claims are synced on every login.
+func (s *OIDCService) resolveUser(subject string, claims map[string]interface{}) (*models.User, error) {
+	email := strings.ToLower(claimString(claims, s.config.OIDCEmailClaim))
+	displayName := claimString(claims, s.config.OIDCNameClaim)
+	verified, _ := claims["email_verified"].(bool)
+
+	var user models.User
+	err := database.DB.Where("oidc_subject = ?", subject).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if email == "" {
+			return nil, ErrOIDCMissingEmail
+		}
+
+		err = database.DB.Where("LOWER(email) = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			if !verified {
+				return nil, ErrOIDCUnverified
+			}
+			if user.OIDCSubject != nil {
+				return nil, ErrOIDCAccountLinked
+			}
+			if err := database.DB.Model(&user).Update("oidc_subject", subject).Error; err != nil {
+				return nil, err
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if !s.config.OIDCAutoProvision {
+				return nil, ErrOIDCNoAccount
+			}
+			user = models.User{
+				Email:       email,
+				VerifiedAt:  verifiedAt(verified),
+				DisplayName: displayName,
+				OIDCSubject: &subject,
+				IsActive:    true,
+				AuthSource:  models.AuthSourceOIDC,
+			}
+			if s.isAdmin(claims) {
+				user.Role = models.RoleAdmin
+			}
+			if err := CreateUser(&user); err != nil {
+				return nil, err
+			}
+			return &user, nil
+		default:
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if displayName != "" && displayName != user.DisplayName {
+		updates["display_name"] = displayName
+	}
+	if len(updates) > 0 {
+		if err := database.DB.Model(&user).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	if s.config.OIDCAdminGroup != "" {
+		if err := syncDirectoryRole(&user, s.isAdmin(claims), "OIDC group claim"); err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (s *OIDCService) isAdmin(claims map[string]interface{}) bool {
+	if s.config.OIDCAdminGroup == "" {
+		return false
+	}
+	switch groups := claims[s.config.OIDCGroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if group == s.config.OIDCAdminGroup {
+				return true
+			}
+		}
+	case string:
+		for _, group := range strings.Fields(strings.ReplaceAll(groups, ",", " ")) {
+			if group == s.config.OIDCAdminGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *OIDCService) pruneLocked() {
+	now := time.Now()
+	for state, flow := range s.flows {
+		if now.After(flow.expiresAt) {
+			delete(s.flows, state)
+		}
+	}
+	for code, handoff := range s.handoffs {
+		if now.After(handoff.expiresAt) {
+			delete(s.handoffs, code)
+		}
+	}
+}
+
+func verifiedAt(verified bool) *time.Time {
+	if !verified {
+		return nil
+	}
+	now := time.Now()
+	return &now
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return strings.TrimSpace(value)
+}
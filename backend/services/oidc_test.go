@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+// fakeProvider is an OpenID provider that issues an authorization code for
+// whatever claims a test asks for.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key, grants: make(map[string]fakeGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize stands in for the user signing in at the provider after being
+// sent to authURL, and returns the code the provider redirects back with.
+// The nonce of the request is echoed unless claims set one.
+func (p *fakeProvider) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = query.Get("nonce")
+	}
+
+	code, err := RandomToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.grants[code] = fakeGrant{claims: claims, challenge: query.Get("code_challenge")}
+	p.mu.Unlock()
+	return code
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	grant, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": p.server.URL,
+		"aud": "stratus",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	}
+	for name, value := range grant.claims {
+		claims[name] = value
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func newTestOIDCService(t *testing.T, provider *fakeProvider) *OIDCService {
+	t.Helper()
+	dbtest.Open(t)
+	cfg := &config.Config{
+		OIDCIssuer:        provider.server.URL,
+		OIDCClientID:      "stratus",
+		OIDCClientSecret:  "secret",
+		OIDCRedirectURL:   "http://localhost/api/auth/oidc/callback",
+		OIDCScopes:        []string{"openid", "email", "profile"},
+		OIDCEmailClaim:    "email",
+		OIDCNameClaim:     "name",
+		OIDCGroupsClaim:   "groups",
+		OIDCAdminGroup:    "stratus-admins",
+		OIDCAutoProvision: true,
+		JWTAlgorithm:      "HS256",
+		JWTSecret:         "test-secret",
+		AccessTTL:         time.Minute,
+		RefreshTTL:        time.Hour,
+	}
+	return NewOIDCService(cfg, mustTokenService(t, cfg))
+}
+
+// login runs one flow for claims in the browser holding boundState; an
+// empty boundState stands for the browser that started the flow.
+func login(t *testing.T, s *OIDCService, provider *fakeProvider, boundState string, claims jwt.MapClaims) (string, error) {
+	t.Helper()
+	authURL, state, err := s.AuthURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boundState == "" {
+		boundState = state
+	}
+	code := provider.authorize(t, authURL, claims)
+	return s.Callback(context.Background(), state, boundState, code, "192.0.2.1", "test")
+}
+
+func TestOIDCCallbackRequiresStateOfStartingBrowser(t *testing.T) {
+	provider := newFakeProvider(t)
+	s := newTestOIDCService(t, provider)
+
+	authURL, state, err := s.AuthURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := provider.authorize(t, authURL, jwt.MapClaims{"sub": "attacker", "email": "attacker@example.com", "email_verified": true})
+
+	if _, err := s.Callback(context.Background(), state, "another-browser", code, "192.0.2.1", "test"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("callback with foreign state cookie: got %v, want ErrOIDCInvalidState", err)
+	}
+	// The failed attempt used the flow up; it cannot be completed later.
+	if _, err := s.Callback(context.Background(), state, state, code, "192.0.2.1", "test"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("reused state: got %v, want ErrOIDCInvalidState", err)
+	}
+
+	var users int64
+	database.DB.Model(&models.User{}).Count(&users)
+	if users != 0 {
+		t.Fatalf("%d users provisioned by rejected callbacks", users)
+	}
+}
+
+func TestOIDCCallbackRejectsReplayedState(t *testing.T) {
+	provider := newFakeProvider(t)
+	s := newTestOIDCService(t, provider)
+
+	authURL, state, err := s.AuthURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+	code := provider.authorize(t, authURL, claims)
+	if _, err := s.Callback(context.Background(), state, state, code, "192.0.2.1", "test"); err != nil {
+		t.Fatalf("first callback: %v", err)
+	}
+
+	code = provider.authorize(t, authURL, claims)
+	if _, err := s.Callback(context.Background(), state, state, code, "192.0.2.1", "test"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("replayed callback: got %v, want ErrOIDCInvalidState", err)
+	}
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	provider := newFakeProvider(t)
+	s := newTestOIDCService(t, provider)
+
+	_, err := login(t, s, provider, "", jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true, "nonce": "forged"})
+	if err == nil {
+		t.Fatal("callback accepted an id_token with another flow's nonce")
+	}
+
+	var users int64
+	database.DB.Model(&models.User{}).Count(&users)
+	if users != 0 {
+		t.Fatalf("%d users provisioned despite the nonce mismatch", users)
+	}
+}
+
+func TestOIDCProvisionsAndReusesAccount(t *testing.T) {
+	provider := newFakeProvider(t)
+	s := newTestOIDCService(t, provider)
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "alice", "email": "Alice@Example.com", "email_verified": true, "name": "Alice"}
+	}
+
+	code, err := login(t, s, provider, "", claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, user, err := s.Exchange(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair == nil || user.Email != "alice@example.com" || user.DisplayName != "Alice" {
+		t.Fatalf("unexpected provisioned user %+v with pair %v", user, pair)
+	}
+	if _, _, err := s.Exchange(code); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("second exchange of a handoff code: got %v, want ErrOIDCInvalidState", err)
+	}
+
+	code, err = login(t, s, provider, "", claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, again, err := s.Exchange(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Fatalf("second login resolved to user %s, want %s", again.ID, user.ID)
+	}
+}
+
+func TestOIDCLinksLocalAccountByVerifiedEmail(t *testing.T) {
+	provider := newFakeProvider(t)
+	s := newTestOIDCService(t, provider)
+
+	local := models.User{Email: "bob@example.com", IsActive: true}
+	if err := CreateUser(&local); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := login(t, s, provider, "", jwt.MapClaims{"sub": "bob", "email": "bob@example.com", "email_verified": false}); !errors.Is(err, ErrOIDCUnverified) {
+		t.Fatalf("unverified email: got %v, want ErrOIDCUnverified", err)
+	}
+
+	code, err := login(t, s, provider, "", jwt.MapClaims{"sub": "bob", "email": "bob@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, user, err := s.Exchange(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != local.ID || user.OIDCSubject == nil || *user.OIDCSubject != "bob" {
+		t.Fatalf("identity was not linked to the local account: %+v", user)
+	}
+
+	// A second identity claiming the same address cannot take it over.
+	if _, err := login(t, s, provider, "", jwt.MapClaims{"sub": "mallory", "email": "bob@example.com", "email_verified": true}); !errors.Is(err, ErrOIDCAccountLinked) {
+		t.Fatalf("second identity: got %v, want ErrOIDCAccountLinked", err)
+	}
+}
+
+func TestOIDCSyncsAdminGroup(t *testing.T) {
+	provider := newFakeProvider(t)
+	s := newTestOIDCService(t, provider)
+
+	code, err := login(t, s, provider, "", jwt.MapClaims{"sub": "dave", "email": "dave@example.com", "email_verified": true, "groups": []string{"staff", "stratus-admins"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, user, _ := s.Exchange(code)
+	if user.Role != models.RoleAdmin {
+		t.Fatal("member of the admin group was not provisioned as admin")
+	}
+
+	// Another admin exists, so leaving the group takes the role away.
+	other := models.User{Email: "erin@example.com", Role: models.RoleAdmin, IsActive: true}
+	if err := CreateUser(&other); err != nil {
+		t.Fatal(err)
+	}
+	code, err = login(t, s, provider, "", jwt.MapClaims{"sub": "dave", "email": "dave@example.com", "email_verified": true, "groups": "staff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, user, _ = s.Exchange(code); user.Role != models.RoleUser {
+		t.Error("admin rights were kept after leaving the admin group")
+	}
+}
+
+func TestOIDCRequiresSecondFactor(t *testing.T) {
+	provider := newFakeProvider(t)
+	s := newTestOIDCService(t, provider)
+
+	local := models.User{Email: "carol@example.com", IsActive: true, TOTPEnabled: true}
+	if err := CreateUser(&local); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := login(t, s, provider, "", jwt.MapClaims{"sub": "carol", "email": "carol@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, user, err := s.Exchange(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair != nil {
+		t.Fatal("SSO login of an account with 2FA returned a session")
+	}
+	if user.ID != local.ID {
+		t.Fatalf("handoff names user %s, want %s", user.ID, local.ID)
+	}
+
+	var sessions int64
+	database.DB.Model(&models.Session{}).Where("user_id = ?", local.ID).Count(&sessions)
+	if sessions != 0 {
+		t.Fatalf("%d sessions created before the second factor", sessions)
+	}
+}
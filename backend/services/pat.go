@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+const PersonalTokenPrefix = "stp_"
+
+const (
+	ScopeFilesRead   = "files:read"
+	ScopeFilesWrite  = "files:write"
+	ScopeAdminRead   = "admin:read"
+	ScopeAdminWrite  = "admin:write"
+	ScopeAccountRead = "account:read"
+)
+
+var personalTokenScopes = map[string]bool{
+	ScopeFilesRead:   true,
+	ScopeFilesWrite:  true,
+	ScopeAdminRead:   true,
+	ScopeAdminWrite:  true,
+	ScopeAccountRead: true,
+}
+
+var (
+	ErrTokenIPNotAllowed = errors.New("token is not allowed from this address")
+	ErrTokenNotFound     = errors.New("token not found")
+
+	ErrInvalidTokenRequest = errors.New("invalid token request")
+)
+
+// validatePersonalToken checks a token request for owner, who may only grant
+// admin scopes if they are an admin themselves.
+func validatePersonalToken(owner *models.User, scopes []string, expiresAt *time.Time, allowedIPs []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !personalTokenScopes[scope] {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+		if strings.HasPrefix(scope, "admin:") && !owner.IsStaff() {
+			return fmt.Errorf("scope %q requires an account with an admin role", scope)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	for _, entry := range allowedIPs {
+		if _, err := parseAllowedIP(entry); err != nil {
+			return fmt.Errorf("invalid allowed IP %q", entry)
+		}
+	}
+	return nil
+}
+
+// CreatePersonalToken returns the new record and the token itself, which is
+// only ever shown once. Validation failures are returned as ErrInvalidTokenRequest.
+func (s *TokenService) CreatePersonalToken(owner *models.User, name string, scopes []string, expiresAt *time.Time, allowedIPs []string) (*models.PersonalAccessToken, string, error) {
+	if err := validatePersonalToken(owner, scopes, expiresAt, allowedIPs); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidTokenRequest, err)
+	}
+
+	secret, err := RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	token := PersonalTokenPrefix + secret
+
+	record := models.PersonalAccessToken{
+		UserID:     owner.ID,
+		Name:       name,
+		TokenHash:  HashToken(token),
+		Hint:       token[:len(PersonalTokenPrefix)+4],
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		ExpiresAt:  expiresAt,
+	}
+	if record.AllowedIPs == nil {
+		record.AllowedIPs = []string{}
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		return nil, "", err
+	}
+	return &record, token, nil
+}
+
+func (s *TokenService) ListPersonalTokens(userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	err := database.DB.
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *TokenService) RevokePersonalToken(userID, id uuid.UUID) error {
+	result := database.DB.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// ParsePersonalToken validates a personal access token presented from
+// ipAddress and records its use.
+func (s *TokenService) ParsePersonalToken(token, ipAddress string) (*models.PersonalAccessToken, error) {
+	var record models.PersonalAccessToken
+	if err := database.DB.Where("token_hash = ?", HashToken(token)).First(&record).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if record.RevokedAt != nil || (record.ExpiresAt != nil && now.After(*record.ExpiresAt)) {
+		return nil, ErrInvalidToken
+	}
+	if !ipAllowed(record.AllowedIPs, ipAddress) {
+		return nil, ErrTokenIPNotAllowed
+	}
+
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > lastUsedInterval || record.LastUsedIP != ipAddress {
+		database.DB.Model(&record).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ipAddress,
+		})
+	}
+	return &record, nil
+}
+
+func ipAllowed(allowed []string, ipAddress string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ipAddress)
+	if err != nil {
+		return false
+	}
+	for _, entry := range allowed {
+		prefix, err := parseAllowedIP(entry)
+		if err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedIP accepts a single address or a CIDR range.
+func parseAllowedIP(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		return netip.ParsePrefix(entry)
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+func TestCreatePersonalTokenValidation(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+	past := time.Now().Add(-time.Hour)
+
+	for name, tc := range map[string]struct {
+		scopes     []string
+		expiresAt  *time.Time
+		allowedIPs []string
+	}{
+		"no scopes":              {},
+		"unknown scope":          {scopes: []string{"files:delete"}},
+		"admin scope for a user": {scopes: []string{ScopeFilesRead, ScopeAdminRead}},
+		"expired":                {scopes: []string{ScopeFilesRead}, expiresAt: &past},
+		"bad address":            {scopes: []string{ScopeFilesRead}, allowedIPs: []string{"10.0.0.300"}},
+		"bad range":              {scopes: []string{ScopeFilesRead}, allowedIPs: []string{"10.0.0.0/33"}},
+	} {
+		if _, _, err := s.CreatePersonalToken(user, "ci", tc.scopes, tc.expiresAt, tc.allowedIPs); !errors.Is(err, ErrInvalidTokenRequest) {
+			t.Errorf("%s: got %v, want ErrInvalidTokenRequest", name, err)
+		}
+	}
+
+	admin := createTestUser(t, "admin@example.com")
+	admin.Role = models.RoleAdmin
+	if _, _, err := s.CreatePersonalToken(admin, "ci", []string{ScopeAdminWrite}, nil, nil); err != nil {
+		t.Errorf("admin scope for an admin: %v", err)
+	}
+}
+
+func TestParsePersonalToken(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+
+	record, token, err := s.CreatePersonalToken(user, "ci", []string{ScopeFilesRead}, nil, []string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Hint != token[:len(PersonalTokenPrefix)+4] {
+		t.Errorf("hint %q does not match token", record.Hint)
+	}
+
+	for ip, want := range map[string]error{
+		"10.1.2.3":        nil,
+		"::ffff:10.1.2.3": nil,
+		"2001:db8::1":     nil,
+		"2001:db8::2":     ErrTokenIPNotAllowed,
+		"192.0.2.1":       ErrTokenIPNotAllowed,
+		"":                ErrTokenIPNotAllowed,
+	} {
+		if _, err := s.ParsePersonalToken(token, ip); !errors.Is(err, want) && !(want == nil && err == nil) {
+			t.Errorf("from %q: got %v, want %v", ip, err, want)
+		}
+	}
+
+	if _, err := s.ParsePersonalToken(token+"x", "10.1.2.3"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("altered token: got %v, want ErrInvalidToken", err)
+	}
+
+	database.DB.Model(record).Update("expires_at", time.Now().Add(-time.Second))
+	if _, err := s.ParsePersonalToken(token, "10.1.2.3"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expired token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRevokePersonalToken(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+	other := createTestUser(t, "other@example.com")
+	record, token, _ := s.CreatePersonalToken(user, "ci", []string{ScopeFilesRead}, nil, nil)
+
+	if err := s.RevokePersonalToken(other.ID, record.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("revoking another user's token: got %v, want ErrTokenNotFound", err)
+	}
+	if err := s.RevokePersonalToken(user.ID, record.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ParsePersonalToken(token, "192.0.2.1"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("revoked token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestPersonalTokenHasScope(t *testing.T) {
+	token := models.PersonalAccessToken{Scopes: []string{ScopeFilesWrite, ScopeAccountRead}}
+	for scope, want := range map[string]bool{
+		ScopeFilesWrite:  true,
+		ScopeFilesRead:   true,
+		ScopeAccountRead: true,
+		ScopeAdminRead:   false,
+		"account:write":  false,
+	} {
+		if got := token.HasScope(scope); got != want {
+			t.Errorf("HasScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+}
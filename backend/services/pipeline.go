@@ -0,0 +1,37 @@
+package services
+
+import "github.com/google/uuid"
+
+// ContentPipeline fans file content changes out to the derived-data
+// services: search index, media metadata and cached thumbnails.
+type ContentPipeline struct {
+	Search     *SearchService
+	Metadata   *MetadataService
+	Thumbnails *ThumbnailService
+}
+
+func NewContentPipeline(search *SearchService, metadata *MetadataService, thumbnails *ThumbnailService) *ContentPipeline {
+	return &ContentPipeline{
+		Search:     search,
+		Metadata:   metadata,
+		Thumbnails: thumbnails,
+	}
+}
+
+// FileChanged is called after a file is created or gets a new version.
+func (p *ContentPipeline) FileChanged(fileID uuid.UUID) {
+	p.Thumbnails.Invalidate(fileID)
+	p.Search.Enqueue(fileID)
+	p.Metadata.Enqueue(fileID)
+}
+
+// FileRenamed refreshes data derived from the file name only.
+func (p *ContentPipeline) FileRenamed(fileID uuid.UUID) {
+	p.Search.Enqueue(fileID)
+}
+
+func (p *ContentPipeline) FileRemoved(fileID uuid.UUID) {
+	p.Search.RemoveFile(fileID)
+	p.Metadata.RemoveFile(fileID)
+	p.Thumbnails.Invalidate(fileID)
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+// jobQueue is a bounded in-memory queue of file IDs drained by a fixed set
+// of workers. Enqueue never blocks a request; anything dropped is picked up
+// again by the owning service's startup scan.
+type jobQueue struct {
+	name   string
+	jobs   chan uuid.UUID
+	handle func(uuid.UUID) error
+}
+
+func newJobQueue(name string, size int, handle func(uuid.UUID) error) *jobQueue {
+	return &jobQueue{
+		name:   name,
+		jobs:   make(chan uuid.UUID, size),
+		handle: handle,
+	}
+}
+
+func (q *jobQueue) start(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+}
+
+func (q *jobQueue) work() {
+	for id := range q.jobs {
+		if err := q.handle(id); err != nil {
+			slog.Error("Job failed", "queue", q.name, "id", id, "error", err)
+		}
+	}
+}
+
+func (q *jobQueue) enqueue(id uuid.UUID) {
+	select {
+	case q.jobs <- id:
+	default:
+		slog.Warn("Queue full, deferring job", "queue", q.name, "id", id)
+	}
+}
+
+func (q *jobQueue) enqueueAll(ids []uuid.UUID) {
+	for _, id := range ids {
+		q.jobs <- id
+	}
+}
+
+func (q *jobQueue) depth() int {
+	return len(q.jobs)
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/metrics"
+	"stratus/models"
+)
+
+// LoginLimiter tracks failed logins in sliding windows per client IP and per
+// account. Repeated account failures first impose a growing delay between
+// attempts and then a temporary lockout; an IP is locked out once it exceeds
+// its own, higher limit. Attempts in progress count against the limits, so
+// a burst of parallel requests cannot slip past them while the password is
+// being checked. State is in memory and resets on restart.
+type LoginLimiter struct {
+	config *config.Config
+
+	mu      sync.Mutex
+	entries map[string]*limitEntry
+}
+
+type limitEntry struct {
+	failures    []time.Time
+	lockedUntil time.Time
+
+	// pending counts attempts that passed Check and have not been released
+	// yet; lastAttempt is when the latest of them started.
+	pending     int
+	lastAttempt time.Time
+}
+
+type Lockout struct {
+	Key         string     `json:"key"`
+	Kind        string     `json:"kind"`
+	Subject     string     `json:"subject"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+func NewLoginLimiter(cfg *config.Config) *LoginLimiter {
+	return &LoginLimiter{config: cfg, entries: make(map[string]*limitEntry)}
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func accountKey(login string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+// Check reports whether a login attempt may proceed and, if not, how long
+// the client has to wait. An admitted attempt is reserved until the
+// returned release function is called, which must happen after Failure or
+// Success has recorded its outcome.
+func (l *LoginLimiter) Check(ip, login string) (release func(), wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.entries) > 10000 {
+		l.pruneLocked(now)
+	}
+	ipEntry := l.entryLocked(ipKey(ip), now, true)
+	accountEntry := l.entryLocked(accountKey(login), now, true)
+
+	for _, entry := range []*limitEntry{ipEntry, accountEntry} {
+		if now.Before(entry.lockedUntil) {
+			wait = max(wait, entry.lockedUntil.Sub(now))
+		}
+	}
+
+	// Attempts still in progress may each end in a failure; treat them as
+	// such until they do.
+	if len(ipEntry.failures)+ipEntry.pending >= l.config.LoginMaxIPFailures ||
+		len(accountEntry.failures)+accountEntry.pending >= l.config.LoginMaxAccountFailures {
+		wait = max(wait, time.Second)
+	}
+	// The delay only starts once the account has failed, so clients that
+	// send parallel requests with valid credentials are not slowed down.
+	if failures := len(accountEntry.failures); failures > 0 {
+		last := maxTime(accountEntry.lastAttempt, accountEntry.failures[failures-1])
+		if next := last.Add(l.delay(failures + accountEntry.pending)); now.Before(next) {
+			wait = max(wait, next.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return func() {}, wait, false
+	}
+
+	ipEntry.pending++
+	accountEntry.pending++
+	accountEntry.lastAttempt = now
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			ipEntry.pending = max(ipEntry.pending-1, 0)
+			accountEntry.pending = max(accountEntry.pending-1, 0)
+			l.mu.Unlock()
+		})
+	}, 0, true
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Failure records a failed attempt against login and, when login names an
+// existing account, logs it to that account's activity.
+func (l *LoginLimiter) Failure(origin models.ActivityOrigin, login, reason string) {
+	locked := l.failure(origin.IPAddress, login)
+	metrics.AuthFailures.WithLabelValues("login", origin.Source).Inc()
+
+	var user models.User
+	if err := database.DB.Where("LOWER(email) = ?", strings.ToLower(strings.TrimSpace(login))).First(&user).Error; err != nil {
+		return
+	}
+
+	database.DB.Create(&models.Activity{
+		UserID:    user.ID,
+		Type:      models.ActivityLoginFailed,
+		IPAddress: origin.IPAddress,
+		UserAgent: origin.UserAgent,
+		Source:    origin.Source,
+		Details:   reason,
+	})
+	if locked {
+		database.DB.Create(&models.Activity{
+			UserID:    user.ID,
+			Type:      models.ActivityAccountLocked,
+			IPAddress: origin.IPAddress,
+			UserAgent: origin.UserAgent,
+			Source:    origin.Source,
+			Details:   fmt.Sprintf("Locked for %s after repeated failed logins", formatTTL(l.config.LoginLockout)),
+		})
+	}
+}
+
+// failure updates the windows and reports whether the account became locked.
+func (l *LoginLimiter) failure(ip, login string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.entries) > 10000 {
+		l.pruneLocked(now)
+	}
+
+	ipEntry := l.entryLocked(ipKey(ip), now, true)
+	ipEntry.failures = append(ipEntry.failures, now)
+	if len(ipEntry.failures) >= l.config.LoginMaxIPFailures {
+		ipEntry.lockedUntil = now.Add(l.config.LoginLockout)
+	}
+
+	accountEntry := l.entryLocked(accountKey(login), now, true)
+	accountEntry.failures = append(accountEntry.failures, now)
+	if len(accountEntry.failures) >= l.config.LoginMaxAccountFailures && !now.Before(accountEntry.lockedUntil) {
+		accountEntry.lockedUntil = now.Add(l.config.LoginLockout)
+		accountEntry.failures = accountEntry.failures[:0]
+		return true
+	}
+	return false
+}
+
+// Success clears the account's failures. IP failures are kept so that a
+// single valid account cannot be used to reset an IP's budget, and so are
+// the account's attempts still in progress.
+func (l *LoginLimiter) Success(login string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := accountKey(login)
+	entry, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	if entry.pending == 0 {
+		delete(l.entries, key)
+		return
+	}
+	entry.failures = entry.failures[:0]
+	entry.lockedUntil = time.Time{}
+}
+
+func (l *LoginLimiter) Lockouts() []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.pruneLocked(now)
+
+	lockouts := make([]Lockout, 0, len(l.entries))
+	for key, entry := range l.entries {
+		if len(entry.failures) == 0 && !now.Before(entry.lockedUntil) {
+			// Only attempts in progress.
+			continue
+		}
+		kind, subject, _ := strings.Cut(key, ":")
+		lockout := Lockout{Key: key, Kind: kind, Subject: subject, Failures: len(entry.failures)}
+		if len(entry.failures) > 0 {
+			lockout.LastFailure = entry.failures[len(entry.failures)-1]
+		}
+		if now.Before(entry.lockedUntil) {
+			lockedUntil := entry.lockedUntil
+			lockout.LockedUntil = &lockedUntil
+		}
+		lockouts = append(lockouts, lockout)
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		if (lockouts[i].LockedUntil != nil) != (lockouts[j].LockedUntil != nil) {
+			return lockouts[i].LockedUntil != nil
+		}
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+	return lockouts
+}
+
+func (l *LoginLimiter) Clear(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.entries[key]
+	delete(l.entries, key)
+	return ok
+}
+
+// delay is the wait imposed after the given number of recent failures,
+// doubling from one second once LoginDelayAfter is reached.
+func (l *LoginLimiter) delay(failures int) time.Duration {
+	if failures < l.config.LoginDelayAfter {
+		return 0
+	}
+	delay := time.Second << min(failures-l.config.LoginDelayAfter, 16)
+	return min(delay, l.config.LoginMaxDelay)
+}
+
+// entryLocked returns the entry for key with failures outside the window
+// dropped, creating it if requested.
+func (l *LoginLimiter) entryLocked(key string, now time.Time, create bool) *limitEntry {
+	entry, ok := l.entries[key]
+	if !ok {
+		if !create {
+			return nil
+		}
+		entry = &limitEntry{}
+		l.entries[key] = entry
+	}
+
+	cutoff := now.Add(-l.config.LoginWindow)
+	i := 0
+	for i < len(entry.failures) && entry.failures[i].Before(cutoff) {
+		i++
+	}
+	entry.failures = entry.failures[i:]
+	return entry
+}
+
+func (l *LoginLimiter) pruneLocked(now time.Time) {
+	for key := range l.entries {
+		entry := l.entryLocked(key, now, false)
+		if len(entry.failures) == 0 && entry.pending == 0 && !now.Before(entry.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+var testOrigin = models.ActivityOrigin{Source: models.ActivitySourceWeb, IPAddress: "192.0.2.1", UserAgent: "test"}
+
+func newTestLimiter(t *testing.T, cfg config.Config) *LoginLimiter {
+	t.Helper()
+	dbtest.Open(t)
+	if cfg.LoginWindow == 0 {
+		cfg.LoginWindow = time.Minute
+	}
+	if cfg.LoginLockout == 0 {
+		cfg.LoginLockout = time.Minute
+	}
+	if cfg.LoginDelayAfter == 0 {
+		cfg.LoginDelayAfter = 100
+	}
+	if cfg.LoginMaxDelay == 0 {
+		cfg.LoginMaxDelay = 30 * time.Second
+	}
+	return NewLoginLimiter(&cfg)
+}
+
+// check runs Check for a sequential attempt, which is over by the time its
+// outcome is recorded.
+func check(l *LoginLimiter, ip, login string) (time.Duration, bool) {
+	release, wait, ok := l.Check(ip, login)
+	release()
+	return wait, ok
+}
+
+func TestLimiterLocksAccount(t *testing.T) {
+	l := newTestLimiter(t, config.Config{LoginMaxAccountFailures: 3, LoginMaxIPFailures: 100})
+	user := createTestUser(t, "alice@example.com")
+
+	for i := 0; i < 3; i++ {
+		if _, ok := check(l, "192.0.2.1", "Alice@example.com"); !ok {
+			t.Fatalf("attempt %d was blocked", i+1)
+		}
+		l.Failure(testOrigin, "Alice@example.com", "invalid password")
+	}
+
+	// The lock is on the account, whichever address the next attempt uses.
+	wait, ok := check(l, "198.51.100.7", "alice@example.com")
+	if ok || wait <= 50*time.Second || wait > time.Minute {
+		t.Fatalf("locked account: Check() = %v, %v", wait, ok)
+	}
+	if _, ok := check(l, "192.0.2.1", "bob@example.com"); !ok {
+		t.Error("another account from the same address was blocked")
+	}
+
+	var failed, locked int64
+	database.DB.Model(&models.Activity{}).Where("user_id = ? AND type = ?", user.ID, models.ActivityLoginFailed).Count(&failed)
+	database.DB.Model(&models.Activity{}).Where("user_id = ? AND type = ?", user.ID, models.ActivityAccountLocked).Count(&locked)
+	if failed != 3 || locked != 1 {
+		t.Errorf("activity: %d failed logins, %d lockouts; want 3 and 1", failed, locked)
+	}
+
+	lockouts := l.Lockouts()
+	if len(lockouts) == 0 || lockouts[0].Key != "account:alice@example.com" || lockouts[0].LockedUntil == nil {
+		t.Fatalf("Lockouts() = %+v", lockouts)
+	}
+	if !l.Clear("account:alice@example.com") {
+		t.Fatal("Clear found no entry")
+	}
+	if _, ok := check(l, "192.0.2.1", "alice@example.com"); !ok {
+		t.Error("account still blocked after Clear")
+	}
+}
+
+func TestLimiterLocksAddress(t *testing.T) {
+	l := newTestLimiter(t, config.Config{LoginMaxAccountFailures: 100, LoginMaxIPFailures: 5})
+
+	// Spraying one password across many accounts trips the address limit.
+	for _, login := range []string{"a", "b", "c", "d", "e"} {
+		l.Failure(testOrigin, login+"@example.com", "invalid password")
+	}
+	if _, ok := check(l, "192.0.2.1", "f@example.com"); ok {
+		t.Error("address over its limit was not blocked")
+	}
+	if _, ok := check(l, "198.51.100.7", "a@example.com"); !ok {
+		t.Error("another address was blocked")
+	}
+
+	// A successful login clears the account but not the address.
+	l.Success("a@example.com")
+	if _, ok := check(l, "192.0.2.1", "a@example.com"); ok {
+		t.Error("a successful login reset the address lockout")
+	}
+}
+
+func TestLimiterDelay(t *testing.T) {
+	l := newTestLimiter(t, config.Config{LoginMaxAccountFailures: 100, LoginMaxIPFailures: 100, LoginDelayAfter: 2, LoginMaxDelay: 3 * time.Second})
+
+	l.Failure(testOrigin, "alice@example.com", "invalid password")
+	if _, ok := check(l, "192.0.2.1", "alice@example.com"); !ok {
+		t.Fatal("delay imposed before LoginDelayAfter failures")
+	}
+
+	for failures, want := range map[int]time.Duration{2: time.Second, 3: 2 * time.Second, 4: 3 * time.Second, 5: 3 * time.Second} {
+		if got := l.delay(failures); got != want {
+			t.Errorf("delay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+
+	l.Failure(testOrigin, "alice@example.com", "invalid password")
+	wait, ok := check(l, "192.0.2.1", "alice@example.com")
+	if ok || wait > time.Second {
+		t.Errorf("after two failures: Check() = %v, %v; want a wait of up to 1s", wait, ok)
+	}
+}
+
+func TestLimiterWindowExpires(t *testing.T) {
+	l := newTestLimiter(t, config.Config{LoginMaxAccountFailures: 3, LoginMaxIPFailures: 100, LoginWindow: 50 * time.Millisecond})
+
+	l.Failure(testOrigin, "alice@example.com", "invalid password")
+	l.Failure(testOrigin, "alice@example.com", "invalid password")
+	time.Sleep(60 * time.Millisecond)
+	l.Failure(testOrigin, "alice@example.com", "invalid password")
+
+	if _, ok := check(l, "192.0.2.1", "alice@example.com"); !ok {
+		t.Error("failures outside the window counted towards the lockout")
+	}
+}
+
+func TestLimiterSuccessKeepsAttemptsInProgress(t *testing.T) {
+	l := newTestLimiter(t, config.Config{LoginMaxAccountFailures: 3, LoginMaxIPFailures: 100, LoginDelayAfter: 100})
+
+	l.Failure(testOrigin, "alice@example.com", "invalid password")
+	for i := 0; i < 2; i++ {
+		if _, _, ok := l.Check("192.0.2.1", "alice@example.com"); !ok {
+			t.Fatalf("parallel attempt %d was blocked", i+1)
+		}
+	}
+
+	// One attempt succeeding clears the failure, but the other two may
+	// still fail.
+	l.Success("alice@example.com")
+	if _, _, ok := l.Check("192.0.2.1", "alice@example.com"); !ok {
+		t.Fatal("attempt after the failure was cleared was blocked")
+	}
+	if _, _, ok := l.Check("192.0.2.1", "alice@example.com"); ok {
+		t.Error("Success dropped the attempts in progress")
+	}
+}
+
+func TestLimiterReservesAttemptsInProgress(t *testing.T) {
+	l := newTestLimiter(t, config.Config{LoginMaxAccountFailures: 3, LoginMaxIPFailures: 100, LoginDelayAfter: 100})
+
+	var releases []func()
+	for i := 0; i < 3; i++ {
+		release, _, ok := l.Check("192.0.2.1", "alice@example.com")
+		if !ok {
+			t.Fatalf("parallel attempt %d was blocked", i+1)
+		}
+		releases = append(releases, release)
+	}
+	// Each of the three could still fail, which would lock the account.
+	if _, _, ok := l.Check("192.0.2.1", "alice@example.com"); ok {
+		t.Fatal("a fourth parallel attempt was admitted")
+	}
+	if len(l.Lockouts()) != 0 {
+		t.Error("attempts in progress are listed as lockouts")
+	}
+
+	// A finished attempt frees its slot; releasing twice changes nothing.
+	releases[0]()
+	releases[0]()
+	release, _, ok := l.Check("192.0.2.1", "alice@example.com")
+	if !ok {
+		t.Fatal("attempt after a release was blocked")
+	}
+	if _, _, ok := l.Check("192.0.2.1", "alice@example.com"); ok {
+		t.Error("double release freed two slots")
+	}
+	release()
+	for _, release := range releases[1:] {
+		release()
+	}
+}
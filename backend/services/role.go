@@ -0,0 +1,367 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleBuiltIn       = errors.New("built-in roles cannot be changed")
+	ErrRoleInUse         = errors.New("role is still assigned")
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrRoleEscalation    = errors.New("cannot grant permissions you do not hold")
+	ErrRoleOwn           = errors.New("cannot change the role you hold")
+	ErrLastAdministrator = errors.New("cannot remove the last administrator")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{1,49}$`)
+
+// adminLockKey serialises changes that can take away the last active
+// administrator.
+const adminLockKey = 0x5374726174757343
+
+func builtInRoles() []models.Role {
+	return []models.Role{
+		{
+			Name:        models.RoleAdmin,
+			Description: "Full access to every administrative function",
+			Permissions: models.AllPermissions,
+		},
+		{
+			Name:        models.RoleUserManager,
+			Description: "Manages accounts, quotas, invites and lockouts",
+			Permissions: []models.Permission{
+				models.PermUsersRead,
+				models.PermUsersWrite,
+				models.PermInvitesManage,
+				models.PermLockoutsRead,
+				models.PermLockoutsWrite,
+				models.PermStatsRead,
+			},
+		},
+		{
+			Name:        models.RoleAuditor,
+			Description: "Read-only access to users, activity, the audit log and settings",
+			Permissions: []models.Permission{
+				models.PermUsersRead,
+				models.PermActivityRead,
+				models.PermAuditRead,
+				models.PermSettingsRead,
+				models.PermLockoutsRead,
+				models.PermStatsRead,
+			},
+		},
+		{
+			Name:        models.RoleUser,
+			Description: "Regular account without administrative access",
+			Permissions: []models.Permission{},
+		},
+	}
+}
+
+// RoleService caches every role in memory; roles change rarely and are
+// consulted on each admin request.
+type RoleService struct {
+	mu    sync.RWMutex
+	roles map[string]models.Role
+}
+
+func NewRoleService() *RoleService {
+	return &RoleService{roles: make(map[string]models.Role)}
+}
+
+// Load writes the built-in roles, so their permissions follow the code, and
+// then reads every role into the cache.
+func (s *RoleService) Load() error {
+	for _, role := range builtInRoles() {
+		role.BuiltIn = true
+		err := database.DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "permissions", "built_in", "updated_at"}),
+		}).Create(&role).Error
+		if err != nil {
+			return err
+		}
+	}
+	return s.reload()
+}
+
+func (s *RoleService) reload() error {
+	var rows []models.Role
+	if err := database.DB.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	roles := make(map[string]models.Role, len(rows))
+	for _, role := range rows {
+		roles[role.Name] = role
+	}
+
+	s.mu.Lock()
+	s.roles = roles
+	s.mu.Unlock()
+	return nil
+}
+
+// List returns built-in roles first, then custom roles by name.
+func (s *RoleService) List() []models.Role {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roles := make([]models.Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].BuiltIn != roles[j].BuiltIn {
+			return roles[i].BuiltIn
+		}
+		return roles[i].Name < roles[j].Name
+	})
+	return roles
+}
+
+func (s *RoleService) Get(name string) (models.Role, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	role, ok := s.roles[name]
+	return role, ok
+}
+
+// RoleOf returns the user's role. Unknown role names grant nothing.
+func (s *RoleService) RoleOf(user *models.User) models.Role {
+	if role, ok := s.Get(user.Role); ok {
+		return role
+	}
+	return models.Role{Name: user.Role}
+}
+
+func (s *RoleService) Can(user *models.User, permission models.Permission) bool {
+	role := s.RoleOf(user)
+	return role.Has(permission)
+}
+
+// Outranks reports whether actor holds every permission of target, which
+// is required to modify target's account.
+func (s *RoleService) Outranks(actor, target *models.User) bool {
+	actorRole, targetRole := s.RoleOf(actor), s.RoleOf(target)
+	return actorRole.Covers(&targetRole)
+}
+
+// grantsOnly rejects permission sets that go beyond actor's own role, so
+// managing roles cannot be used to gain access.
+func (s *RoleService) grantsOnly(actor *models.User, permissions []models.Permission) error {
+	actorRole := s.RoleOf(actor)
+	if !actorRole.Covers(&models.Role{Permissions: permissions}) {
+		return ErrRoleEscalation
+	}
+	return nil
+}
+
+func (s *RoleService) Create(actor *models.User, name, description string, permissions []models.Permission) (*models.Role, error) {
+	if !roleNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: name must be 2-50 lowercase letters, digits or dashes", ErrInvalidRole)
+	}
+	if _, exists := s.Get(name); exists {
+		return nil, fmt.Errorf("%w: role %q already exists", ErrInvalidRole, name)
+	}
+	if err := validatePermissions(permissions); err != nil {
+		return nil, err
+	}
+	if err := s.grantsOnly(actor, permissions); err != nil {
+		return nil, err
+	}
+
+	role := models.Role{Name: name, Description: description, Permissions: permissions}
+	if err := database.DB.Create(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, s.reload()
+}
+
+// Update changes a custom role. Actor must hold every permission the role
+// has before and after the change, and cannot edit the role they hold.
+func (s *RoleService) Update(actor *models.User, name, description string, permissions []models.Permission) (*models.Role, error) {
+	role, ok := s.Get(name)
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	if role.BuiltIn {
+		return nil, ErrRoleBuiltIn
+	}
+	if actor.Role == name {
+		return nil, ErrRoleOwn
+	}
+	if err := validatePermissions(permissions); err != nil {
+		return nil, err
+	}
+	if err := s.grantsOnly(actor, role.Permissions); err != nil {
+		return nil, err
+	}
+	if err := s.grantsOnly(actor, permissions); err != nil {
+		return nil, err
+	}
+
+	role.Description = description
+	role.Permissions = permissions
+	if err := database.DB.Save(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, s.reload()
+}
+
+func (s *RoleService) Delete(name string) error {
+	role, ok := s.Get(name)
+	if !ok {
+		return ErrRoleNotFound
+	}
+	if role.BuiltIn {
+		return ErrRoleBuiltIn
+	}
+
+	var users, invites int64
+	database.DB.Model(&models.User{}).Where("role = ?", name).Count(&users)
+	database.DB.Model(&models.Invite{}).Where("role = ? AND used_at IS NULL AND revoked_at IS NULL", name).Count(&invites)
+	if users > 0 || invites > 0 {
+		return ErrRoleInUse
+	}
+
+	if err := database.DB.Delete(&role).Error; err != nil {
+		return err
+	}
+	return s.reload()
+}
+
+// CanGrant reports whether actor may hand out the named role, to a user or
+// through an invite.
+func (s *RoleService) CanGrant(actor *models.User, name string) error {
+	role, ok := s.Get(name)
+	if !ok {
+		return ErrRoleNotFound
+	}
+	actorRole := s.RoleOf(actor)
+	if !actorRole.Covers(&role) {
+		return ErrRoleEscalation
+	}
+	return nil
+}
+
+// Assign changes target's role on behalf of actor and records the change
+// in target's activity.
+func (s *RoleService) Assign(actor, target *models.User, name string, origin models.ActivityOrigin) error {
+	if target.Role == name {
+		return nil
+	}
+	if err := s.CanGrant(actor, name); err != nil {
+		return err
+	}
+	if !s.Outranks(actor, target) {
+		return ErrRoleEscalation
+	}
+
+	previous := target.Role
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := KeepAdministrator(tx, target); err != nil {
+			return err
+		}
+
+		if err := tx.Model(target).Update("role", name).Error; err != nil {
+			return err
+		}
+		return recordRoleChange(tx, target, previous, name, actor.Email, origin)
+	})
+}
+
+// syncDirectoryRole applies membership of an external admin group: members
+// become admins and admins who left the group drop back to the default
+// role. Other roles are assigned in Stratus and left alone. The last active
+// admin keeps the role, so a directory change cannot lock everyone out of
+// administration.
+func syncDirectoryRole(user *models.User, inAdminGroup bool, source string) error {
+	role := user.Role
+	switch {
+	case inAdminGroup:
+		role = models.RoleAdmin
+	case user.Role == models.RoleAdmin:
+		role = models.RoleUser
+	}
+	if role == user.Role {
+		return nil
+	}
+
+	previous := user.Role
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := KeepAdministrator(tx, user); err != nil {
+			return err
+		}
+		if err := tx.Model(user).Update("role", role).Error; err != nil {
+			return err
+		}
+		return recordRoleChange(tx, user, previous, role, source, models.ActivityOrigin{Source: models.ActivitySourceSystem})
+	})
+	if errors.Is(err, ErrLastAdministrator) {
+		slog.Warn("Kept the admin role of the last administrator after a directory change", "user", user.Email, "source", source)
+		return nil
+	}
+	return err
+}
+
+// KeepAdministrator returns ErrLastAdministrator when user is the only
+// active admin left. Run it in the transaction that demotes or deactivates
+// user; the advisory lock keeps two such changes from each counting the
+// other as the admin that remains.
+func KeepAdministrator(tx *gorm.DB, user *models.User) error {
+	if user.Role != models.RoleAdmin || !user.IsActive {
+		return nil
+	}
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", adminLockKey).Error; err != nil {
+		return err
+	}
+	var others int64
+	if err := tx.Model(&models.User{}).Where("role = ? AND is_active = true AND id <> ?", models.RoleAdmin, user.ID).Count(&others).Error; err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastAdministrator
+	}
+	return nil
+}
+
+func recordRoleChange(tx *gorm.DB, user *models.User, from, to, by string, origin models.ActivityOrigin) error {
+	return tx.Create(&models.Activity{
+		UserID:    user.ID,
+		Type:      models.ActivityRoleChanged,
+		IPAddress: origin.IPAddress,
+		UserAgent: origin.UserAgent,
+		Source:    origin.Source,
+		Details:   fmt.Sprintf("Role changed from %s to %s by %s", from, to, by),
+		Changes:   models.ActivityChanges{"role": {Before: from, After: to}},
+	}).Error
+}
+
+func validatePermissions(permissions []models.Permission) error {
+	if len(permissions) == 0 {
+		return fmt.Errorf("%w: a custom role needs at least one permission", ErrInvalidRole)
+	}
+	known := make(map[models.Permission]bool, len(models.AllPermissions))
+	for _, permission := range models.AllPermissions {
+		known[permission] = true
+	}
+	for _, permission := range permissions {
+		if !known[permission] {
+			return fmt.Errorf("%w: unknown permission %q", ErrInvalidRole, permission)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+func newTestRoleService(t *testing.T) *RoleService {
+	t.Helper()
+	dbtest.Open(t)
+	s := NewRoleService()
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func createUserWithRole(t *testing.T, email, role string) *models.User {
+	t.Helper()
+	user := createTestUser(t, email)
+	if err := database.DB.Model(user).Update("role", role).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRoleLoad(t *testing.T) {
+	s := newTestRoleService(t)
+	admin := createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+	if _, err := s.Create(admin, "support", "", []models.Permission{models.PermUsersRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading again keeps custom roles and does not duplicate built-in ones.
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	roles := s.List()
+	if len(roles) != 5 || !roles[0].BuiltIn || roles[4].Name != "support" {
+		t.Fatalf("List() = %+v", roles)
+	}
+	if admin, _ := s.Get(models.RoleAdmin); len(admin.Permissions) != len(models.AllPermissions) {
+		t.Errorf("admin role has %d permissions, want all %d", len(admin.Permissions), len(models.AllPermissions))
+	}
+	if s.Can(&models.User{Role: "deleted-role"}, models.PermUsersRead) {
+		t.Error("an unknown role granted a permission")
+	}
+}
+
+func TestRoleEscalation(t *testing.T) {
+	s := newTestRoleService(t)
+	admin := createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+	manager := createUserWithRole(t, "manager@example.com", models.RoleUserManager)
+	auditor := createUserWithRole(t, "auditor@example.com", models.RoleAuditor)
+	user := createUserWithRole(t, "user@example.com", models.RoleUser)
+
+	if err := s.CanGrant(manager, models.RoleAdmin); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager granting admin: got %v, want ErrRoleEscalation", err)
+	}
+	if err := s.CanGrant(manager, models.RoleAuditor); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager granting auditor: got %v, want ErrRoleEscalation", err)
+	}
+	if err := s.CanGrant(manager, models.RoleUserManager); err != nil {
+		t.Errorf("manager granting own role: %v", err)
+	}
+	if err := s.CanGrant(admin, "no-such-role"); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("unknown role: got %v, want ErrRoleNotFound", err)
+	}
+
+	if !s.Outranks(manager, user) || s.Outranks(manager, auditor) || s.Outranks(manager, admin) || !s.Outranks(admin, manager) {
+		t.Error("Outranks does not follow the permission sets")
+	}
+
+	if err := s.Assign(manager, user, models.RoleAdmin, models.ActivityOrigin{}); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager promoting a user to admin: got %v, want ErrRoleEscalation", err)
+	}
+	if err := s.Assign(manager, admin, models.RoleUser, models.ActivityOrigin{}); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager demoting an admin: got %v, want ErrRoleEscalation", err)
+	}
+	if err := s.Assign(manager, user, models.RoleUserManager, testOrigin); err != nil {
+		t.Fatalf("manager granting own role: %v", err)
+	}
+
+	var reloaded models.User
+	database.DB.First(&reloaded, "id = ?", user.ID)
+	if reloaded.Role != models.RoleUserManager {
+		t.Errorf("role after Assign = %q", reloaded.Role)
+	}
+	var changes int64
+	database.DB.Model(&models.Activity{}).Where("user_id = ? AND type = ?", user.ID, models.ActivityRoleChanged).Count(&changes)
+	if changes != 1 {
+		t.Errorf("%d role change activities, want 1", changes)
+	}
+}
+
+func TestRoleAssignKeepsLastAdministrator(t *testing.T) {
+	s := newTestRoleService(t)
+	admin := createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+
+	if err := s.Assign(admin, admin, models.RoleUser, models.ActivityOrigin{}); !errors.Is(err, ErrLastAdministrator) {
+		t.Fatalf("demoting the only admin: got %v, want ErrLastAdministrator", err)
+	}
+
+	// An inactive admin does not count towards the guard.
+	inactive := createUserWithRole(t, "inactive@example.com", models.RoleAdmin)
+	database.DB.Model(inactive).Update("is_active", false)
+	if err := s.Assign(admin, admin, models.RoleUser, models.ActivityOrigin{}); !errors.Is(err, ErrLastAdministrator) {
+		t.Fatalf("demoting the only active admin: got %v, want ErrLastAdministrator", err)
+	}
+
+	second := createUserWithRole(t, "second@example.com", models.RoleAdmin)
+	if err := s.Assign(second, admin, models.RoleUser, models.ActivityOrigin{}); err != nil {
+		t.Fatalf("demoting one of two admins: %v", err)
+	}
+	if err := s.Assign(second, second, models.RoleUser, models.ActivityOrigin{}); !errors.Is(err, ErrLastAdministrator) {
+		t.Errorf("demoting the remaining admin: got %v, want ErrLastAdministrator", err)
+	}
+}
+
+func TestCustomRoles(t *testing.T) {
+	s := newTestRoleService(t)
+	admin := createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+
+	for name, permissions := range map[string][]models.Permission{
+		"Support":   {models.PermUsersRead},
+		"x":         {models.PermUsersRead},
+		"empty":     {},
+		"unknown":   {"users:everything"},
+		"user":      {models.PermUsersRead},
+		"has space": {models.PermUsersRead},
+	} {
+		if _, err := s.Create(admin, name, "", permissions); !errors.Is(err, ErrInvalidRole) {
+			t.Errorf("Create(%q): got %v, want ErrInvalidRole", name, err)
+		}
+	}
+
+	if _, err := s.Create(admin, "support", "Help desk", []models.Permission{models.PermUsersRead}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(admin, "support", "Help desk", []models.Permission{models.PermUsersRead, models.PermLockoutsWrite}); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := s.Get("support"); !role.Has(models.PermLockoutsWrite) {
+		t.Error("update did not reach the cache")
+	}
+	if _, err := s.Update(admin, models.RoleAuditor, "", []models.Permission{models.PermUsersRead}); !errors.Is(err, ErrRoleBuiltIn) {
+		t.Errorf("updating a built-in role: got %v, want ErrRoleBuiltIn", err)
+	}
+	if err := s.Delete(models.RoleAdmin); !errors.Is(err, ErrRoleBuiltIn) {
+		t.Errorf("deleting a built-in role: got %v, want ErrRoleBuiltIn", err)
+	}
+
+	createUserWithRole(t, "helper@example.com", "support")
+	if err := s.Delete("support"); !errors.Is(err, ErrRoleInUse) {
+		t.Errorf("deleting an assigned role: got %v, want ErrRoleInUse", err)
+	}
+	database.DB.Model(&models.User{}).Where("role = ?", "support").Update("role", models.RoleUser)
+	if err := s.Delete("support"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("support"); ok {
+		t.Error("deleted role is still cached")
+	}
+}
+
+func TestRoleManagementEscalation(t *testing.T) {
+	s := newTestRoleService(t)
+	admin := createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+	manager := createUserWithRole(t, "manager@example.com", models.RoleUserManager)
+
+	if _, err := s.Create(manager, "operator", "", []models.Permission{models.PermUsersRead, models.PermSettingsWrite}); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager creating a role with settings:write: got %v, want ErrRoleEscalation", err)
+	}
+	if _, err := s.Create(manager, "helpdesk", "", []models.Permission{models.PermUsersRead, models.PermLockoutsWrite}); err != nil {
+		t.Fatalf("manager creating a role within their own permissions: %v", err)
+	}
+	if _, err := s.Update(manager, "helpdesk", "", []models.Permission{models.PermUsersRead, models.PermRolesManage}); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager adding roles:manage: got %v, want ErrRoleEscalation", err)
+	}
+
+	// A role that already goes beyond the actor cannot be edited by them,
+	// even to narrow it.
+	if _, err := s.Create(admin, "auditor-plus", "", []models.Permission{models.PermUsersRead, models.PermAuditRead}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(manager, "auditor-plus", "", []models.Permission{models.PermUsersRead}); !errors.Is(err, ErrRoleEscalation) {
+		t.Errorf("manager editing a role above them: got %v, want ErrRoleEscalation", err)
+	}
+
+	database.DB.Model(manager).Update("role", "helpdesk")
+	if _, err := s.Update(manager, "helpdesk", "", []models.Permission{models.PermUsersRead}); !errors.Is(err, ErrRoleOwn) {
+		t.Errorf("editing the role you hold: got %v, want ErrRoleOwn", err)
+	}
+}
+
+func TestSyncDirectoryRole(t *testing.T) {
+	newTestRoleService(t)
+	user := createUserWithRole(t, "user@example.com", models.RoleUser)
+	auditor := createUserWithRole(t, "auditor@example.com", models.RoleAuditor)
+
+	if err := syncDirectoryRole(user, true, "LDAP group sync"); err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleAdmin {
+		t.Fatalf("member of the admin group has role %q", user.Role)
+	}
+
+	// The only admin keeps the role after leaving the group.
+	if err := syncDirectoryRole(user, false, "LDAP group sync"); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded := reloadUser(t, user.ID); user.Role != models.RoleAdmin || reloaded.Role != models.RoleAdmin {
+		t.Fatalf("last admin demoted to %q by the directory", reloaded.Role)
+	}
+
+	createUserWithRole(t, "admin@example.com", models.RoleAdmin)
+	if err := syncDirectoryRole(user, false, "LDAP group sync"); err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleUser {
+		t.Errorf("former member of the admin group has role %q", user.Role)
+	}
+
+	// Roles assigned inside Stratus are left alone.
+	if err := syncDirectoryRole(auditor, false, "LDAP group sync"); err != nil || auditor.Role != models.RoleAuditor {
+		t.Errorf("auditor outside the admin group: role %q, err %v", auditor.Role, err)
+	}
+}
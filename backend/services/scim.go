@@ -0,0 +1,385 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+var (
+	ErrSCIMNotFound      = errors.New("resource not found")
+	ErrSCIMConflict      = errors.New("a user with this userName already exists")
+	ErrSCIMInvalidFilter = errors.New("unsupported filter")
+	ErrSCIMInvalidValue  = errors.New("invalid attribute value")
+)
+
+// scimFilterPattern matches the only filter form identity providers need
+// to find existing resources: a single attribute compared with eq.
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z.$]+(?:\[type eq "[a-z]+"\]\.value)?)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// SCIMFilter is a parsed "attribute eq value" filter.
+type SCIMFilter struct {
+	Attribute string
+	Value     string
+}
+
+func ParseSCIMFilter(filter string) (*SCIMFilter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return nil, ErrSCIMInvalidFilter
+	}
+	value := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(match[2])
+	return &SCIMFilter{Attribute: strings.ToLower(match[1]), Value: value}, nil
+}
+
+// SCIMUserAttributes are the parts of a user an identity provider manages.
+// UserName is the account's email address. A nil Password leaves the
+// password unchanged.
+type SCIMUserAttributes struct {
+	UserName    string
+	DisplayName string
+	ExternalID  string
+	Active      bool
+	Password    *string
+}
+
+// SCIMService provisions accounts on behalf of an identity provider through
+// SCIM 2.0. Groups are the roles other than the default one, and since a
+// user holds a single role, joining a group leaves any other.
+type SCIMService struct {
+	roles     *RoleService
+	deletions *DeletionService
+}
+
+func NewSCIMService(roles *RoleService, deletions *DeletionService) *SCIMService {
+	return &SCIMService{roles: roles, deletions: deletions}
+}
+
+// provisioned limits queries to accounts SCIM manages, leaving out service
+// accounts.
+func provisioned() *gorm.DB {
+	return database.DB.Model(&models.User{}).Where("is_service = false")
+}
+
+func (s *SCIMService) ListUsers(filter *SCIMFilter, offset, limit int) ([]models.User, int64, error) {
+	query := provisioned()
+	if filter != nil {
+		switch filter.Attribute {
+		case "id":
+			id, err := uuid.Parse(filter.Value)
+			if err != nil {
+				return []models.User{}, 0, nil
+			}
+			query = query.Where("id = ?", id)
+		case "username", "emails.value", `emails[type eq "work"].value`:
+			query = query.Where("LOWER(email) = ?", strings.ToLower(filter.Value))
+		case "externalid":
+			query = query.Where("external_id = ?", filter.Value)
+		case "displayname":
+			query = query.Where("display_name = ?", filter.Value)
+		default:
+			return nil, 0, ErrSCIMInvalidFilter
+		}
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	users := []models.User{}
+	err := query.Order("created_at, id").Offset(offset).Limit(limit).Find(&users).Error
+	return users, total, err
+}
+
+func (s *SCIMService) GetUser(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := provisioned().Where("id = ?", id).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSCIMNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func validateSCIMUser(attrs *SCIMUserAttributes) error {
+	attrs.UserName = strings.TrimSpace(attrs.UserName)
+	address, err := mail.ParseAddress(attrs.UserName)
+	if err != nil || address.Address != attrs.UserName {
+		return fmt.Errorf("%w: userName must be an email address", ErrSCIMInvalidValue)
+	}
+	if len(attrs.DisplayName) > 255 {
+		return fmt.Errorf("%w: displayName is longer than 255 characters", ErrSCIMInvalidValue)
+	}
+	if len(attrs.ExternalID) > 512 {
+		return fmt.Errorf("%w: externalId is longer than 512 characters", ErrSCIMInvalidValue)
+	}
+	if attrs.Password != nil && len(*attrs.Password) < 8 {
+		return fmt.Errorf("%w: password must be at least 8 characters", ErrSCIMInvalidValue)
+	}
+	return nil
+}
+
+// emailTaken includes deleted accounts, which keep their address until
+// they are purged.
+func emailTaken(tx *gorm.DB, email string, except uuid.UUID) (bool, error) {
+	var count int64
+	err := tx.Unscoped().Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", strings.ToLower(email), except).Count(&count).Error
+	return count > 0, err
+}
+
+// CreateUser provisions a local account. The identity provider vouches for
+// the address, so it starts out verified.
+func (s *SCIMService) CreateUser(actor *models.User, attrs SCIMUserAttributes, origin models.ActivityOrigin) (*models.User, error) {
+	if err := validateSCIMUser(&attrs); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := &models.User{
+		Email:       attrs.UserName,
+		DisplayName: attrs.DisplayName,
+		ExternalID:  attrs.ExternalID,
+		VerifiedAt:  &now,
+	}
+	if attrs.Password != nil {
+		if err := user.SetPassword(*attrs.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		taken, err := emailTaken(tx, user.Email, uuid.Nil)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSCIMConflict
+		}
+		if err := createUser(tx, user); err != nil {
+			return err
+		}
+		// A false IsActive is a zero value that the column default
+		// overrides on create.
+		if !attrs.Active {
+			if err := tx.Model(user).Update("is_active", false).Error; err != nil {
+				return err
+			}
+			user.IsActive = false
+		}
+		return tx.Create(&models.Activity{
+			UserID:    user.ID,
+			ActorID:   &actor.ID,
+			Type:      models.ActivityUserRegistered,
+			IPAddress: origin.IPAddress,
+			UserAgent: origin.UserAgent,
+			Source:    origin.Source,
+			Details:   "Provisioned by " + actor.Email,
+			Changes:   models.ActivityChanges{"email": {After: user.Email}, "is_active": {After: user.IsActive}},
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateUser replaces the provider-managed attributes of user.
+// Deactivating an account is how most providers deprovision it; it stays
+// recoverable until it is deleted.
+func (s *SCIMService) UpdateUser(actor, user *models.User, attrs SCIMUserAttributes, origin models.ActivityOrigin) error {
+	if err := validateSCIMUser(&attrs); err != nil {
+		return err
+	}
+	if !s.roles.Outranks(actor, user) {
+		return ErrRoleEscalation
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		updates := make(map[string]interface{})
+		changes := make(models.ActivityChanges)
+		if !strings.EqualFold(attrs.UserName, user.Email) {
+			taken, err := emailTaken(tx, attrs.UserName, user.ID)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return ErrSCIMConflict
+			}
+		}
+		if attrs.UserName != user.Email {
+			updates["email"] = attrs.UserName
+			changes["email"] = models.ActivityChange{Before: user.Email, After: attrs.UserName}
+		}
+		if attrs.DisplayName != user.DisplayName {
+			updates["display_name"] = attrs.DisplayName
+			changes["display_name"] = models.ActivityChange{Before: user.DisplayName, After: attrs.DisplayName}
+		}
+		if attrs.ExternalID != user.ExternalID {
+			updates["external_id"] = attrs.ExternalID
+		}
+		if attrs.Active != user.IsActive {
+			if attrs.Active {
+				if err := EnsureNotBeingDeleted(tx, user.ID); err != nil {
+					return err
+				}
+			}
+			updates["is_active"] = attrs.Active
+			changes["is_active"] = models.ActivityChange{Before: user.IsActive, After: attrs.Active}
+		}
+		if attrs.Password != nil {
+			if err := user.SetPassword(*attrs.Password); err != nil {
+				return err
+			}
+			updates["password_hash"] = user.PasswordHash
+			changes["password"] = models.ActivityChange{After: "changed"}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(user, user.ID).Error; err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return tx.Create(&models.Activity{
+			UserID:    user.ID,
+			ActorID:   &actor.ID,
+			Type:      models.ActivityUserUpdated,
+			IPAddress: origin.IPAddress,
+			UserAgent: origin.UserAgent,
+			Source:    origin.Source,
+			Details:   "Updated by " + actor.Email,
+			Changes:   changes,
+		}).Error
+	})
+}
+
+// DeleteUser schedules the account's deletion, so the usual grace period
+// applies. Deleting a user that is already being deleted succeeds.
+func (s *SCIMService) DeleteUser(actor, user *models.User, origin models.ActivityOrigin) error {
+	if !s.roles.Outranks(actor, user) {
+		return ErrRoleEscalation
+	}
+	_, err := s.deletions.Schedule(actor, user, origin)
+	if errors.Is(err, ErrDeletionPending) {
+		return nil
+	}
+	return err
+}
+
+// Groups lists the roles that can be handed out through group membership.
+func (s *SCIMService) Groups(filter *SCIMFilter) ([]models.Role, error) {
+	groups := []models.Role{}
+	for _, role := range s.roles.List() {
+		if role.Name == models.RoleUser {
+			continue
+		}
+		if filter != nil {
+			switch filter.Attribute {
+			case "id":
+				if role.ID.String() != strings.ToLower(filter.Value) {
+					continue
+				}
+			case "displayname":
+				if role.Name != filter.Value {
+					continue
+				}
+			default:
+				return nil, ErrSCIMInvalidFilter
+			}
+		}
+		groups = append(groups, role)
+	}
+	return groups, nil
+}
+
+func (s *SCIMService) GetGroup(id uuid.UUID) (*models.Role, error) {
+	for _, role := range s.roles.List() {
+		if role.ID == id && role.Name != models.RoleUser {
+			return &role, nil
+		}
+	}
+	return nil, ErrSCIMNotFound
+}
+
+func (s *SCIMService) Members(group *models.Role) ([]models.User, error) {
+	members := []models.User{}
+	err := provisioned().Where("role = ?", group.Name).Order("email").Find(&members).Error
+	return members, err
+}
+
+// AddMembers gives each user the group's role.
+func (s *SCIMService) AddMembers(actor *models.User, group *models.Role, ids []uuid.UUID, origin models.ActivityOrigin) error {
+	for _, id := range ids {
+		user, err := s.GetUser(id)
+		if err != nil {
+			return err
+		}
+		if err := s.roles.Assign(actor, user, group.Name, origin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveMembers returns members of the group to the default role. Users
+// that are not members are left alone.
+func (s *SCIMService) RemoveMembers(actor *models.User, group *models.Role, ids []uuid.UUID, origin models.ActivityOrigin) error {
+	for _, id := range ids {
+		user, err := s.GetUser(id)
+		if errors.Is(err, ErrSCIMNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if user.Role != group.Name {
+			continue
+		}
+		if err := s.roles.Assign(actor, user, models.RoleUser, origin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceMembers makes ids the group's exact membership.
+func (s *SCIMService) ReplaceMembers(actor *models.User, group *models.Role, ids []uuid.UUID, origin models.ActivityOrigin) error {
+	members, err := s.Members(group)
+	if err != nil {
+		return err
+	}
+	keep := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	var removed []uuid.UUID
+	for _, member := range members {
+		if !keep[member.ID] {
+			removed = append(removed, member.ID)
+		}
+	}
+	if err := s.RemoveMembers(actor, group, removed, origin); err != nil {
+		return err
+	}
+	return s.AddMembers(actor, group, ids, origin)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+type SearchService struct {
+	config *config.Config
+	queue  *jobQueue
+}
+
+type SearchResult struct {
+	File    models.File `json:"file"`
+	Snippet string      `json:"snippet"`
+	Rank    float64     `json:"rank"`
+}
+
+func NewSearchService(cfg *config.Config) *SearchService {
+	s := &SearchService{config: cfg}
+	s.queue = newJobQueue("Search index", 1024, s.IndexFile)
+	return s
+}
+
+// Start launches the indexing workers and queues every file whose index
+// entry is missing or stale, so uploads that arrived while the queue was
+// full or the server was down are picked up again.
+func (s *SearchService) Start() {
+	s.queue.start(s.config.SearchWorkers)
+	go s.enqueueStale()
+}
+
+func (s *SearchService) enqueueStale() {
+	var fileIDs []uuid.UUID
+	database.DB.Model(&models.File{}).
+		Joins("LEFT JOIN file_contents ON file_contents.file_id = files.id").
+		Where("files.is_directory = false").
+		Where("file_contents.file_id IS NULL OR file_contents.version <> files.version OR file_contents.checksum <> files.checksum").
+		Pluck("files.id", &fileIDs)
+
+	s.queue.enqueueAll(fileIDs)
+}
+
+func (s *SearchService) Enqueue(fileID uuid.UUID) {
+	s.queue.enqueue(fileID)
+}
+
+func (s *SearchService) QueueDepth() int {
+	return s.queue.depth()
+}
+
+func (s *SearchService) IndexFile(fileID uuid.UUID) error {
+	var file models.File
+	if err := database.DB.Where("id = ? AND is_directory = false", fileID).First(&file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.RemoveFile(fileID)
+		}
+		return err
+	}
+
+	content := models.FileContent{
+		FileID:    file.ID,
+		OwnerID:   file.OwnerID,
+		Version:   file.Version,
+		Checksum:  file.Checksum,
+		IndexedAt: time.Now(),
+	}
+
+	if file.Size <= s.config.SearchMaxFileSize {
+		text, err := ExtractText(file.StoragePath, file.Name, file.MimeType, s.config.SearchMaxTextBytes)
+		if err != nil && !errors.Is(err, ErrUnsupportedContent) {
+			content.Error = truncate(err.Error(), 255)
+		}
+		content.Content = text
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&content).Error; err != nil {
+			return err
+		}
+		return tx.Exec(
+			"UPDATE file_contents SET search_vector = to_tsvector(?::regconfig, ?) || to_tsvector(?::regconfig, content) WHERE file_id = ?",
+			s.config.SearchLanguage, file.Name, s.config.SearchLanguage, file.ID,
+		).Error
+	})
+}
+
+func (s *SearchService) RemoveFile(fileID uuid.UUID) error {
+	return database.DB.Where("file_id = ?", fileID).Delete(&models.FileContent{}).Error
+}
+
+func (s *SearchService) Search(userID uuid.UUID, query string, limit int) ([]SearchResult, error) {
+	type row struct {
+		models.File
+		Snippet string
+		Rank    float64
+	}
+
+	headlineOptions := "StartSel=" + highlightStart + ", StopSel=" + highlightStop +
+		", MaxFragments=3, MaxWords=30, MinWords=10, FragmentDelimiter=\" … \""
+
+	var rows []row
+	err := database.DB.Table("files").
+		Select(
+			"files.*, ts_headline(?::regconfig, file_contents.content, q, ?) AS snippet, ts_rank(file_contents.search_vector, q) AS rank",
+			s.config.SearchLanguage, headlineOptions,
+		).
+		Joins("JOIN file_contents ON file_contents.file_id = files.id").
+		Joins("CROSS JOIN websearch_to_tsquery(?::regconfig, ?) AS q", s.config.SearchLanguage, query).
+		Scopes(VisibleFiles(userID)).
+		Where("file_contents.search_vector @@ q").
+		Order("rank DESC, files.updated_at DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, r := range rows {
+		results = append(results, SearchResult{
+			File:    r.File,
+			Snippet: highlightSnippet(r.Snippet),
+			Rank:    r.Rank,
+		})
+	}
+	return results, nil
+}
+
+// VisibleFiles limits a files query to live, untrashed rows the user may
+// read. Every content query goes through it so access rules stay in one
+// place when sharing is added.
+func VisibleFiles(userID uuid.UUID) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("files.owner_id = ? AND files.is_trashed = false AND files.deleted_at IS NULL", userID)
+	}
+}
+
+// highlightSnippet escapes the headline and swaps the sentinel markers for
+// <mark> tags, so user content can never inject markup into results.
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightStop, "</mark>")
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
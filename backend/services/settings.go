@@ -0,0 +1,263 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+// Settings holds the admin-editable runtime configuration. Each top-level
+// JSON field is persisted as its own row in the settings table, and fields
+// without a row fall back to DefaultSettings.
+type Settings struct {
+	AttachmentMimeTypes   []string         `json:"attachment_mime_types"`
+	MFAPolicy             MFAPolicy        `json:"mfa_policy"`
+	WebDAVAccountPassword bool             `json:"webdav_account_password"`
+	RequireEmailVerified  bool             `json:"require_email_verification"`
+	RegistrationMode      RegistrationMode `json:"registration_mode"`
+	RegistrationDomains   []string         `json:"registration_domains"`
+}
+
+type MFAPolicy string
+
+const (
+	MFAOptional     MFAPolicy = "optional"
+	MFARequireAdmin MFAPolicy = "admins"
+	MFARequireAll   MFAPolicy = "all"
+)
+
+// RegistrationMode controls who may use /api/auth/register. A valid invite
+// code is accepted in every mode except closed.
+type RegistrationMode string
+
+const (
+	RegistrationOpen    RegistrationMode = "open"
+	RegistrationInvite  RegistrationMode = "invite"
+	RegistrationDomains RegistrationMode = "domains"
+	RegistrationClosed  RegistrationMode = "closed"
+)
+
+func DefaultSettings() Settings {
+	return Settings{
+		AttachmentMimeTypes: []string{
+			"text/html",
+			"application/xhtml+xml",
+			"image/svg+xml",
+			"text/xml",
+			"application/xml",
+			"text/javascript",
+			"application/javascript",
+			"application/x-shockwave-flash",
+		},
+		MFAPolicy:             MFAOptional,
+		WebDAVAccountPassword: true,
+		RegistrationMode:      RegistrationOpen,
+		RegistrationDomains:   []string{},
+	}
+}
+
+// ForcesAttachment reports whether files of mimeType must never be rendered
+// inline. Entries ending in "/*" match a whole top-level type.
+func (s Settings) ForcesAttachment(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	for _, pattern := range s.AttachmentMimeTypes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mimeType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresMFA reports whether the two-factor policy applies to user.
+func (s Settings) RequiresMFA(user *models.User) bool {
+	switch s.MFAPolicy {
+	case MFARequireAll:
+		return true
+	case MFARequireAdmin:
+		return user.IsStaff()
+	}
+	return false
+}
+
+// AllowsEmailDomain reports whether email belongs to one of the
+// RegistrationDomains. Subdomains of a listed domain do not match.
+func (s Settings) AllowsEmailDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range s.RegistrationDomains {
+		if strings.ToLower(strings.TrimSpace(allowed)) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Settings) Validate() error {
+	for _, pattern := range s.AttachmentMimeTypes {
+		if !strings.Contains(pattern, "/") {
+			return fmt.Errorf("invalid MIME type %q", pattern)
+		}
+	}
+	switch s.MFAPolicy {
+	case MFAOptional, MFARequireAdmin, MFARequireAll:
+	default:
+		return fmt.Errorf("invalid mfa_policy %q", s.MFAPolicy)
+	}
+	switch s.RegistrationMode {
+	case RegistrationOpen, RegistrationInvite, RegistrationDomains, RegistrationClosed:
+	default:
+		return fmt.Errorf("invalid registration_mode %q", s.RegistrationMode)
+	}
+	for _, domain := range s.RegistrationDomains {
+		if domain == "" || strings.ContainsAny(domain, "@ ") {
+			return fmt.Errorf("invalid registration domain %q", domain)
+		}
+	}
+	return nil
+}
+
+type SettingsService struct {
+	mu       sync.RWMutex
+	settings Settings
+}
+
+func NewSettingsService() *SettingsService {
+	return &SettingsService{settings: DefaultSettings()}
+}
+
+func (s *SettingsService) Load() error {
+	var rows []models.Setting
+	if err := database.DB.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	known := settingKeys()
+	values := make(map[string]json.RawMessage, len(rows))
+	for _, row := range rows {
+		if known[row.Key] {
+			values[row.Key] = json.RawMessage(row.Value)
+		}
+	}
+
+	settings, err := mergeSettings(DefaultSettings(), values)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.settings = settings
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *SettingsService) Get() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings
+}
+
+// Update applies a partial update keyed by JSON field name. Unknown keys and
+// values of the wrong type are rejected before anything is written.
+func (s *SettingsService) Update(patch map[string]json.RawMessage) (Settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings, err := mergeSettings(s.settings, patch)
+	if err != nil {
+		return s.settings, err
+	}
+	if err := settings.Validate(); err != nil {
+		return s.settings, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for key, value := range patch {
+			row := models.Setting{Key: key, Value: string(value)}
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return s.settings, err
+	}
+
+	s.settings = settings
+	return settings, nil
+}
+
+func mergeSettings(base Settings, patch map[string]json.RawMessage) (Settings, error) {
+	raw, err := json.Marshal(base)
+	if err != nil {
+		return base, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return base, err
+	}
+
+	for key, value := range patch {
+		if _, ok := fields[key]; !ok {
+			return base, fmt.Errorf("unknown setting %q", key)
+		}
+		fields[key] = value
+	}
+
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return base, err
+	}
+
+	var settings Settings
+	decoder := json.NewDecoder(strings.NewReader(string(merged)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&settings); err != nil {
+		return base, fmt.Errorf("invalid settings: %w", err)
+	}
+	return settings, nil
+}
+
+// Changes lists the settings whose values differ in next, for the
+// activity log.
+func (s Settings) Changes(next Settings) models.ActivityChanges {
+	before, after := settingValues(s), settingValues(next)
+	changes := make(models.ActivityChanges)
+	for key, value := range after {
+		if string(before[key]) != string(value) {
+			changes[key] = models.ActivityChange{Before: before[key], After: value}
+		}
+	}
+	return changes
+}
+
+func settingValues(settings Settings) map[string]json.RawMessage {
+	raw, _ := json.Marshal(settings)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(raw, &fields)
+	return fields
+}
+
+func settingKeys() map[string]bool {
+	fields := settingValues(DefaultSettings())
+	keys := make(map[string]bool, len(fields))
+	for key := range fields {
+		keys[key] = true
+	}
+	return keys
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSettingsChanges(t *testing.T) {
+	before := DefaultSettings()
+	after := before
+	after.MFAPolicy = MFARequireAll
+	after.RegistrationDomains = []string{"example.com"}
+
+	changes := before.Changes(after)
+	if len(changes) != 2 {
+		t.Fatalf("Changes() = %v, want mfa_policy and registration_domains", changes)
+	}
+	policy := changes["mfa_policy"]
+	if string(policy.Before.(json.RawMessage)) != `"optional"` || string(policy.After.(json.RawMessage)) != `"all"` {
+		t.Errorf("mfa_policy change = %s -> %s", policy.Before, policy.After)
+	}
+	if _, ok := changes["registration_domains"]; !ok {
+		t.Error("registration_domains change is missing")
+	}
+
+	if changes := before.Changes(before); len(changes) != 0 {
+		t.Errorf("unchanged settings report %v", changes)
+	}
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -10,13 +12,21 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
 
 	"stratus/config"
 	"stratus/database"
 	"stratus/models"
+	"stratus/telemetry"
 )
 
+const sniffLength = 3072
+
 type StorageService struct {
 	config *config.Config
 }
@@ -38,43 +48,92 @@ func (s *StorageService) EnsureUserStorage(userID uuid.UUID) error {
 	return os.MkdirAll(path, 0755)
 }
 
-func (s *StorageService) SaveFile(userID uuid.UUID, reader io.Reader, filename string) (string, int64, string, error) {
+type SavedFile struct {
+	StoragePath string
+	Size        int64
+	Checksum    string
+	MimeType    string
+}
+
+// storageSpan traces a storage operation on path.
+func storageSpan(ctx context.Context, operation, path string) trace.Span {
+	_, span := telemetry.Tracer.Start(ctx, "storage."+operation,
+		trace.WithAttributes(attribute.String("storage.path", path)),
+	)
+	return span
+}
+
+// endStorageSpan records err, if any, and ends span.
+func endStorageSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *StorageService) SaveFile(ctx context.Context, userID uuid.UUID, reader io.Reader, filename string) (saved *SavedFile, err error) {
+	span := storageSpan(ctx, "save", s.GetUserStoragePath(userID))
+	defer func() {
+		if saved != nil {
+			span.SetAttributes(attribute.Int64("storage.bytes", saved.Size))
+		}
+		endStorageSpan(span, err)
+	}()
+
 	if err := s.EnsureUserStorage(userID); err != nil {
-		return "", 0, "", err
+		return nil, err
 	}
 
 	ext := filepath.Ext(filename)
 	storageName := fmt.Sprintf("%s%s", uuid.New().String(), ext)
 	storagePath := filepath.Join(s.GetUserStoragePath(userID), storageName)
 
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
 	file, err := os.Create(storagePath)
 	if err != nil {
-		return "", 0, "", err
+		return nil, err
 	}
 	defer file.Close()
 
 	hasher := sha256.New()
 	writer := io.MultiWriter(file, hasher)
 
-	size, err := io.Copy(writer, reader)
+	size, err := io.Copy(writer, io.MultiReader(bytes.NewReader(head), reader))
 	if err != nil {
 		os.Remove(storagePath)
-		return "", 0, "", err
+		return nil, err
 	}
 
-	checksum := hex.EncodeToString(hasher.Sum(nil))
-	return storagePath, size, checksum, nil
+	return &SavedFile{
+		StoragePath: storagePath,
+		Size:        size,
+		Checksum:    hex.EncodeToString(hasher.Sum(nil)),
+		MimeType:    s.DetectMimeType(head, filename),
+	}, nil
 }
 
-func (s *StorageService) DeleteFile(storagePath string) error {
+func (s *StorageService) DeleteFile(ctx context.Context, storagePath string) error {
 	if storagePath == "" {
 		return nil
 	}
-	return os.Remove(storagePath)
+	span := storageSpan(ctx, "delete", storagePath)
+	err := os.Remove(storagePath)
+	endStorageSpan(span, err)
+	return err
 }
 
-func (s *StorageService) GetFile(storagePath string) (*os.File, error) {
-	return os.Open(storagePath)
+func (s *StorageService) GetFile(ctx context.Context, storagePath string) (*os.File, error) {
+	span := storageSpan(ctx, "open", storagePath)
+	f, err := os.Open(storagePath)
+	endStorageSpan(span, err)
+	return f, err
 }
 
 func (s *StorageService) GetMimeType(filename string) string {
@@ -83,10 +142,48 @@ func (s *StorageService) GetMimeType(filename string) string {
 	if mimeType == "" {
 		return "application/octet-stream"
 	}
-	return mimeType
+	return baseMimeType(mimeType)
+}
+
+// DetectMimeType sniffs the leading bytes of a file. The extension is only
+// trusted when sniffing finds nothing more specific than generic text or
+// binary and the extension agrees with it, so a renamed HTML page is still
+// stored as text/html but a .css file keeps text/css.
+func (s *StorageService) DetectMimeType(head []byte, filename string) string {
+	detected := baseMimeType(mimetype.Detect(head).String())
+	byExtension := s.GetMimeType(filename)
+
+	switch detected {
+	case "text/plain":
+		if strings.HasPrefix(byExtension, "text/") || byExtension == "application/json" || byExtension == "application/javascript" {
+			return byExtension
+		}
+	case "application/octet-stream":
+		if len(head) == 0 {
+			return byExtension
+		}
+	case "application/zip":
+		for m := mimetype.Lookup(byExtension); m != nil; m = m.Parent() {
+			if m.Is("application/zip") {
+				return byExtension
+			}
+		}
+	}
+	return detected
 }
 
-func (s *StorageService) CopyFile(src, dst string) error {
+func baseMimeType(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+	return mediaType
+}
+
+func (s *StorageService) CopyFile(ctx context.Context, src, dst string) (err error) {
+	span := storageSpan(ctx, "copy", src)
+	defer func() { endStorageSpan(span, err) }()
+
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
@@ -103,7 +200,9 @@ func (s *StorageService) CopyFile(src, dst string) error {
 	return err
 }
 
-func (s *StorageService) CreateFileVersion(file *models.File) error {
+// CreateFileVersion records the current content of file as a version. Pass
+// the transaction that replaces the content so both happen together.
+func (s *StorageService) CreateFileVersion(tx *gorm.DB, file *models.File) error {
 	version := &models.FileVersion{
 		FileID:      file.ID,
 		Version:     file.Version,
@@ -112,7 +211,7 @@ func (s *StorageService) CreateFileVersion(file *models.File) error {
 		Checksum:    file.Checksum,
 	}
 
-	return database.DB.Create(version).Error
+	return tx.Create(version).Error
 }
 
 func (s *StorageService) GetStorageUsage(userID uuid.UUID) (int64, error) {
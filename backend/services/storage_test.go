@@ -0,0 +1,91 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"stratus/config"
+)
+
+func TestDetectMimeType(t *testing.T) {
+	s := NewStorageService(&config.Config{})
+
+	var docx bytes.Buffer
+	archive := zip.NewWriter(&docx)
+	w, _ := archive.Create("word/document.xml")
+	w.Write([]byte("<w:document/>"))
+	archive.Close()
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+
+	for _, tc := range []struct {
+		name     string
+		head     []byte
+		filename string
+		want     string
+	}{
+		{"html renamed to txt", []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"), "notes.txt", "text/html"},
+		{"html renamed to png", []byte("<html><body>hi</body></html>"), "photo.png", "text/html"},
+		{"svg renamed to png", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script/></svg>`), "icon.png", "image/svg+xml"},
+		{"png renamed to jpg", png, "photo.jpg", "image/png"},
+		{"css keeps its extension", []byte("body { color: red; }"), "site.css", "text/css"},
+		{"json keeps its extension", []byte(`{"a": 1}`), "data.json", "application/json"},
+		{"text with a binary extension", []byte("just text"), "program.exe", "text/plain"},
+		{"office document", docx.Bytes(), "letter.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"office document renamed to zip", docx.Bytes(), "archive.zip", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"empty file", nil, "page.html", "text/html"},
+	} {
+		if got := s.DetectMimeType(tc.head, tc.filename); got != tc.want {
+			t.Errorf("%s: DetectMimeType() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestForcesAttachment(t *testing.T) {
+	defaults := DefaultSettings()
+	for mimeType, want := range map[string]bool{
+		"text/html":              true,
+		"TEXT/HTML":              true,
+		"image/svg+xml":          true,
+		"application/javascript": true,
+		"image/png":              false,
+		"text/plain":             false,
+		"application/pdf":        false,
+	} {
+		if got := defaults.ForcesAttachment(mimeType); got != want {
+			t.Errorf("ForcesAttachment(%q) = %v, want %v", mimeType, got, want)
+		}
+	}
+
+	custom := Settings{AttachmentMimeTypes: []string{" Video/* ", "application/pdf"}}
+	if !custom.ForcesAttachment("video/mp4") || !custom.ForcesAttachment("application/pdf") || custom.ForcesAttachment("videos/mp4") {
+		t.Error("wildcard or exact patterns did not match as expected")
+	}
+}
+
+func TestMergeSettings(t *testing.T) {
+	base := DefaultSettings()
+
+	merged, err := mergeSettings(base, map[string]json.RawMessage{"attachment_mime_types": json.RawMessage(`["text/html"]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.AttachmentMimeTypes) != 1 || merged.AttachmentMimeTypes[0] != "text/html" {
+		t.Errorf("merged settings: %+v", merged)
+	}
+
+	for name, patch := range map[string]map[string]json.RawMessage{
+		"unknown key": {"no_such_setting": json.RawMessage(`true`)},
+		"wrong type":  {"attachment_mime_types": json.RawMessage(`"text/html"`)},
+	} {
+		if _, err := mergeSettings(base, patch); err == nil {
+			t.Errorf("%s: patch was accepted", name)
+		}
+	}
+
+	if err := (Settings{AttachmentMimeTypes: []string{"html"}}).Validate(); err == nil {
+		t.Error("a MIME type without a slash passed validation")
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	_ "golang.org/x/image/bmp"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"stratus/config"
+	"stratus/models"
+)
+
+var (
+	ErrThumbnailUnsupported = errors.New("thumbnails are not supported for this file")
+	ErrThumbnailTooLarge    = errors.New("image is too large to thumbnail")
+)
+
+var ThumbnailSizes = map[string]int{
+	"small":   128,
+	"medium":  256,
+	"large":   512,
+	"preview": 1600,
+}
+
+type ThumbnailService struct {
+	config *config.Config
+	slots  chan struct{}
+
+	// waiting counts renders queued for a free slot.
+	waiting atomic.Int64
+
+	mu       sync.Mutex
+	inflight map[string]*thumbnailCall
+}
+
+type thumbnailCall struct {
+	done chan struct{}
+	err  error
+}
+
+func NewThumbnailService(cfg *config.Config) *ThumbnailService {
+	workers := cfg.ThumbnailWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	return &ThumbnailService{
+		config:   cfg,
+		slots:    make(chan struct{}, workers),
+		inflight: make(map[string]*thumbnailCall),
+	}
+}
+
+func (s *ThumbnailService) InitThumbnails() error {
+	return os.MkdirAll(s.thumbnailRoot(), 0755)
+}
+
+func (s *ThumbnailService) thumbnailRoot() string {
+	return filepath.Join(s.config.StoragePath, ".thumbnails")
+}
+
+func (s *ThumbnailService) thumbnailDir(fileID uuid.UUID) string {
+	return filepath.Join(s.thumbnailRoot(), fileID.String())
+}
+
+// thumbnailPath keys the cache on the content checksum, so a new version
+// never serves a stale image even before Invalidate has run.
+func (s *ThumbnailService) thumbnailPath(file *models.File, size int) string {
+	checksum := file.Checksum
+	if len(checksum) > 16 {
+		checksum = checksum[:16]
+	}
+	return filepath.Join(s.thumbnailDir(file.ID), fmt.Sprintf("%s-%d.jpg", checksum, size))
+}
+
+func (s *ThumbnailService) Supports(file *models.File) bool {
+	if file.IsDirectory {
+		return false
+	}
+	switch strings.ToLower(file.MimeType) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp", "image/bmp", "image/x-ms-bmp", "image/tiff":
+		return true
+	}
+	return false
+}
+
+// Get returns the path of a cached thumbnail, generating it on a worker slot
+// if needed. Concurrent requests for the same thumbnail share one render.
+func (s *ThumbnailService) Get(ctx context.Context, file *models.File, size int) (string, error) {
+	if !s.Supports(file) {
+		return "", ErrThumbnailUnsupported
+	}
+	if file.Size > s.config.ThumbnailMaxFileSize {
+		return "", ErrThumbnailTooLarge
+	}
+
+	path := s.thumbnailPath(file, size)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	s.mu.Lock()
+	call, ok := s.inflight[path]
+	if !ok {
+		call = &thumbnailCall{done: make(chan struct{})}
+		s.inflight[path] = call
+		go s.render(call, file, size, path)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return path, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (s *ThumbnailService) render(call *thumbnailCall, file *models.File, size int, path string) {
+	s.waiting.Add(1)
+	s.slots <- struct{}{}
+	s.waiting.Add(-1)
+	defer func() { <-s.slots }()
+
+	call.err = s.generate(file.StoragePath, size, path)
+
+	s.mu.Lock()
+	delete(s.inflight, path)
+	s.mu.Unlock()
+	close(call.done)
+}
+
+func (s *ThumbnailService) generate(src string, size int, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	cfg, _, err := image.DecodeConfig(in)
+	if err != nil {
+		return ErrThumbnailUnsupported
+	}
+	if cfg.Width*cfg.Height > s.config.ThumbnailMaxPixels {
+		return ErrThumbnailTooLarge
+	}
+	if _, err := in.Seek(0, 0); err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return ErrThumbnailUnsupported
+	}
+
+	thumb := resizeToFit(img, size)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: s.config.ThumbnailJPEGQuality}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// resizeToFit scales img so its longest edge is at most size, flattening
+// transparency onto white since the output is JPEG.
+func resizeToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width > size || height > size {
+		if width >= height {
+			height = max(1, height*size/width)
+			width = size
+		} else {
+			width = max(1, width*size/height)
+			height = size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Over, nil)
+	return dst
+}
+
+func (s *ThumbnailService) Invalidate(fileID uuid.UUID) error {
+	return os.RemoveAll(s.thumbnailDir(fileID))
+}
+
+// QueueDepth is the number of renders waiting for a worker slot; renders
+// in progress are not counted.
+func (s *ThumbnailService) QueueDepth() int {
+	return int(s.waiting.Load())
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"stratus/config"
+	"stratus/models"
+)
+
+func newTestThumbnailService(t *testing.T) *ThumbnailService {
+	t.Helper()
+	s := NewThumbnailService(&config.Config{
+		StoragePath:          t.TempDir(),
+		ThumbnailWorkers:     2,
+		ThumbnailMaxFileSize: 1 << 20,
+		ThumbnailMaxPixels:   1_000_000,
+		ThumbnailJPEGQuality: 80,
+	})
+	if err := s.InitThumbnails(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func encodeTestPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.NRGBA{R: 255, A: 128})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testImageFile(t *testing.T, data []byte) *models.File {
+	t.Helper()
+	return &models.File{
+		ID:          uuid.New(),
+		Name:        "image.png",
+		MimeType:    "image/png",
+		Size:        int64(len(data)),
+		Checksum:    uuid.NewString(),
+		StoragePath: writeTestFile(t, "image.png", data),
+	}
+}
+
+func TestThumbnailResizesToFit(t *testing.T) {
+	s := newTestThumbnailService(t)
+	file := testImageFile(t, encodeTestPNG(t, 400, 100))
+
+	path, err := s.Get(context.Background(), file, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	cfg, err := jpeg.DecodeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 128 || cfg.Height != 32 {
+		t.Errorf("thumbnail is %dx%d, want 128x32", cfg.Width, cfg.Height)
+	}
+
+	// Small images are not scaled up.
+	small := testImageFile(t, encodeTestPNG(t, 20, 10))
+	path, err = s.Get(context.Background(), small, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(data)); err != nil || cfg.Width != 20 || cfg.Height != 10 {
+		t.Errorf("small image thumbnail: %+v %v", cfg, err)
+	}
+}
+
+func TestThumbnailPixelLimit(t *testing.T) {
+	s := newTestThumbnailService(t)
+	file := testImageFile(t, encodeTestPNG(t, 2000, 1000))
+
+	if _, err := s.Get(context.Background(), file, 128); !errors.Is(err, ErrThumbnailTooLarge) {
+		t.Fatalf("2000x1000 image: got %v, want ErrThumbnailTooLarge", err)
+	}
+	if _, err := os.Stat(s.thumbnailPath(file, 128)); !os.IsNotExist(err) {
+		t.Error("a thumbnail was written for a rejected image")
+	}
+}
+
+// A decompression bomb declares huge dimensions in a tiny file; it must be
+// rejected from its header, before any pixels are decoded.
+func TestThumbnailRejectsDeclaredDimensions(t *testing.T) {
+	s := newTestThumbnailService(t)
+	data := encodeTestPNG(t, 1, 1)
+	ihdr := data[16:29]
+	binary.BigEndian.PutUint32(ihdr[0:4], 100_000)
+	binary.BigEndian.PutUint32(ihdr[4:8], 100_000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	if _, err := s.Get(context.Background(), testImageFile(t, data), 128); !errors.Is(err, ErrThumbnailTooLarge) {
+		t.Fatalf("got %v, want ErrThumbnailTooLarge", err)
+	}
+}
+
+func TestThumbnailRejectsUnsupportedFiles(t *testing.T) {
+	s := newTestThumbnailService(t)
+
+	large := testImageFile(t, encodeTestPNG(t, 10, 10))
+	large.Size = 2 << 20
+	if _, err := s.Get(context.Background(), large, 128); !errors.Is(err, ErrThumbnailTooLarge) {
+		t.Errorf("file over the size limit: got %v, want ErrThumbnailTooLarge", err)
+	}
+
+	pdf := testImageFile(t, []byte("%PDF-1.4"))
+	pdf.MimeType = "application/pdf"
+	if _, err := s.Get(context.Background(), pdf, 128); !errors.Is(err, ErrThumbnailUnsupported) {
+		t.Errorf("pdf: got %v, want ErrThumbnailUnsupported", err)
+	}
+
+	corrupt := testImageFile(t, []byte("\x89PNG\r\n\x1a\nnot really"))
+	if _, err := s.Get(context.Background(), corrupt, 128); !errors.Is(err, ErrThumbnailUnsupported) {
+		t.Errorf("corrupt png: got %v, want ErrThumbnailUnsupported", err)
+	}
+}
+
+func TestThumbnailSharesConcurrentRenders(t *testing.T) {
+	s := newTestThumbnailService(t)
+	file := testImageFile(t, encodeTestPNG(t, 300, 300))
+
+	// Hold every slot so the requests below queue up behind one render.
+	for i := 0; i < cap(s.slots); i++ {
+		s.slots <- struct{}{}
+	}
+	results := make(chan error, 5)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, err := s.Get(context.Background(), file, 256)
+			results <- err
+		}()
+	}
+	for {
+		s.mu.Lock()
+		n := len(s.inflight)
+		s.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < cap(s.slots); i++ {
+		<-s.slots
+	}
+
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := os.ReadDir(s.thumbnailDir(file.ID))
+	if len(entries) != 1 {
+		t.Errorf("%d files in the thumbnail directory, want 1", len(entries))
+	}
+}
+
+func TestThumbnailQueueDepth(t *testing.T) {
+	s := newTestThumbnailService(t)
+
+	// Busy workers are not a queue.
+	for i := 0; i < cap(s.slots); i++ {
+		s.slots <- struct{}{}
+	}
+	if depth := s.QueueDepth(); depth != 0 {
+		t.Fatalf("queue depth %d with every worker busy and nothing waiting", depth)
+	}
+
+	results := make(chan error, 3)
+	for i := 0; i < cap(results); i++ {
+		file := testImageFile(t, encodeTestPNG(t, 300, 300))
+		go func() {
+			_, err := s.Get(context.Background(), file, 256)
+			results <- err
+		}()
+	}
+	for s.QueueDepth() != cap(results) {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < cap(s.slots); i++ {
+		<-s.slots
+	}
+
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if depth := s.QueueDepth(); depth != 0 {
+		t.Errorf("queue depth %d after the renders finished", depth)
+	}
+}
@@ -0,0 +1,353 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/models"
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrSessionRevoked      = errors.New("session revoked or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+type Claims struct {
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID uuid.UUID `json:"sid"`
+	// Impersonation tokens carry no session. They name the impersonation
+	// record instead and, following RFC 8693, the acting admin.
+	ImpersonationID *uuid.UUID  `json:"imp,omitempty"`
+	Actor           *ActorClaim `json:"act,omitempty"`
+	jwt.RegisteredClaims
+
+	Impersonation *models.Impersonation `json:"-"`
+}
+
+type ActorClaim struct {
+	Subject uuid.UUID `json:"sub"`
+}
+
+// Every token is signed with the same key, so the audience is what tells
+// an access token apart from the intermediate MFA token. Verifiers must
+// check it.
+const (
+	accessAudience = "stratus-api"
+	mfaAudience    = "stratus-mfa"
+	mfaTokenTTL    = 5 * time.Minute
+)
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+	SessionID    uuid.UUID
+}
+
+type TokenService struct {
+	config *config.Config
+	keys   *SigningKeys
+}
+
+func NewTokenService(cfg *config.Config) (*TokenService, error) {
+	keys, err := LoadSigningKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenService{config: cfg, keys: keys}, nil
+}
+
+// JWKS publishes the public keys other services can verify tokens with.
+func (s *TokenService) JWKS() jose.JSONWebKeySet {
+	return s.keys.JWKS()
+}
+
+// CreateSession starts a new login session and returns its first token pair.
+func (s *TokenService) CreateSession(userID uuid.UUID, ipAddress, userAgent string) (*TokenPair, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:     userID,
+		IPAddress:  ipAddress,
+		UserAgent:  truncate(userAgent, 500),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.config.RefreshTTL),
+	}
+
+	var refreshToken string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		refreshToken, err = s.createRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pair(userID, session.ID, refreshToken)
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already
+// rotated means it leaked, so the whole session is revoked.
+func (s *TokenService) Refresh(refreshToken, ipAddress, userAgent string) (*TokenPair, uuid.UUID, error) {
+	var stored models.RefreshToken
+	if err := database.DB.Preload("Session").Where("token_hash = ?", HashToken(refreshToken)).First(&stored).Error; err != nil {
+		return nil, uuid.Nil, ErrInvalidRefreshToken
+	}
+
+	session := stored.Session
+	if stored.UsedAt != nil {
+		s.RevokeSession(session.ID, "refresh_token_reuse")
+		return nil, session.UserID, ErrRefreshTokenReused
+	}
+	if !session.IsActive() || time.Now().After(stored.ExpiresAt) {
+		return nil, session.UserID, ErrSessionRevoked
+	}
+
+	now := time.Now()
+	var newToken string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", stored.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		expiresAt := now.Add(s.config.RefreshTTL)
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"expires_at":   expiresAt,
+			"ip_address":   ipAddress,
+			"user_agent":   truncate(userAgent, 500),
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		newToken, err = s.createRefreshToken(tx, session.ID, expiresAt)
+		return err
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		s.RevokeSession(session.ID, "refresh_token_reuse")
+		return nil, session.UserID, err
+	}
+	if err != nil {
+		return nil, session.UserID, err
+	}
+
+	pair, err := s.pair(session.UserID, session.ID, newToken)
+	return pair, session.UserID, err
+}
+
+func (s *TokenService) RevokeSession(sessionID uuid.UUID, reason string) error {
+	return database.DB.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		}).Error
+}
+
+// RevokeUserSessions revokes every active session of a user except keep.
+func (s *TokenService) RevokeUserSessions(userID, keep uuid.UUID, reason string) error {
+	return database.DB.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		}).Error
+}
+
+func (s *TokenService) ActiveSessions(userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := database.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (s *TokenService) GenerateAccessToken(userID, sessionID uuid.UUID) (string, int64, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{accessAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.config.AccessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	signedToken, err := s.sign(claims)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return signedToken, int64(s.config.AccessTTL.Seconds()), nil
+}
+
+// ParseAccessToken validates an access token and the session or
+// impersonation it belongs to.
+func (s *TokenService) ParseAccessToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := s.parse(tokenString, claims, jwt.WithAudience(accessAudience))
+	if err != nil || !token.Valid || claims.Subject != claims.UserID.String() {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ImpersonationID != nil {
+		var impersonation models.Impersonation
+		if err := database.DB.First(&impersonation, "id = ?", *claims.ImpersonationID).Error; err != nil {
+			return nil, ErrSessionRevoked
+		}
+		if !impersonation.IsActive() || impersonation.TargetID != claims.UserID {
+			return nil, ErrSessionRevoked
+		}
+		claims.Impersonation = &impersonation
+		return claims, nil
+	}
+
+	if claims.SessionID == uuid.Nil {
+		return nil, ErrInvalidToken
+	}
+
+	var session models.Session
+	if err := database.DB.First(&session, "id = ?", claims.SessionID).Error; err != nil {
+		return nil, ErrSessionRevoked
+	}
+	if !session.IsActive() || session.UserID != claims.UserID {
+		return nil, ErrSessionRevoked
+	}
+
+	return claims, nil
+}
+
+func (s *TokenService) GenerateImpersonationToken(impersonation *models.Impersonation) (string, int64, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:          impersonation.TargetID,
+		ImpersonationID: &impersonation.ID,
+		Actor:           &ActorClaim{Subject: impersonation.ImpersonatorID},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   impersonation.TargetID.String(),
+			Audience:  jwt.ClaimStrings{accessAudience},
+			ExpiresAt: jwt.NewNumericDate(impersonation.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	signedToken, err := s.sign(claims)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return signedToken, int64(impersonation.ExpiresAt.Sub(now).Seconds()), nil
+}
+
+// GenerateMFAToken issues the intermediate token handed out between the
+// password and second-factor steps of login. It carries no session, so
+// ParseAccessToken rejects it.
+func (s *TokenService) GenerateMFAToken(userID uuid.UUID) (string, int64, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	signedToken, err := s.sign(claims)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return signedToken, int64(mfaTokenTTL.Seconds()), nil
+}
+
+func (s *TokenService) ParseMFAToken(tokenString string) (uuid.UUID, error) {
+	claims := &Claims{}
+	token, err := s.parse(tokenString, claims, jwt.WithAudience(mfaAudience))
+	if err != nil || !token.Valid || claims.SessionID != uuid.Nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return claims.UserID, nil
+}
+
+func (s *TokenService) sign(claims *Claims) (string, error) {
+	claims.Issuer = s.config.JWTIssuer
+	return s.keys.Sign(claims)
+}
+
+func (s *TokenService) parse(tokenString string, claims *Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append(options, jwt.WithIssuer(s.config.JWTIssuer))
+	return s.keys.Parse(tokenString, claims, options...)
+}
+
+func (s *TokenService) createRefreshToken(tx *gorm.DB, sessionID uuid.UUID, expiresAt time.Time) (string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	record := models.RefreshToken{
+		SessionID: sessionID,
+		TokenHash: HashToken(token),
+		ExpiresAt: expiresAt,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *TokenService) pair(userID, sessionID uuid.UUID, refreshToken string) (*TokenPair, error) {
+	accessToken, expiresIn, err := s.GenerateAccessToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    expiresIn,
+		SessionID:    sessionID,
+	}, nil
+}
+
+func RandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken is used for every bearer secret stored server-side; the tokens
+// are high-entropy so a fast hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
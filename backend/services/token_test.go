@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"stratus/config"
+	"stratus/database"
+	"stratus/database/dbtest"
+	"stratus/models"
+)
+
+func newTestTokenService(t *testing.T) *TokenService {
+	t.Helper()
+	dbtest.Open(t)
+	return mustTokenService(t, &config.Config{
+		JWTAlgorithm: "HS256",
+		JWTSecret:    "test-secret",
+		AccessTTL:    time.Minute,
+		RefreshTTL:   time.Hour,
+	})
+}
+
+func mustTokenService(t *testing.T, cfg *config.Config) *TokenService {
+	t.Helper()
+	tokens, err := NewTokenService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func createTestUser(t *testing.T, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, IsActive: true}
+	if err := user.SetPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+
+	first, err := s.CreateSession(user.ID, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, userID, err := s.Refresh(first.RefreshToken, "192.0.2.2", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != user.ID || second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken {
+		t.Errorf("refreshed pair: %+v", second)
+	}
+	if _, err := s.ParseAccessToken(second.AccessToken); err != nil {
+		t.Errorf("refreshed access token: %v", err)
+	}
+
+	if _, _, err := s.Refresh("unknown", "", ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown refresh token: got %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+
+	first, _ := s.CreateSession(user.ID, "", "")
+	second, _, err := s.Refresh(first.RefreshToken, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying the rotated token means it was stolen: the session ends and
+	// the legitimate holder of the newer token is logged out as well.
+	if _, _, err := s.Refresh(first.RefreshToken, "", ""); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused refresh token: got %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := s.Refresh(second.RefreshToken, "", ""); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("newer token after reuse: got %v, want ErrSessionRevoked", err)
+	}
+	if _, err := s.ParseAccessToken(second.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("access token after reuse: got %v, want ErrSessionRevoked", err)
+	}
+
+	var session models.Session
+	database.DB.First(&session, "id = ?", first.SessionID)
+	if session.RevokedAt == nil || session.RevokedReason != "refresh_token_reuse" {
+		t.Errorf("session after reuse: %+v", session)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+
+	current, _ := s.CreateSession(user.ID, "", "")
+	other, _ := s.CreateSession(user.ID, "", "")
+	if err := s.RevokeUserSessions(user.ID, current.SessionID, "password_changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ParseAccessToken(current.AccessToken); err != nil {
+		t.Errorf("kept session: %v", err)
+	}
+	if _, err := s.ParseAccessToken(other.AccessToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("other session: got %v, want ErrSessionRevoked", err)
+	}
+	sessions, _ := s.ActiveSessions(user.ID)
+	if len(sessions) != 1 || sessions[0].ID != current.SessionID {
+		t.Errorf("active sessions: %+v", sessions)
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+
+	mfaToken, _, err := s.GenerateMFAToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ParseAccessToken(mfaToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("MFA token as access token: got %v, want ErrInvalidToken", err)
+	}
+	if id, err := s.ParseMFAToken(mfaToken); err != nil || id != user.ID {
+		t.Errorf("ParseMFAToken() = %v, %v", id, err)
+	}
+
+	pair, _ := s.CreateSession(user.ID, "", "")
+	if _, err := s.ParseMFAToken(pair.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("access token as MFA token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAccessTokenRequiresAudienceAndSubject(t *testing.T) {
+	s := newTestTokenService(t)
+	user := createTestUser(t, "user@example.com")
+	other := createTestUser(t, "other@example.com")
+	session, err := s.CreateSession(user.ID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ParseAccessToken(session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != user.ID.String() || len(claims.Audience) != 1 || claims.Audience[0] != accessAudience {
+		t.Errorf("sub %q, aud %v", claims.Subject, claims.Audience)
+	}
+
+	forge := func(subject string, audience ...string) string {
+		token, err := s.sign(&Claims{
+			UserID:    user.ID,
+			SessionID: claims.SessionID,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   subject,
+				Audience:  audience,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	for name, token := range map[string]string{
+		"no audience":        forge(user.ID.String()),
+		"another audience":   forge(user.ID.String(), "another-service"),
+		"no subject":         forge("", accessAudience),
+		"subject of another": forge(other.ID.String(), accessAudience),
+	} {
+		if _, err := s.ParseAccessToken(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
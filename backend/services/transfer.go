@@ -0,0 +1,357 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+var (
+	ErrTransferSameUser      = errors.New("source and recipient are the same user")
+	ErrTransferSourceMissing = errors.New("file not found")
+	ErrTransferTargetMissing = errors.New("target folder not found")
+	ErrTransferQuota         = errors.New("recipient does not have enough space")
+)
+
+// driveRootCondition matches the root folder row every account gets on
+// creation; it is not a folder the user can see or move.
+const driveRootCondition = "(is_directory AND path = '/' AND name = 'root')"
+
+func isDriveRoot(file *models.File) bool {
+	return file.IsDirectory && file.Path == "/" && file.Name == "root"
+}
+
+// TransferRequest moves RootID and everything below it, or the whole drive
+// of From when RootID is nil, into TargetPath in the drive of To.
+type TransferRequest struct {
+	From       *models.User
+	To         *models.User
+	RootID     *uuid.UUID
+	TargetPath string
+}
+
+type TransferResult struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// TransferService hands files from one user to another, for example when
+// an employee leaves. Trashed files stay with the previous owner.
+type TransferService struct {
+	storage *StorageService
+	content *ContentPipeline
+}
+
+func NewTransferService(storage *StorageService, content *ContentPipeline) *TransferService {
+	return &TransferService{storage: storage, content: content}
+}
+
+// blobMove is one blob relocated into the recipient's storage directory.
+type blobMove struct {
+	from, to string
+}
+
+func (s *TransferService) Transfer(actor *models.User, req TransferRequest, origin models.ActivityOrigin) (*TransferResult, error) {
+	if req.From.ID == req.To.ID {
+		return nil, ErrTransferSameUser
+	}
+	targetPath := path.Clean("/" + req.TargetPath)
+
+	var moves []blobMove
+	var result *transferOutcome
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = s.transfer(tx, actor, req, targetPath, origin, &moves)
+		return err
+	})
+	if err != nil {
+		// The rows still point at the old locations.
+		undoBlobMoves(moves)
+		return nil, err
+	}
+
+	for _, id := range result.renamed {
+		s.content.FileRenamed(id)
+	}
+	return &result.TransferResult, nil
+}
+
+type transferOutcome struct {
+	TransferResult
+	renamed []uuid.UUID
+}
+
+func (s *TransferService) transfer(tx *gorm.DB, actor *models.User, req TransferRequest, targetPath string, origin models.ActivityOrigin, moves *[]blobMove) (*transferOutcome, error) {
+	var target *models.File
+	if targetPath != "/" {
+		target = &models.File{}
+		dir, name := path.Split(targetPath)
+		err := tx.Where("owner_id = ? AND path = ? AND name = ? AND is_directory = true AND is_trashed = false", req.To.ID, path.Clean(dir), name).First(target).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTransferTargetMissing
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Everything moves below a single new top-level entry in the target
+	// folder: the transferred folder or file itself, or for a whole drive
+	// a new folder named after its previous owner.
+	var (
+		files   []models.File
+		srcBase string
+		topName string
+		root    *models.File
+	)
+	owned := tx.Where("owner_id = ? AND is_trashed = false", req.From.ID)
+	if req.RootID != nil {
+		root = &models.File{}
+		if err := tx.Where("id = ? AND owner_id = ? AND is_trashed = false", *req.RootID, req.From.ID).First(root).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrTransferSourceMissing
+			}
+			return nil, err
+		}
+		if isDriveRoot(root) {
+			return nil, ErrTransferSourceMissing
+		}
+		files = append(files, *root)
+		srcBase = path.Join(root.Path, root.Name)
+		topName = root.Name
+		if root.IsDirectory {
+			var descendants []models.File
+			if err := owned.Where("path = ? OR path LIKE ?", srcBase, escapeLike(srcBase)+"/%").Find(&descendants).Error; err != nil {
+				return nil, err
+			}
+			files = append(files, descendants...)
+		}
+	} else {
+		// The drive's root folder stays with its owner; only its contents
+		// move.
+		if err := owned.Where("NOT " + driveRootCondition).Find(&files).Error; err != nil {
+			return nil, err
+		}
+		srcBase = "/"
+		topName = req.From.Email
+	}
+
+	var bytes int64
+	for _, file := range files {
+		if !file.IsDirectory {
+			bytes += file.Size
+		}
+	}
+	if !req.To.HasEnoughSpace(bytes) {
+		return nil, ErrTransferQuota
+	}
+
+	topName, err := freeName(tx, req.To.ID, targetPath, topName)
+	if err != nil {
+		return nil, err
+	}
+	newBase := path.Join(targetPath, topName)
+	outcome := &transferOutcome{TransferResult: TransferResult{Path: newBase, Files: len(files), Bytes: bytes}}
+
+	var parentID *uuid.UUID
+	if target != nil {
+		parentID = &target.ID
+	}
+	if root == nil {
+		folder := models.File{
+			Name:        topName,
+			Path:        targetPath,
+			IsDirectory: true,
+			OwnerID:     req.To.ID,
+			ParentID:    parentID,
+			StoragePath: filepath.Join(req.To.ID.String(), uuid.New().String()),
+		}
+		if err := tx.Create(&folder).Error; err != nil {
+			return nil, err
+		}
+		parentID = &folder.ID
+	}
+
+	ids := make([]uuid.UUID, len(files))
+	for i := range files {
+		ids[i] = files[i].ID
+	}
+	var versions []models.FileVersion
+	if err := tx.Where("file_id IN ?", ids).Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	// Versions can share a blob with their file, so each blob moves once.
+	relocated := make(map[string]string)
+	relocate := func(old string) (string, error) {
+		if moved, ok := relocated[old]; ok {
+			return moved, nil
+		}
+		moved := filepath.Join(s.storage.GetUserStoragePath(req.To.ID), filepath.Base(old))
+		if err := moveBlob(old, moved); err != nil {
+			return "", err
+		}
+		*moves = append(*moves, blobMove{from: old, to: moved})
+		relocated[old] = moved
+		return moved, nil
+	}
+	if err := s.storage.EnsureUserStorage(req.To.ID); err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		updates := map[string]interface{}{"owner_id": req.To.ID}
+		if file.IsDirectory {
+			updates["storage_path"] = filepath.Join(req.To.ID.String(), filepath.Base(file.StoragePath))
+		} else {
+			moved, err := relocate(file.StoragePath)
+			if err != nil {
+				return nil, err
+			}
+			updates["storage_path"] = moved
+		}
+
+		switch {
+		case root != nil && file.ID == root.ID:
+			updates["path"] = targetPath
+			updates["name"] = topName
+			updates["parent_id"] = parentID
+			if topName != file.Name {
+				outcome.renamed = append(outcome.renamed, file.ID)
+			}
+		case root == nil && file.Path == "/":
+			updates["path"] = newBase
+			updates["parent_id"] = parentID
+		default:
+			updates["path"] = path.Join(newBase, strings.TrimPrefix(file.Path, srcBase))
+		}
+
+		if err := tx.Model(&models.File{}).Where("id = ?", file.ID).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for _, version := range versions {
+		moved, err := relocate(version.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&models.FileVersion{}).Where("id = ?", version.ID).Update("storage_path", moved).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Model(&models.FileContent{}).Where("file_id IN ?", ids).Update("owner_id", req.To.ID).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.MediaMetadata{}).Where("file_id IN ?", ids).Update("owner_id", req.To.ID).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(req.From).Update("used_space", gorm.Expr("GREATEST(used_space - ?, 0)", bytes)).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(req.To).Update("used_space", gorm.Expr("used_space + ?", bytes)).Error; err != nil {
+		return nil, err
+	}
+
+	source := "the whole drive"
+	if root != nil {
+		source = srcBase
+	}
+	changes := models.ActivityChanges{
+		"owner": {Before: req.From.Email, After: req.To.Email},
+		"path":  {Before: srcBase, After: newBase},
+	}
+	activities := []models.Activity{
+		{
+			UserID:  req.From.ID,
+			FileID:  req.RootID,
+			Details: fmt.Sprintf("%s transferred %s (%d files) to %s", actor.Email, source, len(files), req.To.Email),
+		},
+		{
+			UserID:  req.To.ID,
+			FileID:  req.RootID,
+			Details: fmt.Sprintf("%s transferred %s (%d files) from %s to %s", actor.Email, source, len(files), req.From.Email, newBase),
+		},
+	}
+	for _, activity := range activities {
+		activity.ActorID = &actor.ID
+		activity.Type = models.ActivityFilesTransferred
+		activity.FileName = topName
+		activity.Changes = changes
+		activity.IPAddress = origin.IPAddress
+		activity.UserAgent = origin.UserAgent
+		activity.Source = origin.Source
+		if err := tx.Create(&activity).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return outcome, nil
+}
+
+// freeName returns name, or the first "name (n)" that is not taken in dir.
+func freeName(tx *gorm.DB, ownerID uuid.UUID, dir, name string) (string, error) {
+	var taken []string
+	err := tx.Model(&models.File{}).
+		Where("owner_id = ? AND path = ? AND is_trashed = false", ownerID, dir).
+		Pluck("name", &taken).Error
+	if err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool, len(taken))
+	for _, existing := range taken {
+		used[existing] = true
+	}
+	return uniqueName(used, name), nil
+}
+
+// moveBlob renames a blob, copying it when the two storage directories are
+// on different file systems. A blob that is already gone is left that way.
+func moveBlob(from, to string) error {
+	if from == to {
+		return nil
+	}
+	err := os.Rename(from, to)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
+func undoBlobMoves(moves []blobMove) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		moveBlob(moves[i].to, moves[i].from)
+	}
+}
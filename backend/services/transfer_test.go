@@ -0,0 +1,210 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+func newTestTransferService(t *testing.T) (*TransferService, *StorageService) {
+	t.Helper()
+	deletions, storage := newTestDeletionService(t, 0)
+	return NewTransferService(storage, deletions.content), storage
+}
+
+func createTestFolder(t *testing.T, owner *models.User, parent *models.File, name string) *models.File {
+	t.Helper()
+	folder := &models.File{
+		Name:        name,
+		Path:        folderPath(parent),
+		IsDirectory: true,
+		OwnerID:     owner.ID,
+		StoragePath: filepath.Join(owner.ID.String(), uuid.New().String()),
+	}
+	if parent != nil {
+		folder.ParentID = &parent.ID
+	}
+	if err := database.DB.Create(folder).Error; err != nil {
+		t.Fatal(err)
+	}
+	return folder
+}
+
+func createTestFileIn(t *testing.T, storage *StorageService, owner *models.User, parent *models.File, name, content string) *models.File {
+	t.Helper()
+	file := createTestFile(t, storage, owner, name, content)
+	updates := map[string]interface{}{"path": folderPath(parent)}
+	if parent != nil {
+		updates["parent_id"] = parent.ID
+	}
+	database.DB.Model(file).Updates(updates)
+	database.DB.Model(owner).Update("used_space", gorm.Expr("used_space + ?", file.Size))
+	return file
+}
+
+func folderPath(folder *models.File) string {
+	if folder == nil {
+		return "/"
+	}
+	return path.Join(folder.Path, folder.Name)
+}
+
+func setQuota(t *testing.T, user *models.User, quota int64) {
+	t.Helper()
+	if err := database.DB.Model(user).Update("quota", quota).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferFolder(t *testing.T) {
+	s, storage := newTestTransferService(t)
+	admin := createTestUser(t, "admin@example.com")
+	from := createTestUser(t, "leaver@example.com")
+	to := createTestUser(t, "manager@example.com")
+	setQuota(t, to, 1<<20)
+
+	reports := createTestFolder(t, from, nil, "Reports")
+	q1 := createTestFolder(t, from, reports, "Q1")
+	summary := createTestFileIn(t, storage, from, q1, "summary.txt", "numbers")
+	trashed := createTestFileIn(t, storage, from, reports, "old.txt", "old")
+	database.DB.Model(trashed).Update("is_trashed", true)
+	unrelated := createTestFileIn(t, storage, from, nil, "Reports-2023.txt", "unrelated")
+
+	// The recipient already has a Reports folder.
+	createTestFolder(t, to, nil, "Reports")
+	database.DB.First(from, "id = ?", from.ID)
+	database.DB.First(to, "id = ?", to.ID)
+
+	result, err := s.Transfer(admin, TransferRequest{From: from, To: to, RootID: &reports.ID, TargetPath: "/"}, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Path != "/Reports (2)" || result.Files != 3 || result.Bytes != summary.Size {
+		t.Errorf("result = %+v", result)
+	}
+
+	var moved models.File
+	database.DB.First(&moved, "id = ?", summary.ID)
+	if moved.OwnerID != to.ID || moved.Path != "/Reports (2)/Q1" {
+		t.Errorf("summary.txt now at %s owned by %s", moved.Path, moved.OwnerID)
+	}
+	if !strings.HasPrefix(moved.StoragePath, storage.GetUserStoragePath(to.ID)) {
+		t.Errorf("blob stayed at %s", moved.StoragePath)
+	}
+	if content, err := os.ReadFile(moved.StoragePath); err != nil || string(content) != "numbers" {
+		t.Errorf("moved blob: %q, %v", content, err)
+	}
+
+	for _, file := range []*models.File{trashed, unrelated} {
+		var kept models.File
+		database.DB.First(&kept, "id = ?", file.ID)
+		if kept.OwnerID != from.ID {
+			t.Errorf("%s was transferred", file.Name)
+		}
+	}
+
+	var fromAfter, toAfter models.User
+	database.DB.First(&fromAfter, "id = ?", from.ID)
+	database.DB.First(&toAfter, "id = ?", to.ID)
+	if toAfter.UsedSpace != summary.Size || fromAfter.UsedSpace != from.UsedSpace-summary.Size {
+		t.Errorf("used space: from %d -> %d, to %d", from.UsedSpace, fromAfter.UsedSpace, toAfter.UsedSpace)
+	}
+
+	var recorded int64
+	database.DB.Model(&models.Activity{}).Where("type = ?", models.ActivityFilesTransferred).Count(&recorded)
+	if recorded != 2 {
+		t.Errorf("%d transfer activities, want one per user", recorded)
+	}
+}
+
+func TestTransferWholeDrive(t *testing.T) {
+	s, storage := newTestTransferService(t)
+	admin := createTestUser(t, "admin@example.com")
+	from := createAccountUser(t, "leaver@example.com", true)
+	to := createTestUser(t, "manager@example.com")
+	setQuota(t, to, 1<<20)
+	var root models.File
+	if err := database.DB.First(&root, "owner_id = ? AND name = ?", from.ID, "root").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	docs := createTestFolder(t, from, nil, "Docs")
+	createTestFileIn(t, storage, from, docs, "a.txt", "a")
+	top := createTestFileIn(t, storage, from, nil, "b.txt", "b")
+	archive := createTestFolder(t, to, nil, "Archive")
+	database.DB.First(to, "id = ?", to.ID)
+
+	result, err := s.Transfer(admin, TransferRequest{From: from, To: to, TargetPath: "/Archive"}, models.ActivityOrigin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Path != "/Archive/leaver@example.com" || result.Files != 3 {
+		t.Errorf("result = %+v", result)
+	}
+
+	var folder models.File
+	if err := database.DB.First(&folder, "owner_id = ? AND path = ? AND name = ?", to.ID, "/Archive", "leaver@example.com").Error; err != nil {
+		t.Fatalf("no folder for the previous owner: %v", err)
+	}
+	if folder.ParentID == nil || *folder.ParentID != archive.ID {
+		t.Error("new folder is not inside the target folder")
+	}
+	var moved models.File
+	database.DB.First(&moved, "id = ?", top.ID)
+	if moved.Path != result.Path || moved.ParentID == nil || *moved.ParentID != folder.ID {
+		t.Errorf("top-level file now at %s with parent %v", moved.Path, moved.ParentID)
+	}
+
+	// The root folder stays behind so the account keeps a usable drive.
+	var kept models.File
+	database.DB.First(&kept, "id = ?", root.ID)
+	if kept.OwnerID != from.ID || kept.Path != "/" {
+		t.Errorf("root folder now owned by %s at %s", kept.OwnerID, kept.Path)
+	}
+	if _, err := s.Transfer(admin, TransferRequest{From: from, To: to, RootID: &root.ID}, models.ActivityOrigin{}); !errors.Is(err, ErrTransferSourceMissing) {
+		t.Errorf("transferring the root folder itself: got %v, want ErrTransferSourceMissing", err)
+	}
+}
+
+func TestTransferRejected(t *testing.T) {
+	s, storage := newTestTransferService(t)
+	admin := createTestUser(t, "admin@example.com")
+	from := createTestUser(t, "leaver@example.com")
+	to := createTestUser(t, "manager@example.com")
+	file := createTestFileIn(t, storage, from, nil, "big.txt", "more than the recipient can hold")
+	setQuota(t, to, 4)
+	database.DB.First(to, "id = ?", to.ID)
+
+	for _, tc := range []struct {
+		name string
+		req  TransferRequest
+		want error
+	}{
+		{"same user", TransferRequest{From: from, To: from}, ErrTransferSameUser},
+		{"missing target", TransferRequest{From: from, To: to, RootID: &file.ID, TargetPath: "/nowhere"}, ErrTransferTargetMissing},
+		{"file of someone else", TransferRequest{From: to, To: from, RootID: &file.ID}, ErrTransferSourceMissing},
+		{"over quota", TransferRequest{From: from, To: to, RootID: &file.ID}, ErrTransferQuota},
+	} {
+		if _, err := s.Transfer(admin, tc.req, models.ActivityOrigin{}); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	var unchanged models.File
+	database.DB.First(&unchanged, "id = ?", file.ID)
+	if unchanged.OwnerID != from.ID || unchanged.StoragePath != file.StoragePath {
+		t.Error("a rejected transfer moved the file")
+	}
+	if _, err := os.Stat(file.StoragePath); err != nil {
+		t.Errorf("blob of a rejected transfer: %v", err)
+	}
+}
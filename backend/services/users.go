@@ -0,0 +1,33 @@
+package services
+
+import (
+	"gorm.io/gorm"
+
+	"stratus/database"
+	"stratus/models"
+)
+
+// CreateUser inserts user together with the root folder every account needs.
+func CreateUser(user *models.User) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return createUser(tx, user)
+	})
+}
+
+func createUser(tx *gorm.DB, user *models.User) error {
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+
+	rootFolder := models.File{
+		Name:        "root",
+		Path:        "/",
+		IsDirectory: true,
+		OwnerID:     user.ID,
+		StoragePath: user.ID.String(),
+	}
+	return tx.Create(&rootFolder).Error
+}
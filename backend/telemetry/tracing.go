@@ -0,0 +1,56 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"stratus/config"
+)
+
+// ServiceName identifies this server in traces unless OTEL_SERVICE_NAME
+// overrides it.
+const ServiceName = "stratus"
+
+// Tracer is used for the spans Stratus creates itself. Until SetupTracing
+// installs a provider it is a no-op.
+var Tracer = otel.Tracer(ServiceName)
+
+// SetupTracing exports spans over OTLP/HTTP when TRACING_ENABLED is set.
+// The collector endpoint and headers are taken from the standard
+// OTEL_EXPORTER_OTLP_* variables and default to localhost:4318. The
+// returned function flushes pending spans and must be called on shutdown.
+func SetupTracing(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	if !cfg.TracingEnabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
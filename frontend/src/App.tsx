@@ -3,6 +3,10 @@ import { useAuthStore } from './stores/authStore'
 import Layout from './components/Layout'
 import Login from './pages/Login'
 import Register from './pages/Register'
+import OidcCallback from './pages/OidcCallback'
+import ForgotPassword from './pages/ForgotPassword'
+import ResetPassword from './pages/ResetPassword'
+import VerifyEmail from './pages/VerifyEmail'
 import Files from './pages/Files'
 import Trash from './pages/Trash'
 import Settings from './pages/Settings'
@@ -16,7 +20,7 @@ function PrivateRoute({ children }: { children: React.ReactNode }) {
 function AdminRoute({ children }: { children: React.ReactNode }) {
   const { isAuthenticated, user } = useAuthStore()
   if (!isAuthenticated) return <Navigate to="/login" />
-  if (!user?.is_admin) return <Navigate to="/files" />
+  if (!user?.permissions?.length) return <Navigate to="/files" />
   return <>{children}</>
 }
 
@@ -25,6 +29,10 @@ function App() {
     <Routes>
       <Route path="/login" element={<Login />} />
       <Route path="/register" element={<Register />} />
+      <Route path="/login/oidc" element={<OidcCallback />} />
+      <Route path="/forgot-password" element={<ForgotPassword />} />
+      <Route path="/reset-password" element={<ResetPassword />} />
+      <Route path="/verify-email" element={<VerifyEmail />} />
       <Route
         path="/"
         element={
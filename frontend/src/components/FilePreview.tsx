@@ -37,7 +37,12 @@ export default function FilePreview({
     setTextContent(null)
 
     try {
-      const response = await api.get(`/api/files/${file.id}/download`, {
+      const useThumbnail = category === 'image' &&
+        !['image/gif', 'image/svg+xml'].includes(file.mime_type)
+      const source = useThumbnail
+        ? `/api/files/${file.id}/thumbnail?size=preview`
+        : `/api/files/${file.id}/download`
+      const response = await api.get(source, {
         responseType: category === 'text' ? 'text' : 'blob',
       })
 
@@ -53,7 +58,7 @@ export default function FilePreview({
     } finally {
       setIsLoading(false)
     }
-  }, [file.id, canPreview, category])
+  }, [file.id, file.mime_type, canPreview, category])
 
   useEffect(() => {
     loadPreview()
@@ -34,7 +34,7 @@ export default function Layout() {
     { to: '/settings', icon: Settings, label: '설정' },
   ]
 
-  if (user?.is_admin) {
+  if (user?.permissions?.length) {
     navItems.push({ to: '/admin', icon: Shield, label: '관리자' })
   }
 
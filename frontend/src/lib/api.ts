@@ -18,9 +18,45 @@ api.interceptors.request.use((config) => {
   return config
 })
 
+const unauthenticatedPaths = ['/api/auth/login', '/api/auth/login/mfa', '/api/auth/register', '/api/auth/refresh', '/api/auth/oidc/exchange']
+
+let refreshPromise: Promise<string | null> | null = null
+
+const refreshAccessToken = async (): Promise<string | null> => {
+  const authStorage = localStorage.getItem('auth-storage')
+  if (!authStorage) return null
+
+  const parsed = JSON.parse(authStorage)
+  const refreshToken = parsed.state?.refreshToken
+  if (!refreshToken) return null
+
+  try {
+    const response = await axios.post('/api/auth/refresh', { refresh_token: refreshToken })
+    const { access_token, refresh_token } = response.data
+    parsed.state = { ...parsed.state, token: access_token, refreshToken: refresh_token }
+    localStorage.setItem('auth-storage', JSON.stringify(parsed))
+    api.defaults.headers.common['Authorization'] = `Bearer ${access_token}`
+    return access_token
+  } catch {
+    return null
+  }
+}
+
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config
+    if (error.response?.status === 401 && original && !original._retry && !unauthenticatedPaths.includes(original.url)) {
+      original._retry = true
+      refreshPromise = refreshPromise ?? refreshAccessToken().finally(() => {
+        refreshPromise = null
+      })
+      const token = await refreshPromise
+      if (token) {
+        original.headers.Authorization = `Bearer ${token}`
+        return api(original)
+      }
+    }
     if (error.response?.status === 401) {
       localStorage.removeItem('auth-storage')
       window.location.href = '/login'
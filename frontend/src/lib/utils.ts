@@ -31,3 +31,12 @@ export function getFileExtension(filename: string): string {
   if (lastDot === -1) return ''
   return filename.substring(lastDot + 1).toLowerCase()
 }
+
+export function downloadBlob(blob: Blob, filename: string) {
+  const url = URL.createObjectURL(blob)
+  const link = document.createElement('a')
+  link.href = url
+  link.download = filename
+  link.click()
+  URL.revokeObjectURL(url)
+}
@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react'
 import { api } from '../lib/api'
-import { formatBytes } from '../lib/utils'
+import { formatBytes, downloadBlob } from '../lib/utils'
 import { format } from 'date-fns'
 import {
   Shield,
@@ -12,6 +12,10 @@ import {
   Edit,
   X,
   Check,
+  Download,
+  RotateCcw,
+  ArrowRightLeft,
+  Upload,
 } from 'lucide-react'
 import { AxiosError } from 'axios'
 
@@ -23,13 +27,60 @@ interface User {
   id: string
   email: string
   username: string
-  is_admin: boolean
+  role: string
   is_active: boolean
   storage_quota: number
   storage_used: number
   created_at: string
 }
 
+interface Role {
+  name: string
+  description: string
+  built_in: boolean
+}
+
+const roleLabels: Record<string, string> = {
+  admin: '관리자',
+  'user-manager': '사용자 관리자',
+  auditor: '감사자',
+  user: '사용자',
+}
+
+interface AdminActivity {
+  id: string
+  type: string
+  user_email: string
+  actor_email?: string
+  file_name?: string
+  details?: string
+  ip_address?: string
+  source?: string
+  created_at: string
+}
+
+interface ActivityTypeCount {
+  type: string
+  count: number
+}
+
+interface ImportRow {
+  line: number
+  email: string
+  status: 'create' | 'created' | 'exists' | 'invalid'
+  errors?: string[]
+  invited?: boolean
+}
+
+interface ImportReport {
+  dry_run: boolean
+  valid: boolean
+  created: number
+  existing: number
+  invalid: number
+  rows: ImportRow[]
+}
+
 interface Stats {
   total_users: number
   total_files: number
@@ -43,10 +94,53 @@ export default function Admin() {
   const [loading, setLoading] = useState(true)
   const [showCreateUser, setShowCreateUser] = useState(false)
   const [editingUser, setEditingUser] = useState<User | null>(null)
-  const [newUser, setNewUser] = useState({ email: '', username: '', password: '', is_admin: false })
+  const [roles, setRoles] = useState<Role[]>([])
+  const [newUser, setNewUser] = useState({ email: '', username: '', password: '', role: 'user' })
+  const [transferringUser, setTransferringUser] = useState<User | null>(null)
+  const [transfer, setTransfer] = useState({ to_user_id: '', target_path: '/' })
+  const [showImport, setShowImport] = useState(false)
+  const [importFile, setImportFile] = useState<File | null>(null)
+  const [importInvite, setImportInvite] = useState(false)
+  const [importReport, setImportReport] = useState<ImportReport | null>(null)
+  const [activities, setActivities] = useState<AdminActivity[]>([])
+  const [activityCounts, setActivityCounts] = useState<ActivityTypeCount[]>([])
+  const [nextCursor, setNextCursor] = useState<string | null>(null)
+  const [activityFilter, setActivityFilter] = useState({ type: '', q: '', ip: '', from: '', to: '' })
+
+  const activityParams = () =>
+    Object.fromEntries(Object.entries(activityFilter).filter(([, value]) => value !== ''))
+
+  const loadActivities = (cursor?: string) => {
+    api
+      .get('/api/admin/activities', { params: { ...activityParams(), cursor } })
+      .then((res) => {
+        setActivities((current) => (cursor ? [...current, ...res.data.activities] : res.data.activities))
+        setNextCursor(res.data.next_cursor || null)
+      })
+      .catch(() => {})
+    if (!cursor) {
+      api
+        .get('/api/admin/activities/stats', { params: activityParams() })
+        .then((res) => setActivityCounts(res.data.by_type))
+        .catch(() => {})
+    }
+  }
+
+  const exportActivities = async () => {
+    try {
+      const res = await api.get('/api/admin/activities/export', {
+        params: activityParams(),
+        responseType: 'blob',
+      })
+      downloadBlob(res.data, `activities-${format(new Date(), 'yyyyMMdd-HHmmss')}.csv`)
+    } catch {
+      alert('활동 기록을 내보내지 못했습니다')
+    }
+  }
 
   useEffect(() => {
     fetchData()
+    loadActivities()
   }, [])
 
   const fetchData = async () => {
@@ -58,6 +152,7 @@ export default function Admin() {
       ])
       setUsers(usersRes.data.users || [])
       setStats(statsRes.data)
+      api.get('/api/admin/roles').then((res) => setRoles(res.data.roles)).catch(() => {})
     } catch (error) {
       console.error('Failed to fetch admin data:', error)
     } finally {
@@ -68,7 +163,7 @@ export default function Admin() {
   const handleCreateUser = async () => {
     try {
       await api.post('/api/admin/users', newUser)
-      setNewUser({ email: '', username: '', password: '', is_admin: false })
+      setNewUser({ email: '', username: '', password: '', role: 'user' })
       setShowCreateUser(false)
       await fetchData()
     } catch (error) {
@@ -81,10 +176,12 @@ export default function Admin() {
     try {
       await api.put(`/api/admin/users/${user.id}`, {
         username: user.username,
-        is_admin: user.is_admin,
         is_active: user.is_active,
         storage_quota: user.storage_quota,
       })
+      if (user.role !== users.find((u) => u.id === user.id)?.role) {
+        await api.put(`/api/admin/users/${user.id}/role`, { role: user.role })
+      }
       setEditingUser(null)
       await fetchData()
     } catch (error) {
@@ -93,12 +190,74 @@ export default function Admin() {
     }
   }
 
+  const handleExportUser = async (user: User) => {
+    try {
+      const res = await api.get(`/api/admin/users/${user.id}/export`, { responseType: 'blob' })
+      downloadBlob(res.data, `${user.email}-export.zip`)
+    } catch {
+      alert('데이터를 내보내지 못했습니다')
+    }
+  }
+
+  const handleTransfer = async () => {
+    if (!transferringUser || !transfer.to_user_id) return
+    try {
+      const res = await api.post('/api/admin/transfers', { from_user_id: transferringUser.id, ...transfer })
+      alert(`${res.data.files}개 항목을 ${res.data.path}(으)로 이전했습니다`)
+      setTransferringUser(null)
+      setTransfer({ to_user_id: '', target_path: '/' })
+      await fetchData()
+    } catch (error) {
+      const axiosError = error as AxiosError<ApiError>
+      alert(axiosError.response?.data?.error || '파일 이전 실패')
+    }
+  }
+
+  const closeImport = () => {
+    setShowImport(false)
+    setImportFile(null)
+    setImportReport(null)
+  }
+
+  const handleImport = async (dryRun: boolean) => {
+    if (!importFile) return
+    const form = new FormData()
+    form.append('file', importFile)
+    try {
+      const res = await api.post('/api/admin/users/import', form, {
+        params: { dry_run: dryRun, invite: importInvite },
+      })
+      setImportReport(res.data)
+      if (!dryRun) await fetchData()
+    } catch (error) {
+      const axiosError = error as AxiosError<ImportReport & ApiError>
+      if (axiosError.response?.data?.rows) {
+        setImportReport(axiosError.response.data)
+      } else {
+        alert(axiosError.response?.data?.error || '사용자 가져오기 실패')
+      }
+    }
+  }
+
+  const handleCancelDeletion = async (id: string) => {
+    try {
+      await api.delete(`/api/admin/users/${id}/deletion`)
+      await fetchData()
+    } catch (error) {
+      const axiosError = error as AxiosError<ApiError>
+      alert(axiosError.response?.data?.error || '삭제 취소 실패')
+    }
+  }
+
   const handleDeleteUser = async (id: string) => {
     if (!confirm('이 사용자를 삭제하시겠습니까? 모든 파일도 함께 삭제됩니다.')) {
       return
     }
     try {
-      await api.delete(`/api/admin/users/${id}`)
+      const res = await api.delete(`/api/admin/users/${id}`)
+      if (new Date(res.data.scheduled_for) > new Date()) {
+        alert(`${format(new Date(res.data.scheduled_for), 'yyyy-MM-dd HH:mm')}에 삭제됩니다. 그 전까지 복원할 수 있습니다.`)
+      }
       await fetchData()
     } catch (error) {
       const axiosError = error as AxiosError<ApiError>
@@ -176,13 +335,22 @@ export default function Admin() {
         <div className="bg-white rounded-lg shadow">
           <div className="flex items-center justify-between p-4 border-b">
             <h2 className="text-lg font-semibold">사용자 관리</h2>
-            <button
-              onClick={() => setShowCreateUser(true)}
-              className="flex items-center gap-2 px-4 py-2 bg-primary-600 text-white rounded-lg hover:bg-primary-700"
-            >
-              <UserPlus className="w-4 h-4" />
-              <span>사용자 추가</span>
-            </button>
+            <div className="flex items-center gap-2">
+              <button
+                onClick={() => setShowImport(true)}
+                className="flex items-center gap-2 px-4 py-2 border rounded-lg hover:bg-gray-100"
+              >
+                <Upload className="w-4 h-4" />
+                <span>가져오기</span>
+              </button>
+              <button
+                onClick={() => setShowCreateUser(true)}
+                className="flex items-center gap-2 px-4 py-2 bg-primary-600 text-white rounded-lg hover:bg-primary-700"
+              >
+                <UserPlus className="w-4 h-4" />
+                <span>사용자 추가</span>
+              </button>
+            </div>
           </div>
 
           <table className="w-full">
@@ -219,12 +387,12 @@ export default function Admin() {
                   <td className="px-4 py-3">
                     <span
                       className={`px-2 py-1 text-xs rounded ${
-                        user.is_admin
+                        user.role !== 'user'
                           ? 'bg-purple-100 text-purple-700'
                           : 'bg-gray-100 text-gray-700'
                       }`}
                     >
-                      {user.is_admin ? '관리자' : '사용자'}
+                      {roleLabels[user.role] || user.role}
                     </span>
                   </td>
                   <td className="px-4 py-3 text-sm">
@@ -235,6 +403,29 @@ export default function Admin() {
                   </td>
                   <td className="px-4 py-3">
                     <div className="flex items-center gap-1">
+                      <button
+                        onClick={() => handleExportUser(user)}
+                        className="p-2 hover:bg-gray-200 rounded"
+                        title="데이터 내보내기"
+                      >
+                        <Download className="w-4 h-4 text-gray-500" />
+                      </button>
+                      <button
+                        onClick={() => setTransferringUser(user)}
+                        className="p-2 hover:bg-gray-200 rounded"
+                        title="파일 이전"
+                      >
+                        <ArrowRightLeft className="w-4 h-4 text-gray-500" />
+                      </button>
+                      {!user.is_active && (
+                        <button
+                          onClick={() => handleCancelDeletion(user.id)}
+                          className="p-2 hover:bg-gray-200 rounded"
+                          title="삭제 취소"
+                        >
+                          <RotateCcw className="w-4 h-4 text-gray-500" />
+                        </button>
+                      )}
                       <button
                         onClick={() => setEditingUser(user)}
                         className="p-2 hover:bg-gray-200 rounded"
@@ -254,6 +445,122 @@ export default function Admin() {
             </tbody>
           </table>
         </div>
+
+        <div className="bg-white rounded-lg shadow mt-6">
+          <div className="flex items-center justify-between p-4 border-b">
+            <h2 className="text-lg font-semibold">활동 기록</h2>
+            <button
+              onClick={exportActivities}
+              className="flex items-center gap-2 px-4 py-2 border rounded-lg hover:bg-gray-50"
+            >
+              <Download className="w-4 h-4" />
+              <span>CSV 내보내기</span>
+            </button>
+          </div>
+
+          <form
+            onSubmit={(e) => {
+              e.preventDefault()
+              loadActivities()
+            }}
+            className="flex flex-wrap items-end gap-2 p-4 border-b text-sm"
+          >
+            <input
+              type="text"
+              value={activityFilter.q}
+              onChange={(e) => setActivityFilter({ ...activityFilter, q: e.target.value })}
+              placeholder="검색어"
+              className="px-3 py-2 border rounded-lg"
+            />
+            <input
+              type="text"
+              value={activityFilter.type}
+              onChange={(e) => setActivityFilter({ ...activityFilter, type: e.target.value })}
+              placeholder="유형 (쉼표로 구분)"
+              className="px-3 py-2 border rounded-lg"
+            />
+            <input
+              type="text"
+              value={activityFilter.ip}
+              onChange={(e) => setActivityFilter({ ...activityFilter, ip: e.target.value })}
+              placeholder="IP 주소"
+              className="px-3 py-2 border rounded-lg"
+            />
+            <input
+              type="date"
+              value={activityFilter.from}
+              onChange={(e) => setActivityFilter({ ...activityFilter, from: e.target.value })}
+              className="px-3 py-2 border rounded-lg"
+            />
+            <input
+              type="date"
+              value={activityFilter.to}
+              onChange={(e) => setActivityFilter({ ...activityFilter, to: e.target.value })}
+              className="px-3 py-2 border rounded-lg"
+            />
+            <button type="submit" className="px-4 py-2 bg-primary-600 text-white rounded-lg hover:bg-primary-700">
+              조회
+            </button>
+          </form>
+
+          {activityCounts.length > 0 && (
+            <div className="flex flex-wrap gap-2 p-4 border-b">
+              {activityCounts.map((count) => (
+                <button
+                  key={count.type}
+                  onClick={() => setActivityFilter({ ...activityFilter, type: count.type })}
+                  className="px-2 py-1 text-xs rounded bg-gray-100 text-gray-700 hover:bg-gray-200"
+                >
+                  {count.type} · {count.count}
+                </button>
+              ))}
+            </div>
+          )}
+
+          <table className="w-full text-sm">
+            <thead className="bg-gray-50 border-b">
+              <tr>
+                <th className="px-4 py-3 text-left font-medium text-gray-600">시간</th>
+                <th className="px-4 py-3 text-left font-medium text-gray-600">사용자</th>
+                <th className="px-4 py-3 text-left font-medium text-gray-600">유형</th>
+                <th className="px-4 py-3 text-left font-medium text-gray-600">내용</th>
+                <th className="px-4 py-3 text-left font-medium text-gray-600">IP</th>
+              </tr>
+            </thead>
+            <tbody>
+              {activities.map((activity) => (
+                <tr key={activity.id} className="border-b hover:bg-gray-50">
+                  <td className="px-4 py-3 text-gray-500 whitespace-nowrap">
+                    {format(new Date(activity.created_at), 'yyyy-MM-dd HH:mm:ss')}
+                  </td>
+                  <td className="px-4 py-3">
+                    <p>{activity.user_email}</p>
+                    {activity.actor_email && activity.actor_email !== activity.user_email && (
+                      <p className="text-gray-400">by {activity.actor_email}</p>
+                    )}
+                  </td>
+                  <td className="px-4 py-3">{activity.type}</td>
+                  <td className="px-4 py-3 text-gray-600">
+                    {activity.file_name && <p className="font-medium">{activity.file_name}</p>}
+                    {activity.details && <p>{activity.details}</p>}
+                  </td>
+                  <td className="px-4 py-3 text-gray-500">{activity.ip_address}</td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+
+          {nextCursor && (
+            <div className="p-4 text-center">
+              <button
+                onClick={() => loadActivities(nextCursor)}
+                className="px-4 py-2 text-sm border rounded-lg hover:bg-gray-50"
+              >
+                더 보기
+              </button>
+            </div>
+          )}
+        </div>
       </div>
 
       {showCreateUser && (
@@ -293,15 +600,17 @@ export default function Admin() {
                 placeholder="비밀번호"
                 className="w-full px-4 py-2 border rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500"
               />
-              <label className="flex items-center gap-2">
-                <input
-                  type="checkbox"
-                  checked={newUser.is_admin}
-                  onChange={(e) => setNewUser({ ...newUser, is_admin: e.target.checked })}
-                  className="rounded"
-                />
-                <span>관리자 권한 부여</span>
-              </label>
+              <select
+                value={newUser.role}
+                onChange={(e) => setNewUser({ ...newUser, role: e.target.value })}
+                className="w-full px-4 py-2 border rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500"
+              >
+                {roles.map((role) => (
+                  <option key={role.name} value={role.name}>
+                    {roleLabels[role.name] || role.name}
+                  </option>
+                ))}
+              </select>
             </div>
             <div className="flex justify-end gap-2 mt-6">
               <button
@@ -321,6 +630,146 @@ export default function Admin() {
         </div>
       )}
 
+      {showImport && (
+        <div className="fixed inset-0 bg-black/50 flex items-center justify-center z-50" onClick={closeImport}>
+          <div
+            className="bg-white rounded-lg shadow-xl p-6 w-full max-w-lg"
+            onClick={(e) => e.stopPropagation()}
+          >
+            <div className="flex items-center justify-between mb-4">
+              <h2 className="text-lg font-semibold">사용자 가져오기</h2>
+              <button onClick={closeImport} className="p-1 hover:bg-gray-100 rounded">
+                <X className="w-5 h-5" />
+              </button>
+            </div>
+            <p className="text-sm text-gray-600 mb-4">
+              email, display_name, quota, role, active 열이 있는 CSV 또는 JSON 파일을 올립니다. 먼저 검증한 뒤 가져오세요.
+            </p>
+            <div className="space-y-4">
+              <input
+                type="file"
+                accept=".csv,.json,text/csv,application/json"
+                onChange={(e) => {
+                  setImportFile(e.target.files?.[0] || null)
+                  setImportReport(null)
+                }}
+                className="w-full text-sm"
+              />
+              <label className="flex items-center gap-2 text-sm">
+                <input
+                  type="checkbox"
+                  checked={importInvite}
+                  onChange={(e) => setImportInvite(e.target.checked)}
+                />
+                <span>비밀번호 설정 초대 메일 보내기</span>
+              </label>
+              {importReport && (
+                <div className="text-sm">
+                  <p className="mb-2">
+                    {importReport.dry_run
+                      ? `생성 예정 ${importReport.rows.filter((row) => row.status === 'create').length}명`
+                      : `${importReport.created}명 생성`}
+                    , 기존 {importReport.existing}명, 오류 {importReport.invalid}건
+                  </p>
+                  {importReport.invalid > 0 && (
+                    <ul className="max-h-40 overflow-y-auto border rounded p-2 space-y-1">
+                      {importReport.rows
+                        .filter((row) => row.status === 'invalid')
+                        .map((row) => (
+                          <li key={row.line} className="text-red-600">
+                            {row.line}행 {row.email}: {row.errors?.join(', ')}
+                          </li>
+                        ))}
+                    </ul>
+                  )}
+                </div>
+              )}
+            </div>
+            <div className="flex justify-end gap-2 mt-6">
+              <button
+                onClick={() => handleImport(true)}
+                disabled={!importFile}
+                className="px-4 py-2 border rounded-lg hover:bg-gray-100 disabled:opacity-50"
+              >
+                검증
+              </button>
+              <button
+                onClick={() => handleImport(false)}
+                disabled={!importReport?.valid || !importReport.dry_run}
+                className="px-4 py-2 bg-primary-600 text-white rounded-lg hover:bg-primary-700 disabled:opacity-50"
+              >
+                가져오기
+              </button>
+            </div>
+          </div>
+        </div>
+      )}
+
+      {transferringUser && (
+        <div
+          className="fixed inset-0 bg-black/50 flex items-center justify-center z-50"
+          onClick={() => setTransferringUser(null)}
+        >
+          <div
+            className="bg-white rounded-lg shadow-xl p-6 w-full max-w-md"
+            onClick={(e) => e.stopPropagation()}
+          >
+            <div className="flex items-center justify-between mb-4">
+              <h2 className="text-lg font-semibold">파일 이전</h2>
+              <button onClick={() => setTransferringUser(null)} className="p-1 hover:bg-gray-100 rounded">
+                <X className="w-5 h-5" />
+              </button>
+            </div>
+            <p className="text-sm text-gray-600 mb-4">
+              {transferringUser.email}의 모든 파일을 다른 사용자에게 넘깁니다. 휴지통의 파일은 이전되지 않습니다.
+            </p>
+            <div className="space-y-4">
+              <div>
+                <label className="block text-sm font-medium text-gray-700 mb-1">받는 사용자</label>
+                <select
+                  value={transfer.to_user_id}
+                  onChange={(e) => setTransfer({ ...transfer, to_user_id: e.target.value })}
+                  className="w-full px-4 py-2 border rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500"
+                >
+                  <option value="">선택하세요</option>
+                  {users
+                    .filter((user) => user.id !== transferringUser.id)
+                    .map((user) => (
+                      <option key={user.id} value={user.id}>
+                        {user.email}
+                      </option>
+                    ))}
+                </select>
+              </div>
+              <div>
+                <label className="block text-sm font-medium text-gray-700 mb-1">대상 폴더</label>
+                <input
+                  type="text"
+                  value={transfer.target_path}
+                  onChange={(e) => setTransfer({ ...transfer, target_path: e.target.value })}
+                  className="w-full px-4 py-2 border rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500"
+                />
+              </div>
+            </div>
+            <div className="flex justify-end gap-2 mt-6">
+              <button
+                onClick={() => setTransferringUser(null)}
+                className="px-4 py-2 border rounded-lg hover:bg-gray-100"
+              >
+                취소
+              </button>
+              <button
+                onClick={handleTransfer}
+                disabled={!transfer.to_user_id}
+                className="px-4 py-2 bg-primary-600 text-white rounded-lg hover:bg-primary-700 disabled:opacity-50"
+              >
+                이전
+              </button>
+            </div>
+          </div>
+        </div>
+      )}
+
       {editingUser && (
         <div
           className="fixed inset-0 bg-black/50 flex items-center justify-center z-50"
@@ -364,15 +813,20 @@ export default function Admin() {
                   className="w-full px-4 py-2 border rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500"
                 />
               </div>
-              <label className="flex items-center gap-2">
-                <input
-                  type="checkbox"
-                  checked={editingUser.is_admin}
-                  onChange={(e) => setEditingUser({ ...editingUser, is_admin: e.target.checked })}
-                  className="rounded"
-                />
-                <span>관리자</span>
-              </label>
+              <div>
+                <label className="block text-sm font-medium text-gray-700 mb-1">역할</label>
+                <select
+                  value={editingUser.role}
+                  onChange={(e) => setEditingUser({ ...editingUser, role: e.target.value })}
+                  className="w-full px-4 py-2 border rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500"
+                >
+                  {roles.map((role) => (
+                    <option key={role.name} value={role.name}>
+                      {roleLabels[role.name] || role.name}
+                    </option>
+                  ))}
+                </select>
+              </div>
               <label className="flex items-center gap-2">
                 <input
                   type="checkbox"
@@ -0,0 +1,87 @@
+import { useState } from 'react'
+import { Link } from 'react-router-dom'
+import { Cloud, Mail, AlertCircle, CheckCircle } from 'lucide-react'
+import { AxiosError } from 'axios'
+import { api } from '../lib/api'
+
+interface ApiError {
+  error?: string
+}
+
+export default function ForgotPassword() {
+  const [email, setEmail] = useState('')
+  const [error, setError] = useState('')
+  const [sent, setSent] = useState(false)
+  const [loading, setLoading] = useState(false)
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+    setLoading(true)
+
+    try {
+      await api.post('/api/auth/password/forgot', { email })
+      setSent(true)
+    } catch (err) {
+      const axiosError = err as AxiosError<ApiError>
+      setError(axiosError.response?.data?.error || '요청에 실패했습니다.')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-primary-600 to-primary-800 flex items-center justify-center p-4">
+      <div className="bg-white rounded-2xl shadow-xl w-full max-w-md p-8">
+        <div className="text-center mb-8">
+          <div className="inline-flex items-center justify-center w-16 h-16 bg-primary-100 rounded-full mb-4">
+            <Cloud className="w-8 h-8 text-primary-600" />
+          </div>
+          <h1 className="text-2xl font-bold text-gray-900">비밀번호 재설정</h1>
+          <p className="text-gray-500 mt-2">가입한 이메일로 재설정 링크를 보내드립니다</p>
+        </div>
+
+        {error && (
+          <div className="mb-6 p-4 bg-red-50 border border-red-200 rounded-lg flex items-center gap-3 text-red-700">
+            <AlertCircle className="w-5 h-5 flex-shrink-0" />
+            <span className="text-sm">{error}</span>
+          </div>
+        )}
+
+        {sent ? (
+          <div className="mb-6 p-4 bg-green-50 border border-green-200 rounded-lg flex items-center gap-3 text-green-700">
+            <CheckCircle className="w-5 h-5 flex-shrink-0" />
+            <span className="text-sm">계정이 있다면 재설정 링크가 이메일로 전송되었습니다.</span>
+          </div>
+        ) : (
+          <form onSubmit={handleSubmit} className="space-y-5">
+            <div className="relative">
+              <Mail className="absolute left-3 top-1/2 -translate-y-1/2 w-5 h-5 text-gray-400" />
+              <input
+                type="email"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                required
+                className="w-full pl-10 pr-4 py-3 border border-gray-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500 focus:border-transparent"
+                placeholder="your@email.com"
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full py-3 bg-primary-600 text-white font-medium rounded-lg hover:bg-primary-700 disabled:opacity-50 disabled:cursor-not-allowed transition-colors"
+            >
+              {loading ? '전송 중...' : '재설정 링크 보내기'}
+            </button>
+          </form>
+        )}
+
+        <p className="text-center text-gray-600 mt-6">
+          <Link to="/login" className="text-primary-600 hover:text-primary-700 font-medium">
+            로그인으로 돌아가기
+          </Link>
+        </p>
+      </div>
+    </div>
+  )
+}
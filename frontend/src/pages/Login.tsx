@@ -1,18 +1,30 @@
-import { useState } from 'react'
-import { Link, useNavigate } from 'react-router-dom'
+import { useEffect, useState } from 'react'
+import { Link, useLocation, useNavigate } from 'react-router-dom'
 import { useAuthStore } from '../stores/authStore'
-import { Cloud, Mail, Lock, AlertCircle } from 'lucide-react'
+import { api } from '../lib/api'
+import { Cloud, Mail, Lock, AlertCircle, ShieldCheck } from 'lucide-react'
 import { AxiosError } from 'axios'
 
 interface ApiError {
   error?: string
+  retry_after?: number
 }
 
 export default function Login() {
   const navigate = useNavigate()
-  const { login } = useAuthStore()
+  const location = useLocation()
+  const { login, verifyMfa } = useAuthStore()
   const [email, setEmail] = useState('')
   const [password, setPassword] = useState('')
+  // SSO logins of accounts with 2FA arrive here for the second factor.
+  const [mfaStep, setMfaStep] = useState(Boolean((location.state as { mfa?: boolean } | null)?.mfa))
+  const [code, setCode] = useState('')
+  const [useRecovery, setUseRecovery] = useState(false)
+  const [sso, setSso] = useState<{ enabled: boolean; provider_name: string } | null>(null)
+
+  useEffect(() => {
+    api.get('/api/auth/oidc/config').then((res) => setSso(res.data)).catch(() => {})
+  }, [])
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
 
@@ -22,11 +34,22 @@ export default function Login() {
     setLoading(true)
 
     try {
-      await login(email, password)
-      navigate('/files')
+      if (mfaStep) {
+        await verifyMfa(code, useRecovery ? code : undefined)
+        navigate('/files')
+      } else if (await login(email, password)) {
+        setMfaStep(true)
+      } else {
+        navigate('/files')
+      }
     } catch (err) {
       const axiosError = err as AxiosError<ApiError>
-      setError(axiosError.response?.data?.error || '로그인에 실패했습니다.')
+      if (axiosError.response?.status === 429) {
+        const retryAfter = axiosError.response.data?.retry_after
+        setError(`로그인 시도가 너무 많습니다. ${retryAfter ? `${retryAfter}초 후에 ` : '잠시 후 '}다시 시도해주세요.`)
+      } else {
+        setError(axiosError.response?.data?.error || '로그인에 실패했습니다.')
+      }
     } finally {
       setLoading(false)
     }
@@ -51,6 +74,35 @@ export default function Login() {
         )}
 
         <form onSubmit={handleSubmit} className="space-y-5">
+          {mfaStep ? (
+          <div>
+            <label className="block text-sm font-medium text-gray-700 mb-1">
+              {useRecovery ? '복구 코드' : '인증 코드'}
+            </label>
+            <div className="relative">
+              <ShieldCheck className="absolute left-3 top-1/2 -translate-y-1/2 w-5 h-5 text-gray-400" />
+              <input
+                type="text"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                required
+                autoFocus
+                autoComplete="one-time-code"
+                inputMode={useRecovery ? 'text' : 'numeric'}
+                className="w-full pl-10 pr-4 py-3 border border-gray-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500 focus:border-transparent"
+                placeholder={useRecovery ? 'xxxxx-xxxxx' : '123456'}
+              />
+            </div>
+            <button
+              type="button"
+              onClick={() => { setUseRecovery(!useRecovery); setCode('') }}
+              className="mt-2 text-sm text-primary-600 hover:text-primary-700"
+            >
+              {useRecovery ? '인증 앱 코드 사용' : '복구 코드 사용'}
+            </button>
+          </div>
+          ) : (
+          <>
           <div>
             <label className="block text-sm font-medium text-gray-700 mb-1">
               이메일
@@ -58,7 +110,8 @@ export default function Login() {
             <div className="relative">
               <Mail className="absolute left-3 top-1/2 -translate-y-1/2 w-5 h-5 text-gray-400" />
               <input
-                type="email"
+                type="text"
+                autoComplete="username"
                 value={email}
                 onChange={(e) => setEmail(e.target.value)}
                 required
@@ -84,6 +137,16 @@ export default function Login() {
               />
             </div>
           </div>
+          </>
+          )}
+
+          {!mfaStep && (
+            <div className="text-right -mt-2">
+              <Link to="/forgot-password" className="text-sm text-primary-600 hover:text-primary-700">
+                비밀번호를 잊으셨나요?
+              </Link>
+            </div>
+          )}
 
           <button
             type="submit"
@@ -94,6 +157,15 @@ export default function Login() {
           </button>
         </form>
 
+        {sso?.enabled && !mfaStep && (
+          <a
+            href="/api/auth/oidc/login"
+            className="mt-4 block w-full py-3 text-center border border-gray-300 text-gray-700 font-medium rounded-lg hover:bg-gray-50 transition-colors"
+          >
+            {sso.provider_name}(으)로 로그인
+          </a>
+        )}
+
         <p className="text-center text-gray-600 mt-6">
           계정이 없으신가요?{' '}
           <Link to="/register" className="text-primary-600 hover:text-primary-700 font-medium">
@@ -0,0 +1,47 @@
+import { useEffect, useRef, useState } from 'react'
+import { Link, useNavigate, useSearchParams } from 'react-router-dom'
+import { useAuthStore } from '../stores/authStore'
+import { Cloud, AlertCircle } from 'lucide-react'
+
+export default function OidcCallback() {
+  const navigate = useNavigate()
+  const [params] = useSearchParams()
+  const { exchangeOidcCode } = useAuthStore()
+  const [error, setError] = useState(params.get('error') || '')
+  const started = useRef(false)
+
+  useEffect(() => {
+    const code = params.get('code')
+    if (!code || started.current) return
+    started.current = true
+
+    exchangeOidcCode(code)
+      .then((mfaRequired) =>
+        navigate(mfaRequired ? '/login' : '/files', { replace: true, state: mfaRequired ? { mfa: true } : undefined })
+      )
+      .catch(() => setError('SSO 로그인에 실패했습니다.'))
+  }, [params, exchangeOidcCode, navigate])
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-primary-600 to-primary-800 flex items-center justify-center p-4">
+      <div className="bg-white rounded-2xl shadow-xl w-full max-w-md p-8 text-center">
+        <div className="inline-flex items-center justify-center w-16 h-16 bg-primary-100 rounded-full mb-4">
+          <Cloud className="w-8 h-8 text-primary-600" />
+        </div>
+        {error ? (
+          <>
+            <div className="mb-6 p-4 bg-red-50 border border-red-200 rounded-lg flex items-center gap-3 text-red-700 text-left">
+              <AlertCircle className="w-5 h-5 flex-shrink-0" />
+              <span className="text-sm">{error}</span>
+            </div>
+            <Link to="/login" className="text-primary-600 hover:text-primary-700 font-medium">
+              로그인으로 돌아가기
+            </Link>
+          </>
+        ) : (
+          <p className="text-gray-500">로그인 중...</p>
+        )}
+      </div>
+    </div>
+  )
+}
@@ -0,0 +1,96 @@
+import { useState } from 'react'
+import { Link, useNavigate, useSearchParams } from 'react-router-dom'
+import { Cloud, Lock, AlertCircle } from 'lucide-react'
+import { AxiosError } from 'axios'
+import { api } from '../lib/api'
+
+interface ApiError {
+  error?: string
+}
+
+export default function ResetPassword() {
+  const navigate = useNavigate()
+  const [params] = useSearchParams()
+  const [password, setPassword] = useState('')
+  const [confirmPassword, setConfirmPassword] = useState('')
+  const [error, setError] = useState('')
+  const [loading, setLoading] = useState(false)
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+
+    if (password !== confirmPassword) {
+      setError('비밀번호가 일치하지 않습니다.')
+      return
+    }
+
+    setLoading(true)
+    try {
+      await api.post('/api/auth/password/reset', {
+        token: params.get('token'),
+        new_password: password,
+      })
+      navigate('/login', { replace: true })
+    } catch (err) {
+      const axiosError = err as AxiosError<ApiError>
+      setError(axiosError.response?.data?.error || '비밀번호 재설정에 실패했습니다.')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-primary-600 to-primary-800 flex items-center justify-center p-4">
+      <div className="bg-white rounded-2xl shadow-xl w-full max-w-md p-8">
+        <div className="text-center mb-8">
+          <div className="inline-flex items-center justify-center w-16 h-16 bg-primary-100 rounded-full mb-4">
+            <Cloud className="w-8 h-8 text-primary-600" />
+          </div>
+          <h1 className="text-2xl font-bold text-gray-900">새 비밀번호 설정</h1>
+        </div>
+
+        {error && (
+          <div className="mb-6 p-4 bg-red-50 border border-red-200 rounded-lg flex items-center gap-3 text-red-700">
+            <AlertCircle className="w-5 h-5 flex-shrink-0" />
+            <span className="text-sm">{error}</span>
+          </div>
+        )}
+
+        <form onSubmit={handleSubmit} className="space-y-5">
+          {[
+            { value: password, set: setPassword, placeholder: '새 비밀번호' },
+            { value: confirmPassword, set: setConfirmPassword, placeholder: '새 비밀번호 확인' },
+          ].map((field) => (
+            <div key={field.placeholder} className="relative">
+              <Lock className="absolute left-3 top-1/2 -translate-y-1/2 w-5 h-5 text-gray-400" />
+              <input
+                type="password"
+                value={field.value}
+                onChange={(e) => field.set(e.target.value)}
+                required
+                minLength={8}
+                autoComplete="new-password"
+                className="w-full pl-10 pr-4 py-3 border border-gray-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-primary-500 focus:border-transparent"
+                placeholder={field.placeholder}
+              />
+            </div>
+          ))}
+          <button
+            type="submit"
+            disabled={loading}
+            className="w-full py-3 bg-primary-600 text-white font-medium rounded-lg hover:bg-primary-700 disabled:opacity-50 disabled:cursor-not-allowed transition-colors"
+          >
+            {loading ? '변경 중...' : '비밀번호 변경'}
+          </button>
+        </form>
+
+        <p className="text-center text-gray-600 mt-6">
+          <Link to="/login" className="text-primary-600 hover:text-primary-700 font-medium">
+            로그인으로 돌아가기
+          </Link>
+        </p>
+      </div>
+    </div>
+  )
+}
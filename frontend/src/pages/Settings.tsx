@@ -1,14 +1,64 @@
-import { useState } from 'react'
+import { useEffect, useState } from 'react'
 import { useAuthStore } from '../stores/authStore'
 import { api } from '../lib/api'
-import { formatBytes } from '../lib/utils'
-import { Settings as SettingsIcon, User, HardDrive, Key, Check } from 'lucide-react'
+import { formatBytes, downloadBlob } from '../lib/utils'
+import { format } from 'date-fns'
+import { Settings as SettingsIcon, User, HardDrive, Key, Check, Eye, History, Download } from 'lucide-react'
 import { AxiosError } from 'axios'
 
 interface ApiError {
   error?: string
 }
 
+interface Impersonation {
+  id: string
+  reason: string
+  read_only: boolean
+  expires_at: string
+  ended_at?: string
+  created_at: string
+  impersonator?: { email: string; display_name: string }
+}
+
+interface ActivityItem {
+  id: string
+  type: string
+  file_name?: string
+  details?: string
+  source?: string
+  created_at: string
+  actor?: { email: string; display_name: string }
+}
+
+const activityLabels: Record<string, string> = {
+  file_created: '파일 업로드',
+  file_updated: '파일 수정',
+  file_deleted: '파일 삭제',
+  file_moved: '파일 이동',
+  file_renamed: '이름 변경',
+  file_copied: '파일 복사',
+  file_trashed: '휴지통으로 이동',
+  file_restored: '휴지통에서 복원',
+  file_downloaded: '파일 다운로드',
+  folder_created: '폴더 생성',
+  trash_emptied: '휴지통 비우기',
+  user_login: '로그인',
+  user_logout: '로그아웃',
+  login_failed: '로그인 실패',
+  password_changed: '비밀번호 변경',
+  password_reset: '비밀번호 재설정',
+  profile_updated: '프로필 변경',
+  user_data_exported: '데이터 내보내기',
+}
+
+const sourceLabels: Record<string, string> = {
+  web: '웹',
+  webdav: 'WebDAV',
+  api: 'API',
+  system: '시스템',
+  scim: 'SCIM',
+}
+
 export default function Settings() {
   const { user } = useAuthStore()
   const [currentPassword, setCurrentPassword] = useState('')
@@ -16,6 +66,38 @@ export default function Settings() {
   const [confirmPassword, setConfirmPassword] = useState('')
   const [loading, setLoading] = useState(false)
   const [message, setMessage] = useState<{ type: 'success' | 'error'; text: string } | null>(null)
+  const [impersonations, setImpersonations] = useState<Impersonation[]>([])
+  const [activities, setActivities] = useState<ActivityItem[]>([])
+  const [nextBefore, setNextBefore] = useState<string | null>(null)
+
+  const [exporting, setExporting] = useState(false)
+
+  const exportData = async () => {
+    setExporting(true)
+    try {
+      const res = await api.get('/api/auth/export', { responseType: 'blob' })
+      downloadBlob(res.data, `stratus-export-${format(new Date(), 'yyyyMMdd')}.zip`)
+    } catch {
+      setMessage({ type: 'error', text: '데이터를 내보내지 못했습니다' })
+    } finally {
+      setExporting(false)
+    }
+  }
+
+  const loadActivity = (before?: string) => {
+    api
+      .get('/api/activity', { params: { limit: 20, before } })
+      .then((res) => {
+        setActivities((current) => (before ? [...current, ...res.data.activities] : res.data.activities))
+        setNextBefore(res.data.next_before || null)
+      })
+      .catch(() => {})
+  }
+
+  useEffect(() => {
+    api.get('/api/auth/impersonations').then((res) => setImpersonations(res.data)).catch(() => {})
+    loadActivity()
+  }, [])
 
   const handleChangePassword = async (e: React.FormEvent) => {
     e.preventDefault()
@@ -96,6 +178,15 @@ export default function Settings() {
               {storagePercent.toFixed(1)}% 사용 중
             </p>
           </div>
+
+          <button
+            onClick={exportData}
+            disabled={exporting}
+            className="flex items-center gap-2 mt-4 px-4 py-2 text-sm border rounded-lg hover:bg-gray-50 disabled:opacity-50"
+          >
+            <Download className="w-4 h-4" />
+            <span>{exporting ? '내보내는 중...' : '내 데이터 내보내기 (ZIP)'}</span>
+          </button>
         </div>
 
         <div className="bg-white rounded-lg shadow p-6">
@@ -170,6 +261,77 @@ export default function Settings() {
             </button>
           </form>
         </div>
+
+        <div className="bg-white rounded-lg shadow p-6">
+          <div className="flex items-center gap-3 mb-4">
+            <History className="w-5 h-5 text-gray-500" />
+            <h2 className="text-lg font-semibold">내 활동</h2>
+          </div>
+
+          {activities.length === 0 ? (
+            <p className="text-sm text-gray-500">활동 기록이 없습니다.</p>
+          ) : (
+            <ul className="divide-y">
+              {activities.map((activity) => (
+                <li key={activity.id} className="py-3 text-sm">
+                  <div className="flex justify-between">
+                    <span className="font-medium text-gray-900">
+                      {activityLabels[activity.type] || activity.type}
+                      {activity.file_name && <span className="font-normal text-gray-600"> · {activity.file_name}</span>}
+                    </span>
+                    <span className="text-gray-500">
+                      {format(new Date(activity.created_at), 'yyyy-MM-dd HH:mm')}
+                    </span>
+                  </div>
+                  {activity.details && <p className="text-gray-600 mt-1">{activity.details}</p>}
+                  <p className="text-gray-400 mt-1">
+                    {sourceLabels[activity.source || ''] || activity.source || '알 수 없음'}
+                    {activity.actor && ` · ${activity.actor.display_name || activity.actor.email}`}
+                  </p>
+                </li>
+              ))}
+            </ul>
+          )}
+
+          {nextBefore && (
+            <button
+              onClick={() => loadActivity(nextBefore)}
+              className="mt-4 text-sm text-primary-600 hover:text-primary-700"
+            >
+              더 보기
+            </button>
+          )}
+        </div>
+
+        {impersonations.length > 0 && (
+          <div className="bg-white rounded-lg shadow p-6">
+            <div className="flex items-center gap-3 mb-2">
+              <Eye className="w-5 h-5 text-gray-500" />
+              <h2 className="text-lg font-semibold">관리자 접근 기록</h2>
+            </div>
+            <p className="text-sm text-gray-500 mb-4">관리자가 지원을 위해 내 계정으로 접속한 기록입니다.</p>
+
+            <ul className="divide-y">
+              {impersonations.map((impersonation) => (
+                <li key={impersonation.id} className="py-3 text-sm">
+                  <div className="flex justify-between">
+                    <span className="font-medium text-gray-900">
+                      {impersonation.impersonator?.display_name || impersonation.impersonator?.email || '관리자'}
+                    </span>
+                    <span className="text-gray-500">
+                      {format(new Date(impersonation.created_at), 'yyyy-MM-dd HH:mm')}
+                    </span>
+                  </div>
+                  <p className="text-gray-600 mt-1">{impersonation.reason}</p>
+                  <p className="text-gray-400 mt-1">
+                    {impersonation.read_only ? '읽기 전용' : '전체 권한'} ·{' '}
+                    {format(new Date(impersonation.ended_at || impersonation.expires_at), 'HH:mm')}까지
+                  </p>
+                </li>
+              ))}
+            </ul>
+          </div>
+        )}
       </div>
     </div>
   )
@@ -0,0 +1,55 @@
+import { useEffect, useRef, useState } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { Cloud, AlertCircle, CheckCircle } from 'lucide-react'
+import { AxiosError } from 'axios'
+import { api } from '../lib/api'
+
+interface ApiError {
+  error?: string
+}
+
+export default function VerifyEmail() {
+  const [params] = useSearchParams()
+  const [status, setStatus] = useState<'pending' | 'done' | 'error'>('pending')
+  const [message, setMessage] = useState('')
+  const started = useRef(false)
+
+  useEffect(() => {
+    if (started.current) return
+    started.current = true
+
+    api.post('/api/auth/email/verify', { token: params.get('token') })
+      .then((res) => {
+        setStatus('done')
+        setMessage(`${res.data.email} 주소가 확인되었습니다.`)
+      })
+      .catch((err: AxiosError<ApiError>) => {
+        setStatus('error')
+        setMessage(err.response?.data?.error || '이메일 확인에 실패했습니다.')
+      })
+  }, [params])
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-primary-600 to-primary-800 flex items-center justify-center p-4">
+      <div className="bg-white rounded-2xl shadow-xl w-full max-w-md p-8 text-center">
+        <div className="inline-flex items-center justify-center w-16 h-16 bg-primary-100 rounded-full mb-4">
+          <Cloud className="w-8 h-8 text-primary-600" />
+        </div>
+        {status === 'pending' && <p className="text-gray-500">확인 중...</p>}
+        {status !== 'pending' && (
+          <div
+            className={`mb-6 p-4 rounded-lg flex items-center gap-3 text-left border ${
+              status === 'done' ? 'bg-green-50 border-green-200 text-green-700' : 'bg-red-50 border-red-200 text-red-700'
+            }`}
+          >
+            {status === 'done' ? <CheckCircle className="w-5 h-5 flex-shrink-0" /> : <AlertCircle className="w-5 h-5 flex-shrink-0" />}
+            <span className="text-sm">{message}</span>
+          </div>
+        )}
+        <Link to="/login" className="text-primary-600 hover:text-primary-700 font-medium">
+          로그인으로 이동
+        </Link>
+      </div>
+    </div>
+  )
+}
@@ -5,17 +5,22 @@ import { api } from '../lib/api'
 interface User {
   id: string
   email: string
-  is_admin: boolean
+  role: string
+  permissions: string[]
   storage_quota: number
   storage_used: number
 }
 
 interface AuthState {
   token: string | null
+  refreshToken: string | null
   user: User | null
   isAuthenticated: boolean
-  login: (email: string, password: string) => Promise<void>
-  register: (email: string, password: string) => Promise<void>
+  mfaToken: string | null
+  login: (email: string, password: string) => Promise<boolean>
+  verifyMfa: (code: string, recoveryCode?: string) => Promise<void>
+  exchangeOidcCode: (code: string) => Promise<boolean>
+  register: (email: string, password: string, inviteCode?: string) => Promise<void>
   logout: () => void
   fetchUser: () => Promise<void>
 }
@@ -24,22 +29,58 @@ export const useAuthStore = create<AuthState>()(
   persist(
     (set, get) => ({
       token: null,
+      refreshToken: null,
       user: null,
       isAuthenticated: false,
+      mfaToken: null,
 
       login: async (email: string, password: string) => {
         const response = await api.post('/api/auth/login', { email, password })
-        const { access_token, user } = response.data
-        set({ token: access_token, user, isAuthenticated: true })
+        if (response.data.mfa_required) {
+          set({ mfaToken: response.data.mfa_token })
+          return true
+        }
+        const { access_token, refresh_token, user } = response.data
+        set({ token: access_token, refreshToken: refresh_token, user, isAuthenticated: true })
+        api.defaults.headers.common['Authorization'] = `Bearer ${access_token}`
+        return false
+      },
+
+      verifyMfa: async (code: string, recoveryCode?: string) => {
+        const response = await api.post('/api/auth/login/mfa', {
+          mfa_token: get().mfaToken,
+          code: recoveryCode ? undefined : code,
+          recovery_code: recoveryCode,
+        })
+        const { access_token, refresh_token, user } = response.data
+        set({ token: access_token, refreshToken: refresh_token, user, isAuthenticated: true, mfaToken: null })
+        api.defaults.headers.common['Authorization'] = `Bearer ${access_token}`
+      },
+
+      exchangeOidcCode: async (code: string) => {
+        const response = await api.post('/api/auth/oidc/exchange', { code })
+        if (response.data.mfa_required) {
+          set({ mfaToken: response.data.mfa_token })
+          return true
+        }
+        const { access_token, refresh_token, user } = response.data
+        set({ token: access_token, refreshToken: refresh_token, user, isAuthenticated: true })
         api.defaults.headers.common['Authorization'] = `Bearer ${access_token}`
+        return false
       },
 
-      register: async (email: string, password: string) => {
-        await api.post('/api/auth/register', { email, password })
+      register: async (email: string, password: string, inviteCode?: string) => {
+        await api.post('/api/auth/register', { email, password, invite_code: inviteCode || undefined })
       },
 
       logout: () => {
-        set({ token: null, user: null, isAuthenticated: false })
+        const token = get().token
+        if (token) {
+          api.post('/api/auth/logout', null, {
+            headers: { Authorization: `Bearer ${token}` },
+          }).catch(() => {})
+        }
+        set({ token: null, refreshToken: null, user: null, isAuthenticated: false })
         delete api.defaults.headers.common['Authorization']
       },
 
@@ -57,7 +98,7 @@ export const useAuthStore = create<AuthState>()(
     }),
     {
       name: 'auth-storage',
-      partialize: (state) => ({ token: state.token }),
+      partialize: (state) => ({ token: state.token, refreshToken: state.refreshToken }),
       onRehydrateStorage: () => (state) => {
         if (state?.token) {
           state.isAuthenticated = true